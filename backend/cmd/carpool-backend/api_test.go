@@ -0,0 +1,1365 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"carpool/backend/config"
+	"carpool/backend/internal/blob"
+	"carpool/backend/internal/health"
+	"carpool/backend/internal/mail/mailtest"
+	"carpool/backend/internal/memstore"
+	"carpool/backend/internal/openapi"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/sso/ssotest"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/totp"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// fixedETA estimates every trip at the same number of minutes.
+type fixedETA int
+
+func (m fixedETA) GetDuration(ctx context.Context, fromLon, fromLat, toLon, toLat float64) (int, error) {
+	return int(m), nil
+}
+
+// api is the handler tree from newHandler, on memstore and served over
+// a real listener. Every response is checked against the OpenAPI spec.
+type api struct {
+	t    *testing.T
+	srv  *httptest.Server
+	spec *openapi.Spec
+	mail *mailtest.Outbox
+	// store is for setting up what the API can't, such as rides that
+	// have already departed.
+	store *memstore.Store
+	keys  *token.KeySet
+}
+
+func newAPI(t *testing.T) *api {
+	t.Helper()
+	return newAPIWith(t, config.Defaults())
+}
+
+func newAPIWith(t *testing.T, cfg *config.Config) *api {
+	t.Helper()
+	keys, err := token.LoadKeySet(token.Options{Secret: "test-secret-that-is-long-enough"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The listener is bound before wiring so config can point at it.
+	srv := httptest.NewUnstartedServer(nil)
+	t.Cleanup(srv.Close)
+	base := "http://" + srv.Listener.Addr().String()
+	if cfg.SSOEnabled() {
+		// The OIDC redirect URL is a path on the test server.
+		cfg.OIDC.RedirectURL = base + cfg.OIDC.RedirectURL
+	}
+	store := memstore.New()
+	media := &blob.Local{Dir: t.TempDir(), BaseURL: base + "/media"}
+	outbox := &mailtest.Outbox{}
+	h, err := newHandler(cfg, backends{
+		Keys:          keys,
+		Users:         store.Users(),
+		Rides:         store.Rides(),
+		Bookings:      store.Bookings(),
+		Reviews:       store.Reviews(),
+		Vehicles:      store.Vehicles(),
+		Verifications: store.Verifications(),
+		Idempotency:   store.Idempotency(),
+		Media:         media,
+		Mail:          outbox,
+		ETA:           fixedETA(45),
+		Health:        &health.Handler{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = h
+	srv.Start()
+	// Responses are checked one at a time, redirects included.
+	srv.Client().CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &api{t: t, srv: srv, spec: spec, mail: outbox, store: store, keys: keys}
+}
+
+// do sends body as JSON with the bearer token, if any, and decodes a JSON
+// response into out, if not nil.
+func (a *api) do(method, path, token string, body, out any) *http.Response {
+	a.t.Helper()
+	return a.doWithHeader(nil, method, path, token, body, out)
+}
+
+// doWithHeader is do with extra request headers.
+func (a *api) doWithHeader(header http.Header, method, path, token string, body, out any) *http.Response {
+	a.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.srv.URL+path, reader)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return a.send(req, out)
+}
+
+// send sends req, checks the response against the spec and decodes a JSON
+// response into out, if not nil.
+func (a *api) send(req *http.Request, out any) *http.Response {
+	a.t.Helper()
+	method, path := req.Method, req.URL.RequestURI()
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	// Legacy aliases are documented only under /v1.
+	specPath := req.URL.Path
+	if resp.Header.Get("Deprecation") != "" {
+		specPath = "/v1" + specPath
+	}
+	if err := a.spec.ValidateResponse(method, specPath, resp.StatusCode, resp.Header.Get("Content-Type"), data); err != nil {
+		a.t.Errorf("response doesn't match openapi.json: %v", err)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			a.t.Fatalf("%s %s: decoding %q: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+// expect sends a request and fails the test unless it gets status.
+func (a *api) expect(status int, method, path, token string, body, out any) *http.Response {
+	a.t.Helper()
+	resp := a.do(method, path, token, body, out)
+	if resp.StatusCode != status {
+		a.t.Fatalf("%s %s: got status %d, want %d", method, path, resp.StatusCode, status)
+	}
+	return resp
+}
+
+// signUp registers a user and logs them in, returning their ID and token.
+func (a *api) signUp(name, email string) (int, string) {
+	a.t.Helper()
+	creds := map[string]string{"name": name, "email": email, "password": "correct horse"}
+	var registered struct {
+		ID int `json:"id"`
+	}
+	a.expect(http.StatusCreated, "POST", "/v1/register", "", creds, &registered)
+	var login struct {
+		Token string `json:"token"`
+	}
+	a.expect(http.StatusOK, "POST", "/v1/login", "", creds, &login)
+	if registered.ID == 0 || login.Token == "" {
+		a.t.Fatalf("signing up %s: got id %d, token %q", email, registered.ID, login.Token)
+	}
+	return registered.ID, login.Token
+}
+
+// adminToken promotes userID to admin, which no endpoint does, and signs
+// a token with the admin role for them.
+func (a *api) adminToken(userID int) string {
+	a.t.Helper()
+	a.store.SetRole(userID, "admin")
+	tok, err := a.keys.Sign(jwt.MapClaims{
+		"user_id": userID,
+		"role":    "admin",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return tok
+}
+
+// addVehicle registers a car with the given number of seats for the user
+// of token and returns its ID.
+func (a *api) addVehicle(token string, seats int) int {
+	a.t.Helper()
+	var v struct {
+		VehicleID int `json:"vehicle_id"`
+	}
+	a.expect(http.StatusCreated, "POST", "/v1/vehicles", token, map[string]any{
+		"make": "Toyota", "model": "Corolla", "color": "Blue", "plate": "ZH 123456", "seats": seats,
+	}, &v)
+	return v.VehicleID
+}
+
+type rideJSON struct {
+	RideID         int     `json:"ride_id"`
+	UserID         int     `json:"user_id"`
+	ETA            *string `json:"eta"`
+	AvailableSeats int     `json:"available_seats"`
+	DriverName     *string `json:"driver_name"`
+}
+
+type bookingJSON struct {
+	BookingID int    `json:"booking_id"`
+	UserID    int    `json:"user_id"`
+	RideID    int    `json:"ride_id"`
+	SeatCount int    `json:"seat_count"`
+	Status    string `json:"status"`
+}
+
+func TestRideSharingFlow(t *testing.T) {
+	a := newAPI(t)
+	driverID, driverToken := a.signUp("Ada", "ada@example.com")
+	riderID, riderToken := a.signUp("Grace", "grace@example.com")
+
+	departure := time.Now().UTC().AddDate(0, 0, 2).Truncate(24 * time.Hour).Add(8 * time.Hour)
+	var posted rideJSON
+	a.expect(http.StatusCreated, "POST", "/v1/rides", driverToken, map[string]any{
+		"from_lon": 8.5417, "from_lat": 47.3769,
+		"to_lon": 7.4474, "to_lat": 46.9480,
+		"from_address": "Zurich HB", "to_address": "Bern Bahnhof",
+		"price": 25.5, "ride_time": departure, "available_seats": 3,
+		"vehicle_id": a.addVehicle(driverToken, 4),
+	}, &posted)
+	if posted.RideID == 0 || posted.UserID != driverID || posted.ETA == nil || *posted.ETA != "08:45" {
+		t.Fatalf("posted ride %+v", posted)
+	}
+
+	var found []rideJSON
+	search := "/v1/rides/search?fromLon=8.5420&fromLat=47.3780&toLon=7.4480&toLat=46.9490" +
+		"&rideDate=" + departure.Format("2006-01-02") + "&rideTime=08:30&numPeople=2"
+	a.expect(http.StatusOK, "GET", search, "", nil, &found)
+	if len(found) != 1 || found[0].RideID != posted.RideID {
+		t.Fatalf("search found %+v, want ride %d", found, posted.RideID)
+	}
+	found = nil
+	a.expect(http.StatusOK, "GET", strings.Replace(search, "numPeople=2", "numPeople=4", 1), "", nil, &found)
+	if len(found) != 0 {
+		t.Errorf("search for 4 seats found %+v", found)
+	}
+
+	var ride rideJSON
+	a.expect(http.StatusOK, "GET", "/v1/rides/"+strconv.Itoa(posted.RideID), "", nil, &ride)
+	if ride.DriverName == nil || *ride.DriverName != "Ada" {
+		t.Errorf("ride %+v has no driver name", ride)
+	}
+
+	var booked bookingJSON
+	a.expect(http.StatusCreated, "POST", "/v1/bookings", riderToken, map[string]int{"ride_id": posted.RideID, "seat_count": 2}, &booked)
+	if booked.BookingID == 0 || booked.UserID != riderID || booked.Status != "pending" {
+		t.Fatalf("booking %+v", booked)
+	}
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/bookings", riderToken, map[string]int{"ride_id": posted.RideID, "seat_count": 4}, nil)
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/bookings", riderToken, map[string]int{"ride_id": 4242, "seat_count": 1}, nil)
+
+	var bookings []bookingJSON
+	a.expect(http.StatusOK, "GET", "/v1/bookings", riderToken, nil, &bookings)
+	if len(bookings) != 1 || bookings[0].BookingID != booked.BookingID || bookings[0].SeatCount != 2 {
+		t.Errorf("rider's bookings %+v", bookings)
+	}
+	bookings = nil
+	a.expect(http.StatusOK, "GET", "/v1/bookings", driverToken, nil, &bookings)
+	if len(bookings) != 0 {
+		t.Errorf("driver's bookings %+v", bookings)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	a := newAPI(t)
+	_, userToken := a.signUp("Ada", "ada@example.com")
+	demotedID, _ := a.signUp("Grace", "grace@example.com")
+	demotedToken := a.adminToken(demotedID)
+	a.store.SetRole(demotedID, "user")
+
+	a.expect(http.StatusConflict, "POST", "/v1/register", "",
+		map[string]string{"name": "Other", "email": "ada@example.com", "password": "correct horse"}, nil)
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/register", "",
+		map[string]string{"name": "Short", "email": "short@example.com", "password": "short"}, nil)
+	a.expect(http.StatusUnauthorized, "POST", "/v1/login", "",
+		map[string]string{"email": "ada@example.com", "password": "wrong password"}, nil)
+	a.expect(http.StatusUnauthorized, "POST", "/v1/login", "",
+		map[string]string{"email": "nobody@example.com", "password": "correct horse"}, nil)
+
+	for _, tc := range []struct {
+		name, method, path, token string
+		status                    int
+	}{
+		{"no token", "GET", "/v1/bookings", "", http.StatusUnauthorized},
+		{"garbage token", "GET", "/v1/bookings", "not-a-jwt", http.StatusUnauthorized},
+		{"no token posting a ride", "POST", "/v1/rides", "", http.StatusUnauthorized},
+		{"no token on profile", "GET", "/v1/profile", "", http.StatusUnauthorized},
+		{"user on an admin route", "GET", "/v1/admin/users", userToken, http.StatusForbidden},
+		{"demoted admin on an admin route", "GET", "/v1/admin/users", demotedToken, http.StatusForbidden},
+		{"valid token", "GET", "/v1/profile", userToken, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := a.do(tc.method, tc.path, tc.token, nil, nil)
+			if resp.StatusCode != tc.status {
+				t.Errorf("%s %s: got status %d, want %d", tc.method, tc.path, resp.StatusCode, tc.status)
+			}
+		})
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	a := newAPI(t)
+	_, token := a.signUp("Ada", "ada@example.com")
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	a.expect(http.StatusOK, "POST", "/v1/profile/2fa/enroll", token, nil, &enrollment)
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	a.expect(http.StatusOK, "POST", "/v1/profile/2fa/confirm", token, map[string]string{"code": code}, &confirmed)
+	if len(confirmed.RecoveryCodes) < 4 {
+		t.Fatalf("got %d recovery codes", len(confirmed.RecoveryCodes))
+	}
+
+	creds := map[string]string{"email": "ada@example.com", "password": "correct horse"}
+	challenge := func() string {
+		t.Helper()
+		var login struct {
+			ChallengeToken string `json:"challenge_token"`
+		}
+		a.expect(http.StatusOK, "POST", "/v1/login", "", creds, &login)
+		return login.ChallengeToken
+	}
+	attempt := func(status int, challenge, code string) {
+		t.Helper()
+		a.expect(status, "POST", "/v1/login/2fa", "", map[string]string{"challenge_token": challenge, "code": code}, nil)
+	}
+
+	// A challenge allows five codes, then has to be started over.
+	guessed := challenge()
+	for range 5 {
+		attempt(http.StatusUnauthorized, guessed, "000000")
+	}
+	attempt(http.StatusTooManyRequests, guessed, confirmed.RecoveryCodes[0])
+	attempt(http.StatusUnauthorized, guessed, confirmed.RecoveryCodes[0])
+
+	// A challenge completes one login.
+	used := challenge()
+	attempt(http.StatusOK, used, confirmed.RecoveryCodes[0])
+	attempt(http.StatusUnauthorized, used, confirmed.RecoveryCodes[1])
+	attempt(http.StatusOK, challenge(), confirmed.RecoveryCodes[1])
+
+	// Wrong codes add up across challenges until a login succeeds, and ten
+	// in a row lock two-factor sign-in whatever the challenge.
+	guess := func(n int) {
+		t.Helper()
+		c := challenge()
+		for range n {
+			attempt(http.StatusUnauthorized, c, "000000")
+		}
+	}
+	guess(5)
+	guess(4)
+	attempt(http.StatusOK, challenge(), confirmed.RecoveryCodes[2])
+	guess(5)
+	guess(5)
+	attempt(http.StatusTooManyRequests, challenge(), confirmed.RecoveryCodes[3])
+}
+
+func TestSingleSignOn(t *testing.T) {
+	issuer, err := ssotest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuer.Close()
+	issuer.SetUser(ssotest.User{Subject: "sso-grace", Email: "grace@example.com", EmailVerified: true, Name: "Grace"})
+	cfg := config.Defaults()
+	cfg.Features.SSO = true
+	cfg.OIDC.IssuerURL = issuer.URL
+	cfg.OIDC.ClientID = issuer.ClientID
+	cfg.OIDC.ClientSecret = issuer.ClientSecret
+	cfg.OIDC.RedirectURL = "/v1/auth/oidc/callback"
+	a := newAPIWith(t, cfg)
+
+	// The flow cookie is Secure, so the jar stands in for a browser on the
+	// HTTPS deployment and decides which paths it reaches.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := &url.URL{Scheme: "https", Host: a.srv.Listener.Addr().String()}
+
+	// signIn runs login → provider → callback one hop at a time, expects
+	// status from the callback and returns the app's token, if any.
+	signIn := func(status int) string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", a.srv.URL+"/v1/auth/oidc/login", nil)
+		resp := a.send(req, nil)
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("login: got status %d, want 302", resp.StatusCode)
+		}
+		jar.SetCookies(site, resp.Cookies())
+		authorize, err := a.srv.Client().Get(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authorize.Body.Close()
+		if authorize.StatusCode != http.StatusFound {
+			t.Fatalf("authorize: got status %d, want 302", authorize.StatusCode)
+		}
+		callback, err := url.Parse(authorize.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if callback.Path != "/v1/auth/oidc/callback" {
+			t.Fatalf("provider redirected to %s", callback)
+		}
+
+		req, _ = http.NewRequest("GET", callback.String(), nil)
+		for _, c := range jar.Cookies(site.ResolveReference(&url.URL{Path: callback.Path})) {
+			req.AddCookie(c)
+		}
+		var result map[string]any
+		resp = a.send(req, &result)
+		token, _ := result["token"].(string)
+		if resp.StatusCode != status || (status == http.StatusOK && token == "") {
+			t.Fatalf("callback: got status %d, %v", resp.StatusCode, result)
+		}
+		return token
+	}
+
+	token := signIn(http.StatusOK)
+	var profile struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	a.expect(http.StatusOK, "GET", "/v1/profile", token, nil, &profile)
+	if profile.Email != "grace@example.com" {
+		t.Errorf("signed in as %q, want grace@example.com", profile.Email)
+	}
+
+	// Providers may send an email without a local part and no name.
+	for _, email := range []string{"operator", "@example.com"} {
+		issuer.SetUser(ssotest.User{Subject: "sso-" + email, Email: email, EmailVerified: true})
+		a.expect(http.StatusOK, "GET", "/v1/profile", signIn(http.StatusOK), nil, &profile)
+		if profile.Name != "Carpool user" || profile.Email != email {
+			t.Errorf("signed in %q as %+v", email, profile)
+		}
+	}
+
+	// Whoever registered an unverified email with a password may not own
+	// it, so the provider's user isn't signed in to that account.
+	adaID, _ := a.signUp("Ada", "ada@example.com")
+	issuer.SetUser(ssotest.User{Subject: "sso-ada", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+	signIn(http.StatusUnauthorized)
+	identities, err := a.store.Users().ListIdentities(context.Background(), adaID)
+	if err != nil || len(identities) != 0 {
+		t.Errorf("identities of the password account: %v, %v", identities, err)
+	}
+}
+
+func TestRoutingErrors(t *testing.T) {
+	a := newAPI(t)
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{"DELETE", "/v1/rides/search", http.StatusMethodNotAllowed, "GET"},
+		{"PUT", "/v1/bookings", http.StatusMethodNotAllowed, "GET, POST"},
+		{"GET", "/v1/login", http.StatusMethodNotAllowed, "POST"},
+		{"GET", "/v1/no/such/endpoint", http.StatusNotFound, ""},
+		{"GET", "/v1/rides/4242", http.StatusNotFound, ""},
+		{"GET", "/v1/rides/abc", http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			var problem struct {
+				Status int `json:"status"`
+			}
+			resp := a.do(tc.method, tc.path, "", nil, &problem)
+			if resp.StatusCode != tc.status || problem.Status != tc.status {
+				t.Errorf("got status %d (document says %d), want %d", resp.StatusCode, problem.Status, tc.status)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("got Content-Type %q", ct)
+			}
+			if got := resp.Header.Get("Allow"); got != tc.allow {
+				t.Errorf("got Allow %q, want %q", got, tc.allow)
+			}
+		})
+	}
+}
+
+func TestLegacyAliases(t *testing.T) {
+	a := newAPI(t)
+	_, userToken := a.signUp("Legacy", "legacy@example.com")
+	for _, tc := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/bookings", userToken, http.StatusOK},
+		{"GET", "/bookings", "", http.StatusUnauthorized},
+		{"DELETE", "/rides/search", "", http.StatusMethodNotAllowed},
+		{"GET", "/rides/4242", "", http.StatusNotFound},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			resp := a.expect(tc.status, tc.method, tc.path, tc.token, nil, nil)
+			if got, want := resp.Header.Get("Deprecation"), "@1792368000"; got != want {
+				t.Errorf("got Deprecation %q, want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Sunset"), "Fri, 30 Apr 2027 00:00:00 GMT"; got != want {
+				t.Errorf("got Sunset %q, want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Link"), "</v1"+tc.path+`>; rel="successor-version"`; got != want {
+				t.Errorf("got Link %q, want %q", got, want)
+			}
+		})
+	}
+
+	// Versioned paths and service routes aren't deprecated.
+	for _, path := range []string{"/v1/bookings", "/healthz", "/v1/no/such/endpoint"} {
+		resp := a.do("GET", path, userToken, nil, nil)
+		if dep := resp.Header.Get("Deprecation"); dep != "" {
+			t.Errorf("GET %s: got Deprecation %q", path, dep)
+		}
+	}
+
+	// Legacy logins work, and their tokens are good for /v1.
+	var login struct {
+		Token string `json:"token"`
+	}
+	creds := map[string]string{"email": "legacy@example.com", "password": "correct horse"}
+	a.expect(http.StatusOK, "POST", "/login", "", creds, &login)
+	a.expect(http.StatusOK, "GET", "/v1/profile", login.Token, nil, nil)
+}
+
+func TestLegacyAliasesDisabled(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.LegacyAPI.Enabled = false
+	a := newAPIWith(t, cfg)
+	a.expect(http.StatusNotFound, "GET", "/bookings", "", nil, nil)
+	a.expect(http.StatusNotFound, "POST", "/login", "", map[string]string{}, nil)
+	a.expect(http.StatusUnauthorized, "GET", "/v1/bookings", "", nil, nil)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	a := newAPI(t)
+	_, driverToken := a.signUp("Dana Driver", "dana@example.com")
+	_, riderToken := a.signUp("Rui Rider", "rui@example.com")
+	key := func(k string) http.Header { return http.Header{"Idempotency-Key": {k}} }
+	newRide := map[string]any{
+		"from_lon": 8.5417, "from_lat": 47.3769, "to_lon": 7.4474, "to_lat": 46.9480,
+		"from_address": "Zurich HB", "to_address": "Bern",
+		"price": 25.5, "ride_time": time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339),
+		"available_seats": 3, "vehicle_id": a.addVehicle(driverToken, 4),
+	}
+
+	var first, retried rideJSON
+	resp := a.doWithHeader(key("ride-1"), "POST", "/v1/rides", driverToken, newRide, &first)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first POST: got status %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	resp = a.doWithHeader(key("ride-1"), "POST", "/v1/rides", driverToken, newRide, &retried)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "true" || retried.RideID != first.RideID {
+		t.Fatalf("retry: got status %d, replayed %q, ride %d; want ride %d replayed",
+			resp.StatusCode, resp.Header.Get("Idempotent-Replayed"), retried.RideID, first.RideID)
+	}
+	a.expect(http.StatusNotFound, "GET", "/v1/rides/"+strconv.Itoa(first.RideID+1), "", nil, nil)
+
+	// The same key with a different payload is rejected; under another
+	// user it is independent.
+	changed := map[string]any{}
+	for k, v := range newRide {
+		changed[k] = v
+	}
+	changed["price"] = 30
+	if resp := a.doWithHeader(key("ride-1"), "POST", "/v1/rides", driverToken, changed, nil); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got status %d, want 422", resp.StatusCode)
+	}
+	otherRide := map[string]any{}
+	for k, v := range newRide {
+		otherRide[k] = v
+	}
+	otherRide["vehicle_id"] = a.addVehicle(riderToken, 4)
+	var other rideJSON
+	a.doWithHeader(key("ride-1"), "POST", "/v1/rides", riderToken, otherRide, &other)
+	if other.RideID == 0 || other.RideID == first.RideID {
+		t.Errorf("another user's key: got ride %d", other.RideID)
+	}
+
+	// Duplicate bookings collapse into one; failures are replayed too.
+	book := map[string]int{"ride_id": first.RideID, "seat_count": 1}
+	var b1, b2 bookingJSON
+	a.doWithHeader(key("book-1"), "POST", "/v1/bookings", riderToken, book, &b1)
+	a.doWithHeader(key("book-1"), "POST", "/v1/bookings", riderToken, book, &b2)
+	if b1.BookingID == 0 || b2.BookingID != b1.BookingID {
+		t.Errorf("got bookings %d and %d, want one", b1.BookingID, b2.BookingID)
+	}
+	var bookings []bookingJSON
+	a.expect(http.StatusOK, "GET", "/v1/bookings", riderToken, nil, &bookings)
+	if len(bookings) != 1 {
+		t.Errorf("got %d bookings, want 1", len(bookings))
+	}
+	tooMany := map[string]int{"ride_id": first.RideID, "seat_count": 9}
+	for i, replayed := range []string{"", "true"} {
+		resp := a.doWithHeader(key("book-2"), "POST", "/v1/bookings", riderToken, tooMany, nil)
+		if resp.StatusCode != http.StatusUnprocessableEntity || resp.Header.Get("Idempotent-Replayed") != replayed {
+			t.Errorf("attempt %d: got status %d, replayed %q", i+1, resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+		}
+	}
+
+	// Without a key nothing is deduplicated.
+	var b3 bookingJSON
+	a.expect(http.StatusCreated, "POST", "/v1/bookings", riderToken, book, &b3)
+	if b3.BookingID == b1.BookingID {
+		t.Error("a request without a key was replayed")
+	}
+	if resp := a.doWithHeader(key(strings.Repeat("k", 256)), "POST", "/v1/bookings", riderToken, book, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("overlong key: got status %d, want 400", resp.StatusCode)
+	}
+}
+
+// upload posts data as the multipart field, if not empty, with the bearer
+// token.
+func (a *api) upload(path, token, field string, data []byte, out any) *http.Response {
+	a.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if field != "" {
+		fw, err := mw.CreateFormFile(field, "picture")
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+	req, err := http.NewRequest("POST", a.srv.URL+path, &body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.send(req, out)
+}
+
+// fetch GETs an absolute URL, such as a stored picture, returning its
+// status and body.
+func (a *api) fetch(url string) (int, []byte) {
+	a.t.Helper()
+	resp, err := a.srv.Client().Get(url)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func pngOf(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProfilePicture(t *testing.T) {
+	type profileJSON struct {
+		ProfilePic          *string `json:"profile_pic"`
+		ProfilePicThumbnail *string `json:"profile_pic_thumbnail"`
+	}
+	a := newAPI(t)
+	_, token := a.signUp("Ada", "ada@example.com")
+
+	var first profileJSON
+	if resp := a.upload("/v1/profile/picture", token, "profile_pic", pngOf(t, 800, 600), &first); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: got status %d", resp.StatusCode)
+	}
+	if first.ProfilePic == nil || first.ProfilePicThumbnail == nil {
+		t.Fatalf("uploaded profile %+v", first)
+	}
+	for url, size := range map[string]int{*first.ProfilePic: 512, *first.ProfilePicThumbnail: 128} {
+		status, data := a.fetch(url)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s: status %d", url, status)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "jpeg" || cfg.Width != size || cfg.Height != size {
+			t.Errorf("GET %s: %s %dx%d, %v; want %d square jpeg", url, format, cfg.Width, cfg.Height, err, size)
+		}
+	}
+	var profile profileJSON
+	a.expect(http.StatusOK, "GET", "/v1/profile", token, nil, &profile)
+	if profile.ProfilePic == nil || *profile.ProfilePic != *first.ProfilePic {
+		t.Errorf("profile picture %v, want %s", profile.ProfilePic, *first.ProfilePic)
+	}
+
+	// Replacing the picture deletes the old files.
+	var second profileJSON
+	a.upload("/v1/profile/picture", token, "profile_pic", pngOf(t, 300, 300), &second)
+	if second.ProfilePic == nil || *second.ProfilePic == *first.ProfilePic {
+		t.Fatalf("replaced picture %v, was %s", second.ProfilePic, *first.ProfilePic)
+	}
+	if status, _ := a.fetch(*first.ProfilePic); status != http.StatusNotFound {
+		t.Errorf("old picture: status %d, want 404", status)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		field  string
+		data   []byte
+		status int
+	}{
+		{"not an image", "profile_pic", []byte("%PDF-1.7"), http.StatusUnsupportedMediaType},
+		{"too large", "profile_pic", make([]byte, 5<<20+1), http.StatusRequestEntityTooLarge},
+		{"missing field", "", nil, http.StatusUnprocessableEntity},
+	} {
+		if resp := a.upload("/v1/profile/picture", token, tc.field, tc.data, nil); resp.StatusCode != tc.status {
+			t.Errorf("%s: got status %d, want %d", tc.name, resp.StatusCode, tc.status)
+		}
+	}
+	a.expect(http.StatusUnsupportedMediaType, "POST", "/v1/profile/picture", token, map[string]string{"profile_pic": "x"}, nil)
+
+	var removed profileJSON
+	a.expect(http.StatusOK, "DELETE", "/v1/profile/picture", token, nil, &removed)
+	if removed.ProfilePic != nil || removed.ProfilePicThumbnail != nil {
+		t.Errorf("removed picture, profile still has %+v", removed)
+	}
+	if status, _ := a.fetch(*second.ProfilePicThumbnail); status != http.StatusNotFound {
+		t.Errorf("removed thumbnail: status %d, want 404", status)
+	}
+}
+
+func TestProfileEditing(t *testing.T) {
+	type profileJSON struct {
+		Name         string  `json:"name"`
+		Email        string  `json:"email"`
+		PendingEmail *string `json:"pending_email"`
+		Phone        *string `json:"phone"`
+		Bio          *string `json:"bio"`
+	}
+	a := newAPI(t)
+	_, token := a.signUp("Ada", "ada@example.com")
+	a.signUp("Grace", "grace@example.com")
+	mergePatch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+	patch := func(status int, body any, out any) {
+		t.Helper()
+		if resp := a.doWithHeader(mergePatch, "PATCH", "/v1/profile", token, body, out); resp.StatusCode != status {
+			t.Fatalf("PATCH /v1/profile %v: got status %d, want %d", body, resp.StatusCode, status)
+		}
+	}
+
+	var p profileJSON
+	patch(http.StatusOK, map[string]any{"name": "Ada Lovelace", "bio": "Engines", "phone": "0041 (79) 123-45-67"}, &p)
+	if p.Name != "Ada Lovelace" || p.Bio == nil || *p.Bio != "Engines" || p.Phone == nil || *p.Phone != "+41791234567" {
+		t.Fatalf("patched profile %+v", p)
+	}
+	p = profileJSON{}
+	patch(http.StatusOK, map[string]any{"bio": nil, "phone": nil}, &p)
+	if p.Name != "Ada Lovelace" || p.Bio != nil || p.Phone != nil {
+		t.Fatalf("cleared bio and phone, got %+v", p)
+	}
+
+	for _, body := range []any{
+		map[string]any{"phone": "079 123 45 67"},
+		map[string]any{"name": nil},
+		map[string]any{"name": 7},
+		map[string]any{"email": "not-an-address"},
+		map[string]any{"role": "admin"},
+	} {
+		patch(http.StatusUnprocessableEntity, body, nil)
+	}
+	patch(http.StatusBadRequest, []string{"name"}, nil)
+	if resp := a.doWithHeader(http.Header{"Content-Type": {"text/plain"}}, "PATCH", "/v1/profile", token, map[string]any{"name": "x"}, nil); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH /v1/profile as text/plain: got status %d, want 415", resp.StatusCode)
+	}
+	patch(http.StatusConflict, map[string]any{"email": "grace@example.com"}, nil)
+
+	// A new email waits for the link mailed to it.
+	patch(http.StatusOK, map[string]any{"email": "ada@new.example"}, &p)
+	if p.Email != "ada@example.com" || p.PendingEmail == nil || *p.PendingEmail != "ada@new.example" {
+		t.Fatalf("email change applied too early: %+v", p)
+	}
+	msg, ok := a.mail.Last("ada@new.example")
+	if !ok {
+		t.Fatal("no confirmation mail sent")
+	}
+	m := regexp.MustCompile(`/confirm-email\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	if m == nil {
+		t.Fatalf("no link in %q", msg.Body)
+	}
+	a.expect(http.StatusBadRequest, "POST", "/v1/profile/email/confirm", "", map[string]string{"token": "forged"}, nil)
+	a.expect(http.StatusOK, "POST", "/v1/profile/email/confirm", "", map[string]string{"token": m[1]}, nil)
+	a.expect(http.StatusBadRequest, "POST", "/v1/profile/email/confirm", "", map[string]string{"token": m[1]}, nil)
+
+	p = profileJSON{}
+	a.expect(http.StatusOK, "GET", "/v1/profile", token, nil, &p)
+	if p.Email != "ada@new.example" || p.PendingEmail != nil {
+		t.Errorf("after confirming: %+v", p)
+	}
+	a.expect(http.StatusOK, "POST", "/v1/login", "", map[string]string{"email": "ada@new.example", "password": "correct horse"}, nil)
+}
+
+func TestPublicProfile(t *testing.T) {
+	a := newAPI(t)
+	driverID, driverToken := a.signUp("Ada", "ada@example.com")
+	riderID, riderToken := a.signUp("Grace", "grace@example.com")
+	_, strangerToken := a.signUp("Alan", "alan@example.com")
+	bio := "Commuting to Bern most weekdays."
+	a.expect(http.StatusOK, "PATCH", "/v1/profile", driverToken, map[string]any{"bio": bio, "phone": "+41 79 123 45 67"}, nil)
+
+	newRide := func(when time.Time) int {
+		t.Helper()
+		rd := &ride.Ride{
+			UserID: driverID, FromLon: 8.5417, FromLat: 47.3769, ToLon: 7.4474, ToLat: 46.9480,
+			FromAddress: "Zurich HB", ToAddress: "Bern Bahnhof", Price: 25.5, RideTime: when, AvailableSeats: 3,
+		}
+		if err := a.store.Rides().CreateRide(context.Background(), rd); err != nil {
+			t.Fatal(err)
+		}
+		a.expect(http.StatusCreated, "POST", "/v1/bookings", riderToken, map[string]int{"ride_id": rd.RideID, "seat_count": 1}, nil)
+		return rd.RideID
+	}
+	past := "/v1/rides/" + strconv.Itoa(newRide(time.Now().Add(-48*time.Hour))) + "/reviews"
+	upcoming := "/v1/rides/" + strconv.Itoa(newRide(time.Now().Add(48*time.Hour))) + "/reviews"
+
+	var rev struct {
+		ReviewID   int    `json:"review_id"`
+		ReviewerID int    `json:"reviewer_id"`
+		Rating     int    `json:"rating"`
+		Comment    string `json:"comment"`
+	}
+	a.expect(http.StatusCreated, "POST", past, riderToken, map[string]any{"reviewee_id": driverID, "rating": 5, "comment": "Punctual and friendly"}, &rev)
+	if rev.ReviewID == 0 || rev.ReviewerID != riderID || rev.Rating != 5 {
+		t.Fatalf("review %+v", rev)
+	}
+	a.expect(http.StatusCreated, "POST", past, driverToken, map[string]any{"reviewee_id": riderID, "rating": 4}, nil)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		token  string
+		body   map[string]any
+		status int
+	}{
+		{"twice", past, riderToken, map[string]any{"reviewee_id": driverID, "rating": 1}, http.StatusConflict},
+		{"before departure", upcoming, riderToken, map[string]any{"reviewee_id": driverID, "rating": 5}, http.StatusConflict},
+		{"not on the ride", past, strangerToken, map[string]any{"reviewee_id": driverID, "rating": 1}, http.StatusForbidden},
+		{"oneself", past, driverToken, map[string]any{"reviewee_id": driverID, "rating": 5}, http.StatusBadRequest},
+		{"rating out of range", past, riderToken, map[string]any{"reviewee_id": driverID, "rating": 6}, http.StatusUnprocessableEntity},
+		{"anonymously", past, "", map[string]any{"reviewee_id": driverID, "rating": 5}, http.StatusUnauthorized},
+		{"unknown ride", "/v1/rides/4242/reviews", riderToken, map[string]any{"reviewee_id": driverID, "rating": 5}, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if resp := a.do("POST", tc.path, tc.token, tc.body, nil); resp.StatusCode != tc.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tc.status)
+			}
+		})
+	}
+
+	var raw map[string]json.RawMessage
+	a.expect(http.StatusOK, "GET", "/v1/users/"+strconv.Itoa(driverID), "", nil, &raw)
+	for _, private := range []string{"email", "phone", "pending_email", "role", "status"} {
+		if _, ok := raw[private]; ok {
+			t.Errorf("public profile shows %q", private)
+		}
+	}
+	var profile struct {
+		Name          string   `json:"name"`
+		Bio           *string  `json:"bio"`
+		Rating        *float64 `json:"rating"`
+		RatingCount   int      `json:"rating_count"`
+		RidesDriven   int      `json:"rides_driven"`
+		RidesTaken    int      `json:"rides_taken"`
+		Badges        []string `json:"badges"`
+		RecentReviews []struct {
+			ReviewerName string `json:"reviewer_name"`
+			Comment      string `json:"comment"`
+		} `json:"recent_reviews"`
+	}
+	a.expect(http.StatusOK, "GET", "/v1/users/"+strconv.Itoa(driverID), "", nil, &profile)
+	if profile.Name != "Ada" || profile.Bio == nil || *profile.Bio != bio || profile.Rating == nil || *profile.Rating != 5 ||
+		profile.RatingCount != 1 || profile.RidesDriven != 1 || profile.RidesTaken != 0 || len(profile.Badges) != 0 {
+		t.Errorf("driver profile %+v", profile)
+	}
+	if len(profile.RecentReviews) != 1 || profile.RecentReviews[0].ReviewerName != "Grace" || profile.RecentReviews[0].Comment != "Punctual and friendly" {
+		t.Errorf("driver reviews %+v", profile.RecentReviews)
+	}
+
+	a.expect(http.StatusOK, "GET", "/v1/users/"+strconv.Itoa(riderID), "", nil, &profile)
+	if profile.Rating == nil || *profile.Rating != 4 || profile.RidesDriven != 0 || profile.RidesTaken != 1 {
+		t.Errorf("rider profile %+v", profile)
+	}
+
+	a.expect(http.StatusNotFound, "GET", "/v1/users/4242", "", nil, nil)
+	a.expect(http.StatusBadRequest, "GET", "/v1/users/ada", "", nil, nil)
+}
+
+func TestDataExport(t *testing.T) {
+	a := newAPI(t)
+	driverID, driverToken := a.signUp("Ada", "ada@example.com")
+	riderID, riderToken := a.signUp("Grace", "grace@example.com")
+	rd := &ride.Ride{
+		UserID: driverID, FromLon: 8.5417, FromLat: 47.3769, ToLon: 7.4474, ToLat: 46.9480,
+		FromAddress: "Zurich HB", ToAddress: "Bern Bahnhof", Price: 25.5, RideTime: time.Now().Add(-48 * time.Hour), AvailableSeats: 3,
+	}
+	if err := a.store.Rides().CreateRide(context.Background(), rd); err != nil {
+		t.Fatal(err)
+	}
+	a.expect(http.StatusCreated, "POST", "/v1/bookings", riderToken, map[string]int{"ride_id": rd.RideID, "seat_count": 1}, nil)
+	reviews := "/v1/rides/" + strconv.Itoa(rd.RideID) + "/reviews"
+	a.expect(http.StatusCreated, "POST", reviews, riderToken, map[string]any{"reviewee_id": driverID, "rating": 5}, nil)
+	a.expect(http.StatusCreated, "POST", reviews, driverToken, map[string]any{"reviewee_id": riderID, "rating": 4, "comment": "On time"}, nil)
+
+	req, _ := http.NewRequest("GET", a.srv.URL+"/v1/profile/export", nil)
+	req.Header.Set("Authorization", "Bearer "+riderToken)
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"profile.json", "identities.json", "preferences.json", "rides.json", "bookings.json", "reviews.json", "vehicles.json", "verification.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export has no %s", name)
+		}
+	}
+
+	var got struct {
+		Written []struct {
+			RevieweeID int `json:"reviewee_id"`
+			Rating     int `json:"rating"`
+		} `json:"written"`
+		Received []struct {
+			ReviewerName string `json:"reviewer_name"`
+			Comment      string `json:"comment"`
+		} `json:"received"`
+	}
+	if err := json.Unmarshal(files["reviews.json"], &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Written) != 1 || got.Written[0].RevieweeID != driverID || got.Written[0].Rating != 5 {
+		t.Errorf("written reviews %+v", got.Written)
+	}
+	if len(got.Received) != 1 || got.Received[0].ReviewerName != "Ada" || got.Received[0].Comment != "On time" {
+		t.Errorf("received reviews %+v", got.Received)
+	}
+}
+
+func TestVehicles(t *testing.T) {
+	type vehicleJSON struct {
+		VehicleID int      `json:"vehicle_id"`
+		UserID    int      `json:"user_id"`
+		Plate     string   `json:"plate"`
+		Seats     int      `json:"seats"`
+		Photos    []string `json:"photos"`
+	}
+	a := newAPI(t)
+	driverID, driverToken := a.signUp("Ada", "ada@example.com")
+	_, otherToken := a.signUp("Grace", "grace@example.com")
+
+	var car vehicleJSON
+	a.expect(http.StatusCreated, "POST", "/v1/vehicles", driverToken, map[string]any{
+		"make": "Toyota", "model": "Corolla", "color": "Blue", "plate": " zh  123 456 ", "seats": 4,
+	}, &car)
+	if car.VehicleID == 0 || car.UserID != driverID || car.Plate != "ZH 123 456" || car.Photos == nil {
+		t.Fatalf("registered %+v", car)
+	}
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/vehicles", driverToken, map[string]any{
+		"make": "Toyota", "model": "Corolla", "color": "Blue", "plate": "ZH 1", "seats": 9,
+	}, nil)
+	spare := a.addVehicle(driverToken, 2)
+	var mine []vehicleJSON
+	a.expect(http.StatusOK, "GET", "/v1/vehicles", driverToken, nil, &mine)
+	if len(mine) != 2 || mine[0].VehicleID != car.VehicleID || mine[1].VehicleID != spare {
+		t.Errorf("listed %+v", mine)
+	}
+
+	// Photos are scaled to fit 1280 pixels.
+	photos := "/v1/vehicles/" + strconv.Itoa(car.VehicleID) + "/photos"
+	if resp := a.upload(photos, driverToken, "photo", pngOf(t, 2000, 1000), &car); resp.StatusCode != http.StatusOK || len(car.Photos) != 1 {
+		t.Fatalf("upload: status %d, vehicle %+v", resp.StatusCode, car)
+	}
+	status, data := a.fetch(car.Photos[0])
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if status != http.StatusOK || err != nil || format != "jpeg" || cfg.Width != 1280 || cfg.Height != 640 {
+		t.Errorf("stored photo: status %d, %s %dx%d, %v", status, format, cfg.Width, cfg.Height, err)
+	}
+	if resp := a.upload(photos, otherToken, "photo", pngOf(t, 20, 20), nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("someone else's upload: status %d, want 404", resp.StatusCode)
+	}
+
+	newRide := func(vehicleID any, seats int) map[string]any {
+		return map[string]any{
+			"from_lon": 8.5417, "from_lat": 47.3769, "to_lon": 7.4474, "to_lat": 46.9480,
+			"price": 25.5, "ride_time": time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339),
+			"available_seats": seats, "vehicle_id": vehicleID,
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		vehicle any
+		seats   int
+	}{
+		{"more seats than the vehicle", car.VehicleID, 5},
+		{"someone else's vehicle", a.addVehicle(otherToken, 4), 1},
+		{"unknown vehicle", 4242, 1},
+		{"no vehicle", nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if resp := a.do("POST", "/v1/rides", driverToken, newRide(tc.vehicle, tc.seats), nil); resp.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("status %d, want 422", resp.StatusCode)
+			}
+		})
+	}
+
+	var posted struct {
+		RideID    int          `json:"ride_id"`
+		CarType   string       `json:"car_type"`
+		VehicleID int          `json:"vehicle_id"`
+		Vehicle   *vehicleJSON `json:"vehicle"`
+	}
+	a.expect(http.StatusCreated, "POST", "/v1/rides", driverToken, newRide(car.VehicleID, 4), &posted)
+	if posted.CarType != "Blue Toyota Corolla" || posted.VehicleID != car.VehicleID || posted.Vehicle == nil || posted.Vehicle.Plate != "ZH 123 456" {
+		t.Errorf("posted %+v", posted)
+	}
+	a.expect(http.StatusOK, "GET", "/v1/rides/"+strconv.Itoa(posted.RideID), "", nil, &posted)
+	if posted.Vehicle == nil || len(posted.Vehicle.Photos) != 1 {
+		t.Errorf("ride shows vehicle %+v", posted.Vehicle)
+	}
+
+	path := "/v1/vehicles/" + strconv.Itoa(car.VehicleID)
+	update := map[string]any{"make": "Toyota", "model": "Corolla", "color": "Red", "plate": "ZH 123 456", "seats": 4}
+	a.expect(http.StatusNotFound, "PUT", path, otherToken, update, nil)
+	a.expect(http.StatusOK, "PUT", path, driverToken, update, &car)
+	if len(car.Photos) != 1 {
+		t.Errorf("update dropped the photos: %+v", car)
+	}
+
+	a.expect(http.StatusNotFound, "DELETE", photos+"/1", driverToken, nil, nil)
+	a.expect(http.StatusOK, "DELETE", photos+"/0", driverToken, nil, &car)
+	if len(car.Photos) != 0 {
+		t.Errorf("photos after delete: %v", car.Photos)
+	}
+	if status, _ := a.fetch(posted.Vehicle.Photos[0]); status != http.StatusNotFound {
+		t.Errorf("deleted photo: status %d, want 404", status)
+	}
+
+	a.expect(http.StatusConflict, "DELETE", path, driverToken, nil, nil)
+	a.expect(http.StatusNotFound, "DELETE", "/v1/vehicles/"+strconv.Itoa(spare), otherToken, nil, nil)
+	a.expect(http.StatusNoContent, "DELETE", "/v1/vehicles/"+strconv.Itoa(spare), driverToken, nil, nil)
+	a.expect(http.StatusOK, "GET", "/v1/vehicles", driverToken, nil, &mine)
+	if len(mine) != 1 {
+		t.Errorf("%d vehicles left, want 1", len(mine))
+	}
+}
+
+func TestRidePreferences(t *testing.T) {
+	type rulesJSON struct {
+		Smoking   bool   `json:"smoking"`
+		Pets      bool   `json:"pets"`
+		Luggage   string `json:"luggage"`
+		WomenOnly bool   `json:"women_only"`
+	}
+	a := newAPI(t)
+	_, driverToken := a.signUp("Ada", "ada@example.com")
+	_, riderToken := a.signUp("Alan", "alan@example.com")
+	_, womanToken := a.signUp("Grace", "grace@example.com")
+
+	var settings struct {
+		Gender *string   `json:"gender"`
+		Driver rulesJSON `json:"driver"`
+	}
+	a.expect(http.StatusOK, "GET", "/v1/profile/preferences", driverToken, nil, &settings)
+	if settings.Gender != nil || settings.Driver.Luggage != "medium" || settings.Driver.Pets {
+		t.Errorf("default preferences %+v", settings)
+	}
+	a.expect(http.StatusUnprocessableEntity, "PUT", "/v1/profile/preferences", driverToken, map[string]any{
+		"gender": "unknown", "driver": map[string]any{"luggage": "huge"},
+	}, nil)
+
+	departure := time.Now().UTC().AddDate(0, 0, 2).Truncate(24 * time.Hour).Add(8 * time.Hour)
+	vehicleID := a.addVehicle(driverToken, 4)
+	post := func(rules any) *http.Response {
+		body := map[string]any{
+			"from_lon": 8.5417, "from_lat": 47.3769, "to_lon": 7.4474, "to_lat": 46.9480,
+			"price": 25.5, "ride_time": departure, "available_seats": 3, "vehicle_id": vehicleID,
+		}
+		if rules != nil {
+			body["rules"] = rules
+		}
+		return a.do("POST", "/v1/rides", driverToken, body, nil)
+	}
+	womenOnly := map[string]any{"luggage": "small", "women_only": true}
+	if resp := post(womenOnly); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("women-only ride without a gender: status %d, want 422", resp.StatusCode)
+	}
+
+	// Rides posted without rules get the driver's defaults.
+	a.expect(http.StatusOK, "PUT", "/v1/profile/preferences", driverToken, map[string]any{
+		"gender": "female", "driver": map[string]any{"pets": true, "luggage": "large"},
+	}, &settings)
+	if settings.Gender == nil || *settings.Gender != "female" || !settings.Driver.Pets {
+		t.Errorf("saved preferences %+v", settings)
+	}
+	var rides []struct {
+		RideID int       `json:"ride_id"`
+		Rules  rulesJSON `json:"rules"`
+	}
+	for _, rules := range []any{nil, womenOnly} {
+		if resp := post(rules); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("posting with rules %v: status %d", rules, resp.StatusCode)
+		}
+	}
+	search := "/v1/rides/search?fromLon=8.5417&fromLat=47.3769&toLon=7.4474&toLat=46.9480" +
+		"&rideDate=" + departure.Format("2006-01-02") + "&rideTime=08:00"
+	a.expect(http.StatusOK, "GET", search, "", nil, &rides)
+	if len(rides) != 2 || rides[0].Rules != (rulesJSON{Pets: true, Luggage: "large"}) || rides[1].Rules != (rulesJSON{Luggage: "small", WomenOnly: true}) {
+		t.Fatalf("search found %+v", rides)
+	}
+	open, womens := rides[0].RideID, rides[1].RideID
+
+	for _, tc := range []struct {
+		query string
+		want  []int
+	}{
+		{"&pets=true", []int{open}},
+		{"&womenOnly=true", []int{womens}},
+		{"&luggage=medium", []int{open}},
+		{"&smoking=false&luggage=none", []int{open, womens}},
+		{"&smoking=true", nil},
+	} {
+		rides = nil
+		a.expect(http.StatusOK, "GET", search+tc.query, "", nil, &rides)
+		var got []int
+		for _, r := range rides {
+			got = append(got, r.RideID)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("search%s found %v, want %v", tc.query, got, tc.want)
+		}
+	}
+	a.expect(http.StatusUnprocessableEntity, "GET", search+"&pets=maybe", "", nil, nil)
+	a.expect(http.StatusUnprocessableEntity, "GET", search+"&luggage=huge", "", nil, nil)
+
+	// Booking checks the hard rules against the rider's preferences.
+	book := func(token string, rideID int) int {
+		return a.do("POST", "/v1/bookings", token, map[string]int{"ride_id": rideID, "seat_count": 1}, nil).StatusCode
+	}
+	if status := book(riderToken, womens); status != http.StatusForbidden {
+		t.Errorf("man booking a women-only ride: status %d, want 403", status)
+	}
+	a.expect(http.StatusOK, "PUT", "/v1/profile/preferences", womanToken, map[string]any{
+		"gender": "female", "rider": map[string]any{"pets": true},
+	}, nil)
+	if status := book(womanToken, womens); status != http.StatusForbidden {
+		t.Errorf("booking with a pet where pets aren't allowed: status %d, want 403", status)
+	}
+	a.expect(http.StatusOK, "PUT", "/v1/profile/preferences", womanToken, map[string]any{
+		"gender": "female", "rider": map[string]any{"luggage": "small"},
+	}, nil)
+	if status := book(womanToken, womens); status != http.StatusCreated {
+		t.Errorf("woman booking a women-only ride: status %d, want 201", status)
+	}
+	a.expect(http.StatusOK, "PUT", "/v1/profile/preferences", riderToken, map[string]any{
+		"rider": map[string]any{"luggage": "large"},
+	}, nil)
+	if status := book(riderToken, open); status != http.StatusCreated {
+		t.Errorf("booking a ride with room for the luggage: status %d, want 201", status)
+	}
+}
+
+func TestDriverVerification(t *testing.T) {
+	type verificationJSON struct {
+		UserID    int     `json:"user_id"`
+		Name      string  `json:"name"`
+		Status    string  `json:"status"`
+		Reason    *string `json:"reason"`
+		Documents []struct {
+			Kind        string `json:"kind"`
+			ContentType string `json:"content_type"`
+		} `json:"documents"`
+	}
+	cfg := config.Defaults()
+	cfg.Verification.Required = true
+	a := newAPIWith(t, cfg)
+	driverID, driverToken := a.signUp("Ada", "ada@example.com")
+	adminID, _ := a.signUp("Admin", "admin@example.com")
+	adminToken := a.adminToken(adminID)
+	path := "/v1/admin/verifications/" + strconv.Itoa(driverID)
+
+	departure := time.Now().UTC().AddDate(0, 0, 2).Truncate(24 * time.Hour).Add(8 * time.Hour)
+	vehicleID := a.addVehicle(driverToken, 4)
+	post := func() *http.Response {
+		return a.do("POST", "/v1/rides", driverToken, map[string]any{
+			"from_lon": 8.5417, "from_lat": 47.3769, "to_lon": 7.4474, "to_lat": 46.9480,
+			"price": 25.5, "ride_time": departure, "available_seats": 3, "vehicle_id": vehicleID,
+		}, nil)
+	}
+	if resp := post(); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unverified driver posting a ride: status %d, want 403", resp.StatusCode)
+	}
+
+	var v verificationJSON
+	a.expect(http.StatusOK, "GET", "/v1/profile/verification", driverToken, nil, &v)
+	if v.Status != "unverified" || len(v.Documents) != 0 {
+		t.Errorf("new driver's verification %+v", v)
+	}
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/profile/verification/submit", driverToken, nil, nil)
+	upload := func(kind string, data []byte, out any) int {
+		return a.upload("/v1/profile/verification/documents/"+kind, driverToken, "document", data, out).StatusCode
+	}
+	if status := upload("license", []byte("just some text"), nil); status != http.StatusUnsupportedMediaType {
+		t.Errorf("text document: status %d, want 415", status)
+	}
+	if status := upload("passport", pngOf(t, 10, 10), nil); status != http.StatusNotFound {
+		t.Errorf("unknown kind: status %d, want 404", status)
+	}
+	if status := upload("license", pngOf(t, 10, 10), &v); status != http.StatusOK {
+		t.Fatalf("uploading a license: status %d", status)
+	}
+	a.expect(http.StatusUnprocessableEntity, "POST", "/v1/profile/verification/submit", driverToken, nil, nil)
+	if status := upload("insurance", []byte("%PDF-1.4\n%fake policy\n"), &v); status != http.StatusOK {
+		t.Fatalf("uploading insurance: status %d", status)
+	}
+	if len(v.Documents) != 2 || v.Documents[0].Kind != "insurance" || v.Documents[0].ContentType != "application/pdf" ||
+		v.Documents[1].ContentType != "image/png" {
+		t.Errorf("uploaded documents %+v", v.Documents)
+	}
+	a.expect(http.StatusOK, "POST", "/v1/profile/verification/submit", driverToken, nil, &v)
+	if v.Status != "pending" {
+		t.Errorf("submitted verification %+v", v)
+	}
+	if status := upload("license", pngOf(t, 10, 10), nil); status != http.StatusConflict {
+		t.Errorf("uploading while under review: status %d, want 409", status)
+	}
+	a.expect(http.StatusConflict, "POST", "/v1/profile/verification/submit", driverToken, nil, nil)
+
+	// Admins work through the queue.
+	a.expect(http.StatusForbidden, "GET", "/v1/admin/verifications", driverToken, nil, nil)
+	var queue []verificationJSON
+	a.expect(http.StatusOK, "GET", "/v1/admin/verifications", adminToken, nil, &queue)
+	if len(queue) != 1 || queue[0].UserID != driverID || queue[0].Name != "Ada" {
+		t.Fatalf("queue %+v", queue)
+	}
+	resp := a.expect(http.StatusOK, "GET", path+"/documents/insurance", adminToken, nil, nil)
+	if resp.Header.Get("Content-Type") != "application/pdf" || resp.Header.Get("Cache-Control") != "no-store" ||
+		!strings.Contains(resp.Header.Get("Content-Disposition"), "attachment") {
+		t.Errorf("document served with headers %v", resp.Header)
+	}
+	a.expect(http.StatusUnprocessableEntity, "PATCH", path, adminToken, map[string]string{"status": "rejected"}, nil)
+	a.expect(http.StatusUnprocessableEntity, "PATCH", path, adminToken, map[string]string{"status": "approved"}, nil)
+	a.expect(http.StatusNoContent, "PATCH", path, adminToken, map[string]string{"status": "rejected", "reason": "The license is unreadable"}, nil)
+	if msg, ok := a.mail.Last("ada@example.com"); !ok || !strings.Contains(msg.Body, "The license is unreadable") {
+		t.Errorf("rejection mail %+v", msg)
+	}
+	a.expect(http.StatusNotFound, "PATCH", path, adminToken, map[string]string{"status": "verified"}, nil)
+	a.expect(http.StatusOK, "GET", "/v1/profile/verification", driverToken, nil, &v)
+	if v.Status != "rejected" || v.Reason == nil || *v.Reason != "The license is unreadable" {
+		t.Errorf("rejected verification %+v", v)
+	}
+
+	// Rejected drivers try again.
+	if status := upload("license", pngOf(t, 20, 20), nil); status != http.StatusOK {
+		t.Fatalf("uploading a new license: status %d", status)
+	}
+	a.expect(http.StatusOK, "POST", "/v1/profile/verification/submit", driverToken, nil, nil)
+	a.expect(http.StatusNoContent, "PATCH", path, adminToken, map[string]string{"status": "verified"}, nil)
+	if msg, ok := a.mail.Last("ada@example.com"); !ok || !strings.Contains(msg.Subject, "verified driver") {
+		t.Errorf("approval mail %+v", msg)
+	}
+	v = verificationJSON{}
+	a.expect(http.StatusOK, "GET", "/v1/profile/verification", driverToken, nil, &v)
+	if v.Status != "verified" || v.Reason != nil || len(v.Documents) != 0 {
+		t.Errorf("verified verification %+v", v)
+	}
+	a.expect(http.StatusNotFound, "GET", path+"/documents/license", adminToken, nil, nil)
+
+	// Verification shows on rides and the public profile.
+	var ride struct {
+		RideID         int  `json:"ride_id"`
+		DriverVerified bool `json:"driver_verified"`
+	}
+	if resp := a.do("POST", "/v1/rides", driverToken, map[string]any{
+		"from_lon": 8.5417, "from_lat": 47.3769, "to_lon": 7.4474, "to_lat": 46.9480,
+		"price": 25.5, "ride_time": departure, "available_seats": 3, "vehicle_id": vehicleID,
+	}, &ride); resp.StatusCode != http.StatusCreated || !ride.DriverVerified {
+		t.Fatalf("verified driver posting a ride: status %d, %+v", resp.StatusCode, ride)
+	}
+	ride.DriverVerified = false
+	a.expect(http.StatusOK, "GET", "/v1/rides/"+strconv.Itoa(ride.RideID), "", nil, &ride)
+	if !ride.DriverVerified {
+		t.Error("ride doesn't show its driver as verified")
+	}
+	var profile struct {
+		Badges []string `json:"badges"`
+	}
+	a.expect(http.StatusOK, "GET", "/v1/users/"+strconv.Itoa(driverID), "", nil, &profile)
+	if !slices.Contains(profile.Badges, "verified_driver") {
+		t.Errorf("badges %v", profile.Badges)
+	}
+}
@@ -2,118 +2,327 @@ package main
 
 import (
 	"carpool/backend/config"
+	"carpool/backend/internal/account"
+	"carpool/backend/internal/admin"
+	"carpool/backend/internal/blob"
 	"carpool/backend/internal/booking"
+	"carpool/backend/internal/health"
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/mail"
+	"carpool/backend/internal/metrics"
 	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/profile"
+	"carpool/backend/internal/review"
 	"carpool/backend/internal/ride"
+	"carpool/backend/internal/routes"
+	"carpool/backend/internal/sso"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/tracing"
 	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+	"context"
 	"log"
 	"net/http"
-
-	"github.com/rs/cors"
+	"os"
+	"time"
 )
 
 func main() {
-	cfg := config.LoadConfig()
-	db := config.ConnectDB()
+	// CONFIG_FILE optionally names a JSON config file; environment
+	// variables override it.
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// `carpool-backend migrate ...` only needs the database.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := cfg.ValidateDatabase(); err != nil {
+			log.Fatal(err)
+		}
+		db, err := config.ConnectDB(cfg.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.Tracing.Exporter,
+		ServiceName:  cfg.Tracing.ServiceName,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := config.ConnectDB(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ensureSchema(db, cfg.Database.MigrateOnStart); err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := token.LoadKeySet(token.Options{
+		Secret:          cfg.JWT.Secret,
+		PreviousSecrets: cfg.JWT.PreviousSecrets,
+		SigningKeyFile:  cfg.JWT.SigningKeyFile,
+		VerifyKeyFiles:  cfg.JWT.VerifyKeyFiles,
+	})
+	if err != nil {
+		log.Fatal("Cannot load JWT keys: ", err)
+	}
+
+	timeouts := cfg.Database.Timeouts()
+	healthHandler := &health.Handler{DB: db}
+
+	// Metrics are served on their own port, behind a token, or both.
+	metrics.RegisterDB(db, "carpool")
+	var metricsHandler http.Handler
+	if cfg.Metrics.Port != "" || cfg.Metrics.Token != "" {
+		metricsHandler = metrics.Handler()
+		if cfg.Metrics.Token != "" {
+			metricsHandler = middleware.Chain(metricsHandler, middleware.StaticToken(cfg.Metrics.Token))
+		}
+	}
+	var metricsSrv *http.Server
+	if cfg.Metrics.Port != "" {
+		metricsSrv = newMetricsServer(":"+cfg.Metrics.Port, metricsHandler)
+		// Only the separate port serves metrics.
+		metricsHandler = nil
+	}
+
+	// Expired idempotency keys are ignored, and deleted now and then.
+	idempotencyKeys := &idempotency.Repository{DB: db, Timeouts: timeouts}
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go idempotency.PurgeExpired(purgeCtx, idempotencyKeys, time.Hour)
+
+	handler, err := newHandler(cfg, backends{
+		Keys:          keys,
+		Users:         &user.Repository{DB: db, Timeouts: timeouts},
+		Rides:         &ride.Repository{DB: db, Timeouts: timeouts},
+		Bookings:      &booking.Repository{DB: db, Timeouts: timeouts},
+		Reviews:       &review.Repository{DB: db, Timeouts: timeouts},
+		Vehicles:      &vehicle.Repository{DB: db, Timeouts: timeouts},
+		Verifications: &verification.Repository{DB: db, Timeouts: timeouts},
+		Idempotency:   idempotencyKeys,
+		Media:         newMediaStore(cfg),
+		Mail:          newMailSender(cfg.Mail),
+		ETA: &ride.ORSClient{
+			APIKey:  cfg.ORS.APIKey,
+			BaseURL: cfg.ORS.BaseURL,
+			Timeout: time.Duration(cfg.ORS.Timeout),
+		},
+		Health:  healthHandler,
+		Metrics: metricsHandler,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+	}
+	if err := serve(srv, metricsSrv, cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile, time.Duration(cfg.Server.DrainDelay), time.Duration(cfg.Server.ShutdownTimeout), healthHandler.Drain); err != nil {
+		log.Fatal(err)
+	}
+	db.Close()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Flushing traces: %v", err)
+	}
+}
+
+// backends are what the handler tree is built on besides the
+// configuration: Postgres and openrouteservice in main, memstore and a stub
+// estimator in tests.
+type backends struct {
+	Keys          *token.KeySet
+	Users         user.Store
+	Rides         ride.Store
+	Bookings      booking.Store
+	Reviews       review.Store
+	Vehicles      vehicle.Store
+	Verifications verification.Store
+	Idempotency   idempotency.Store
+	// Media stores uploaded files; a *blob.Local is also served at
+	// GET /media.
+	Media  blob.Store
+	Mail   mail.Sender
+	ETA    ride.DurationEstimator
+	Health *health.Handler
+	// Metrics, if set, is served at GET /metrics on the API port.
+	Metrics http.Handler
+}
 
+// newHandler wires the domain services and handlers together and returns
+// the server's handler.
+func newHandler(cfg *config.Config, b backends) (http.Handler, error) {
 	// Initialize User domain.
-	userRepo := &user.Repository{DB: db}
-	userService := &user.Service{Repo: userRepo}
-	userHandler := &user.Handler{Service: userService, JWTKey: []byte(cfg.JWTSecret)}
+	userService := &user.Service{
+		Repo:            b.Users,
+		Pictures:        b.Media,
+		Mail:            b.Mail,
+		EmailConfirmURL: cfg.Mail.ConfirmEmailURL,
+		EmailChangeTTL:  time.Duration(cfg.Mail.EmailChangeTTL),
+	}
+	userHandler := &user.Handler{
+		Service:         userService,
+		Keys:            b.Keys,
+		TokenLifetime:   time.Duration(cfg.JWT.AccessTokenLifetime),
+		SSOPostLoginURL: cfg.OIDC.PostLoginURL,
+		MaxPictureBytes: int64(cfg.Media.MaxPictureBytes),
+	}
+	if cfg.SSOEnabled() {
+		provider, err := sso.NewProvider(context.Background(), sso.Config{
+			IssuerURL:    cfg.OIDC.IssuerURL,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		userHandler.SSO = provider
+	}
+
+	// Initialize Vehicle domain.
+	vehicleService := &vehicle.Service{Repo: b.Vehicles, Photos: b.Media}
+	vehicleHandler := &vehicle.Handler{
+		Service:       vehicleService,
+		MaxPhotoBytes: int64(cfg.Media.MaxPictureBytes),
+	}
+
+	// Initialize Driver Verification domain.
+	verificationService := &verification.Service{Repo: b.Verifications, Users: b.Users, Mail: b.Mail}
+	verificationHandler := &verification.Handler{
+		Service:          verificationService,
+		MaxDocumentBytes: int64(cfg.Verification.MaxDocumentBytes),
+	}
 
 	// Initialize Ride domain.
-	rideRepo := &ride.Repository{DB: db}
-	rideService := &ride.Service{Repo: rideRepo}
+	rideService := &ride.Service{
+		Repo:                  b.Rides,
+		Vehicles:              b.Vehicles,
+		Users:                 b.Users,
+		Verifications:         b.Verifications,
+		RequireVerifiedDriver: cfg.Verification.Required,
+		ETA:                   b.ETA,
+		SearchWindow:          time.Duration(cfg.Search.TimeWindow),
+		DefaultRadiusKm:       cfg.Search.DefaultRadiusKm,
+		MaxRadiusKm:           cfg.Search.MaxRadiusKm,
+	}
 	rideHandler := &ride.Handler{Service: rideService}
 
 	// Initialize Booking domain.
-	bookingRepo := &booking.Repository{DB: db}
-	bookingService := &booking.Service{Repo: bookingRepo}
+	bookingService := &booking.Service{Repo: b.Bookings, Rides: b.Rides, Users: b.Users}
 	bookingHandler := &booking.Handler{Service: bookingService}
 
-	// Set JWT key for middleware.
-	middleware.SetJWTKey([]byte(cfg.JWTSecret))
-
-	// Routes for User domain.
-	// Assuming userHandler is already initialized
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Hello from the backend!"))
-	})	
-	http.HandleFunc("/register", userHandler.RegisterHandler)
-	http.HandleFunc("/login", userHandler.LoginHandler)
-	http.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			middleware.JWTMiddleware(userHandler.GetProfileHandler, []byte(cfg.JWTSecret))(w, r)
-		} else if r.Method == http.MethodPatch {
-			middleware.JWTMiddleware(userHandler.UpdateProfileHandler, []byte(cfg.JWTSecret))(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-	//http.HandleFunc("/profile/picture", middleware.JWTMiddleware(userHandler.UploadProfilePicture, []byte(cfg.JWTSecret)))
+	// Initialize Review domain and public profiles.
+	reviewService := &review.Service{Repo: b.Reviews}
+	reviewHandler := &review.Handler{Service: reviewService}
+	profileHandler := &profile.Handler{Users: userService, Reviews: reviewService, Verifications: verificationService}
 
-	http.HandleFunc("/profile/password", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPatch {
-			middleware.JWTMiddleware(userHandler.ChangePasswordHandler, []byte(cfg.JWTSecret))(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	// Initialize Account (export and deletion) domain.
+	accountHandler := &account.Handler{
+		Users:         userService,
+		Rides:         rideService,
+		Bookings:      bookingService,
+		Reviews:       reviewService,
+		Vehicles:      vehicleService,
+		Verifications: verificationService,
+	}
 
-	// Routes for Ride domain.
-	http.HandleFunc("/rides", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			// POST requires JWT authentication.
-			middleware.JWTMiddleware(rideHandler.PostRideHandler, []byte(cfg.JWTSecret))(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-	http.HandleFunc("/rides/search", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			// GET is public for search.
-			rideHandler.SearchRidesHandler(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	// Initialize Admin domain.
+	adminHandler := &admin.Handler{
+		Users:         userService,
+		Rides:         rideService,
+		Bookings:      bookingService,
+		Verifications: verificationService,
+	}
 
-	// Serve static files from the "uploads" directory at the "/uploads" path
-	// http.Handle("/uploads/",
-	// 	http.StripPrefix("/uploads/",
-	// 		http.FileServer(http.Dir("./uploads"))))
-
-	// Routes for Booking domain.
-	http.HandleFunc("/bookings", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			middleware.JWTMiddleware(bookingHandler.CreateBookingHandler, []byte(cfg.JWTSecret))(w, r)
-		} else if r.Method == http.MethodGet {
-			middleware.JWTMiddleware(bookingHandler.GetUserBookingsHandler, []byte(cfg.JWTSecret))(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	var media http.Handler
+	if local, ok := b.Media.(*blob.Local); ok {
+		media = local
+	}
 
-	// Set up CORS options.
-	c := cors.New(cors.Options{
-        AllowedOrigins:   []string{"https://carpoolapp-q00v.onrender.com"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowCredentials: true,
-	})
+	// Reject suspended and banned users, and use the stored role, on every
+	// authenticated request.
+	middleware.SetAccountChecker(userService.ActiveRole)
 
-	// Wrap the default mux with the CORS handler.
-	handler := c.Handler(http.DefaultServeMux)
+	return routes.New(routes.Deps{
+		Keys:          b.Keys,
+		Health:        b.Health,
+		Metrics:       b.Metrics,
+		Media:         media,
+		Users:         userHandler,
+		Rides:         rideHandler,
+		Bookings:      bookingHandler,
+		Accounts:      accountHandler,
+		Admin:         adminHandler,
+		Profiles:      profileHandler,
+		Reviews:       reviewHandler,
+		Vehicles:      vehicleHandler,
+		Verifications: verificationHandler,
+		Idempotency: &idempotency.Guard{
+			Store: b.Idempotency,
+			TTL:   time.Duration(cfg.Idempotency.KeyTTL),
+		},
+		CORSOrigins: cfg.CORS.AllowedOrigins,
+		Features:    cfg.Features,
+		Legacy: routes.Legacy{
+			Enabled:         cfg.LegacyAPI.Enabled,
+			DeprecatedSince: cfg.LegacyAPI.DeprecatedSince.Time,
+			Sunset:          cfg.LegacyAPI.Sunset.Time,
+		},
+	}), nil
+}
 
-	// TLS or HTTP fallback.
-	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
-		log.Println("Server started on :8443 with HTTPS")
-		log.Fatal(http.ListenAndServeTLS(":8443", cfg.TLSCertFile, cfg.TLSKeyFile, handler))
-	} else {
-		log.Println("TLS_CERT_FILE or TLS_KEY_FILE not set, falling back to HTTP on :8080")
-		log.Fatal(http.ListenAndServe(":8080", handler))
+// newMediaStore returns the store for uploaded files that cfg selects.
+func newMediaStore(c *config.Config) blob.Store {
+	cfg := c.Media
+	if cfg.Backend == "s3" {
+		return &blob.S3{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PathStyle:       cfg.S3.PathStyle,
+			PublicURL:       cfg.PublicURL,
+		}
+	}
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = "http://localhost:" + c.Server.Port + "/media"
 	}
+	return &blob.Local{Dir: cfg.Dir, BaseURL: cfg.PublicURL}
 }
 
-
-
+// newMailSender returns the sender that cfg selects.
+func newMailSender(cfg config.MailConfig) mail.Sender {
+	if cfg.Backend == "smtp" {
+		return &mail.SMTP{
+			Addr:     cfg.SMTP.Addr,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.From,
+		}
+	}
+	return mail.Log{}
+}
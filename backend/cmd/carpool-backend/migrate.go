@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"carpool/backend/internal/migrate"
+)
+
+const migrateUsage = "usage: carpool-backend migrate up | down [N] | status"
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(db *sql.DB, args []string) error {
+	m, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			log.Printf("Applied %04d_%s", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("down takes a positive number of steps")
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			log.Printf("Reverted %04d_%s", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+	}
+	return errors.New(migrateUsage)
+}
+
+// ensureSchema refuses to start against a database that isn't at the
+// binary's schema version, applying pending migrations first if asked to.
+func ensureSchema(db *sql.DB, migrateOnStart bool) error {
+	m, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	if migrateOnStart {
+		applied, err := m.Up(context.Background())
+		for _, mig := range applied {
+			log.Printf("Applied %04d_%s", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return m.Check(context.Background())
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs srv, and metricsSrv unless it is nil, until SIGINT or
+// SIGTERM, then calls drain, keeps serving for drainDelay so load
+// balancers see readiness fail, and gives in-flight requests up to
+// shutdownTimeout to finish. If either server fails, the other is closed
+// and the error returned.
+func serve(srv, metricsSrv *http.Server, certFile, keyFile string, drainDelay, shutdownTimeout time.Duration, drain func()) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		if certFile != "" && keyFile != "" {
+			log.Printf("Server started on %s with HTTPS", srv.Addr)
+			errCh <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Printf("TLS_CERT_FILE or TLS_KEY_FILE not set, falling back to HTTP on %s", srv.Addr)
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+	// Receiving from a nil channel blocks, so without a metrics server only
+	// srv and the signals are waited for.
+	var metricsErrCh chan error
+	if metricsSrv != nil {
+		metricsErrCh = make(chan error, 1)
+		go func() {
+			log.Printf("Metrics served on %s/metrics", metricsSrv.Addr)
+			metricsErrCh <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if metricsSrv != nil {
+			metricsSrv.Close()
+		}
+		return err
+	case err := <-metricsErrCh:
+		srv.Close()
+		return fmt.Errorf("metrics server: %w", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("Draining for %s before shutting down", drainDelay)
+	drain()
+	time.Sleep(drainDelay)
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// newMetricsServer serves h at /metrics on its own listener, so the port
+// can be kept off the public network.
+func newMetricsServer(addr string, h http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", h)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
@@ -0,0 +1,28 @@
+// Command mock-oidc runs a local OpenID Connect issuer that signs every
+// login in as a fixed user, for developing the single sign-on flow without
+// a real identity provider.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"carpool/backend/internal/sso/ssotest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9999", "listen address")
+	email := flag.String("email", "mock.user@example.com", "email asserted for the signed-in user")
+	name := flag.String("name", "Mock User", "name asserted for the signed-in user")
+	flag.Parse()
+
+	issuer, err := ssotest.NewIssuer("http://" + *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	issuer.SetUser(ssotest.User{Subject: "mock-" + *email, Email: *email, EmailVerified: true, Name: *name})
+
+	log.Printf("Mock issuer %s (client_id=%s client_secret=%s)", issuer.URL, issuer.ClientID, issuer.ClientSecret)
+	log.Fatal(http.ListenAndServe(*addr, issuer))
+}
@@ -0,0 +1,21 @@
+// Command mock-s3 runs a local S3-compatible server that keeps objects in
+// memory, for developing uploads without a real bucket.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"carpool/backend/internal/blob/blobtest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "listen address")
+	flag.Parse()
+
+	s := blobtest.NewS3("http://" + *addr)
+	log.Printf("Mock S3 %s (region=%s access_key_id=%s secret_access_key=%s, path-style)",
+		s.URL, s.Region, s.AccessKeyID, s.SecretAccessKey)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
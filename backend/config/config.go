@@ -1,42 +1,496 @@
+// Package config loads the server configuration from an optional JSON file
+// and environment variables, which take precedence over the file.
 package config
 
 import (
-	"database/sql"
-	"log"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"carpool/backend/internal/deadline"
+	"carpool/backend/internal/validation"
 )
 
 type Config struct {
-	JWTSecret   string
-	TLSCertFile string
-	TLSKeyFile  string
+	Server       ServerConfig       `json:"server"`
+	Database     DatabaseConfig     `json:"database"`
+	JWT          JWTConfig          `json:"jwt"`
+	CORS         CORSConfig         `json:"cors"`
+	OIDC         OIDCConfig         `json:"oidc"`
+	ORS          ORSConfig          `json:"ors"`
+	Search       SearchConfig       `json:"search"`
+	Idempotency  IdempotencyConfig  `json:"idempotency"`
+	Media        MediaConfig        `json:"media"`
+	Mail         MailConfig         `json:"mail"`
+	Verification VerificationConfig `json:"verification"`
+	Metrics      MetricsConfig      `json:"metrics"`
+	Tracing      TracingConfig      `json:"tracing"`
+	Features     Features           `json:"features"`
+	LegacyAPI    LegacyAPIConfig    `json:"legacy_api"`
 }
 
-func LoadConfig() *Config {
-	cfg := &Config{
-		JWTSecret:   os.Getenv("JWT_SECRET"),
-		TLSCertFile: os.Getenv("TLS_CERT_FILE"),
-		TLSKeyFile:  os.Getenv("TLS_KEY_FILE"),
+type ServerConfig struct {
+	// Port defaults to 8443 with TLS and 8080 without. Render sets PORT.
+	Port         string   `json:"port"`
+	TLSCertFile  string   `json:"tls_cert_file"`
+	TLSKeyFile   string   `json:"tls_key_file"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	IdleTimeout  Duration `json:"idle_timeout"`
+	// DrainDelay is how long the server keeps serving after SIGTERM with
+	// /readyz failing, so load balancers stop routing to it first.
+	DrainDelay Duration `json:"drain_delay"`
+	// ShutdownTimeout is how long in-flight requests get to finish after
+	// the drain delay.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	URL             string   `json:"url"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time"`
+	// QueryTimeout bounds each repository operation. OperationTimeouts
+	// override it by name, such as "ride.SearchRidesFiltered".
+	QueryTimeout      Duration            `json:"query_timeout"`
+	OperationTimeouts map[string]Duration `json:"operation_timeouts"`
+	// MigrateOnStart applies pending migrations before serving instead of
+	// refusing to start.
+	MigrateOnStart bool `json:"migrate_on_start"`
+}
+
+type JWTConfig struct {
+	Secret string `json:"secret"`
+	// PreviousSecrets are retired HS256 secrets still accepted so that
+	// rotating the secret doesn't log everyone out.
+	PreviousSecrets []string `json:"previous_secrets"`
+	// SigningKeyFile, if set, is a PEM RSA or Ed25519 private key used to
+	// sign tokens with RS256 or EdDSA instead of Secret.
+	SigningKeyFile string `json:"signing_key_file"`
+	// VerifyKeyFiles are PEM public keys of retired signing keys.
+	VerifyKeyFiles      []string `json:"verify_key_files"`
+	AccessTokenLifetime Duration `json:"access_token_lifetime"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// OIDCConfig configures OpenID Connect single sign-on. It is disabled when
+// IssuerURL is empty.
+type OIDCConfig struct {
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	// PostLoginURL is the frontend page that receives the app token.
+	PostLoginURL string `json:"post_login_url"`
+}
+
+// ORSConfig configures the openrouteservice client used for ride ETAs.
+// Without an API key rides are saved without an estimate.
+type ORSConfig struct {
+	APIKey  string   `json:"api_key"`
+	BaseURL string   `json:"base_url"`
+	Timeout Duration `json:"timeout"`
+}
+
+type SearchConfig struct {
+	// TimeWindow is how far either side of the requested time a ride may
+	// depart and still match.
+	TimeWindow      Duration `json:"time_window"`
+	DefaultRadiusKm int      `json:"default_radius_km"`
+	MaxRadiusKm     int      `json:"max_radius_km"`
+}
+
+type IdempotencyConfig struct {
+	// KeyTTL is how long the response to a request with an Idempotency-Key
+	// is kept for retries.
+	KeyTTL Duration `json:"key_ttl"`
+}
+
+// MediaConfig says where uploaded files such as profile pictures are
+// stored: under Dir, served by this server at /media, or in an
+// S3-compatible bucket.
+type MediaConfig struct {
+	// Backend is "local" or "s3".
+	Backend string `json:"backend"`
+	Dir     string `json:"dir"`
+	// PublicURL is the base of the URLs handed to clients: where this
+	// server's /media is reachable for the local backend (by default on
+	// localhost), or a CDN in front of the bucket for s3 (by default the
+	// bucket itself).
+	PublicURL       string   `json:"public_url"`
+	MaxPictureBytes int      `json:"max_picture_bytes"`
+	S3              S3Config `json:"s3"`
+}
+
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	// PathStyle addresses the bucket as Endpoint/Bucket, as MinIO expects.
+	PathStyle bool `json:"path_style"`
+}
+
+// MailConfig says how email, such as the link confirming a new address,
+// is sent: written to the log ("log") or through an SMTP server ("smtp").
+type MailConfig struct {
+	Backend string     `json:"backend"`
+	From    string     `json:"from"`
+	SMTP    SMTPConfig `json:"smtp"`
+	// ConfirmEmailURL is the frontend page that confirms a new email
+	// address; the link adds the token as its "token" query parameter.
+	ConfirmEmailURL string   `json:"confirm_email_url"`
+	EmailChangeTTL  Duration `json:"email_change_ttl"`
+}
+
+type SMTPConfig struct {
+	// Addr is the server's host:port, such as "smtp.example.com:587".
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// VerificationConfig controls the review of drivers' license and
+// insurance. With Required, only verified drivers may post rides.
+type VerificationConfig struct {
+	Required         bool `json:"required"`
+	MaxDocumentBytes int  `json:"max_document_bytes"`
+}
+
+// MetricsConfig controls how /metrics is exposed: on its own port, which
+// should not be publicly reachable, and/or behind a bearer token. With
+// neither set the endpoint is not served.
+type MetricsConfig struct {
+	Port  string `json:"port"`
+	Token string `json:"token"`
+}
+
+// TracingConfig selects where OpenTelemetry spans go: "none", "stdout" or
+// "otlp" (OTLP over HTTP).
+type TracingConfig struct {
+	Exporter     string  `json:"exporter"`
+	ServiceName  string  `json:"service_name"`
+	OTLPEndpoint string  `json:"otlp_endpoint"`
+	SampleRatio  float64 `json:"sample_ratio"`
+}
+
+// Features switch optional parts of the API on and off.
+type Features struct {
+	Registration    bool `json:"registration"`
+	TwoFactor       bool `json:"two_factor"`
+	SSO             bool `json:"sso"`
+	DataExport      bool `json:"data_export"`
+	AccountDeletion bool `json:"account_deletion"`
+}
+
+// LegacyAPIConfig controls the unversioned aliases of the /v1 routes kept
+// for clients that predate versioning. Their responses announce
+// DeprecatedSince and Sunset; turn them off once Sunset has passed.
+type LegacyAPIConfig struct {
+	Enabled         bool `json:"enabled"`
+	DeprecatedSince Date `json:"deprecated_since"`
+	Sunset          Date `json:"sunset"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in the
+// config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
 	}
-	if cfg.JWTSecret == "" {
-		log.Fatal("JWT_SECRET environment variable not set")
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
 	}
-	return cfg
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Date is a day written as "2006-01-02" in the config file. The zero value
+// means unset.
+type Date struct {
+	time.Time
 }
 
-func ConnectDB() *sql.DB {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL environment variable not set")
+// dateLayout is how dates are written in the file and environment.
+const dateLayout = "2006-01-02"
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("date must be a string like \"2006-01-02\"")
+	}
+	if s == "" {
+		*d = Date{}
+		return nil
 	}
-	db, err := sql.Open("postgres", connStr)
+	parsed, err := time.Parse(dateLayout, s)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	*d = Date{parsed}
+	return nil
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return json.Marshal("")
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+// Defaults returns the configuration used for anything the file and
+// environment leave unset.
+func Defaults() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ReadTimeout:     Duration(15 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(120 * time.Second),
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(25 * time.Second),
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+			ConnMaxIdleTime: Duration(5 * time.Minute),
+			QueryTimeout:    Duration(5 * time.Second),
+			OperationTimeouts: map[string]Duration{
+				"ride.SearchRidesFiltered": Duration(10 * time.Second),
+				"user.AnonymizeUser":       Duration(15 * time.Second),
+			},
+		},
+		JWT: JWTConfig{
+			AccessTokenLifetime: Duration(time.Hour),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"https://carpoolapp-q00v.onrender.com"},
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"email", "profile"},
+		},
+		ORS: ORSConfig{
+			BaseURL: "https://api.openrouteservice.org",
+			Timeout: Duration(10 * time.Second),
+		},
+		Search: SearchConfig{
+			TimeWindow:      Duration(time.Hour),
+			DefaultRadiusKm: 5,
+			MaxRadiusKm:     100,
+		},
+		Idempotency: IdempotencyConfig{
+			KeyTTL: Duration(24 * time.Hour),
+		},
+		Media: MediaConfig{
+			Backend:         "local",
+			Dir:             "media",
+			MaxPictureBytes: 5 << 20,
+		},
+		Mail: MailConfig{
+			Backend:         "log",
+			From:            "Carpool <no-reply@localhost>",
+			ConfirmEmailURL: "http://localhost:3000/confirm-email",
+			EmailChangeTTL:  Duration(24 * time.Hour),
+		},
+		Verification: VerificationConfig{
+			MaxDocumentBytes: 10 << 20,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "carpool-backend",
+			SampleRatio: 1,
+		},
+		Features: Features{
+			Registration:    true,
+			TwoFactor:       true,
+			SSO:             true,
+			DataExport:      true,
+			AccountDeletion: true,
+		},
+		LegacyAPI: LegacyAPIConfig{
+			Enabled:         true,
+			DeprecatedSince: Date{time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)},
+			Sunset:          Date{time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+}
+
+// Load reads the JSON file at path, if path isn't empty, then applies
+// environment variables on top of Defaults. It reports malformed values but
+// doesn't call Validate.
+func Load(path string) (*Config, error) {
+	cfg := Defaults()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+	env := &envReader{}
+	env.applyTo(cfg)
+	if errs := env.v.Errors(); errs != nil {
+		return nil, fmt.Errorf("invalid environment: %w", errs)
+	}
+	if cfg.Server.Port == "" {
+		cfg.Server.Port = "8080"
+		if cfg.TLSEnabled() {
+			cfg.Server.Port = "8443"
+		}
+	}
+	return cfg, nil
+}
+
+func (m *MediaConfig) validate(v *validation.Validator) {
+	switch m.Backend {
+	case "local":
+		v.Check(m.Dir != "", "MEDIA_DIR", "must be set for the local backend")
+	case "s3":
+		v.Check(m.S3.Endpoint != "", "S3_ENDPOINT", "must be set for the s3 backend")
+		v.Check(m.S3.Region != "", "S3_REGION", "must be set for the s3 backend")
+		v.Check(m.S3.Bucket != "", "S3_BUCKET", "must be set for the s3 backend")
+		v.Check(m.S3.AccessKeyID != "" && m.S3.SecretAccessKey != "", "S3_ACCESS_KEY_ID", "must be set with S3_SECRET_ACCESS_KEY for the s3 backend")
+	default:
+		v.Check(false, "MEDIA_BACKEND", "must be local or s3")
+	}
+	if m.PublicURL != "" {
+		u, err := url.Parse(m.PublicURL)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "", "MEDIA_PUBLIC_URL", "must be an absolute URL")
+	}
+	v.Check(m.MaxPictureBytes > 0, "MEDIA_MAX_PICTURE_BYTES", "must be positive")
+}
+
+func (m *MailConfig) validate(v *validation.Validator) {
+	switch m.Backend {
+	case "log":
+	case "smtp":
+		v.Check(m.SMTP.Addr != "", "SMTP_ADDR", "must be set for the smtp backend")
+	default:
+		v.Check(false, "MAIL_BACKEND", "must be log or smtp")
 	}
-	if err = db.Ping(); err != nil {
-		log.Fatal("Cannot connect to database:", err)
+	_, err := mail.ParseAddress(m.From)
+	v.Check(err == nil, "MAIL_FROM", "must be an address such as \"Carpool <no-reply@example.com>\"")
+	u, err := url.Parse(m.ConfirmEmailURL)
+	v.Check(err == nil && u.Scheme != "" && u.Host != "", "EMAIL_CONFIRM_URL", "must be an absolute URL")
+	v.Check(m.EmailChangeTTL > 0, "EMAIL_CHANGE_TTL", "must be positive")
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.Server.TLSCertFile != "" && c.Server.TLSKeyFile != ""
+}
+
+// SSOEnabled reports whether OpenID Connect sign-in is configured and
+// switched on.
+func (c *Config) SSOEnabled() bool {
+	return c.Features.SSO && c.OIDC.IssuerURL != ""
+}
+
+// Validate checks everything the server needs to start. Problems are keyed
+// by the environment variable that sets the value.
+func (c *Config) Validate() error {
+	v := &validation.Validator{}
+	c.Database.validate(v)
+	v.Check(c.JWT.Secret != "" || c.JWT.SigningKeyFile != "", "JWT_SECRET", "must be set unless JWT_SIGNING_KEY_FILE is")
+	v.Check(c.JWT.AccessTokenLifetime > 0, "ACCESS_TOKEN_LIFETIME", "must be positive")
+	v.Check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "TLS_CERT_FILE", "must be set together with TLS_KEY_FILE")
+	port, err := strconv.Atoi(c.Server.Port)
+	v.Check(err == nil && port > 0 && port < 65536, "PORT", "must be a port number")
+	v.Check(c.Server.ReadTimeout > 0, "HTTP_READ_TIMEOUT", "must be positive")
+	v.Check(c.Server.WriteTimeout > 0, "HTTP_WRITE_TIMEOUT", "must be positive")
+	v.Check(c.Server.IdleTimeout > 0, "HTTP_IDLE_TIMEOUT", "must be positive")
+	v.Check(c.Server.DrainDelay >= 0, "DRAIN_DELAY", "must not be negative")
+	v.Check(c.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT", "must be positive")
+	for _, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(origin == "*" || (err == nil && u.Scheme != "" && u.Host != ""), "CORS_ALLOWED_ORIGINS", "must be absolute origins like https://example.com")
+	}
+	if c.OIDC.IssuerURL != "" {
+		v.Check(c.OIDC.ClientID != "", "OIDC_CLIENT_ID", "must be set when OIDC_ISSUER_URL is set")
+		v.Check(c.OIDC.RedirectURL != "", "OIDC_REDIRECT_URL", "must be set when OIDC_ISSUER_URL is set")
+	}
+	_, err = url.Parse(c.ORS.BaseURL)
+	v.Check(c.ORS.BaseURL != "" && err == nil, "ORS_BASE_URL", "must be a URL")
+	v.Check(c.ORS.Timeout > 0, "ORS_TIMEOUT", "must be positive")
+	v.Check(c.Search.TimeWindow > 0, "SEARCH_TIME_WINDOW", "must be positive")
+	v.Check(c.Search.DefaultRadiusKm > 0, "SEARCH_DEFAULT_RADIUS_KM", "must be positive")
+	v.Check(c.Search.MaxRadiusKm >= c.Search.DefaultRadiusKm, "SEARCH_MAX_RADIUS_KM", "must be at least SEARCH_DEFAULT_RADIUS_KM")
+	v.Check(c.Idempotency.KeyTTL > 0, "IDEMPOTENCY_KEY_TTL", "must be positive")
+	c.Media.validate(v)
+	c.Mail.validate(v)
+	v.Check(c.Verification.MaxDocumentBytes > 0, "VERIFICATION_MAX_DOCUMENT_BYTES", "must be positive")
+	if c.Metrics.Port != "" {
+		port, err := strconv.Atoi(c.Metrics.Port)
+		v.Check(err == nil && port > 0 && port < 65536, "METRICS_PORT", "must be a port number")
+		v.Check(c.Metrics.Port != c.Server.Port, "METRICS_PORT", "must differ from PORT")
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		v.Check(false, "TRACING_EXPORTER", "must be none, stdout or otlp")
+	}
+	v.Check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+	if c.LegacyAPI.Enabled && !c.LegacyAPI.DeprecatedSince.IsZero() {
+		v.Check(c.LegacyAPI.Sunset.IsZero() || c.LegacyAPI.Sunset.After(c.LegacyAPI.DeprecatedSince.Time), "LEGACY_API_SUNSET", "must be after LEGACY_API_DEPRECATED_SINCE")
+	}
+	if errs := v.Errors(); errs != nil {
+		return fmt.Errorf("invalid configuration: %w", errs)
+	}
+	return nil
+}
+
+// ValidateDatabase checks only what connecting to the database needs, for
+// commands such as migrate.
+func (c *Config) ValidateDatabase() error {
+	v := &validation.Validator{}
+	c.Database.validate(v)
+	if errs := v.Errors(); errs != nil {
+		return fmt.Errorf("invalid configuration: %w", errs)
+	}
+	return nil
+}
+
+func (d *DatabaseConfig) validate(v *validation.Validator) {
+	v.Check(d.URL != "", "DATABASE_URL", "must be set")
+	v.Check(d.MaxOpenConns > 0, "DB_MAX_OPEN_CONNS", "must be positive")
+	v.Check(d.MaxIdleConns >= 0 && d.MaxIdleConns <= d.MaxOpenConns, "DB_MAX_IDLE_CONNS", "must be between 0 and DB_MAX_OPEN_CONNS")
+	v.Check(d.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME", "must not be negative")
+	v.Check(d.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME", "must not be negative")
+	v.Check(d.QueryTimeout >= 0, "DB_QUERY_TIMEOUT", "must not be negative")
+	for op, timeout := range d.OperationTimeouts {
+		v.Check(strings.Count(op, ".") == 1 && timeout >= 0, "DB_OPERATION_TIMEOUTS", "entries must look like ride.SearchRidesFiltered=10s")
+	}
+}
+
+// Timeouts converts the query timeouts for the repositories.
+func (d *DatabaseConfig) Timeouts() deadline.Timeouts {
+	t := deadline.Timeouts{Default: time.Duration(d.QueryTimeout), Operations: map[string]time.Duration{}}
+	for op, timeout := range d.OperationTimeouts {
+		t.Operations[op] = time.Duration(timeout)
 	}
-	return db
+	return t
 }
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"carpool/backend/internal/validation"
+)
+
+// envReader overrides config values with the environment variables that
+// are set, recording any that don't parse.
+type envReader struct {
+	v validation.Validator
+}
+
+func (e *envReader) applyTo(c *Config) {
+	e.string("PORT", &c.Server.Port)
+	e.string("TLS_CERT_FILE", &c.Server.TLSCertFile)
+	e.string("TLS_KEY_FILE", &c.Server.TLSKeyFile)
+	e.duration("HTTP_READ_TIMEOUT", &c.Server.ReadTimeout)
+	e.duration("HTTP_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	e.duration("HTTP_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	e.duration("DRAIN_DELAY", &c.Server.DrainDelay)
+	e.duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	e.string("DATABASE_URL", &c.Database.URL)
+	e.int("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	e.int("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	e.duration("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	e.duration("DB_CONN_MAX_IDLE_TIME", &c.Database.ConnMaxIdleTime)
+	e.duration("DB_QUERY_TIMEOUT", &c.Database.QueryTimeout)
+	e.durationMap("DB_OPERATION_TIMEOUTS", &c.Database.OperationTimeouts)
+	e.bool("MIGRATE_ON_START", &c.Database.MigrateOnStart)
+
+	e.string("JWT_SECRET", &c.JWT.Secret)
+	e.list("JWT_PREVIOUS_SECRETS", &c.JWT.PreviousSecrets)
+	e.string("JWT_SIGNING_KEY_FILE", &c.JWT.SigningKeyFile)
+	e.list("JWT_VERIFY_KEY_FILES", &c.JWT.VerifyKeyFiles)
+	e.duration("ACCESS_TOKEN_LIFETIME", &c.JWT.AccessTokenLifetime)
+
+	e.list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+
+	e.string("OIDC_ISSUER_URL", &c.OIDC.IssuerURL)
+	e.string("OIDC_CLIENT_ID", &c.OIDC.ClientID)
+	e.string("OIDC_CLIENT_SECRET", &c.OIDC.ClientSecret)
+	e.string("OIDC_REDIRECT_URL", &c.OIDC.RedirectURL)
+	e.list("OIDC_SCOPES", &c.OIDC.Scopes)
+	e.string("OIDC_POST_LOGIN_URL", &c.OIDC.PostLoginURL)
+
+	e.string("ORS_API_KEY", &c.ORS.APIKey)
+	e.string("ORS_BASE_URL", &c.ORS.BaseURL)
+	e.duration("ORS_TIMEOUT", &c.ORS.Timeout)
+
+	e.duration("SEARCH_TIME_WINDOW", &c.Search.TimeWindow)
+	e.int("SEARCH_DEFAULT_RADIUS_KM", &c.Search.DefaultRadiusKm)
+	e.int("SEARCH_MAX_RADIUS_KM", &c.Search.MaxRadiusKm)
+
+	e.duration("IDEMPOTENCY_KEY_TTL", &c.Idempotency.KeyTTL)
+
+	e.string("MEDIA_BACKEND", &c.Media.Backend)
+	e.string("MEDIA_DIR", &c.Media.Dir)
+	e.string("MEDIA_PUBLIC_URL", &c.Media.PublicURL)
+	e.int("MEDIA_MAX_PICTURE_BYTES", &c.Media.MaxPictureBytes)
+	e.string("S3_ENDPOINT", &c.Media.S3.Endpoint)
+	e.string("S3_REGION", &c.Media.S3.Region)
+	e.string("S3_BUCKET", &c.Media.S3.Bucket)
+	e.string("S3_ACCESS_KEY_ID", &c.Media.S3.AccessKeyID)
+	e.string("S3_SECRET_ACCESS_KEY", &c.Media.S3.SecretAccessKey)
+	e.bool("S3_PATH_STYLE", &c.Media.S3.PathStyle)
+
+	e.string("MAIL_BACKEND", &c.Mail.Backend)
+	e.string("MAIL_FROM", &c.Mail.From)
+	e.string("SMTP_ADDR", &c.Mail.SMTP.Addr)
+	e.string("SMTP_USERNAME", &c.Mail.SMTP.Username)
+	e.string("SMTP_PASSWORD", &c.Mail.SMTP.Password)
+	e.string("EMAIL_CONFIRM_URL", &c.Mail.ConfirmEmailURL)
+	e.duration("EMAIL_CHANGE_TTL", &c.Mail.EmailChangeTTL)
+
+	e.bool("VERIFICATION_REQUIRED", &c.Verification.Required)
+	e.int("VERIFICATION_MAX_DOCUMENT_BYTES", &c.Verification.MaxDocumentBytes)
+
+	e.string("METRICS_PORT", &c.Metrics.Port)
+	e.string("METRICS_TOKEN", &c.Metrics.Token)
+
+	e.string("TRACING_EXPORTER", &c.Tracing.Exporter)
+	e.string("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	e.string("TRACING_OTLP_ENDPOINT", &c.Tracing.OTLPEndpoint)
+	e.float("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+
+	e.bool("FEATURE_REGISTRATION", &c.Features.Registration)
+	e.bool("FEATURE_TWO_FACTOR", &c.Features.TwoFactor)
+	e.bool("FEATURE_SSO", &c.Features.SSO)
+	e.bool("FEATURE_DATA_EXPORT", &c.Features.DataExport)
+	e.bool("FEATURE_ACCOUNT_DELETION", &c.Features.AccountDeletion)
+
+	e.bool("LEGACY_API_ENABLED", &c.LegacyAPI.Enabled)
+	e.date("LEGACY_API_DEPRECATED_SINCE", &c.LegacyAPI.DeprecatedSince)
+	e.date("LEGACY_API_SUNSET", &c.LegacyAPI.Sunset)
+}
+
+func (e *envReader) string(name string, dst *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = value
+	}
+}
+
+// list splits a comma-separated value, dropping empty entries.
+func (e *envReader) list(name string, dst *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+func (e *envReader) int(name string, dst *int) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	e.v.Check(err == nil, name, "must be a whole number, got "+strconv.Quote(value))
+	if err == nil {
+		*dst = n
+	}
+}
+
+func (e *envReader) float(name string, dst *float64) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	e.v.Check(err == nil, name, "must be a number, got "+strconv.Quote(value))
+	if err == nil {
+		*dst = f
+	}
+}
+
+func (e *envReader) bool(name string, dst *bool) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	e.v.Check(err == nil, name, "must be true or false, got "+strconv.Quote(value))
+	if err == nil {
+		*dst = b
+	}
+}
+
+func (e *envReader) duration(name string, dst *Duration) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	e.v.Check(err == nil, name, "must be a duration like 30s, got "+strconv.Quote(value))
+	if err == nil {
+		*dst = Duration(d)
+	}
+}
+
+// date reads a day like 2006-01-02. An empty value clears it.
+func (e *envReader) date(name string, dst *Date) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	if value == "" {
+		*dst = Date{}
+		return
+	}
+	d, err := time.Parse(dateLayout, value)
+	e.v.Check(err == nil, name, "must be a date like 2006-01-02, got "+strconv.Quote(value))
+	if err == nil {
+		*dst = Date{d}
+	}
+}
+
+// durationMap reads "name=30s,other=1m", adding to or replacing entries
+// already in dst.
+func (e *envReader) durationMap(name string, dst *map[string]Duration) {
+	var entries []string
+	e.list(name, &entries)
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		e.v.Check(ok && err == nil, name, "must be a list like ride.SearchRidesFiltered=10s, got "+strconv.Quote(entry))
+		if !ok || err != nil {
+			continue
+		}
+		if *dst == nil {
+			*dst = map[string]Duration{}
+		}
+		(*dst)[strings.TrimSpace(key)] = Duration(d)
+	}
+}
@@ -0,0 +1,147 @@
+// Package account lets users take their data with them or leave.
+package account
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+)
+
+// recentLogin is how fresh a token must be to delete an account without
+// re-entering the password, which users signed in through SSO may not have.
+const recentLogin = 5 * time.Minute
+
+type Handler struct {
+	Users         *user.Service
+	Rides         *ride.Service
+	Bookings      *booking.Service
+	Reviews       *review.Service
+	Vehicles      *vehicle.Service
+	Verifications *verification.Service
+}
+
+// ExportHandler returns a zip archive with one JSON file per kind of data
+// held about the user.
+func (h *Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+
+	profile, err := h.Users.GetUserByID(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	profile.Password = ""
+	identities, err := h.Users.ListIdentities(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	preferences, err := h.Users.GetPreferences(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	rides, err := h.Rides.GetRidesByUser(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	bookings, err := h.Bookings.GetUserBookings(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	reviews, err := h.Reviews.UserReviews(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	vehicles, err := h.Vehicles.ListVehicles(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	verification, err := h.Verifications.GetVerification(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profile},
+		{"identities.json", identities},
+		{"preferences.json", preferences},
+		{"rides.json", rides},
+		{"bookings.json", bookings},
+		{"reviews.json", reviews},
+		{"vehicles.json", vehicles},
+		{"verification.json", verification},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="carpool-export-%d.zip"`, userID))
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
+
+// DeleteHandler anonymizes the account. The request body must contain the
+// current password unless the token was issued within the last few minutes.
+func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	var req struct {
+		Password string `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := h.Users.VerifyPassword(r.Context(), userID, req.Password); err != nil {
+			apperr.Write(w, r, err)
+			return
+		}
+	} else if time.Since(middleware.GetIssuedAtFromContext(r.Context())) > recentLogin {
+		apperr.Write(w, r, apperr.Forbidden("Confirm your password or sign in again to delete your account"))
+		return
+	}
+	// Anonymizing deletes the vehicle rows, so their photos are looked up
+	// first.
+	vehicles, err := h.Vehicles.ListVehicles(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if err := h.Users.DeleteAccount(r.Context(), userID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	h.Vehicles.DeletePhotoFiles(r.Context(), vehicles)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,187 @@
+// Package admin exposes moderation endpoints. Every route must be wrapped
+// with middleware.RequireRole(..., user.RoleAdmin).
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/verification"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type Handler struct {
+	Users         *user.Service
+	Rides         *ride.Service
+	Bookings      *booking.Service
+	Verifications *verification.Service
+}
+
+// ListUsersHandler lists users, optionally filtered by ?q= (name or email)
+// and ?status=, paginated with ?limit= and ?offset=.
+func (h *Handler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, maxPageSize)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(query.Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+	users, err := h.Users.SearchUsers(r.Context(), query.Get("q"), query.Get("status"), limit, offset)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+// UpdateUserStatusHandler suspends, bans or reinstates the user {id}.
+func (h *Handler) UpdateUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid user id"))
+		return
+	}
+	var req struct {
+		Status string  `json:"status"`
+		Reason *string `json:"reason,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+		return
+	}
+	if userID == middleware.GetUserIDFromContext(r.Context()) {
+		apperr.Write(w, r, apperr.Forbidden("Admins cannot change their own status"))
+		return
+	}
+	if err := h.Users.SetStatus(r.Context(), userID, req.Status, req.Reason); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveRideHandler takes down the ride {id} and cancels its bookings.
+func (h *Handler) RemoveRideHandler(w http.ResponseWriter, r *http.Request) {
+	rideID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid ride id"))
+		return
+	}
+	if err := h.Rides.RemoveRide(r.Context(), rideID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveBookingHandler cancels the booking {id}.
+func (h *Handler) RemoveBookingHandler(w http.ResponseWriter, r *http.Request) {
+	bookingID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid booking id"))
+		return
+	}
+	if err := h.Bookings.RemoveBooking(r.Context(), bookingID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListVerificationsHandler lists driver verifications with ?status=,
+// pending by default, oldest submission first.
+func (h *Handler) ListVerificationsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = verification.StatusPending
+	}
+	verifications, err := h.Verifications.ListVerifications(r.Context(), status)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(verifications)
+}
+
+// GetVerificationHandler returns the verification of the user {id} with
+// the documents they uploaded.
+func (h *Handler) GetVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid user id"))
+		return
+	}
+	if _, err := h.Users.GetUserByID(r.Context(), userID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	v, err := h.Verifications.GetVerification(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// GetVerificationDocumentHandler downloads the {kind} document of the
+// user {id}.
+func (h *Handler) GetVerificationDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid user id"))
+		return
+	}
+	doc, err := h.Verifications.GetDocument(r.Context(), userID, r.PathValue("kind"))
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, doc.Filename()))
+	// Identity documents must not linger in shared caches.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(doc.Data)
+}
+
+// DecideVerificationHandler approves or rejects the pending verification
+// of the user {id}.
+func (h *Handler) DecideVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid user id"))
+		return
+	}
+	var req struct {
+		Status string  `json:"status"`
+		Reason *string `json:"reason,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+		return
+	}
+	reviewerID := middleware.GetUserIDFromContext(r.Context())
+	if userID == reviewerID {
+		apperr.Write(w, r, apperr.Forbidden("Admins cannot decide their own verification"))
+		return
+	}
+	if err := h.Verifications.Decide(r.Context(), userID, reviewerID, req.Status, req.Reason); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+// Package apperr defines the error kinds services return and renders them
+// as RFC 7807 problem documents.
+package apperr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"carpool/backend/internal/validation"
+)
+
+// Kind classifies an Error and decides its HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindBadRequest
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindTooLarge
+	KindUnsupportedMediaType
+	KindTooManyRequests
+)
+
+var statusByKind = map[Kind]int{
+	KindInternal:             http.StatusInternalServerError,
+	KindBadRequest:           http.StatusBadRequest,
+	KindUnauthorized:         http.StatusUnauthorized,
+	KindForbidden:            http.StatusForbidden,
+	KindNotFound:             http.StatusNotFound,
+	KindConflict:             http.StatusConflict,
+	KindValidation:           http.StatusUnprocessableEntity,
+	KindTooLarge:             http.StatusRequestEntityTooLarge,
+	KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	KindTooManyRequests:      http.StatusTooManyRequests,
+}
+
+// Error is a domain error. Message is shown to clients; Err is only logged.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  validation.Errors
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func BadRequest(msg string) *Error {
+	return &Error{Kind: KindBadRequest, Message: msg}
+}
+
+func Unauthorized(msg string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: msg}
+}
+
+func Forbidden(msg string) *Error {
+	return &Error{Kind: KindForbidden, Message: msg}
+}
+
+func NotFound(msg string) *Error {
+	return &Error{Kind: KindNotFound, Message: msg}
+}
+
+func Conflict(msg string) *Error {
+	return &Error{Kind: KindConflict, Message: msg}
+}
+
+// TooLarge rejects an upload over the size limit.
+func TooLarge(msg string) *Error {
+	return &Error{Kind: KindTooLarge, Message: msg}
+}
+
+// UnsupportedMediaType rejects an upload of a type the endpoint doesn't
+// accept.
+func UnsupportedMediaType(msg string) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Message: msg}
+}
+
+// TooManyRequests rejects a request after too many failed attempts.
+func TooManyRequests(msg string) *Error {
+	return &Error{Kind: KindTooManyRequests, Message: msg}
+}
+
+// Validation wraps field-level errors.
+func Validation(fields validation.Errors) *Error {
+	return &Error{Kind: KindValidation, Message: "Validation failed", Fields: fields}
+}
+
+// KindOf returns the Kind of err, treating bare validation.Errors as
+// KindValidation and anything unrecognised as KindInternal.
+func KindOf(err error) Kind {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+	if _, ok := validation.AsErrors(err); ok {
+		return KindValidation
+	}
+	return KindInternal
+}
+
+// Problem is an RFC 7807 problem document.
+type Problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Errors   validation.Errors `json:"errors,omitempty"`
+}
+
+// Write renders err as a problem document. Internal errors are logged and
+// their details withheld from the client.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := Problem{Instance: r.URL.Path}
+	var appErr *Error
+	switch {
+	case errors.As(err, &appErr):
+		p.Status = statusByKind[appErr.Kind]
+		p.Detail = appErr.Message
+		p.Errors = appErr.Fields
+		if appErr.Kind == KindInternal {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			p.Detail = "An unexpected error occurred"
+		}
+	default:
+		if fields, ok := validation.AsErrors(err); ok {
+			p.Status = http.StatusUnprocessableEntity
+			p.Detail = "Validation failed"
+			p.Errors = fields
+			break
+		}
+		if isTimeout(err) {
+			if r.Context().Err() != nil {
+				// The client went away; nobody will read the response.
+				log.Printf("%s %s: cancelled by client", r.Method, r.URL.Path)
+			} else {
+				log.Printf("%s %s: timed out: %v", r.Method, r.URL.Path, err)
+			}
+			p.Status = http.StatusGatewayTimeout
+			p.Detail = "The request took too long to process"
+			break
+		}
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		p.Status = http.StatusInternalServerError
+		p.Detail = "An unexpected error occurred"
+	}
+	writeProblem(w, p)
+}
+
+// WriteStatus renders a problem document for transport-level failures
+// that don't originate in a service, such as an unsupported method.
+func WriteStatus(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, Problem{Status: status, Detail: detail, Instance: r.URL.Path})
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// queryCanceled is the Postgres error code for a statement cancelled by a
+// context or statement_timeout.
+const queryCanceled = "57014"
+
+// isTimeout reports whether err comes from a context deadline or
+// cancellation, including a query Postgres cancelled because of one.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var sqlErr interface{ SQLState() string }
+	return errors.As(err, &sqlErr) && sqlErr.SQLState() == queryCanceled
+}
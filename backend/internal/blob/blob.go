@@ -0,0 +1,50 @@
+// Package blob stores uploaded files, such as profile pictures, on local
+// disk or in an S3-compatible bucket, and hands out their public URLs.
+package blob
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Store keeps objects under slash-separated keys such as
+// "profile-pictures/7/abc.jpg".
+type Store interface {
+	// Put saves data under key, replacing any object already there, and
+	// returns the URL clients fetch it from.
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+	// Delete removes the object at a URL Put returned. Missing objects and
+	// URLs the store didn't issue are ignored.
+	Delete(ctx context.Context, url string) error
+}
+
+var errInvalidKey = errors.New("blob: invalid key")
+
+// validKey accepts keys made of non-empty segments of letters, digits,
+// '.', '-' and '_', none starting with a dot, so keys are safe as file
+// paths and need no escaping in URLs.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg[0] == '.' {
+			return false
+		}
+		for _, c := range seg {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keyOf returns the key of url if it lies under base.
+func keyOf(base, url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, strings.TrimSuffix(base, "/")+"/")
+	return key, ok && validKey(key)
+}
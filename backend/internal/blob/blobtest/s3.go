@@ -0,0 +1,211 @@
+// Package blobtest provides a minimal S3-compatible server for tests and
+// local development. It keeps objects in memory, addresses buckets by path
+// and checks Signature Version 4 on every write, while anyone may read, as
+// with a public-read bucket policy.
+package blobtest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object is a stored object.
+type Object struct {
+	ContentType  string
+	CacheControl string
+	Data         []byte
+}
+
+// S3 is an http.Handler serving PUT, GET, HEAD and DELETE of objects at
+// /<bucket>/<key>.
+type S3 struct {
+	// URL is the endpoint clients connect to.
+	URL             string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	mu      sync.Mutex
+	objects map[string]Object
+
+	server *httptest.Server
+}
+
+// NewS3 returns an empty server with fixed test credentials. Buckets
+// spring into existence on first write.
+func NewS3(url string) *S3 {
+	return &S3{
+		URL:             url,
+		Region:          "us-east-1",
+		AccessKeyID:     "carpool",
+		SecretAccessKey: "carpool-secret",
+		objects:         map[string]Object{},
+	}
+}
+
+// Start runs a server on a local httptest server. Call Close when done.
+func Start() *S3 {
+	s := NewS3("")
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close stops a server created by Start.
+func (s *S3) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// Object returns the object at path, "<bucket>/<key>".
+func (s *S3) Object(path string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[path]
+	return obj, ok
+}
+
+// Len returns the number of objects stored.
+func (s *S3) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func (s *S3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(path, "/") {
+		s.fail(w, http.StatusBadRequest, "InvalidRequest", "bucket listing is not supported")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, path)
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		if !s.authorized(w, r, body) {
+			return
+		}
+		s.mu.Lock()
+		s.objects[path] = Object{ContentType: r.Header.Get("Content-Type"), CacheControl: r.Header.Get("Cache-Control"), Data: body}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if !s.authorized(w, r, nil) {
+			return
+		}
+		s.mu.Lock()
+		delete(s.objects, path)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported")
+	}
+}
+
+func (s *S3) get(w http.ResponseWriter, r *http.Request, path string) {
+	obj, ok := s.Object(path)
+	if !ok {
+		s.fail(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	w.Header().Set("Content-Type", obj.ContentType)
+	if obj.CacheControl != "" {
+		w.Header().Set("Cache-Control", obj.CacheControl)
+	}
+	if r.Method == http.MethodGet {
+		w.Write(obj.Data)
+	}
+}
+
+// authorized verifies the request's Signature Version 4 from scratch, as
+// S3 would, and answers 403 if it doesn't match.
+func (s *S3) authorized(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	const prefix = "AWS4-HMAC-SHA256 "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		s.fail(w, http.StatusForbidden, "AccessDenied", "missing Signature Version 4 authorization")
+		return false
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, prefix), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.AccessKeyID || credential[2] != s.Region ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		s.fail(w, http.StatusForbidden, "InvalidAccessKeyId", "unknown credential "+fields["Credential"])
+		return false
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, credential[1]) || time.Since(t).Abs() > 15*time.Minute {
+		s.fail(w, http.StatusForbidden, "RequestTimeTooSkewed", "bad X-Amz-Date "+amzDate)
+		return false
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+		s.fail(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash doesn't match the body")
+		return false
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) || !contains(signed, "host") || !contains(signed, "x-amz-date") {
+		s.fail(w, http.StatusForbidden, "AccessDenied", "bad SignedHeaders "+fields["SignedHeaders"])
+		return false
+	}
+	var canonical strings.Builder
+	canonical.WriteString(r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.RawQuery + "\n")
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonical.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+	canonical.WriteString("\n" + fields["SignedHeaders"] + "\n" + payloadHash)
+
+	canonicalHash := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + strings.Join(credential[1:], "/") + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range append(credential[1:], stringToSign) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(fields["Signature"])) {
+		s.fail(w, http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match")
+		return false
+	}
+	return true
+}
+
+func (s *S3) fail(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+message+"</Message></Error>")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
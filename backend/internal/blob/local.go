@@ -0,0 +1,102 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"carpool/backend/internal/apperr"
+)
+
+// Local keeps objects as files under Dir and serves them itself.
+type Local struct {
+	Dir string
+	// BaseURL is where ServeHTTP is reachable, such as
+	// "https://api.example.com/media".
+	BaseURL string
+}
+
+var _ Store = (*Local)(nil)
+
+func (l *Local) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	if !validKey(key) {
+		return "", errInvalidKey
+	}
+	path := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first so readers never see half an object.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(l.BaseURL, "/") + "/" + key, nil
+}
+
+func (l *Local) Delete(ctx context.Context, url string) error {
+	key, ok := keyOf(l.BaseURL, url)
+	if !ok {
+		return nil
+	}
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP serves the object named by the "key" path value, for a route
+// such as "GET /media/{key...}". Keys are never reused, so responses may
+// be cached indefinitely.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !validKey(key) {
+		apperr.Write(w, r, apperr.NotFound("File not found"))
+		return
+	}
+	f, err := os.Open(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		apperr.Write(w, r, apperr.NotFound("File not found"))
+		return
+	}
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	if info.IsDir() {
+		apperr.Write(w, r, apperr.NotFound("File not found"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, key, info.ModTime(), f)
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(key))
+}
@@ -0,0 +1,113 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 keeps objects in a bucket of Amazon S3 or a compatible service such
+// as MinIO or Cloudflare R2. Objects must be publicly readable, typically
+// through a bucket policy, or served through PublicURL.
+type S3 struct {
+	// Endpoint is the service's base URL, such as
+	// "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket as Endpoint/Bucket instead of
+	// Bucket.Endpoint, as MinIO and most local stand-ins require.
+	PathStyle bool
+	// PublicURL is the base of the URLs Put returns, such as a CDN in front
+	// of the bucket. It defaults to the bucket's own address.
+	PublicURL string
+	// Client defaults to an http.Client with a 30 second timeout.
+	Client *http.Client
+}
+
+var _ Store = (*S3)(nil)
+
+var defaultS3Client = &http.Client{Timeout: 30 * time.Second}
+
+func (s *S3) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	if !validKey(key) {
+		return "", errInvalidKey
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	if err := s.do(req, hexSHA256(data)); err != nil {
+		return "", fmt.Errorf("uploading %s: %w", key, err)
+	}
+	return strings.TrimSuffix(s.publicURL(), "/") + "/" + key, nil
+}
+
+func (s *S3) Delete(ctx context.Context, url string) error {
+	key, ok := keyOf(s.publicURL(), url)
+	if !ok {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	if err := s.do(req, emptyPayloadHash); err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// do signs and sends req. S3 answers a DELETE of a missing object with
+// 204, so any status outside 2xx is an error.
+func (s *S3) do(req *http.Request, payloadHash string) error {
+	signV4(req, payloadHash, s.AccessKeyID, s.SecretAccessKey, s.Region, "s3", time.Now())
+	client := s.Client
+	if client == nil {
+		client = defaultS3Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// bucketURL is the bucket's address at Endpoint.
+func (s *S3) bucketURL() string {
+	endpoint := strings.TrimSuffix(s.Endpoint, "/")
+	if s.PathStyle {
+		return endpoint + "/" + s.Bucket
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint + "/" + s.Bucket
+	}
+	u.Host = s.Bucket + "." + u.Host
+	return u.String()
+}
+
+func (s *S3) objectURL(key string) string {
+	return s.bucketURL() + "/" + key
+}
+
+func (s *S3) publicURL() string {
+	if s.PublicURL != "" {
+		return s.PublicURL
+	}
+	return s.bucketURL()
+}
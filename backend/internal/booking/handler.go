@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"carpool/backend/internal/apperr"
 	"carpool/backend/internal/middleware"
 )
 
@@ -12,19 +13,19 @@ type Handler struct {
 }
 
 func (h *Handler) CreateBookingHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	var b Booking
 	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return
+	}
+	if errs := b.Validate(); errs != nil {
+		apperr.Write(w, r, errs)
 		return
 	}
 	// Get authenticated user's ID from JWT middleware context.
 	b.UserID = middleware.GetUserIDFromContext(r.Context())
-	if err := h.Service.CreateBooking(&b); err != nil {
-		http.Error(w, "Error creating booking", http.StatusInternalServerError)
+	if err := h.Service.CreateBooking(r.Context(), &b); err != nil {
+		apperr.Write(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
@@ -32,15 +33,11 @@ func (h *Handler) CreateBookingHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetUserBookingsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	userID := middleware.GetUserIDFromContext(r.Context())
-	bookings, err := h.Service.GetUserBookings(userID)
+	bookings, err := h.Service.GetUserBookings(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "Error fetching bookings", http.StatusInternalServerError)
+		apperr.Write(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(bookings)
-}
\ No newline at end of file
+}
@@ -1,6 +1,10 @@
 package booking
 
-import "time"
+import (
+	"time"
+
+	"carpool/backend/internal/validation"
+)
 
 type Booking struct {
 	BookingID int       `json:"booking_id,omitempty"`
@@ -9,4 +13,18 @@ type Booking struct {
 	SeatCount int       `json:"seat_count"`
 	Status    string    `json:"status,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
-}
\ No newline at end of file
+}
+
+// Booking statuses stored in bookings.status.
+const (
+	StatusPending   = "pending"
+	StatusCancelled = "cancelled"
+)
+
+// Validate checks the fields a rider supplies when booking.
+func (b *Booking) Validate() validation.Errors {
+	v := &validation.Validator{}
+	v.Check(b.RideID > 0, "ride_id", "is required")
+	v.Check(b.SeatCount >= 1, "seat_count", "must be at least 1")
+	return v.Errors()
+}
@@ -1,36 +1,58 @@
 package booking
 
 import (
+	"context"
 	"database/sql"
 	//"time"
+
+	"carpool/backend/internal/deadline"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
 }
 
-func (r *Repository) CreateBooking(b *Booking) error {
+func (r *Repository) CreateBooking(ctx context.Context, b *Booking) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "booking.CreateBooking")
+	defer cancel()
+
 	query := `
          INSERT INTO bookings (user_id, ride_id, seat_count, status, created_at)
          VALUES ($1, $2, $3, 'pending', NOW())
-         RETURNING booking_id, created_at
+         RETURNING booking_id, status, created_at
     `
-	return r.DB.QueryRow(query, b.UserID, b.RideID, b.SeatCount).Scan(&b.BookingID, &b.CreatedAt)
+	return r.DB.QueryRowContext(ctx, query, b.UserID, b.RideID, b.SeatCount).Scan(&b.BookingID, &b.Status, &b.CreatedAt)
 }
 
-func (r *Repository) GetBookingsByUser(userID int) ([]*Booking, error) {
+// GetRideAvailableSeats returns sql.ErrNoRows if the ride does not exist
+// or has been removed or cancelled.
+func (r *Repository) GetRideAvailableSeats(ctx context.Context, rideID int) (int, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "booking.GetRideAvailableSeats")
+	defer cancel()
+
+	var seats int
+	query := `SELECT available_seats FROM rides WHERE ride_id = $1 AND (ride_status IS NULL OR ride_status NOT IN ('removed', 'cancelled'))`
+	err := r.DB.QueryRowContext(ctx, query, rideID).Scan(&seats)
+	return seats, err
+}
+
+func (r *Repository) GetBookingsByUser(ctx context.Context, userID int) ([]*Booking, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "booking.GetBookingsByUser")
+	defer cancel()
+
 	query := `
          SELECT booking_id, user_id, ride_id, seat_count, status, created_at
          FROM bookings
          WHERE user_id = $1
          ORDER BY created_at DESC
     `
-	rows, err := r.DB.Query(query, userID)
+	rows, err := r.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var bookings []*Booking
+	bookings := []*Booking{}
 	for rows.Next() {
 		var b Booking
 		if err := rows.Scan(&b.BookingID, &b.UserID, &b.RideID, &b.SeatCount, &b.Status, &b.CreatedAt); err != nil {
@@ -39,4 +61,19 @@ func (r *Repository) GetBookingsByUser(userID int) ([]*Booking, error) {
 		bookings = append(bookings, &b)
 	}
 	return bookings, nil
-}
\ No newline at end of file
+}
+
+// CancelBooking returns sql.ErrNoRows if the booking does not exist.
+func (r *Repository) CancelBooking(ctx context.Context, bookingID int) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "booking.CancelBooking")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE bookings SET status = $1 WHERE booking_id = $2`, StatusCancelled, bookingID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
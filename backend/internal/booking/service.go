@@ -1,14 +1,68 @@
 package booking
 
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/metrics"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/validation"
+)
+
 type Service struct {
-	Repo *Repository
+	Repo Store
+	// Rides and Users provide the ride's rules and the rider's
+	// preferences, checked before a booking is made.
+	Rides ride.Store
+	Users user.Store
 }
 
-func (s *Service) CreateBooking(b *Booking) error {
-	// (Additional business logic like checking seat availability can be added here)
-	return s.Repo.CreateBooking(b)
+// CreateBooking books seats for a rider who meets the ride's hard rules:
+// women-only rides, and the pets and luggage the rider's preferences say
+// they bring.
+func (s *Service) CreateBooking(ctx context.Context, b *Booking) error {
+	// The ride must exist and have enough free seats.
+	availableSeats, err := s.Repo.GetRideAvailableSeats(ctx, b.RideID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.Validation(validation.Errors{"ride_id": "ride does not exist"})
+	}
+	if err != nil {
+		return err
+	}
+	if b.SeatCount > availableSeats {
+		return apperr.Validation(validation.Errors{"seat_count": "exceeds the seats available on this ride"})
+	}
+	rd, err := s.Rides.GetRideByID(ctx, b.RideID)
+	if err != nil {
+		return err
+	}
+	rider, err := s.Users.GetPreferences(ctx, b.UserID)
+	if err != nil {
+		return err
+	}
+	if msg := preference.Violation(*rd.Rules, rider); msg != "" {
+		return apperr.Forbidden(msg)
+	}
+	if err := s.Repo.CreateBooking(ctx, b); err != nil {
+		return err
+	}
+	metrics.BookingCreated(b.Status)
+	return nil
 }
 
-func (s *Service) GetUserBookings(userID int) ([]*Booking, error) {
-	return s.Repo.GetBookingsByUser(userID)
-}
\ No newline at end of file
+func (s *Service) GetUserBookings(ctx context.Context, userID int) ([]*Booking, error) {
+	return s.Repo.GetBookingsByUser(ctx, userID)
+}
+
+// RemoveBooking cancels a booking on behalf of an admin.
+func (s *Service) RemoveBooking(ctx context.Context, bookingID int) error {
+	err := s.Repo.CancelBooking(ctx, bookingID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("Booking not found")
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+package booking
+
+import "context"
+
+// Store persists bookings. Repository implements it on Postgres; memstore
+// implements it in memory for tests. Lookups of a missing booking or ride
+// return sql.ErrNoRows.
+type Store interface {
+	// CreateBooking fills in BookingID, Status and CreatedAt.
+	CreateBooking(ctx context.Context, b *Booking) error
+	// GetRideAvailableSeats treats removed and cancelled rides as missing.
+	GetRideAvailableSeats(ctx context.Context, rideID int) (int, error)
+	GetBookingsByUser(ctx context.Context, userID int) ([]*Booking, error)
+	CancelBooking(ctx context.Context, bookingID int) error
+}
+
+var _ Store = (*Repository)(nil)
@@ -0,0 +1,37 @@
+// Package deadline bounds how long an operation, typically a database
+// query, may run on behalf of a request.
+package deadline
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts gives each named operation a deadline. Names are
+// "<package>.<Method>" of the repository method, such as
+// "ride.SearchRidesFiltered". The zero value sets no deadlines.
+type Timeouts struct {
+	// Default applies to operations not listed in Operations. Zero means no
+	// deadline.
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+// For returns the timeout for op.
+func (t Timeouts) For(op string) time.Duration {
+	if d, ok := t.Operations[op]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// Apply derives a context that expires after op's timeout. An earlier
+// deadline already on ctx, or cancellation of ctx when the client goes
+// away, still ends the operation first.
+func (t Timeouts) Apply(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	d := t.For(op)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
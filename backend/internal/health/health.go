@@ -0,0 +1,57 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// pingTimeout bounds the database check so a hung connection fails the
+// probe instead of stalling it.
+const pingTimeout = 2 * time.Second
+
+type Handler struct {
+	DB       *sql.DB
+	draining atomic.Bool
+}
+
+// Drain makes readiness fail from now on, so the load balancer stops
+// sending new requests while in-flight ones finish.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// LivenessHandler reports that the process is up. It never touches
+// dependencies, so a database outage doesn't get the instance restarted.
+func (h *Handler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadinessHandler reports whether the instance can serve traffic: it isn't
+// shutting down and the database answers.
+func (h *Handler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeStatus(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+	if err := h.DB.PingContext(ctx); err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		writeStatus(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "database": "unreachable"})
+		return
+	}
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok", "database": "ok"})
+}
+
+func writeStatus(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
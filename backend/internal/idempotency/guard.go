@@ -0,0 +1,214 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/middleware"
+)
+
+// Header is the request header carrying the client's key, and
+// ReplayedHeader marks a response that was stored earlier.
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxKeyLength matches idempotency_keys.idem_key.
+const maxKeyLength = 255
+
+// maxBodyBytes caps the request bodies read into memory for hashing. The
+// guarded endpoints take small JSON documents.
+const maxBodyBytes = 64 << 10
+
+// Guard makes handlers idempotent for requests that carry a key.
+type Guard struct {
+	Store Store
+	// TTL is how long a key is remembered.
+	TTL time.Duration
+}
+
+// Wrap stores the first response next gives under each user's key and
+// replays it, with ReplayedHeader set, to retries of the same request. A
+// key reused for a different request is rejected with 422, one whose
+// first request is still running with 409, and a body over maxBodyBytes
+// with 413. Server errors aren't stored, so the request can be retried.
+// Requests without a key, and every request when g is nil, go straight to
+// next. It must run after Auth.
+func (g *Guard) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	if g == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLength || !printable(key) {
+			apperr.Write(w, r, apperr.BadRequest("Idempotency-Key must be at most 255 printable ASCII characters"))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apperr.Write(w, r, apperr.TooLarge("Request body is too large"))
+			return
+		}
+		if err != nil {
+			apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := &Record{
+			UserID:      middleware.GetUserIDFromContext(r.Context()),
+			Key:         key,
+			RequestHash: requestHash(r, body),
+		}
+		existing, err := g.Store.Claim(r.Context(), rec, g.TTL)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The key was released between our attempt and the lookup.
+			apperr.Write(w, r, apperr.Conflict("A request with this Idempotency-Key is still being processed"))
+			return
+		}
+		if err != nil {
+			apperr.Write(w, r, err)
+			return
+		}
+		if existing != nil {
+			replay(w, r, existing, rec.RequestHash)
+			return
+		}
+		g.record(w, r, next, rec)
+	}
+}
+
+// record runs next, passing its response through to the client, and then
+// completes or releases the claim on rec.
+func (g *Guard) record(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, rec *Record) {
+	// The outcome is saved even if the client has gone away, since that is
+	// exactly when it will retry.
+	ctx := context.WithoutCancel(r.Context())
+	tee := &teeWriter{ResponseWriter: w}
+	defer func() {
+		if p := recover(); p != nil {
+			g.release(ctx, rec)
+			panic(p)
+		}
+	}()
+	next(tee, r)
+
+	if tee.status == 0 {
+		tee.status = http.StatusOK
+	}
+	if tee.status >= http.StatusInternalServerError {
+		g.release(ctx, rec)
+		return
+	}
+	rec.Status = tee.status
+	rec.ContentType = tee.Header().Get("Content-Type")
+	rec.Body = tee.body.Bytes()
+	if err := g.Store.Complete(ctx, rec); err != nil {
+		log.Printf("Storing response for Idempotency-Key %q of user %d: %v", rec.Key, rec.UserID, err)
+	}
+}
+
+func (g *Guard) release(ctx context.Context, rec *Record) {
+	if err := g.Store.Release(ctx, rec.UserID, rec.Key); err != nil {
+		log.Printf("Releasing Idempotency-Key %q of user %d: %v", rec.Key, rec.UserID, err)
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, rec *Record, hash string) {
+	switch {
+	case rec.RequestHash != hash:
+		apperr.WriteStatus(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	case rec.InProgress():
+		apperr.Write(w, r, apperr.Conflict("A request with this Idempotency-Key is still being processed"))
+	default:
+		if rec.ContentType != "" {
+			w.Header().Set("Content-Type", rec.ContentType)
+		}
+		w.Header().Set(ReplayedHeader, "true")
+		w.WriteHeader(rec.Status)
+		w.Write(rec.Body)
+	}
+}
+
+// legacyPrefix is the version whose routes are also served at their
+// unversioned paths.
+const legacyPrefix = "/v1"
+
+// requestHash identifies a request by its method, path and body. The path
+// is taken without legacyPrefix, so a retry that reaches the same route
+// through its unversioned alias counts as the same request.
+func requestHash(r *http.Request, body []byte) string {
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, legacyPrefix); ok && strings.HasPrefix(rest, "/") {
+		path = rest
+	}
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// teeWriter passes a response through while keeping a copy of it.
+type teeWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (t *teeWriter) WriteHeader(status int) {
+	if t.status == 0 {
+		t.status = status
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	t.body.Write(b)
+	return t.ResponseWriter.Write(b)
+}
+
+// PurgeExpired deletes expired records every interval until ctx is done.
+func PurgeExpired(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := store.DeleteExpired(ctx); err != nil {
+				log.Printf("Deleting expired idempotency keys: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d expired idempotency keys", n)
+			}
+		}
+	}
+}
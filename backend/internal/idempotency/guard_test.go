@@ -0,0 +1,160 @@
+package idempotency_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/memstore"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/user"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// guarded wraps next in a Guard on memstore behind Auth, and returns it
+// with a token for the only user.
+func guarded(t *testing.T, next http.HandlerFunc) (http.Handler, string) {
+	t.Helper()
+	store := memstore.New()
+	u := &user.User{Name: "Ada", Email: "ada@example.com"}
+	if err := store.Users().CreateUser(context.Background(), u, "x"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.NewKeySet(token.NewHMACKey([]byte("test-secret-that-is-long-enough")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := keys.Sign(jwt.MapClaims{"user_id": u.ID, "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &idempotency.Guard{Store: store.Idempotency(), TTL: time.Hour}
+	return middleware.Chain(g.Wrap(next), middleware.Auth(keys)), tok
+}
+
+func post(h http.Handler, tok, key string) *httptest.ResponseRecorder {
+	return postTo(h, "/v1/rides", tok, key)
+}
+
+func postTo(h http.Handler, path, tok, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set(idempotency.Header, key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServerErrorsAreNotStored(t *testing.T) {
+	calls := 0
+	h, tok := guarded(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusCreated, http.StatusCreated} {
+		if got := post(h, tok, "k").Code; got != want {
+			t.Errorf("got status %d, want %d", got, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestPanicsReleaseTheKey(t *testing.T) {
+	calls := 0
+	h, tok := guarded(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic was swallowed")
+			}
+		}()
+		post(h, tok, "k")
+	}()
+	if got := post(h, tok, "k").Code; got != http.StatusCreated {
+		t.Errorf("retry after panic: got status %d", got)
+	}
+}
+
+func TestConcurrentRetryConflicts(t *testing.T) {
+	var h http.Handler
+	var tok string
+	var inner *httptest.ResponseRecorder
+	h, tok = guarded(t, func(w http.ResponseWriter, r *http.Request) {
+		// The retry arrives while the first request is still running.
+		if inner == nil {
+			inner = post(h, tok, "k")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	if got := post(h, tok, "k").Code; got != http.StatusCreated {
+		t.Errorf("first request: got status %d", got)
+	}
+	if inner.Code != http.StatusConflict {
+		t.Errorf("retry while in progress: got status %d, want 409", inner.Code)
+	}
+}
+
+func TestLargeBodiesAreRejected(t *testing.T) {
+	calls := 0
+	h, tok := guarded(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+	req := httptest.NewRequest("POST", "/v1/rides", strings.NewReader(`{"note":"`+strings.Repeat("x", 64<<10)+`"}`))
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set(idempotency.Header, "k")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", rec.Code)
+	}
+	if calls != 0 {
+		t.Errorf("handler ran %d times, want 0", calls)
+	}
+	// The key wasn't claimed, so a request that fits goes through.
+	if got := post(h, tok, "k").Code; got != http.StatusCreated {
+		t.Errorf("got status %d, want 201", got)
+	}
+}
+
+func TestLegacyAliasesShareKeys(t *testing.T) {
+	calls := 0
+	h, tok := guarded(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/v1/rides", http.StatusCreated},
+		{"/rides", http.StatusCreated},
+		{"/v1/bookings", http.StatusUnprocessableEntity},
+		{"/v1rides", http.StatusUnprocessableEntity},
+	} {
+		if got := postTo(h, tc.path, tok, "k").Code; got != tc.want {
+			t.Errorf("POST %s: got status %d, want %d", tc.path, got, tc.want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,26 @@
+// Package idempotency lets clients retry a mutating request safely: the
+// first response under a user's Idempotency-Key is stored and replayed to
+// every retry with the same key.
+package idempotency
+
+import "time"
+
+// Record is the stored outcome of one request under a key.
+type Record struct {
+	UserID int
+	Key    string
+	// RequestHash identifies the request, so a key reused for a different
+	// one can be rejected.
+	RequestHash string
+	// Status is zero while the first request is still running.
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// InProgress reports whether the request hasn't finished yet.
+func (rec *Record) InProgress() bool {
+	return rec.Status == 0
+}
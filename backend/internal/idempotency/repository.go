@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"carpool/backend/internal/deadline"
+)
+
+type Repository struct {
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
+}
+
+func (r *Repository) Claim(ctx context.Context, rec *Record, ttl time.Duration) (*Record, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "idempotency.Claim")
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND idem_key = $2 AND expires_at <= NOW()`, rec.UserID, rec.Key)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        INSERT INTO idempotency_keys (user_id, idem_key, request_hash, created_at, expires_at)
+        VALUES ($1, $2, $3, NOW(), NOW() + $4 * INTERVAL '1 millisecond')
+        ON CONFLICT (user_id, idem_key) DO NOTHING
+        RETURNING created_at, expires_at
+    `
+	err = r.DB.QueryRowContext(ctx, query, rec.UserID, rec.Key, rec.RequestHash, ttl.Milliseconds()).Scan(&rec.CreatedAt, &rec.ExpiresAt)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Another request holds the key. If it has just been released this
+	// returns sql.ErrNoRows, and the client should try again.
+	existing := &Record{UserID: rec.UserID, Key: rec.Key}
+	var status sql.NullInt64
+	var contentType sql.NullString
+	query = `
+        SELECT request_hash, status, content_type, body, created_at, expires_at
+        FROM idempotency_keys
+        WHERE user_id = $1 AND idem_key = $2
+    `
+	err = r.DB.QueryRowContext(ctx, query, rec.UserID, rec.Key).Scan(
+		&existing.RequestHash, &status, &contentType, &existing.Body, &existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	existing.Status = int(status.Int64)
+	existing.ContentType = contentType.String
+	return existing, nil
+}
+
+// Complete returns sql.ErrNoRows if the claim no longer exists.
+func (r *Repository) Complete(ctx context.Context, rec *Record) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "idempotency.Complete")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `
+        UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5
+        WHERE user_id = $1 AND idem_key = $2
+    `, rec.UserID, rec.Key, rec.Status, rec.ContentType, rec.Body)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) Release(ctx context.Context, userID int, key string) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "idempotency.Release")
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND idem_key = $2 AND status IS NULL`, userID, key)
+	return err
+}
+
+func (r *Repository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "idempotency.DeleteExpired")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,25 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists records. Repository implements it on Postgres; memstore
+// implements it in memory for tests. Expired records are treated as
+// missing.
+type Store interface {
+	// Claim saves rec as in progress, expiring after ttl, and fills in
+	// CreatedAt and ExpiresAt. If the user already has a record under
+	// rec.Key it returns that one instead and saves nothing.
+	Claim(ctx context.Context, rec *Record, ttl time.Duration) (*Record, error)
+	// Complete stores the response of a claimed request.
+	Complete(ctx context.Context, rec *Record) error
+	// Release drops a claim that is still in progress, so the request can
+	// be retried.
+	Release(ctx context.Context, userID int, key string) error
+	// DeleteExpired removes expired records and returns how many there were.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+var _ Store = (*Repository)(nil)
@@ -0,0 +1,137 @@
+// Package imaging decodes uploaded pictures and re-encodes them at
+// bounded sizes. Re-encoding keeps only the pixels, so EXIF (including GPS
+// position), XMP and other metadata never reach storage.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+
+	// Registered decoders for the accepted formats.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Accepted lists the content types Decode understands, as sniffed from the
+// data rather than taken from the client.
+var Accepted = []string{"image/jpeg", "image/png", "image/gif"}
+
+var (
+	ErrUnsupportedFormat = errors.New("imaging: unsupported format")
+	ErrTooManyPixels     = errors.New("imaging: image has too many pixels")
+)
+
+// Decode decodes a JPEG, PNG or GIF image of at most maxPixels pixels,
+// rotates it upright according to its EXIF orientation and flattens any
+// transparency onto white. The dimensions are checked before the pixels
+// are decoded, so a small file can't expand into a huge image.
+func Decode(data []byte, maxPixels int) (*image.RGBA, error) {
+	if !accepted(http.DetectContentType(data)) {
+		return nil, ErrUnsupportedFormat
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+	return orient(flat, exifOrientation(data)), nil
+}
+
+func accepted(contentType string) bool {
+	for _, t := range Accepted {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Square crops the centre square out of img and scales it to size×size,
+// averaging the source pixels that fall in each target pixel.
+func Square(img *image.RGBA, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	return scale(img, image.Rect(x0, y0, x0+side, y0+side), size, size)
+}
+
+// Fit scales img down, keeping its aspect ratio, so that neither side is
+// longer than limit pixels. Smaller images are returned as they are.
+func Fit(img *image.RGBA, limit int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() <= limit && b.Dy() <= limit {
+		return img
+	}
+	w, h := limit, limit
+	if b.Dx() > b.Dy() {
+		h = (b.Dy()*limit + b.Dx()/2) / b.Dx()
+	} else {
+		w = (b.Dx()*limit + b.Dy()/2) / b.Dy()
+	}
+	return scale(img, b, max(w, 1), max(h, 1))
+}
+
+// scale resizes the src rectangle of img to w×h, averaging the source
+// pixels that fall in each target pixel.
+func scale(img *image.RGBA, src image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy0, sy1 := span(y, h, src.Dy())
+		for x := 0; x < w; x++ {
+			sx0, sx1 := span(x, w, src.Dx())
+			var r, g, bl, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				i := img.PixOffset(src.Min.X+sx0, src.Min.Y+sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(img.Pix[i])
+					g += uint32(img.Pix[i+1])
+					bl += uint32(img.Pix[i+2])
+					n++
+					i += 4
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels [from, to) covered by target pixel i of
+// n when scaling side source pixels; at least one when enlarging.
+func span(i, n, side int) (from, to int) {
+	from = i * side / n
+	to = (i + 1) * side / n
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// EncodeJPEG encodes img as a baseline JPEG without metadata.
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{0xff, 0, 0, 0xff}
+	blue = color.RGBA{0, 0, 0xff, 0xff}
+)
+
+// halves returns a w×h image, red on the left half and blue on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+// withEXIF inserts an APP1 segment with the given orientation and a
+// GPS-like comment right after the JPEG's SOI marker.
+func withEXIF(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	// Orientation, SHORT, count 1.
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	// ImageDescription, ASCII, pointing nowhere useful.
+	tiff = append(tiff, 0x01, 0x0e, 0x00, 0x02, 0, 0, 0, 4, 'G', 'P', 'S', 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, "lat 47.3769 lon 8.5417"...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xff, 0xd8, 0xff, 0xe1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(a uint32, b uint8) bool {
+		d := int(a>>8) - int(b)
+		return d > -40 && d < 40
+	}
+	return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+}
+
+func TestOrientationAndMetadata(t *testing.T) {
+	jpg := withEXIF(encodeJPEG(t, halves(64, 32)), 6)
+	if got := exifOrientation(jpg); got != 6 {
+		t.Fatalf("exifOrientation = %d, want 6", got)
+	}
+	img, err := Decode(jpg, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Turned clockwise, the red left half ends up on top.
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 64 {
+		t.Fatalf("got %v, want 32×64", b)
+	}
+	if !near(img.At(16, 8), red) || !near(img.At(16, 56), blue) {
+		t.Errorf("got %v on top and %v below, want red over blue", img.At(16, 8), img.At(16, 56))
+	}
+
+	out, err := EncodeJPEG(Square(img, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"Exif", "lat 47.3769"} {
+		if bytes.Contains(out, []byte(leak)) {
+			t.Errorf("output still contains %q", leak)
+		}
+	}
+	if exifOrientation(out) != 1 {
+		t.Error("output has an orientation tag")
+	}
+}
+
+func TestOrientations(t *testing.T) {
+	// A 3×2 image with distinct pixels, and where each ends up displayed.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i / 4)
+	}
+	for _, tc := range []struct {
+		orientation int
+		want        [][]byte // rows of pixel numbers as displayed
+	}{
+		{1, [][]byte{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]byte{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]byte{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]byte{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]byte{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]byte{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]byte{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]byte{{2, 5}, {1, 4}, {0, 3}}},
+	} {
+		got := orient(src, tc.orientation)
+		for y, row := range tc.want {
+			for x, want := range row {
+				if p := got.Pix[got.PixOffset(x, y)]; p != want {
+					t.Errorf("orientation %d: pixel (%d, %d) is %d, want %d", tc.orientation, x, y, p, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	var small, large bytes.Buffer
+	png.Encode(&small, halves(50, 50))
+	png.Encode(&large, halves(100, 100))
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("hello, world"), ErrUnsupportedFormat},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedFormat},
+		{"truncated", small.Bytes()[:small.Len()/2], ErrUnsupportedFormat},
+		{"too many pixels", large.Bytes(), ErrTooManyPixels},
+	} {
+		if _, err := Decode(tc.data, 5000); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestTransparencyAndSquare(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	// Fully transparent, except a red column in the middle.
+	for y := 0; y < 20; y++ {
+		img.Set(20, y, red)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	flat, err := Decode(buf.Bytes(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(flat.At(0, 0), color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("transparent pixel became %v, want white", flat.At(0, 0))
+	}
+
+	// The centre square is columns 10–29; scaled to 2×2 each output pixel
+	// averages ten columns, one of them red on the right.
+	sq := Square(flat, 2)
+	if b := sq.Bounds(); b.Dx() != 2 || b.Dy() != 2 {
+		t.Fatalf("got %v", b)
+	}
+	if !near(sq.At(0, 0), color.RGBA{0xff, 0xff, 0xff, 0xff}) || !near(sq.At(1, 0), color.RGBA{0xff, 0xe6, 0xe6, 0xff}) {
+		t.Errorf("got %v and %v", sq.At(0, 0), sq.At(1, 0))
+	}
+}
+
+func TestFit(t *testing.T) {
+	for _, tc := range []struct {
+		w, h, limit  int
+		wantW, wantH int
+	}{
+		{40, 20, 10, 10, 5},
+		{20, 40, 10, 5, 10},
+		{30, 30, 10, 10, 10},
+		{8, 4, 10, 8, 4},
+		{1000, 1, 10, 10, 1},
+	} {
+		got := Fit(halves(tc.w, tc.h), tc.limit)
+		if b := got.Bounds(); b.Dx() != tc.wantW || b.Dy() != tc.wantH {
+			t.Errorf("Fit(%d×%d, %d) is %d×%d, want %d×%d", tc.w, tc.h, tc.limit, b.Dx(), b.Dy(), tc.wantW, tc.wantH)
+		}
+	}
+
+	// Scaling keeps the left half red and the right half blue.
+	got := Fit(halves(40, 20), 10)
+	if !near(got.At(0, 2), red) || !near(got.At(9, 2), blue) {
+		t.Errorf("got %v and %v", got.At(0, 2), got.At(9, 2))
+	}
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+	data, err := format("Carpool <no-reply@example.com>", Message{
+		To:      "ada@example.com",
+		Subject: "Bestätige deine Adresse",
+		Body:    "Hi Ada,\n\nopen https://example.com/verify-email?token=" + strings.Repeat("x", 80) + "\n",
+	}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Bestätige deine Adresse" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	if got := msg.Header.Get("To"); got != "ada@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got, _ := msg.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %v", got)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.com/verify-email?token=" + strings.Repeat("x", 80); !strings.Contains(string(body), want) {
+		t.Errorf("body %q doesn't contain the link", body)
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line longer than 78 characters: %q", line)
+		}
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	for _, msg := range []Message{
+		{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Hi"},
+		{To: "ada@example.com", Subject: "Hi\nBcc: eve@example.com"},
+	} {
+		if _, err := format("no-reply@example.com", msg, time.Now()); !errors.Is(err, errHeaderInjection) {
+			t.Errorf("format(%q, %q) = %v, want errHeaderInjection", msg.To, msg.Subject, err)
+		}
+	}
+	if _, err := format("no-reply@example.com", Message{To: "not an address"}, time.Now()); err == nil {
+		t.Error("format accepted an invalid recipient")
+	}
+}
@@ -0,0 +1,41 @@
+// Package mailtest records mail instead of sending it, for tests.
+package mailtest
+
+import (
+	"context"
+	"sync"
+
+	"carpool/backend/internal/mail"
+)
+
+// Outbox is a mail.Sender that keeps every message.
+type Outbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (o *Outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (o *Outbox) Messages() []mail.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]mail.Message(nil), o.messages...)
+}
+
+// Last returns the most recent message to addr, if any.
+func (o *Outbox) Last(addr string) (mail.Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == addr {
+			return o.messages[i], true
+		}
+	}
+	return mail.Message{}, false
+}
@@ -0,0 +1,73 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"carpool/backend/internal/booking"
+)
+
+type bookings struct{ s *Store }
+
+func (r bookings) CreateBooking(ctx context.Context, b *booking.Booking) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[b.UserID]; !ok {
+		return foreignKeyError("user", b.UserID)
+	}
+	if _, ok := r.s.rides[b.RideID]; !ok {
+		return foreignKeyError("ride", b.RideID)
+	}
+	r.s.lastBookingID++
+	b.BookingID = r.s.lastBookingID
+	b.Status = booking.StatusPending
+	b.CreatedAt = now()
+	stored := *b
+	r.s.bookings[b.BookingID] = &stored
+	return nil
+}
+
+func (r bookings) GetRideAvailableSeats(ctx context.Context, rideID int) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rd, ok := r.s.rides[rideID]
+	if !ok || !open(rd) {
+		return 0, sql.ErrNoRows
+	}
+	return rd.AvailableSeats, nil
+}
+
+func (r bookings) GetBookingsByUser(ctx context.Context, userID int) ([]*booking.Booking, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*booking.Booking{}
+	for _, b := range r.s.bookings {
+		if b.UserID == userID {
+			c := *b
+			found = append(found, &c)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].CreatedAt.Equal(found[j].CreatedAt) {
+			return found[i].CreatedAt.After(found[j].CreatedAt)
+		}
+		return found[i].BookingID > found[j].BookingID
+	})
+	return found, nil
+}
+
+func (r bookings) CancelBooking(ctx context.Context, bookingID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	b, ok := r.s.bookings[bookingID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	b.Status = booking.StatusCancelled
+	return nil
+}
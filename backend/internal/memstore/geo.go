@@ -0,0 +1,17 @@
+package memstore
+
+import "math"
+
+// earthRadius is the mean radius in metres.
+const earthRadius = 6_371_008.8
+
+// distance returns the great-circle distance in metres between two points
+// given in degrees.
+func distance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,82 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"carpool/backend/internal/idempotency"
+)
+
+// idemKey is the idempotency_keys primary key.
+type idemKey struct {
+	userID int
+	key    string
+}
+
+type idemStore struct{ s *Store }
+
+func (r idemStore) Claim(ctx context.Context, rec *idempotency.Record, ttl time.Duration) (*idempotency.Record, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[rec.UserID]; !ok {
+		return nil, foreignKeyError("user", rec.UserID)
+	}
+	k := idemKey{rec.UserID, rec.Key}
+	t := now()
+	if existing, ok := r.s.idemKeys[k]; ok && existing.ExpiresAt.After(t) {
+		return copyRecord(existing), nil
+	}
+	rec.CreatedAt = t
+	rec.ExpiresAt = t.Add(ttl).Truncate(time.Microsecond)
+	stored := idempotency.Record{UserID: rec.UserID, Key: rec.Key, RequestHash: rec.RequestHash, CreatedAt: rec.CreatedAt, ExpiresAt: rec.ExpiresAt}
+	r.s.idemKeys[k] = &stored
+	return nil, nil
+}
+
+func (r idemStore) Complete(ctx context.Context, rec *idempotency.Record) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.idemKeys[idemKey{rec.UserID, rec.Key}]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Status = rec.Status
+	stored.ContentType = rec.ContentType
+	stored.Body = append([]byte(nil), rec.Body...)
+	return nil
+}
+
+func (r idemStore) Release(ctx context.Context, userID int, key string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	k := idemKey{userID, key}
+	if stored, ok := r.s.idemKeys[k]; ok && stored.InProgress() {
+		delete(r.s.idemKeys, k)
+	}
+	return nil
+}
+
+func (r idemStore) DeleteExpired(ctx context.Context) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t := now()
+	var n int64
+	for k, rec := range r.s.idemKeys {
+		if !rec.ExpiresAt.After(t) {
+			delete(r.s.idemKeys, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func copyRecord(rec *idempotency.Record) *idempotency.Record {
+	c := *rec
+	c.Body = append([]byte(nil), rec.Body...)
+	return &c
+}
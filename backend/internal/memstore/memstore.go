@@ -0,0 +1,125 @@
+// Package memstore keeps users, vehicles, rides, bookings, reviews, driver
+// verifications and idempotency keys in memory. It implements the same
+// Store interfaces as the Postgres repositories so services and handlers
+// can be tested without a database.
+//
+// The stores share one state and mirror the database's behaviour where
+// callers can observe it: missing rows are sql.ErrNoRows, foreign keys are
+// enforced, cascades such as RemoveRide and AnonymizeUser reach the other
+// tables, and results are copies in the same order the SQL returns them.
+package memstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+)
+
+// Store holds the tables. The zero value is not usable; call New.
+type Store struct {
+	mu sync.Mutex
+
+	users    map[int]*userRow
+	rides    map[int]*ride.Ride
+	bookings map[int]*booking.Booking
+	idemKeys map[idemKey]*idempotency.Record
+	reviews  map[int]*review.Review
+	vehicles map[int]*vehicle.Vehicle
+	// verifications and documents are keyed by user.
+	verifications map[int]*verification.Verification
+	documents     map[documentKey]*verification.Document
+	challenges    map[string]*loginChallenge
+
+	lastUserID, lastRideID, lastBookingID, lastReviewID, lastVehicleID int
+}
+
+// userRow is a users row together with its recovery_codes and
+// user_identities rows.
+type userRow struct {
+	user            user.User // Password holds the hash.
+	statusReason    *string
+	totpSecret      *string
+	totpLastStep    *int64
+	totpFailures    int
+	totpLockedUntil *time.Time
+	recoveryCodes   []recoveryCode
+	identities      []user.Identity
+	emailChange     *emailChange
+	// preferences is nil until the user saves some.
+	preferences *preference.Settings
+}
+
+// emailChange is the users.pending_email* columns.
+type emailChange struct {
+	email     string
+	tokenHash string
+	expiresAt time.Time
+}
+
+// loginChallenge is a login_challenges row.
+type loginChallenge struct {
+	userID    int
+	attempts  int
+	expiresAt time.Time
+}
+
+type recoveryCode struct {
+	hash string
+	used bool
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{
+		users:    map[int]*userRow{},
+		rides:    map[int]*ride.Ride{},
+		bookings: map[int]*booking.Booking{},
+		idemKeys: map[idemKey]*idempotency.Record{},
+		reviews:  map[int]*review.Review{},
+		vehicles: map[int]*vehicle.Vehicle{},
+
+		verifications: map[int]*verification.Verification{},
+		documents:     map[documentKey]*verification.Document{},
+		challenges:    map[string]*loginChallenge{},
+	}
+}
+
+// Users returns the store's user.Store.
+func (s *Store) Users() user.Store { return users{s} }
+
+// Rides returns the store's ride.Store.
+func (s *Store) Rides() ride.Store { return rides{s} }
+
+// Bookings returns the store's booking.Store.
+func (s *Store) Bookings() booking.Store { return bookings{s} }
+
+// Idempotency returns the store's idempotency.Store.
+func (s *Store) Idempotency() idempotency.Store { return idemStore{s} }
+
+// Reviews returns the store's review.Store.
+func (s *Store) Reviews() review.Store { return reviews{s} }
+
+// Vehicles returns the store's vehicle.Store.
+func (s *Store) Vehicles() vehicle.Store { return vehicles{s} }
+
+// Verifications returns the store's verification.Store.
+func (s *Store) Verifications() verification.Store { return verifications{s} }
+
+// now matches the precision of a Postgres TIMESTAMP.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// foreignKeyError stands in for the Postgres foreign key violation.
+func foreignKeyError(table string, id int) error {
+	return fmt.Errorf("memstore: %s %d does not exist", table, id)
+}
@@ -0,0 +1,119 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/review"
+)
+
+type reviews struct{ s *Store }
+
+func (r reviews) CreateReview(ctx context.Context, rev *review.Review) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.rides[rev.RideID]; !ok {
+		return foreignKeyError("ride", rev.RideID)
+	}
+	for _, id := range []int{rev.ReviewerID, rev.RevieweeID} {
+		if _, ok := r.s.users[id]; !ok {
+			return foreignKeyError("user", id)
+		}
+	}
+	// The CHECK constraints.
+	if rev.Rating < 1 || rev.Rating > 5 || rev.ReviewerID == rev.RevieweeID {
+		return fmt.Errorf("memstore: invalid review %+v", rev)
+	}
+	for _, other := range r.s.reviews {
+		if other.RideID == rev.RideID && other.ReviewerID == rev.ReviewerID && other.RevieweeID == rev.RevieweeID {
+			return apperr.Conflict("You have already reviewed this person for this ride")
+		}
+	}
+	r.s.lastReviewID++
+	rev.ReviewID = r.s.lastReviewID
+	rev.CreatedAt = now()
+	stored := *rev
+	stored.ReviewerName = nil
+	stored.Comment = copyPtr(rev.Comment)
+	r.s.reviews[rev.ReviewID] = &stored
+
+	sum, n := 0, 0
+	for _, other := range r.s.reviews {
+		if other.RevieweeID == rev.RevieweeID {
+			sum += other.Rating
+			n++
+		}
+	}
+	// users.rating is DECIMAL(3,2).
+	avg := math.Round(float64(sum)/float64(n)*100) / 100
+	r.s.users[rev.RevieweeID].user.Rating = &avg
+	return nil
+}
+
+func (r reviews) ListReviewsFor(ctx context.Context, revieweeID, limit int) ([]*review.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := r.list(func(rev *review.Review) bool { return rev.RevieweeID == revieweeID })
+	if limit < len(found) {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (r reviews) ListUserReviews(ctx context.Context, userID int) ([]*review.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.list(func(rev *review.Review) bool { return rev.ReviewerID == userID || rev.RevieweeID == userID }), nil
+}
+
+// list returns copies of the reviews matching keep with their reviewers'
+// names, newest first.
+func (r reviews) list(keep func(*review.Review) bool) []*review.Review {
+	found := []*review.Review{}
+	for _, rev := range r.s.reviews {
+		if !keep(rev) {
+			continue
+		}
+		c := *rev
+		c.Comment = copyPtr(rev.Comment)
+		if reviewer, ok := r.s.users[rev.ReviewerID]; ok {
+			c.ReviewerName = strPtr(reviewer.user.Name)
+		}
+		found = append(found, &c)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].CreatedAt.Equal(found[j].CreatedAt) {
+			return found[i].CreatedAt.After(found[j].CreatedAt)
+		}
+		return found[i].ReviewID > found[j].ReviewID
+	})
+	return found
+}
+
+func (r reviews) GetParticipants(ctx context.Context, rideID int) (*review.Participants, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rd, ok := r.s.rides[rideID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	p := &review.Participants{DriverID: rd.UserID, RideTime: rd.RideTime, Open: open(rd)}
+	seen := map[int]bool{}
+	for _, b := range r.s.bookings {
+		if b.RideID == rideID && b.Status != booking.StatusCancelled && !seen[b.UserID] {
+			seen[b.UserID] = true
+			p.RiderIDs = append(p.RiderIDs, b.UserID)
+		}
+	}
+	sort.Ints(p.RiderIDs)
+	return p, nil
+}
@@ -0,0 +1,216 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/verification"
+)
+
+type rides struct{ s *Store }
+
+func (r rides) CreateRide(ctx context.Context, rd *ride.Ride) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[rd.UserID]; !ok {
+		return foreignKeyError("user", rd.UserID)
+	}
+	if rd.VehicleID != nil {
+		if _, ok := r.s.vehicles[*rd.VehicleID]; !ok {
+			return foreignKeyError("vehicle", *rd.VehicleID)
+		}
+	}
+	rules := preference.DefaultRules()
+	if rd.Rules != nil {
+		rules = *rd.Rules
+	}
+	r.s.lastRideID++
+	rd.RideID = r.s.lastRideID
+	rd.CreatedAt = now()
+	r.s.rides[rd.RideID] = &ride.Ride{
+		RideID:         rd.RideID,
+		UserID:         rd.UserID,
+		FromLon:        rd.FromLon,
+		FromLat:        rd.FromLat,
+		ToLon:          rd.ToLon,
+		ToLat:          rd.ToLat,
+		FromAddress:    rd.FromAddress,
+		ToAddress:      rd.ToAddress,
+		Price:          rd.Price,
+		RideTime:       rd.RideTime,
+		ArrivalTime:    copyPtr(rd.ArrivalTime),
+		AvailableSeats: rd.AvailableSeats,
+		VehicleID:      copyPtr(rd.VehicleID),
+		CarType:        rd.CarType,
+		InstantBooking: rd.InstantBooking,
+		Rules:          &rules,
+		CreatedAt:      rd.CreatedAt,
+	}
+	return nil
+}
+
+func (r rides) GetAllRides(ctx context.Context) ([]*ride.Ride, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*ride.Ride{}
+	for _, rd := range r.s.rides {
+		if open(rd) {
+			c := r.s.withDriver(rd)
+			// The query doesn't select instant_booking.
+			c.InstantBooking = false
+			found = append(found, c)
+		}
+	}
+	sortByRideTime(found, false)
+	return found, nil
+}
+
+func (r rides) GetRideByID(ctx context.Context, rideID int) (*ride.Ride, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rd, ok := r.s.rides[rideID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return r.s.withDriver(rd), nil
+}
+
+func (r rides) GetRidesByUser(ctx context.Context, userID int) ([]*ride.Ride, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*ride.Ride{}
+	for _, rd := range r.s.rides {
+		if rd.UserID == userID {
+			found = append(found, r.s.withJoins(copyRide(rd)))
+		}
+	}
+	sortByRideTime(found, true)
+	return found, nil
+}
+
+// SearchRidesFiltered measures distances with the haversine formula where
+// PostGIS uses the WGS 84 spheroid, so the two can disagree about rides
+// within about half a percent of the radius.
+func (r rides) SearchRidesFiltered(ctx context.Context, fromLon, fromLat, toLon, toLat float64, timeLowerBound, timeUpperBound time.Time, numPeople int, maximumDistance int, filter preference.Filter) ([]*ride.Ride, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*ride.Ride{}
+	for _, rd := range r.s.rides {
+		if !open(rd) ||
+			distance(rd.FromLon, rd.FromLat, fromLon, fromLat) > float64(maximumDistance) ||
+			distance(rd.ToLon, rd.ToLat, toLon, toLat) > float64(maximumDistance) ||
+			rd.RideTime.Before(timeLowerBound) || rd.RideTime.After(timeUpperBound) ||
+			rd.AvailableSeats < numPeople ||
+			!filter.Matches(*rd.Rules) {
+			continue
+		}
+		// Only the columns the query selects.
+		found = append(found, r.s.withJoins(&ride.Ride{
+			RideID:         rd.RideID,
+			UserID:         rd.UserID,
+			FromLon:        rd.FromLon,
+			FromLat:        rd.FromLat,
+			ToLon:          rd.ToLon,
+			ToLat:          rd.ToLat,
+			FromAddress:    rd.FromAddress,
+			ToAddress:      rd.ToAddress,
+			Price:          rd.Price,
+			RideTime:       rd.RideTime,
+			AvailableSeats: rd.AvailableSeats,
+			VehicleID:      copyPtr(rd.VehicleID),
+			CarType:        rd.CarType,
+			Rules:          copyPtr(rd.Rules),
+			CreatedAt:      rd.CreatedAt,
+		}))
+	}
+	sortByRideTime(found, false)
+	return found, nil
+}
+
+func (r rides) RemoveRide(ctx context.Context, rideID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	rd, ok := r.s.rides[rideID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	rd.RideStatus = strPtr(ride.StatusRemoved)
+	for _, b := range r.s.bookings {
+		if b.RideID == rideID {
+			b.Status = booking.StatusCancelled
+		}
+	}
+	return nil
+}
+
+// open reports whether the ride can still be listed and booked.
+func open(rd *ride.Ride) bool {
+	return rd.RideStatus == nil || (*rd.RideStatus != ride.StatusRemoved && *rd.RideStatus != ride.StatusCancelled)
+}
+
+// withDriver copies the ride with its driver's name and rating, as the
+// queries that join users return it.
+func (s *Store) withDriver(rd *ride.Ride) *ride.Ride {
+	c := copyRide(rd)
+	if driver, ok := s.users[rd.UserID]; ok {
+		c.DriverName = strPtr(driver.user.Name)
+		c.DriverRating = copyPtr(driver.user.Rating)
+	}
+	return s.withJoins(c)
+}
+
+// withJoins fills in the ride's vehicle and whether its driver is
+// verified, as the queries that join vehicles and driver_verifications do.
+func (s *Store) withJoins(c *ride.Ride) *ride.Ride {
+	dv, ok := s.verifications[c.UserID]
+	c.DriverVerified = ok && dv.Status == verification.StatusVerified
+	if c.VehicleID != nil {
+		if v, ok := s.vehicles[*c.VehicleID]; ok {
+			c.Vehicle = copyVehicle(v)
+		}
+	}
+	return c
+}
+
+// copyRide copies the stored columns, deriving ETA from the arrival time
+// as the queries' to_char(eta, 'HH24:MI') does.
+func copyRide(rd *ride.Ride) *ride.Ride {
+	c := *rd
+	c.ArrivalTime = copyPtr(rd.ArrivalTime)
+	c.RideStatus = copyPtr(rd.RideStatus)
+	c.AdditionalNotes = copyPtr(rd.AdditionalNotes)
+	c.VehicleID = copyPtr(rd.VehicleID)
+	c.Rules = copyPtr(rd.Rules)
+	c.Vehicle = nil
+	c.ETA = nil
+	if rd.ArrivalTime != nil {
+		c.ETA = strPtr(rd.ArrivalTime.Format("15:04"))
+	}
+	return &c
+}
+
+// sortByRideTime orders by ride time, breaking ties by ID so results are
+// stable.
+func sortByRideTime(rs []*ride.Ride, desc bool) {
+	sort.Slice(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if desc {
+			a, b = b, a
+		}
+		if !a.RideTime.Equal(b.RideTime) {
+			return a.RideTime.Before(b.RideTime)
+		}
+		return a.RideID < b.RideID
+	})
+}
@@ -0,0 +1,575 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+)
+
+type users struct{ s *Store }
+
+// SetRole changes a user's role, which the API has no endpoint for; in
+// production an operator does it in the database.
+func (s *Store) SetRole(userID int, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.users[userID]; ok {
+		row.user.Role = role
+	}
+}
+
+func (u users) CreateUser(ctx context.Context, usr *user.User, hashedPassword string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for _, row := range u.s.users {
+		if row.user.Email == usr.Email {
+			return apperr.Conflict("Email is already registered")
+		}
+	}
+	u.s.lastUserID++
+	usr.ID = u.s.lastUserID
+	usr.Role = user.RoleUser
+	usr.Status = user.StatusActive
+	usr.CreatedAt = now()
+	usr.TwoFactorEnabled = false
+	u.s.users[usr.ID] = &userRow{user: user.User{
+		ID:        usr.ID,
+		Name:      usr.Name,
+		Email:     usr.Email,
+		Password:  hashedPassword,
+		Role:      usr.Role,
+		Status:    usr.Status,
+		CreatedAt: usr.CreatedAt,
+	}}
+	return nil
+}
+
+func (u users) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for _, row := range u.s.users {
+		if row.user.Email == email {
+			return row.copy(), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (u users) GetUserByID(ctx context.Context, userID int) (*user.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return row.copy(), nil
+}
+
+func (u users) SearchUsers(ctx context.Context, q, status string, limit, offset int) ([]*user.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	q = strings.ToLower(q)
+	found := []*user.User{}
+	for _, row := range u.s.users {
+		matches := q == "" ||
+			strings.Contains(strings.ToLower(row.user.Name), q) ||
+			strings.Contains(strings.ToLower(row.user.Email), q)
+		if matches && (status == "" || row.user.Status == status) {
+			found = append(found, row.copy())
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	if offset >= len(found) {
+		return []*user.User{}, nil
+	}
+	found = found[offset:]
+	if limit < len(found) {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (u users) UpdateProfile(ctx context.Context, userID int, update user.ProfileUpdate) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if update.Name != nil {
+		row.user.Name = *update.Name
+	}
+	if update.SetBio {
+		row.user.Bio = copyPtr(update.Bio)
+	}
+	if update.SetPhone {
+		row.user.Phone = copyPtr(update.Phone)
+	}
+	return nil
+}
+
+func (u users) UpdateUserPassword(ctx context.Context, userID int, hashedPwd string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.user.Password = hashedPwd
+	}
+	return nil
+}
+
+func (u users) UpdateUserStatus(ctx context.Context, userID int, status string, reason *string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	// The users_status_check constraint.
+	if !user.ValidStatus(status) && status != user.StatusDeleted {
+		return fmt.Errorf("memstore: invalid user status %q", status)
+	}
+	row, ok := u.s.users[userID]
+	if !ok || row.user.Status == user.StatusDeleted {
+		return sql.ErrNoRows
+	}
+	row.user.Status = status
+	row.statusReason = copyPtr(reason)
+	return nil
+}
+
+func (u users) SetProfilePicture(ctx context.Context, userID int, url, thumbnailURL *string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	row.user.ProfilePic = copyPtr(url)
+	row.user.ProfilePicThumbnail = copyPtr(thumbnailURL)
+	return nil
+}
+
+func (u users) GetPreferences(ctx context.Context, userID int) (*preference.Settings, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if row.preferences == nil {
+		return preference.DefaultSettings(), nil
+	}
+	return copySettings(row.preferences), nil
+}
+
+func (u users) SetPreferences(ctx context.Context, userID int, settings *preference.Settings) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	row.preferences = copySettings(settings)
+	return nil
+}
+
+func (u users) RequestEmailChange(ctx context.Context, userID int, email, tokenHash string, ttl time.Duration) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	// The UNIQUE constraint on pending_email_token_hash.
+	for _, other := range u.s.users {
+		if other != row && other.emailChange != nil && other.emailChange.tokenHash == tokenHash {
+			return fmt.Errorf("memstore: duplicate email token hash")
+		}
+	}
+	row.emailChange = &emailChange{email: email, tokenHash: tokenHash, expiresAt: now().Add(ttl)}
+	return nil
+}
+
+func (u users) CancelEmailChange(ctx context.Context, userID int) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.emailChange = nil
+	}
+	return nil
+}
+
+func (u users) ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for id, row := range u.s.users {
+		change := row.emailChange
+		if change == nil || change.tokenHash != tokenHash || !change.expiresAt.After(now()) {
+			continue
+		}
+		for _, other := range u.s.users {
+			if other.user.Email == change.email {
+				return 0, apperr.Conflict("Email is already registered")
+			}
+		}
+		row.user.Email = change.email
+		row.user.EmailVerified = true
+		row.emailChange = nil
+		return id, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (u users) GetTOTPState(ctx context.Context, userID int) (*user.TOTPState, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user.TOTPState{
+		Secret:      copyPtr(row.totpSecret),
+		Enabled:     row.user.TwoFactorEnabled,
+		LastStep:    copyPtr(row.totpLastStep),
+		LockedUntil: copyPtr(row.totpLockedUntil),
+	}, nil
+}
+
+func (u users) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.totpSecret = &secret
+		row.user.TwoFactorEnabled = false
+		row.totpLastStep = nil
+	}
+	return nil
+}
+
+func (u users) EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		if len(codeHashes) > 0 {
+			return foreignKeyError("user", userID)
+		}
+		return nil
+	}
+	row.user.TwoFactorEnabled = true
+	row.totpLastStep = &step
+	row.recoveryCodes = nil
+	for _, hash := range codeHashes {
+		row.recoveryCodes = append(row.recoveryCodes, recoveryCode{hash: hash})
+	}
+	return nil
+}
+
+func (u users) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok || (row.totpLastStep != nil && *row.totpLastStep >= step) {
+		return false, nil
+	}
+	row.totpLastStep = &step
+	return true, nil
+}
+
+func (u users) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return false, nil
+	}
+	used := false
+	for i := range row.recoveryCodes {
+		if c := &row.recoveryCodes[i]; c.hash == codeHash && !c.used {
+			c.used = true
+			used = true
+		}
+	}
+	return used, nil
+}
+
+func (u users) CreateLoginChallenge(ctx context.Context, challengeID string, userID int, ttl time.Duration) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	t := now()
+	for id, c := range u.s.challenges {
+		if !c.expiresAt.After(t) {
+			delete(u.s.challenges, id)
+		}
+	}
+	if _, ok := u.s.users[userID]; !ok {
+		return foreignKeyError("user", userID)
+	}
+	if _, ok := u.s.challenges[challengeID]; ok {
+		return fmt.Errorf("memstore: duplicate login challenge")
+	}
+	u.s.challenges[challengeID] = &loginChallenge{userID: userID, expiresAt: t.Add(ttl)}
+	return nil
+}
+
+func (u users) AttemptLoginChallenge(ctx context.Context, challengeID string, userID int) (int, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	c, ok := u.s.challenges[challengeID]
+	if !ok || c.userID != userID || !c.expiresAt.After(now()) {
+		return 0, sql.ErrNoRows
+	}
+	c.attempts++
+	return c.attempts, nil
+}
+
+func (u users) ConsumeLoginChallenge(ctx context.Context, challengeID string) (bool, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	_, ok := u.s.challenges[challengeID]
+	delete(u.s.challenges, challengeID)
+	return ok, nil
+}
+
+func (u users) RecordTwoFactorFailure(ctx context.Context, userID, limit int, lockedUntil time.Time) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.totpFailures++
+		if row.totpFailures >= limit {
+			row.totpFailures = 0
+			row.totpLockedUntil = &lockedUntil
+		}
+	}
+	return nil
+}
+
+func (u users) ResetTwoFactorFailures(ctx context.Context, userID int) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.totpFailures = 0
+	}
+	return nil
+}
+
+func (u users) DisableTOTP(ctx context.Context, userID int) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	if row, ok := u.s.users[userID]; ok {
+		row.totpSecret = nil
+		row.user.TwoFactorEnabled = false
+		row.totpLastStep = nil
+		row.totpFailures = 0
+		row.totpLockedUntil = nil
+		row.recoveryCodes = nil
+	}
+	return nil
+}
+
+func (u users) GetUserByIdentity(ctx context.Context, issuer, subject string) (*user.User, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	for _, row := range u.s.users {
+		for _, id := range row.identities {
+			if id.Issuer == issuer && id.Subject == subject {
+				return row.copy(), nil
+			}
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (u users) LinkIdentity(ctx context.Context, userID int, issuer, subject string) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok {
+		return foreignKeyError("user", userID)
+	}
+	for _, other := range u.s.users {
+		for _, id := range other.identities {
+			if id.Issuer == issuer && id.Subject == subject {
+				return nil
+			}
+		}
+	}
+	row.identities = append(row.identities, user.Identity{Issuer: issuer, Subject: subject, CreatedAt: now()})
+	row.user.EmailVerified = true
+	return nil
+}
+
+func (u users) ListIdentities(ctx context.Context, userID int) ([]*user.Identity, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	identities := []*user.Identity{}
+	if row, ok := u.s.users[userID]; ok {
+		for _, id := range row.identities {
+			id := id
+			identities = append(identities, &id)
+		}
+	}
+	return identities, nil
+}
+
+func (u users) GetUserStats(ctx context.Context, userID int) (*user.Stats, error) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	t := now()
+	stats := &user.Stats{}
+	taken := map[int]bool{}
+	for _, r := range u.s.rides {
+		if r.UserID == userID && open(r) && !r.RideTime.After(t) {
+			stats.RidesDriven++
+		}
+	}
+	for _, b := range u.s.bookings {
+		r := u.s.rides[b.RideID]
+		if b.UserID == userID && b.Status != booking.StatusCancelled && open(r) && !r.RideTime.After(t) {
+			taken[b.RideID] = true
+		}
+	}
+	stats.RidesTaken = len(taken)
+	for _, rev := range u.s.reviews {
+		if rev.RevieweeID == userID {
+			stats.RatingCount++
+		}
+	}
+	return stats, nil
+}
+
+func (u users) AnonymizeUser(ctx context.Context, userID int) error {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+
+	row, ok := u.s.users[userID]
+	if !ok || row.user.Status == user.StatusDeleted {
+		return sql.ErrNoRows
+	}
+	row.user.Name = "Deleted user"
+	row.user.Email = "deleted-" + strconv.Itoa(userID) + "@deleted.invalid"
+	row.user.Password = "!"
+	row.user.Phone = nil
+	row.user.Bio = nil
+	row.user.ProfilePic = nil
+	row.user.ProfilePicThumbnail = nil
+	row.user.TwoFactorEnabled = false
+	row.user.EmailVerified = false
+	row.user.Status = user.StatusDeleted
+	row.statusReason = nil
+	row.totpSecret = nil
+	row.totpLastStep = nil
+	row.recoveryCodes = nil
+	row.identities = nil
+	row.emailChange = nil
+	row.preferences = nil
+	for id, c := range u.s.challenges {
+		if c.userID == userID {
+			delete(u.s.challenges, id)
+		}
+	}
+	for k := range u.s.idemKeys {
+		if k.userID == userID {
+			delete(u.s.idemKeys, k)
+		}
+	}
+
+	t := now()
+	upcoming := func(rideID int) bool {
+		r, ok := u.s.rides[rideID]
+		return ok && r.RideTime.After(t)
+	}
+	for _, r := range u.s.rides {
+		if r.UserID != userID || !upcoming(r.RideID) {
+			continue
+		}
+		r.RideStatus = strPtr(ride.StatusCancelled)
+		for _, b := range u.s.bookings {
+			if b.RideID == r.RideID {
+				b.Status = booking.StatusCancelled
+			}
+		}
+	}
+	for _, b := range u.s.bookings {
+		if b.UserID == userID && upcoming(b.RideID) {
+			b.Status = booking.StatusCancelled
+		}
+	}
+	for id, v := range u.s.vehicles {
+		if v.UserID == userID {
+			u.s.deleteVehicle(id)
+		}
+	}
+	u.s.deleteVerificationDocuments(userID)
+	delete(u.s.verifications, userID)
+	return nil
+}
+
+func (row *userRow) copy() *user.User {
+	c := row.user
+	c.Phone = copyPtr(c.Phone)
+	c.Bio = copyPtr(c.Bio)
+	c.Rating = copyPtr(c.Rating)
+	c.ProfilePic = copyPtr(c.ProfilePic)
+	c.ProfilePicThumbnail = copyPtr(c.ProfilePicThumbnail)
+	if row.emailChange != nil && row.emailChange.expiresAt.After(now()) {
+		c.PendingEmail = strPtr(row.emailChange.email)
+	}
+	return &c
+}
+
+func copyPtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copySettings(s *preference.Settings) *preference.Settings {
+	c := *s
+	c.Gender = copyPtr(s.Gender)
+	c.Rider.Smoking = copyPtr(s.Rider.Smoking)
+	c.Rider.Pets = copyPtr(s.Rider.Pets)
+	c.Rider.Music = copyPtr(s.Rider.Music)
+	c.Rider.Quiet = copyPtr(s.Rider.Quiet)
+	c.Rider.WomenOnly = copyPtr(s.Rider.WomenOnly)
+	return &c
+}
+
+func strPtr(s string) *string { return &s }
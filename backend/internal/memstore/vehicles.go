@@ -0,0 +1,139 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/vehicle"
+)
+
+type vehicles struct{ s *Store }
+
+func (r vehicles) CreateVehicle(ctx context.Context, v *vehicle.Vehicle) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[v.UserID]; !ok {
+		return foreignKeyError("user", v.UserID)
+	}
+	r.s.lastVehicleID++
+	v.VehicleID = r.s.lastVehicleID
+	v.Photos = []string{}
+	v.CreatedAt = now()
+	r.s.vehicles[v.VehicleID] = copyVehicle(v)
+	return nil
+}
+
+func (r vehicles) GetVehicle(ctx context.Context, vehicleID int) (*vehicle.Vehicle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	v, ok := r.s.vehicles[vehicleID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyVehicle(v), nil
+}
+
+func (r vehicles) ListVehicles(ctx context.Context, userID int) ([]*vehicle.Vehicle, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*vehicle.Vehicle{}
+	for _, v := range r.s.vehicles {
+		if v.UserID == userID {
+			found = append(found, copyVehicle(v))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].VehicleID < found[j].VehicleID })
+	return found, nil
+}
+
+func (r vehicles) UpdateVehicle(ctx context.Context, v *vehicle.Vehicle) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.vehicles[v.VehicleID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Make, stored.Model, stored.Color, stored.Plate, stored.Seats = v.Make, v.Model, v.Color, v.Plate, v.Seats
+	return nil
+}
+
+func (r vehicles) AddPhoto(ctx context.Context, vehicleID int, url string, limit int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.vehicles[vehicleID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if len(stored.Photos) >= limit {
+		return apperr.Conflict(fmt.Sprintf("A vehicle can have at most %d photos", limit))
+	}
+	stored.Photos = append(stored.Photos, url)
+	return nil
+}
+
+func (r vehicles) RemovePhoto(ctx context.Context, vehicleID int, url string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.vehicles[vehicleID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	kept := []string{}
+	for _, photo := range stored.Photos {
+		if photo != url {
+			kept = append(kept, photo)
+		}
+	}
+	stored.Photos = kept
+	return nil
+}
+
+func (r vehicles) DeleteVehicle(ctx context.Context, vehicleID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.vehicles[vehicleID]; !ok {
+		return sql.ErrNoRows
+	}
+	r.s.deleteVehicle(vehicleID)
+	return nil
+}
+
+func (r vehicles) HasUpcomingRides(ctx context.Context, vehicleID int) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t := now()
+	for _, rd := range r.s.rides {
+		if rd.VehicleID != nil && *rd.VehicleID == vehicleID && open(rd) && rd.RideTime.After(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteVehicle deletes a vehicle and, like ON DELETE SET NULL, clears
+// the vehicle_id of rides posted with it.
+func (s *Store) deleteVehicle(vehicleID int) {
+	delete(s.vehicles, vehicleID)
+	for _, rd := range s.rides {
+		if rd.VehicleID != nil && *rd.VehicleID == vehicleID {
+			rd.VehicleID = nil
+		}
+	}
+}
+
+func copyVehicle(v *vehicle.Vehicle) *vehicle.Vehicle {
+	c := *v
+	c.Photos = append([]string{}, v.Photos...)
+	return &c
+}
@@ -0,0 +1,152 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"carpool/backend/internal/verification"
+)
+
+type verifications struct{ s *Store }
+
+// documentKey is the primary key of verification_documents.
+type documentKey struct {
+	userID int
+	kind   string
+}
+
+func (r verifications) PutDocument(ctx context.Context, d *verification.Document) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[d.UserID]; !ok {
+		return foreignKeyError("user", d.UserID)
+	}
+	d.Size = len(d.Data)
+	d.UploadedAt = now()
+	c := *d
+	c.Data = append([]byte{}, d.Data...)
+	r.s.documents[documentKey{d.UserID, d.Kind}] = &c
+	return nil
+}
+
+func (r verifications) GetDocument(ctx context.Context, userID int, kind string) (*verification.Document, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	d, ok := r.s.documents[documentKey{userID, kind}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *d
+	c.Data = append([]byte{}, d.Data...)
+	return &c, nil
+}
+
+func (r verifications) ListDocuments(ctx context.Context, userID int) ([]*verification.Document, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*verification.Document{}
+	for k, d := range r.s.documents {
+		if k.userID == userID {
+			c := *d
+			c.Data = nil
+			found = append(found, &c)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Kind < found[j].Kind })
+	return found, nil
+}
+
+func (r verifications) GetVerification(ctx context.Context, userID int) (*verification.Verification, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	v, ok := r.s.verifications[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyVerification(v), nil
+}
+
+func (r verifications) Submit(ctx context.Context, userID int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[userID]; !ok {
+		return foreignKeyError("user", userID)
+	}
+	t := now()
+	r.s.verifications[userID] = &verification.Verification{
+		UserID:      userID,
+		Status:      verification.StatusPending,
+		SubmittedAt: &t,
+	}
+	return nil
+}
+
+func (r verifications) ListVerifications(ctx context.Context, status string) ([]*verification.Verification, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	found := []*verification.Verification{}
+	for _, v := range r.s.verifications {
+		if v.Status != status {
+			continue
+		}
+		c := copyVerification(v)
+		c.Name = r.s.users[v.UserID].user.Name
+		found = append(found, c)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if !a.SubmittedAt.Equal(*b.SubmittedAt) {
+			return a.SubmittedAt.Before(*b.SubmittedAt)
+		}
+		return a.UserID < b.UserID
+	})
+	return found, nil
+}
+
+func (r verifications) Decide(ctx context.Context, userID, reviewerID int, status string, reason *string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	v, ok := r.s.verifications[userID]
+	if !ok || v.Status != verification.StatusPending {
+		return sql.ErrNoRows
+	}
+	if _, ok := r.s.users[reviewerID]; !ok {
+		return foreignKeyError("user", reviewerID)
+	}
+	t := now()
+	v.Status = status
+	v.Reason = copyPtr(reason)
+	v.ReviewedAt = &t
+	v.ReviewerID = &reviewerID
+	if status == verification.StatusVerified {
+		r.s.deleteVerificationDocuments(userID)
+	}
+	return nil
+}
+
+// deleteVerificationDocuments deletes the user's uploaded documents.
+func (s *Store) deleteVerificationDocuments(userID int) {
+	for k := range s.documents {
+		if k.userID == userID {
+			delete(s.documents, k)
+		}
+	}
+}
+
+func copyVerification(v *verification.Verification) *verification.Verification {
+	c := *v
+	c.Reason = copyPtr(v.Reason)
+	c.SubmittedAt = copyPtr(v.SubmittedAt)
+	c.ReviewedAt = copyPtr(v.ReviewedAt)
+	c.ReviewerID = copyPtr(v.ReviewerID)
+	c.Documents = nil
+	return &c
+}
@@ -0,0 +1,113 @@
+// Package metrics holds the Prometheus collectors the server exports at
+// /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "carpool"
+
+// Registry holds every collector below plus the Go runtime and process
+// collectors. It is separate from prometheus.DefaultRegisterer so that
+// libraries can't add metrics behind our back.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	routingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "routing_request_duration_seconds",
+		Help:      "Latency of routing provider calls made to estimate ride ETAs.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"provider", "outcome"})
+
+	routingFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "routing_request_failures_total",
+		Help:      "Routing provider calls that returned no usable duration.",
+	}, []string{"provider"})
+
+	ridesPosted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rides_posted_total",
+		Help:      "Rides posted by drivers.",
+	})
+
+	bookingsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bookings_created_total",
+		Help:      "Bookings created, by the status they were created with.",
+	}, []string{"status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		routingDuration,
+		routingFailures,
+		ridesPosted,
+		bookingsCreated,
+	)
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records one served request. route is the pattern it
+// matched, such as "GET /rides/{id}", so that IDs don't explode the label
+// set.
+func ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(elapsed.Seconds())
+}
+
+// ObserveRoutingCall records one call to a routing provider.
+func ObserveRoutingCall(provider string, elapsed time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		routingFailures.WithLabelValues(provider).Inc()
+	}
+	routingDuration.WithLabelValues(provider, outcome).Observe(elapsed.Seconds())
+}
+
+// RidePosted counts a newly posted ride.
+func RidePosted() {
+	ridesPosted.Inc()
+}
+
+// BookingCreated counts a new booking with the status it was created in.
+func BookingCreated(status string) {
+	bookingsCreated.WithLabelValues(status).Inc()
+}
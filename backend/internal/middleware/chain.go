@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/token"
+)
+
+// Middleware wraps a handler with extra behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h so that mws run in the order given: the first middleware
+// sees the request first.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Auth adapts JWTMiddleware for use in a chain.
+func Auth(keys *token.KeySet) Middleware {
+	return func(next http.Handler) http.Handler {
+		return JWTMiddleware(next.ServeHTTP, keys)
+	}
+}
+
+// Roles adapts RequireRole for use in a chain after Auth.
+func Roles(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return RequireRole(next.ServeHTTP, roles...)
+	}
+}
+
+// StaticToken requires "Authorization: Bearer <token>" with a fixed token,
+// for machine clients such as a Prometheus scraper.
+func StaticToken(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				apperr.Write(w, r, apperr.Unauthorized("Invalid or missing token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
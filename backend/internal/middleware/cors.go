@@ -0,0 +1,16 @@
+package middleware
+
+import "github.com/rs/cors"
+
+// CORS allows browsers on origins to call the API with credentials.
+func CORS(origins []string) Middleware {
+	c := cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Idempotency-Key"},
+		AllowCredentials: true,
+		// Let the frontend notice deprecated endpoints and replayed responses.
+		ExposedHeaders: []string{"Deprecation", "Sunset", "Link", "Idempotent-Replayed"},
+	})
+	return c.Handler
+}
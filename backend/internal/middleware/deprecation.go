@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deprecated marks responses as coming from a deprecated endpoint with the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, leaving out either
+// when its time is zero, and links to the same path under successor.
+func Deprecated(since, sunset time.Time, successor string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if !since.IsZero() {
+				h.Set("Deprecation", "@"+strconv.FormatInt(since.Unix(), 10))
+			}
+			if !sunset.IsZero() {
+				h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			h.Add("Link", "<"+successor+r.URL.RequestURI()+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -4,52 +4,101 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/token"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey     = contextKey("user")
+	roleContextKey     = contextKey("role")
+	issuedAtContextKey = contextKey("issued_at")
+)
+
+// defaultRole is assumed for tokens issued before roles existed.
+const defaultRole = "user"
 
-var jwtKey []byte
+// accountChecker, when set, is consulted on every authenticated request so
+// suspended or banned users are rejected even while their token is valid.
+// The role it returns replaces the one in the token, so a demoted admin
+// loses access straight away.
+var accountChecker func(ctx context.Context, userID int) (string, error)
 
-func SetJWTKey(key []byte) {
-	jwtKey = key
+// SetAccountChecker installs the function JWTMiddleware uses to reject
+// inactive accounts and look up the user's current role.
+func SetAccountChecker(check func(ctx context.Context, userID int) (string, error)) {
+	accountChecker = check
 }
 
-func JWTMiddleware(next http.HandlerFunc, key []byte) http.HandlerFunc {
+// JWTMiddleware authenticates the bearer token against keys. Tokens must
+// be signed with the algorithm registered for their kid.
+func JWTMiddleware(next http.HandlerFunc, keys *token.KeySet) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Missing auth token", http.StatusUnauthorized)
+			apperr.Write(w, r, apperr.Unauthorized("Missing auth token"))
 			return
 		}
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return key, nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		claims, err := keys.Parse(tokenStr)
+		if err != nil {
+			apperr.Write(w, r, apperr.Unauthorized("Invalid token"))
 			return
 		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		// Tokens issued for a specific purpose, such as a two-factor login
+		// challenge, are not access tokens.
+		if _, ok := claims["purpose"]; ok {
+			apperr.Write(w, r, apperr.Unauthorized("Invalid token"))
 			return
 		}
 		// Assume user_id is stored in token claims.
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			apperr.Write(w, r, apperr.Unauthorized("Invalid token claims"))
 			return
 		}
 		userID := int(userIDFloat)
+		role, _ := claims["role"].(string)
+		if accountChecker != nil {
+			current, err := accountChecker(r.Context(), userID)
+			if err != nil {
+				apperr.Write(w, r, err)
+				return
+			}
+			role = current
+		}
+		if role == "" {
+			role = defaultRole
+		}
 		ctx := context.WithValue(r.Context(), userContextKey, userID)
+		ctx = context.WithValue(ctx, roleContextKey, role)
+		if iat, ok := claims["iat"].(float64); ok {
+			ctx = context.WithValue(ctx, issuedAtContextKey, time.Unix(int64(iat), 0))
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// RequireRole allows the request through only if the authenticated user
+// has one of roles. It must be wrapped by JWTMiddleware:
+//
+//	middleware.JWTMiddleware(middleware.RequireRole(h, "admin"), keys)
+func RequireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := GetRoleFromContext(r.Context())
+		for _, allowed := range roles {
+			if role == allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		apperr.Write(w, r, apperr.Forbidden("Insufficient permissions"))
+	}
+}
+
 func GetUserIDFromContext(ctx context.Context) int {
 	userID, ok := ctx.Value(userContextKey).(int)
 	if !ok {
@@ -57,3 +106,15 @@ func GetUserIDFromContext(ctx context.Context) int {
 	}
 	return userID
 }
+
+func GetRoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleContextKey).(string)
+	return role
+}
+
+// GetIssuedAtFromContext returns when the request's token was issued, or
+// the zero time if it carries no "iat" claim.
+func GetIssuedAtFromContext(ctx context.Context) time.Time {
+	iat, _ := ctx.Value(issuedAtContextKey).(time.Time)
+	return iat
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"carpool/backend/internal/metrics"
+)
+
+// Metrics records the request count and latency of the handler under the
+// route label, which should be the pattern it is registered with.
+func Metrics(route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				// A panic is answered with a 500 by Recovery further out.
+				if p := recover(); p != nil {
+					metrics.ObserveRequest(route, r.Method, http.StatusInternalServerError, time.Since(start))
+					panic(p)
+				}
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				metrics.ObserveRequest(route, r.Method, rec.status, time.Since(start))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -1,5 +1,7 @@
--- Create Users table
-CREATE TABLE users (
+-- Baseline schema, as previously created by hand from schema.sql. IF NOT
+-- EXISTS lets databases set up that way adopt migrations in place.
+
+CREATE TABLE IF NOT EXISTS users (
     user_id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
@@ -10,8 +12,7 @@ CREATE TABLE users (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- Create Rides table
-CREATE TABLE rides (
+CREATE TABLE IF NOT EXISTS rides (
     ride_id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL,
     price NUMERIC(10,2) NOT NULL,
@@ -31,8 +32,7 @@ CREATE TABLE rides (
     CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(user_id)
 );
 
--- Create Bookings table
-CREATE TABLE bookings (
+CREATE TABLE IF NOT EXISTS bookings (
     booking_id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL,
     ride_id INTEGER NOT NULL,
@@ -42,4 +42,3 @@ CREATE TABLE bookings (
     CONSTRAINT fk_booking_user FOREIGN KEY(user_id) REFERENCES users(user_id),
     CONSTRAINT fk_booking_ride FOREIGN KEY(ride_id) REFERENCES rides(ride_id)
 );
-
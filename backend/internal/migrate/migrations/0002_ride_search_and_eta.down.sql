@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_bookings_ride_id;
+DROP INDEX IF EXISTS idx_bookings_user_id;
+DROP INDEX IF EXISTS idx_rides_ride_time;
+
+ALTER TABLE rides ALTER COLUMN eta TYPE VARCHAR(50) USING to_char(eta, 'HH24:MI');
+
+-- instant_booking and the postgis extension predate migrations in some
+-- databases, so they are left in place.
@@ -0,0 +1,19 @@
+-- Ride search uses ST_DWithin.
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+-- Written by CreateRide but missing from the original schema.
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS instant_booking BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- eta held "HH:MM" text. Store the full arrival time instead, rolling over
+-- to the next day when the arrival is earlier than the departure.
+ALTER TABLE rides ALTER COLUMN eta TYPE TIMESTAMP USING (
+    CASE
+        WHEN eta ~ '^\d{1,2}:\d{2}(:\d{2})?$' THEN
+            ride_time::date + eta::time
+            + CASE WHEN eta::time < ride_time::time THEN INTERVAL '1 day' ELSE INTERVAL '0' END
+    END
+);
+
+CREATE INDEX IF NOT EXISTS idx_rides_ride_time ON rides (ride_time);
+CREATE INDEX IF NOT EXISTS idx_bookings_user_id ON bookings (user_id);
+CREATE INDEX IF NOT EXISTS idx_bookings_ride_id ON bookings (ride_id);
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user'
+        CONSTRAINT users_role_check CHECK (role IN ('user', 'admin')),
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'banned', 'deleted')),
+    ADD COLUMN status_reason TEXT;
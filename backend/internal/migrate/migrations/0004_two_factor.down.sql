@@ -0,0 +1,6 @@
+DROP TABLE login_challenges;
+DROP TABLE recovery_codes;
+ALTER TABLE users
+    DROP COLUMN totp_last_step,
+    DROP COLUMN totp_enabled,
+    DROP COLUMN totp_secret;
@@ -0,0 +1,26 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64),
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT;
+
+-- Hashed one-time recovery codes for two-factor authentication.
+CREATE TABLE recovery_codes (
+    code_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    CONSTRAINT fk_recovery_user FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+-- Challenges issued after the password step of a two-factor login. Each
+-- challenge token names one row, which is deleted when the login completes
+-- and counts the codes tried until then.
+CREATE TABLE login_challenges (
+    challenge_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_login_challenge_user FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX idx_login_challenges_expires_at ON login_challenges (expires_at);
@@ -0,0 +1,10 @@
+-- External OpenID Connect identities linked to users.
+CREATE TABLE user_identities (
+    identity_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_identity_user FOREIGN KEY(user_id) REFERENCES users(user_id),
+    CONSTRAINT uq_identity UNIQUE (issuer, subject)
+);
@@ -0,0 +1,15 @@
+-- Responses to requests sent with an Idempotency-Key header, replayed when
+-- the client retries. status is NULL while the first request is running.
+CREATE TABLE idempotency_keys (
+    user_id INTEGER NOT NULL,
+    idem_key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status INTEGER,
+    content_type VARCHAR(255),
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, idem_key),
+    CONSTRAINT fk_idempotency_user FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN profile_pic_thumbnail,
+    ALTER COLUMN profile_pic TYPE VARCHAR(255);
@@ -0,0 +1,5 @@
+-- profile_pic holds the URL of the 512×512 picture; the thumbnail is
+-- 128×128. URLs from a CDN can outgrow 255 characters.
+ALTER TABLE users
+    ALTER COLUMN profile_pic TYPE TEXT,
+    ADD COLUMN profile_pic_thumbnail TEXT;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN pending_email_expires_at,
+    DROP COLUMN pending_email_token_hash,
+    DROP COLUMN pending_email,
+    DROP COLUMN bio;
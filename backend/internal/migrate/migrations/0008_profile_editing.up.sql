@@ -0,0 +1,7 @@
+-- A changed email address waits in pending_email until the user follows
+-- the link sent to it; only the hash of the link's token is stored.
+ALTER TABLE users
+    ADD COLUMN bio TEXT,
+    ADD COLUMN pending_email VARCHAR(255),
+    ADD COLUMN pending_email_token_hash VARCHAR(64) UNIQUE,
+    ADD COLUMN pending_email_expires_at TIMESTAMP;
@@ -0,0 +1,17 @@
+-- Ratings that people who shared a ride give each other. users.rating
+-- is kept as the average of the ratings a user has received.
+CREATE TABLE reviews (
+    review_id SERIAL PRIMARY KEY,
+    ride_id INTEGER NOT NULL,
+    reviewer_id INTEGER NOT NULL,
+    reviewee_id INTEGER NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    comment TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (ride_id, reviewer_id, reviewee_id),
+    CHECK (reviewer_id <> reviewee_id),
+    CONSTRAINT fk_review_ride FOREIGN KEY(ride_id) REFERENCES rides(ride_id),
+    CONSTRAINT fk_review_reviewer FOREIGN KEY(reviewer_id) REFERENCES users(user_id),
+    CONSTRAINT fk_review_reviewee FOREIGN KEY(reviewee_id) REFERENCES users(user_id)
+);
+CREATE INDEX idx_reviews_reviewee_id ON reviews (reviewee_id, created_at DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE rides DROP COLUMN vehicle_id;
+DROP TABLE vehicles;
@@ -0,0 +1,20 @@
+-- Vehicles drivers register and choose from when posting a ride. seats
+-- counts the seats offered to riders, not the driver's.
+CREATE TABLE vehicles (
+    vehicle_id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    make VARCHAR(50) NOT NULL,
+    model VARCHAR(50) NOT NULL,
+    color VARCHAR(30) NOT NULL,
+    plate VARCHAR(20) NOT NULL,
+    seats SMALLINT NOT NULL CHECK (seats BETWEEN 1 AND 8),
+    photos TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_vehicle_user FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX idx_vehicles_user_id ON vehicles (user_id);
+
+-- car_type stays as the description of the car at the time the ride was
+-- posted, so rides keep it when their vehicle is deleted.
+ALTER TABLE rides ADD COLUMN vehicle_id INTEGER
+    CONSTRAINT fk_ride_vehicle REFERENCES vehicles(vehicle_id) ON DELETE SET NULL;
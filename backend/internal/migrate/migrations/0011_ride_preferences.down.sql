@@ -0,0 +1,11 @@
+ALTER TABLE users
+    DROP COLUMN rider_filter,
+    DROP COLUMN driver_rules,
+    DROP COLUMN gender;
+ALTER TABLE rides
+    DROP COLUMN women_only,
+    DROP COLUMN luggage,
+    DROP COLUMN quiet,
+    DROP COLUMN music,
+    DROP COLUMN pets,
+    DROP COLUMN smoking;
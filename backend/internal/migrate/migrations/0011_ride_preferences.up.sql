@@ -0,0 +1,18 @@
+-- Rules drivers set on each ride. luggage is the largest size each rider
+-- may bring.
+ALTER TABLE rides
+    ADD COLUMN smoking BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN pets BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN music BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN quiet BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN luggage VARCHAR(10) NOT NULL DEFAULT 'medium'
+        CHECK (luggage IN ('none', 'small', 'medium', 'large')),
+    ADD COLUMN women_only BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- gender only admits riders to women-only rides. driver_rules are the
+-- rules new rides get by default and rider_filter what the user searches
+-- with; both are NULL until the user saves them.
+ALTER TABLE users
+    ADD COLUMN gender VARCHAR(10) CHECK (gender IN ('female', 'male', 'diverse')),
+    ADD COLUMN driver_rules JSONB,
+    ADD COLUMN rider_filter JSONB;
@@ -0,0 +1,2 @@
+DROP TABLE driver_verifications;
+DROP TABLE verification_documents;
@@ -0,0 +1,26 @@
+-- Documents drivers upload to get verified. They are only ever shown to
+-- admins, so they are kept here rather than in the public media store,
+-- and deleted once the driver is verified.
+CREATE TABLE verification_documents (
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('license', 'insurance')),
+    content_type VARCHAR(50) NOT NULL,
+    data BYTEA NOT NULL,
+    uploaded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, kind),
+    CONSTRAINT fk_verification_document_user FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+
+-- Where a driver's verification stands. Drivers without a row have never
+-- submitted their documents. reason explains a rejection.
+CREATE TABLE driver_verifications (
+    user_id INTEGER PRIMARY KEY,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('pending', 'verified', 'rejected')),
+    reason TEXT,
+    submitted_at TIMESTAMP NOT NULL,
+    reviewed_at TIMESTAMP,
+    reviewer_id INTEGER,
+    CONSTRAINT fk_driver_verification_user FOREIGN KEY(user_id) REFERENCES users(user_id),
+    CONSTRAINT fk_driver_verification_reviewer FOREIGN KEY(reviewer_id) REFERENCES users(user_id) ON DELETE SET NULL
+);
+CREATE INDEX idx_driver_verifications_status ON driver_verifications (status, submitted_at);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN totp_locked_until,
+    DROP COLUMN totp_failed_attempts;
@@ -0,0 +1,5 @@
+-- Wrong second-factor codes in a row across all of a user's login
+-- challenges, and how long two-factor sign-in stays locked after too many.
+ALTER TABLE users
+    ADD COLUMN totp_failed_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN totp_locked_until TIMESTAMP;
@@ -0,0 +1,5 @@
+-- Shown as a badge on public profiles. Addresses confirmed through an
+-- identity provider count as verified. Databases that ran 0009 before the
+-- column moved here already have it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE WHERE user_id IN (SELECT user_id FROM user_identities);
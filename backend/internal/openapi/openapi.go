@@ -0,0 +1,22 @@
+// Package openapi serves the OpenAPI 3 description of the API and checks
+// responses against it.
+//
+// openapi.json is written by hand. Tests keep it honest: every route in
+// routes.Table must be documented, the schemas must list the JSON fields
+// of the Go types they describe, and the end-to-end API tests validate
+// every response body against the operation that produced it.
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Handler serves the document.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(document)
+}
@@ -0,0 +1,235 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"carpool/backend/config"
+	"carpool/backend/internal/account"
+	"carpool/backend/internal/admin"
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/health"
+	"carpool/backend/internal/openapi"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/profile"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/routes"
+	"carpool/backend/internal/sso"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+)
+
+func load(t *testing.T) *openapi.Spec {
+	t.Helper()
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// TestEveryRouteIsDocumented compares the spec with the routes served when
+// every feature is on.
+func TestEveryRouteIsDocumented(t *testing.T) {
+	table := routes.Table(routes.Deps{
+		Keys:          &token.KeySet{},
+		Health:        &health.Handler{},
+		Users:         &user.Handler{SSO: &sso.Provider{}},
+		Rides:         &ride.Handler{},
+		Bookings:      &booking.Handler{},
+		Accounts:      &account.Handler{},
+		Admin:         &admin.Handler{},
+		Profiles:      &profile.Handler{},
+		Reviews:       &review.Handler{},
+		Vehicles:      &vehicle.Handler{},
+		Verifications: &verification.Handler{},
+		Metrics:       http.NotFoundHandler(),
+		Media:         http.NotFoundHandler(),
+		Features:      config.Defaults().Features,
+	})
+	var served []string
+	for _, rt := range table {
+		// OpenAPI has no wildcards, so {key...} is documented as {key}.
+		path := strings.ReplaceAll(strings.TrimSuffix(rt.Path, "{$}"), "...}", "}")
+		served = append(served, rt.Method+" "+path)
+	}
+	sort.Strings(served)
+
+	documented := load(t).Operations()
+	if missing := difference(served, documented); len(missing) > 0 {
+		t.Errorf("routes missing from openapi.json: %v", missing)
+	}
+	if stale := difference(documented, served); len(stale) > 0 {
+		t.Errorf("openapi.json documents routes that aren't served: %v", stale)
+	}
+}
+
+// TestSchemasMatchModels checks that each schema lists exactly the JSON
+// fields of the Go type it describes.
+func TestSchemasMatchModels(t *testing.T) {
+	spec := load(t)
+	for _, tc := range []struct {
+		schema string
+		model  any
+	}{
+		{"User", user.User{}},
+		{"Ride", ride.Ride{}},
+		{"Booking", booking.Booking{}},
+		{"Review", review.Review{}},
+		{"Vehicle", vehicle.Vehicle{}},
+		{"RideRules", preference.Rules{}},
+		{"RideFilter", preference.Filter{}},
+		{"Preferences", preference.Settings{}},
+		{"Verification", verification.Verification{}},
+		{"VerificationDocument", verification.Document{}},
+		{"PublicProfile", profile.Public{}},
+		{"TOTPEnrollment", user.TOTPEnrollment{}},
+		{"Problem", apperr.Problem{}},
+	} {
+		t.Run(tc.schema, func(t *testing.T) {
+			schema := spec.Schema(tc.schema)
+			if schema == nil {
+				t.Fatalf("no schema %s", tc.schema)
+			}
+			compareFields(t, schema, reflect.TypeOf(tc.model))
+		})
+	}
+	t.Run("JWK", func(t *testing.T) {
+		compareFields(t, spec.Schema("JWKS").Properties["keys"].Items, reflect.TypeOf(token.JWK{}))
+	})
+}
+
+// TestRefsResolve walks the document for $ref values.
+func TestRefsResolve(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openapi.Handler(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("served as %q", ct)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && !resolves(doc, ref) {
+				t.Errorf("unresolved $ref %s", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func TestValidateResponse(t *testing.T) {
+	spec := load(t)
+	const jsonType = "application/json"
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		status       int
+		contentType  string
+		body         string
+		wantErr      string
+	}{
+		{"booking", "POST", "/v1/bookings", 201, jsonType,
+			`{"booking_id":1,"user_id":2,"ride_id":3,"seat_count":1,"status":"pending","created_at":"2025-01-02T03:04:05Z"}`, ""},
+		{"undocumented field", "POST", "/v1/bookings", 201, jsonType,
+			`{"booking_id":1,"user_id":2,"ride_id":3,"seat_count":1,"status":"pending","created_at":"2025-01-02T03:04:05Z","extra":1}`, `undocumented property "extra"`},
+		{"missing field", "GET", "/v1/bookings", 200, jsonType,
+			`[{"booking_id":1,"user_id":2,"ride_id":3,"seat_count":1,"created_at":"2025-01-02T03:04:05Z"}]`, `missing required "status"`},
+		{"null list", "GET", "/v1/bookings", 200, jsonType, `null`, "null is not allowed"},
+		{"wrong type", "GET", "/v1/bookings", 200, jsonType,
+			`[{"booking_id":"1","user_id":2,"ride_id":3,"seat_count":1,"status":"pending","created_at":"2025-01-02T03:04:05Z"}]`, "body[0].booking_id: got 1, want an integer"},
+		{"literal path beats parameter", "GET", "/v1/rides/search", 200, "text/plain; charset=utf-8", `[]`, ""},
+		{"password leaked", "GET", "/v1/profile", 200, jsonType,
+			`{"id":1,"name":"A","email":"a@example.com","password":"x","created_at":"2025-01-02T03:04:05Z","two_factor_enabled":false,"email_verified":false}`, `write-only "password"`},
+		{"login challenge", "POST", "/v1/login", 200, jsonType, `{"two_factor_required":true,"challenge_token":"t"}`, ""},
+		{"problem", "GET", "/v1/rides/7", 404, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"Ride not found"}`, ""},
+		{"no content", "DELETE", "/v1/admin/rides/7", 204, "", ``, ""},
+		{"undocumented path", "GET", "/nope", 200, jsonType, `{}`, "not documented"},
+		{"router 404", "GET", "/nope", 404, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"No such endpoint","instance":"/nope"}`, ""},
+		{"router 405", "PUT", "/v1/bookings", 405, "application/problem+json", `{"status":405}`, `missing required "type"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := spec.ValidateResponse(tc.method, tc.path, tc.status, tc.contentType, []byte(tc.body))
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func compareFields(t *testing.T, schema *openapi.Schema, typ reflect.Type) {
+	t.Helper()
+	var fields []string
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	var props []string
+	for name := range schema.Properties {
+		props = append(props, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(props)
+	if missing := difference(fields, props); len(missing) > 0 {
+		t.Errorf("%s fields missing from the schema: %v", typ, missing)
+	}
+	if extra := difference(props, fields); len(extra) > 0 {
+		t.Errorf("schema properties %s doesn't have: %v", typ, extra)
+	}
+}
+
+func resolves(doc map[string]any, ref string) bool {
+	var v any = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		if v, ok = m[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// difference returns the items of a not in b.
+func difference(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,292 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of an OpenAPI schema object the validator
+// understands.
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []any              `json:"enum"`
+	Properties map[string]*Schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *Schema            `json:"items"`
+	OneOf      []*Schema          `json:"oneOf"`
+	Nullable   bool               `json:"nullable"`
+	WriteOnly  bool               `json:"writeOnly"`
+	// AdditionalProperties is false, true or a schema.
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]mediaType `json:"content"`
+}
+
+type operation struct {
+	Responses map[string]*response `json:"responses"`
+}
+
+// Spec is a parsed document.
+type Spec struct {
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*response `json:"responses"`
+	} `json:"components"`
+}
+
+// Load parses the served document.
+func Load() (*Spec, error) {
+	var s Spec
+	if err := json.Unmarshal(document, &s); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return &s, nil
+}
+
+// Operations lists the documented operations as "METHOD /path", sorted.
+func (s *Spec) Operations() []string {
+	var ops []string
+	for path, methods := range s.Paths {
+		for method := range methods {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Schema returns the component schema called name, or nil.
+func (s *Spec) Schema(name string) *Schema {
+	return s.Components.Schemas[name]
+}
+
+// ValidateResponse checks a response to method and path (a concrete path
+// such as /rides/7) against the documented response for its status, or
+// the default response. Undocumented paths and methods may only answer
+// with the router's 404 and 405 problem documents. Bodies that aren't JSON
+// are not checked.
+func (s *Spec) ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	var resp *response
+	if op := s.operation(method, path); op != nil {
+		resp = op.Responses[strconv.Itoa(status)]
+		if resp == nil {
+			resp = op.Responses["default"]
+		}
+	} else if status == http.StatusNotFound || status == http.StatusMethodNotAllowed {
+		resp = &response{Ref: "#/components/responses/Problem"}
+	} else {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+	if resp == nil {
+		return fmt.Errorf("%s %s: status %d is not documented", method, path, status)
+	}
+	if ref := resp.Ref; ref != "" {
+		resp = s.Components.Responses[strings.TrimPrefix(ref, "#/components/responses/")]
+		if resp == nil {
+			return fmt.Errorf("%s %s: unresolved response %s", method, path, ref)
+		}
+	}
+	if len(resp.Content) == 0 {
+		if len(strings.TrimSpace(string(body))) > 0 {
+			return fmt.Errorf("%s %s: status %d should have no body", method, path, status)
+		}
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	media, ok := resp.Content[mt]
+	if !ok {
+		// Handlers that encode JSON without setting a Content-Type are
+		// sniffed as text/plain.
+		media, ok = resp.Content["application/json"]
+		if !ok || mt != "text/plain" {
+			return fmt.Errorf("%s %s: status %d is documented as %s, got %s", method, path, status, keys(resp.Content), contentType)
+		}
+	}
+	if !strings.HasSuffix(mt, "json") && mt != "text/plain" || media.Schema == nil || media.Schema.Type == "string" {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("%s %s: body is not JSON: %v", method, path, err)
+	}
+	if err := s.validate(media.Schema, v, "body"); err != nil {
+		return fmt.Errorf("%s %s: %d response: %w", method, path, status, err)
+	}
+	return nil
+}
+
+// operation finds the operation for a concrete path. Literal segments
+// beat parameters, so /rides/search doesn't match /rides/{id}.
+func (s *Spec) operation(method, path string) *operation {
+	var best *operation
+	bestLiterals := -1
+	segments := strings.Split(path, "/")
+	for template, methods := range s.Paths {
+		op := methods[strings.ToLower(method)]
+		if op == nil {
+			continue
+		}
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		literals := 0
+		matched := true
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				matched = segments[i] != ""
+			} else {
+				matched = part == segments[i]
+				literals++
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched && literals > bestLiterals {
+			best, bestLiterals = op, literals
+		}
+	}
+	return best
+}
+
+func (s *Spec) validate(schema *Schema, v any, at string) error {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved := s.Components.Schemas[name]
+		if resolved == nil {
+			return fmt.Errorf("%s: unresolved %s", at, schema.Ref)
+		}
+		schema = resolved
+	}
+	if v == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+	if len(schema.OneOf) > 0 {
+		matches := 0
+		for _, alt := range schema.OneOf {
+			if s.validate(alt, v, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of the oneOf schemas, want 1", at, matches)
+		}
+		return nil
+	}
+	if len(schema.Enum) > 0 && !contains(schema.Enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", at, v, schema.Enum)
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want an object", at, v)
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", at, name)
+			}
+		}
+		var extra *Schema
+		allowExtra := string(schema.AdditionalProperties) == "true"
+		if len(schema.AdditionalProperties) > 0 && schema.AdditionalProperties[0] == '{' {
+			extra = &Schema{}
+			if err := json.Unmarshal(schema.AdditionalProperties, extra); err != nil {
+				return fmt.Errorf("%s: %v", at, err)
+			}
+		}
+		for _, name := range sortedKeys(obj) {
+			prop := schema.Properties[name]
+			switch {
+			case prop != nil && prop.WriteOnly:
+				return fmt.Errorf("%s: write-only %q was returned", at, name)
+			case prop == nil && extra != nil:
+				prop = extra
+			case prop == nil && allowExtra:
+				continue
+			case prop == nil:
+				return fmt.Errorf("%s: undocumented property %q", at, name)
+			}
+			if err := s.validate(prop, obj[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want an array", at, v)
+		}
+		for i, item := range items {
+			if err := s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want a string", at, v)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: got %v, want an integer", at, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: got %T, want a number", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: got %T, want a boolean", at, v)
+		}
+	}
+	return nil
+}
+
+func contains(list []any, v any) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func keys(m map[string]mediaType) string {
+	return strings.Join(sortedKeys(m), ", ")
+}
@@ -0,0 +1,130 @@
+// Package preference describes what drivers allow on their rides and what
+// riders look for, and decides whether the two are compatible.
+package preference
+
+import (
+	"slices"
+
+	"carpool/backend/internal/validation"
+)
+
+// Luggage sizes, smallest first.
+const (
+	LuggageNone   = "none"
+	LuggageSmall  = "small"
+	LuggageMedium = "medium"
+	LuggageLarge  = "large"
+)
+
+var luggageSizes = []string{LuggageNone, LuggageSmall, LuggageMedium, LuggageLarge}
+
+// ValidLuggage reports whether size is one of the luggage sizes.
+func ValidLuggage(size string) bool {
+	return slices.Contains(luggageSizes, size)
+}
+
+// LuggageAtLeast returns the sizes from size up, or nil if size is not
+// one of them.
+func LuggageAtLeast(size string) []string {
+	i := slices.Index(luggageSizes, size)
+	if i < 0 {
+		return nil
+	}
+	return luggageSizes[i:]
+}
+
+// Genders riders may declare. Only women may book women-only rides.
+const (
+	GenderFemale  = "female"
+	GenderMale    = "male"
+	GenderDiverse = "diverse"
+)
+
+// Rules are what a driver allows on a ride.
+type Rules struct {
+	Smoking bool `json:"smoking"`
+	Pets    bool `json:"pets"`
+	Music   bool `json:"music"`
+	// Quiet asks riders to keep conversation to a minimum.
+	Quiet bool `json:"quiet"`
+	// Luggage is the largest size each rider may bring.
+	Luggage   string `json:"luggage"`
+	WomenOnly bool   `json:"women_only"`
+}
+
+// DefaultRules apply to rides of drivers who haven't set their own.
+func DefaultRules() Rules {
+	return Rules{Luggage: LuggageMedium}
+}
+
+// Filter is what a rider looks for in a ride. Nil fields and an empty
+// Luggage match any ride.
+type Filter struct {
+	Smoking   *bool `json:"smoking,omitempty"`
+	Pets      *bool `json:"pets,omitempty"`
+	Music     *bool `json:"music,omitempty"`
+	Quiet     *bool `json:"quiet,omitempty"`
+	WomenOnly *bool `json:"women_only,omitempty"`
+	// Luggage is the size the rider brings; rides must allow at least it.
+	Luggage string `json:"luggage,omitempty"`
+}
+
+// Matches reports whether a ride with rules suits the filter.
+func (f *Filter) Matches(rules Rules) bool {
+	is := func(want *bool, got bool) bool { return want == nil || *want == got }
+	return is(f.Smoking, rules.Smoking) &&
+		is(f.Pets, rules.Pets) &&
+		is(f.Music, rules.Music) &&
+		is(f.Quiet, rules.Quiet) &&
+		is(f.WomenOnly, rules.WomenOnly) &&
+		(f.Luggage == "" || slices.Contains(LuggageAtLeast(f.Luggage), rules.Luggage))
+}
+
+// Settings are a user's stored preferences: the rules their rides get
+// unless they post with others, and the filter they search with.
+type Settings struct {
+	// Gender is only used to admit riders to women-only rides and is
+	// never shown to others.
+	Gender *string `json:"gender"`
+	Driver Rules   `json:"driver"`
+	Rider  Filter  `json:"rider"`
+}
+
+// DefaultSettings are the settings of users who haven't saved any.
+func DefaultSettings() *Settings {
+	return &Settings{Driver: DefaultRules()}
+}
+
+// Validate checks settings submitted by a user.
+func (s *Settings) Validate() validation.Errors {
+	v := &validation.Validator{}
+	v.Check(s.Gender == nil || validGender(*s.Gender), "gender", "must be female, male, diverse or null")
+	v.Check(ValidLuggage(s.Driver.Luggage), "driver.luggage", "must be none, small, medium or large")
+	v.Check(s.Rider.Luggage == "" || ValidLuggage(s.Rider.Luggage), "rider.luggage", "must be none, small, medium or large")
+	return v.Errors()
+}
+
+func validGender(gender string) bool {
+	switch gender {
+	case GenderFemale, GenderMale, GenderDiverse:
+		return true
+	}
+	return false
+}
+
+// Violation returns why a rider with settings may not join a ride with
+// rules, or "" if they may. Only hard rules count: women-only rides, and
+// the pets and luggage the rider brings. Smoking, music and quiet are
+// left to searching.
+func Violation(rules Rules, rider *Settings) string {
+	if rules.WomenOnly && (rider.Gender == nil || *rider.Gender != GenderFemale) {
+		return "This ride is for women only"
+	}
+	if rider.Rider.Pets != nil && *rider.Rider.Pets && !rules.Pets {
+		return "Pets are not allowed on this ride"
+	}
+	if rider.Rider.Luggage != "" && !slices.Contains(LuggageAtLeast(rider.Rider.Luggage), rules.Luggage) {
+		return "This ride has no room for " + rider.Rider.Luggage + " luggage"
+	}
+	return ""
+}
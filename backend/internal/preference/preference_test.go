@@ -0,0 +1,39 @@
+package preference
+
+import "testing"
+
+func TestViolation(t *testing.T) {
+	female, male := GenderFemale, GenderMale
+	yes, no := true, false
+	for _, tc := range []struct {
+		name  string
+		rules Rules
+		rider Settings
+		want  string
+	}{
+		{"defaults", DefaultRules(), *DefaultSettings(), ""},
+		{"women-only, no gender", Rules{Luggage: LuggageSmall, WomenOnly: true}, Settings{}, "This ride is for women only"},
+		{"women-only, man", Rules{Luggage: LuggageSmall, WomenOnly: true}, Settings{Gender: &male}, "This ride is for women only"},
+		{"women-only, woman", Rules{Luggage: LuggageSmall, WomenOnly: true}, Settings{Gender: &female}, ""},
+		{"pet", DefaultRules(), Settings{Rider: Filter{Pets: &yes}}, "Pets are not allowed on this ride"},
+		{"pet allowed", Rules{Pets: true, Luggage: LuggageMedium}, Settings{Rider: Filter{Pets: &yes}}, ""},
+		{"no pet", DefaultRules(), Settings{Rider: Filter{Pets: &no}}, ""},
+		{"too much luggage", DefaultRules(), Settings{Rider: Filter{Luggage: LuggageLarge}}, "This ride has no room for large luggage"},
+		{"room for luggage", DefaultRules(), Settings{Rider: Filter{Luggage: LuggageSmall}}, ""},
+		// Smoking, music and quiet only narrow searches.
+		{"soft rules", Rules{Smoking: true, Music: true, Luggage: LuggageNone}, Settings{Rider: Filter{Smoking: &no, Quiet: &yes}}, ""},
+	} {
+		if got := Violation(tc.rules, &tc.rider); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLuggageAtLeast(t *testing.T) {
+	if got := LuggageAtLeast(LuggageMedium); len(got) != 2 || got[0] != LuggageMedium || got[1] != LuggageLarge {
+		t.Errorf("LuggageAtLeast(medium) = %v", got)
+	}
+	if got := LuggageAtLeast("huge"); got != nil {
+		t.Errorf("LuggageAtLeast(huge) = %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+// Package profile shows users to each other.
+package profile
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/verification"
+)
+
+// recentReviews is how many reviews a public profile shows.
+const recentReviews = 5
+
+// Badges shown on public profiles.
+const (
+	BadgeEmailVerified = "email_verified"
+	// BadgeVerifiedDriver is shown once an admin has checked the user's
+	// license and insurance.
+	BadgeVerifiedDriver = "verified_driver"
+)
+
+// Public is what anyone may see about a user. It leaves out everything
+// that would let them contact the user outside the app, such as email and
+// phone.
+type Public struct {
+	ID                  int              `json:"id"`
+	Name                string           `json:"name"`
+	Bio                 *string          `json:"bio,omitempty"`
+	ProfilePic          *string          `json:"profile_pic,omitempty"`
+	ProfilePicThumbnail *string          `json:"profile_pic_thumbnail,omitempty"`
+	MemberSince         time.Time        `json:"member_since"`
+	Rating              *float64         `json:"rating,omitempty"`
+	RatingCount         int              `json:"rating_count"`
+	RidesDriven         int              `json:"rides_driven"`
+	RidesTaken          int              `json:"rides_taken"`
+	Badges              []string         `json:"badges"`
+	RecentReviews       []*review.Review `json:"recent_reviews"`
+}
+
+type Handler struct {
+	Users         *user.Service
+	Reviews       *review.Service
+	Verifications *verification.Service
+}
+
+// GetPublicProfileHandler returns the public profile of the user in the
+// path. Deleted and banned users have none.
+func (h *Handler) GetPublicProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid user id"))
+		return
+	}
+	u, err := h.Users.GetUserByID(r.Context(), userID)
+	if err == nil && (u.Status == user.StatusDeleted || u.Status == user.StatusBanned) {
+		err = apperr.NotFound("User not found")
+	}
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	stats, err := h.Users.GetStats(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	reviews, err := h.Reviews.RecentReviews(r.Context(), userID, recentReviews)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	verified, err := h.Verifications.IsVerified(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	badges := []string{}
+	if u.EmailVerified {
+		badges = append(badges, BadgeEmailVerified)
+	}
+	if verified {
+		badges = append(badges, BadgeVerifiedDriver)
+	}
+	json.NewEncoder(w).Encode(Public{
+		ID:                  u.ID,
+		Name:                u.Name,
+		Bio:                 u.Bio,
+		ProfilePic:          u.ProfilePic,
+		ProfilePicThumbnail: u.ProfilePicThumbnail,
+		MemberSince:         u.CreatedAt,
+		Rating:              u.Rating,
+		RatingCount:         stats.RatingCount,
+		RidesDriven:         stats.RidesDriven,
+		RidesTaken:          stats.RidesTaken,
+		Badges:              badges,
+		RecentReviews:       reviews,
+	})
+}
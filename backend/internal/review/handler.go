@@ -0,0 +1,45 @@
+package review
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/middleware"
+)
+
+type Handler struct {
+	Service *Service
+}
+
+// CreateReviewHandler rates someone the caller shared the ride in the
+// path with.
+func (h *Handler) CreateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	rideID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid ride id"))
+		return
+	}
+	var rev Review
+	if err := json.NewDecoder(r.Body).Decode(&rev); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return
+	}
+	if errs := rev.Validate(); errs != nil {
+		apperr.Write(w, r, errs)
+		return
+	}
+	rev.RideID = rideID
+	rev.ReviewerID = middleware.GetUserIDFromContext(r.Context())
+	rev.ReviewerName = nil
+	if rev.Comment != nil && *rev.Comment == "" {
+		rev.Comment = nil
+	}
+	if err := h.Service.CreateReview(r.Context(), &rev); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rev)
+}
@@ -0,0 +1,62 @@
+// Package review lets people who shared a ride rate each other.
+package review
+
+import (
+	"time"
+
+	"carpool/backend/internal/validation"
+)
+
+type Review struct {
+	ReviewID   int `json:"review_id,omitempty"`
+	RideID     int `json:"ride_id"`
+	ReviewerID int `json:"reviewer_id"`
+	// ReviewerName is filled in when reviews are listed.
+	ReviewerName *string   `json:"reviewer_name,omitempty"`
+	RevieweeID   int       `json:"reviewee_id"`
+	Rating       int       `json:"rating"`
+	Comment      *string   `json:"comment,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserReviews are the reviews a user wrote and received, newest first.
+type UserReviews struct {
+	Written  []*Review `json:"written"`
+	Received []*Review `json:"received"`
+}
+
+// Participants are the people on a ride who may review each other: the
+// driver and riders whose bookings weren't cancelled.
+type Participants struct {
+	DriverID int
+	RiderIDs []int
+	RideTime time.Time
+	// Open is false for cancelled and removed rides.
+	Open bool
+}
+
+// Includes reports whether userID was on the ride.
+func (p *Participants) Includes(userID int) bool {
+	if userID == p.DriverID {
+		return true
+	}
+	for _, id := range p.RiderIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+const maxCommentLength = 1000
+
+// Validate checks the fields a reviewer supplies.
+func (r *Review) Validate() validation.Errors {
+	v := &validation.Validator{}
+	v.Check(r.RevieweeID > 0, "reviewee_id", "is required")
+	v.Check(r.Rating >= 1 && r.Rating <= 5, "rating", "must be between 1 and 5")
+	if r.Comment != nil {
+		v.MaxLength("comment", *r.Comment, maxCommentLength)
+	}
+	return v.Errors()
+}
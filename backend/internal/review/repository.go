@@ -0,0 +1,123 @@
+package review
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/deadline"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the Postgres error code for a UNIQUE constraint failure.
+const uniqueViolation = "23505"
+
+type Repository struct {
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
+}
+
+func (r *Repository) CreateReview(ctx context.Context, rev *Review) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "review.CreateReview")
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO reviews (ride_id, reviewer_id, reviewee_id, rating, comment, created_at)
+        VALUES ($1, $2, $3, $4, $5, NOW())
+        RETURNING review_id, created_at
+    `
+	err = tx.QueryRowContext(ctx, query, rev.RideID, rev.ReviewerID, rev.RevieweeID, rev.Rating, rev.Comment).Scan(&rev.ReviewID, &rev.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return apperr.Conflict("You have already reviewed this person for this ride")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET rating = (SELECT AVG(rating) FROM reviews WHERE reviewee_id = $1) WHERE user_id = $1`, rev.RevieweeID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) ListReviewsFor(ctx context.Context, revieweeID, limit int) ([]*Review, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "review.ListReviewsFor")
+	defer cancel()
+
+	query := `
+        SELECT v.review_id, v.ride_id, v.reviewer_id, u.name, v.reviewee_id, v.rating, v.comment, v.created_at
+        FROM reviews v
+        JOIN users u ON u.user_id = v.reviewer_id
+        WHERE v.reviewee_id = $1
+        ORDER BY v.created_at DESC, v.review_id DESC
+        LIMIT $2
+    `
+	return r.listReviews(ctx, query, revieweeID, limit)
+}
+
+func (r *Repository) ListUserReviews(ctx context.Context, userID int) ([]*Review, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "review.ListUserReviews")
+	defer cancel()
+
+	query := `
+        SELECT v.review_id, v.ride_id, v.reviewer_id, u.name, v.reviewee_id, v.rating, v.comment, v.created_at
+        FROM reviews v
+        JOIN users u ON u.user_id = v.reviewer_id
+        WHERE v.reviewer_id = $1 OR v.reviewee_id = $1
+        ORDER BY v.created_at DESC, v.review_id DESC
+    `
+	return r.listReviews(ctx, query, userID)
+}
+
+func (r *Repository) listReviews(ctx context.Context, query string, args ...any) ([]*Review, error) {
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reviews := []*Review{}
+	for rows.Next() {
+		rev := &Review{}
+		if err := rows.Scan(&rev.ReviewID, &rev.RideID, &rev.ReviewerID, &rev.ReviewerName, &rev.RevieweeID, &rev.Rating, &rev.Comment, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rev)
+	}
+	return reviews, rows.Err()
+}
+
+func (r *Repository) GetParticipants(ctx context.Context, rideID int) (*Participants, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "review.GetParticipants")
+	defer cancel()
+
+	p := &Participants{}
+	query := `
+        SELECT user_id, ride_time, ride_status IS NULL OR ride_status NOT IN ('removed', 'cancelled')
+        FROM rides WHERE ride_id = $1
+    `
+	if err := r.DB.QueryRowContext(ctx, query, rideID).Scan(&p.DriverID, &p.RideTime, &p.Open); err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, `SELECT DISTINCT user_id FROM bookings WHERE ride_id = $1 AND status <> 'cancelled' ORDER BY user_id`, rideID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		p.RiderIDs = append(p.RiderIDs, id)
+	}
+	return p, rows.Err()
+}
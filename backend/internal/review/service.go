@@ -0,0 +1,63 @@
+package review
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"carpool/backend/internal/apperr"
+)
+
+type Service struct {
+	Repo Store
+}
+
+// CreateReview records a rating by one person on a departed ride of
+// another: the driver rates riders and riders rate the driver.
+func (s *Service) CreateReview(ctx context.Context, rev *Review) error {
+	p, err := s.Repo.GetParticipants(ctx, rev.RideID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("Ride not found")
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case !p.Includes(rev.ReviewerID):
+		return apperr.Forbidden("Only people on a ride can review it")
+	case !p.Open:
+		return apperr.Conflict("Cancelled rides can't be reviewed")
+	case p.RideTime.After(time.Now()):
+		return apperr.Conflict("Rides can be reviewed once they have departed")
+	case rev.RevieweeID == rev.ReviewerID:
+		return apperr.BadRequest("You can't review yourself")
+	case !p.Includes(rev.RevieweeID):
+		return apperr.NotFound("That person wasn't on this ride")
+	case rev.ReviewerID != p.DriverID && rev.RevieweeID != p.DriverID:
+		return apperr.BadRequest("Riders review the driver, not each other")
+	}
+	return s.Repo.CreateReview(ctx, rev)
+}
+
+// RecentReviews returns up to limit reviews of the user, newest first.
+func (s *Service) RecentReviews(ctx context.Context, userID, limit int) ([]*Review, error) {
+	return s.Repo.ListReviewsFor(ctx, userID, limit)
+}
+
+// UserReviews returns the reviews the user wrote and received.
+func (s *Service) UserReviews(ctx context.Context, userID int) (*UserReviews, error) {
+	all, err := s.Repo.ListUserReviews(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	reviews := &UserReviews{Written: []*Review{}, Received: []*Review{}}
+	for _, rev := range all {
+		if rev.ReviewerID == userID {
+			reviews.Written = append(reviews.Written, rev)
+		} else {
+			reviews.Received = append(reviews.Received, rev)
+		}
+	}
+	return reviews, nil
+}
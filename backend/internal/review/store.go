@@ -0,0 +1,22 @@
+package review
+
+import "context"
+
+// Store persists reviews. Repository implements it on Postgres; memstore
+// implements it in memory for tests. Lookups of a missing ride return
+// sql.ErrNoRows.
+type Store interface {
+	// CreateReview fills in ReviewID and CreatedAt and updates the
+	// reviewee's average rating. A second review of the same person for
+	// the same ride is an apperr.Conflict.
+	CreateReview(ctx context.Context, r *Review) error
+	// ListReviewsFor returns up to limit reviews of the user, newest
+	// first, with their reviewers' names.
+	ListReviewsFor(ctx context.Context, revieweeID, limit int) ([]*Review, error)
+	// ListUserReviews returns every review the user wrote or received,
+	// newest first, with their reviewers' names.
+	ListUserReviews(ctx context.Context, userID int) ([]*Review, error)
+	GetParticipants(ctx context.Context, rideID int) (*Participants, error)
+}
+
+var _ Store = (*Repository)(nil)
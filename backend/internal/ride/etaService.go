@@ -1,13 +1,38 @@
 package ride
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/go-resty/resty/v2"
-	"os"
+	"net/http"
+	"strings"
 	"time"
+
+	"carpool/backend/internal/metrics"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = otel.Tracer("carpool/backend/internal/ride")
+
+// DurationEstimator returns the driving time in minutes between two points.
+type DurationEstimator interface {
+	GetDuration(ctx context.Context, fromLon, fromLat, toLon, toLat float64) (int, error)
+}
+
+// ORSClient estimates driving times with the openrouteservice directions
+// API.
+type ORSClient struct {
+	APIKey  string
+	BaseURL string
+	Timeout time.Duration
+}
+
 type ORSResponse struct {
 	Features []struct {
 		Properties struct {
@@ -19,35 +44,58 @@ type ORSResponse struct {
 }
 
 // GetDuration returns the duration (in minutes) for a trip.
-func GetDuration(fromLon, fromLat, toLon, toLat float64) (int, error) {
-    apiKey := os.Getenv("ORS_API_KEY")
-    if apiKey == "" {
-        return 0, fmt.Errorf("ORS_API_KEY not set")
-    }
+func (c *ORSClient) GetDuration(ctx context.Context, fromLon, fromLat, toLon, toLat float64) (int, error) {
+	if c.APIKey == "" {
+		return 0, errors.New("ORS_API_KEY not set")
+	}
+	ctx, span := tracer.Start(ctx, "openrouteservice.GetDuration")
+	defer span.End()
 
-    client := resty.New()
-    client.SetTimeout(10 * time.Second)
-
-    url := fmt.Sprintf("https://api.openrouteservice.org/v2/directions/driving-car?api_key=%s&start=%f,%f&end=%f,%f",
-        apiKey, fromLon, fromLat, toLon, toLat)
+	start := time.Now()
+	minutes, err := c.getDuration(ctx, fromLon, fromLat, toLon, toLat)
+	metrics.ObserveRoutingCall("openrouteservice", time.Since(start), err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("route.duration_minutes", minutes))
+	}
+	return minutes, err
+}
 
-    resp, err := client.R().Get(url)
-    if err != nil {
-        return 0, err
-    }
+func (c *ORSClient) getDuration(ctx context.Context, fromLon, fromLat, toLon, toLat float64) (int, error) {
+	client := resty.New()
+	client.SetTimeout(c.Timeout)
+	// The transport adds a client span and the traceparent header.
+	client.SetTransport(otelhttp.NewTransport(http.DefaultTransport))
 
+	resp, err := client.R().
+		SetContext(ctx).
+		// Sent as a header rather than ?api_key= so the key stays out of
+		// URLs recorded in traces and logs.
+		SetHeader("Authorization", c.APIKey).
+		SetQueryParams(map[string]string{
+			"start": fmt.Sprintf("%f,%f", fromLon, fromLat),
+			"end":   fmt.Sprintf("%f,%f", toLon, toLat),
+		}).
+		Get(strings.TrimSuffix(c.BaseURL, "/") + "/v2/directions/driving-car")
+	if err != nil {
+		return 0, err
+	}
+	if resp.IsError() {
+		return 0, fmt.Errorf("openrouteservice returned %s", resp.Status())
+	}
 
-    var orsResp ORSResponse
-    if err := json.Unmarshal(resp.Body(), &orsResp); err != nil {
-        return 0, err
-    }
+	var orsResp ORSResponse
+	if err := json.Unmarshal(resp.Body(), &orsResp); err != nil {
+		return 0, err
+	}
 
-    if len(orsResp.Features) == 0 || len(orsResp.Features[0].Properties.Segments) == 0 {
-        return 0, fmt.Errorf("no route found")
-    }
+	if len(orsResp.Features) == 0 || len(orsResp.Features[0].Properties.Segments) == 0 {
+		return 0, fmt.Errorf("no route found")
+	}
 
-    durationSeconds := orsResp.Features[0].Properties.Segments[0].Duration
-    minutes := int(durationSeconds / 60)
-    return minutes, nil
+	durationSeconds := orsResp.Features[0].Properties.Segments[0].Duration
+	minutes := int(durationSeconds / 60)
+	return minutes, nil
 }
-
@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
+	"carpool/backend/internal/apperr"
 	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/validation"
 )
 
 type Handler struct {
@@ -16,50 +20,58 @@ type Handler struct {
 
 // PostRideHandler allows an authenticated user to post a new ride.
 func (h *Handler) PostRideHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var ride Ride
 	// Decode the JSON payload into the Ride struct.
 	if err := json.NewDecoder(r.Body).Decode(&ride); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return
+	}
+
+	if errs := ride.Validate(); errs != nil {
+		apperr.Write(w, r, errs)
 		return
 	}
-	log.Println("Decoded Ride Payload:", ride)
 
 	// Retrieve the user ID from JWT middleware context.
 	ride.UserID = middleware.GetUserIDFromContext(r.Context())
-	log.Println("User ID from JWT context:", ride.UserID)
 
 	// Create the ride via the Service layer.
-	if err := h.Service.CreateRide(&ride); err != nil {
+	if err := h.Service.CreateRide(r.Context(), &ride); err != nil {
 		log.Println("Error creating ride in service:", err)
-		http.Error(w, "Error posting ride", http.StatusInternalServerError)
+		apperr.Write(w, r, err)
 		return
 	}
 
-	log.Println("Ride created successfully:", ride)
 	// Write header and JSON response only once.
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(ride)
 }
 
-// SearchRidesHandler checks for "all=true" to return all rides,
-// otherwise applies filters: geospatial proximity, time window, and seat availability.
-func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+// GetRideHandler returns the ride {id}.
+func (h *Handler) GetRideHandler(w http.ResponseWriter, r *http.Request) {
+	rideID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid ride id"))
+		return
+	}
+	ride, err := h.Service.GetRide(r.Context(), rideID)
+	if err != nil {
+		apperr.Write(w, r, err)
 		return
 	}
+	json.NewEncoder(w).Encode(ride)
+}
 
+// SearchRidesHandler checks for "all=true" to return all rides,
+// otherwise applies filters: geospatial proximity, time window, seat availability
+// and the ride rules the rider asks for.
+func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if "all" flag is set.
 	allParam := r.URL.Query().Get("all")
 	if allParam == "true" {
-		rides, err := h.Service.GetAllRides()
+		rides, err := h.Service.GetAllRides(r.Context())
 		if err != nil {
-			http.Error(w, "Error fetching all rides", http.StatusInternalServerError)
+			apperr.Write(w, r, err)
 			return
 		}
 		json.NewEncoder(w).Encode(rides)
@@ -80,7 +92,7 @@ func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 
 	if fromLonStr == "" || fromLatStr == "" || toLonStr == "" || toLatStr == "" ||
 		rideDateStr == "" || rideTimeStr == "" {
-		http.Error(w, "Invalid or missing coordinate or time parameters", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid or missing coordinate or time parameters"))
 		return
 	}
 
@@ -90,7 +102,17 @@ func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 	toLon, err3 := strconv.ParseFloat(toLonStr, 64)
 	toLat, err4 := strconv.ParseFloat(toLatStr, 64)
 	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
-		http.Error(w, "Invalid coordinate parameters", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid coordinate parameters"))
+		return
+	}
+	v := &validation.Validator{}
+	v.Longitude("fromLon", fromLon)
+	v.Latitude("fromLat", fromLat)
+	v.Longitude("toLon", toLon)
+	v.Latitude("toLat", toLat)
+	filter := parseFilter(v, r.URL.Query())
+	if errs := v.Errors(); errs != nil {
+		apperr.Write(w, r, errs)
 		return
 	}
 
@@ -98,7 +120,7 @@ func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 	fullRideTimeStr := rideDateStr + "T" + rideTimeStr + ":00Z"
 	rideTime, err := time.Parse(time.RFC3339, fullRideTimeStr)
 	if err != nil {
-		http.Error(w, "Invalid ride time format (expected RFC3339, e.g., 2025-03-02T10:00:00Z)", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid ride time format (expected RFC3339, e.g., 2025-03-02T10:00:00Z)"))
 		return
 	}
 
@@ -110,8 +132,8 @@ func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Parse maxDistance parameter (default to 5 if not provided).
-	maxDistance := 5
+	// Parse maxDistance parameter (the service applies its default if not provided).
+	maxDistance := 0
 	if maxDistanceStr != "" {
 		if val, err := strconv.Atoi(maxDistanceStr); err == nil {
 			maxDistance = val
@@ -119,11 +141,35 @@ func (h *Handler) SearchRidesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the Service method to search for rides.
-	rides, err := h.Service.SearchRidesFiltered(fromLon, fromLat, toLon, toLat, rideTime, numPeople, maxDistance)
+	rides, err := h.Service.SearchRidesFiltered(r.Context(), fromLon, fromLat, toLon, toLat, rideTime, numPeople, maxDistance, filter)
 	if err != nil {
-		http.Error(w, "Error searching rides", http.StatusInternalServerError)
+		apperr.Write(w, r, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(rides)
 }
+
+// parseFilter reads the optional smoking, pets, music, quiet and womenOnly
+// ("true" or "false") and luggage parameters of a search.
+func parseFilter(v *validation.Validator, q url.Values) preference.Filter {
+	flag := func(name string) *bool {
+		s := q.Get(name)
+		if s == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		v.Check(err == nil, name, "must be true or false")
+		return &b
+	}
+	filter := preference.Filter{
+		Smoking:   flag("smoking"),
+		Pets:      flag("pets"),
+		Music:     flag("music"),
+		Quiet:     flag("quiet"),
+		WomenOnly: flag("womenOnly"),
+		Luggage:   q.Get("luggage"),
+	}
+	v.Check(filter.Luggage == "" || preference.ValidLuggage(filter.Luggage), "luggage", "must be none, small, medium or large")
+	return filter
+}
@@ -1,30 +1,75 @@
 package ride
 
-import "time"
+import (
+	"time"
+
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/validation"
+	"carpool/backend/internal/vehicle"
+)
 
 type Ride struct {
-	RideID          int       `json:"ride_id,omitempty"`
-	UserID          int       `json:"user_id"`
-	FromLon         float64   `json:"from_lon"`
-	FromLat         float64   `json:"from_lat"`
-	ToLon           float64   `json:"to_lon"`
-	ToLat           float64   `json:"to_lat"`
-	FromAddress     string    `json:"from_address,omitempty"`
-	ToAddress       string    `json:"to_address,omitempty"`
-	Price           float64   `json:"price"`
-	RideTime        time.Time `json:"ride_time"`
-	ETA             *string   `json:"eta,omitempty"` // changed to pointer
-	AvailableSeats  int       `json:"available_seats,omitempty"`
+	RideID      int       `json:"ride_id,omitempty"`
+	UserID      int       `json:"user_id"`
+	FromLon     float64   `json:"from_lon"`
+	FromLat     float64   `json:"from_lat"`
+	ToLon       float64   `json:"to_lon"`
+	ToLat       float64   `json:"to_lat"`
+	FromAddress string    `json:"from_address,omitempty"`
+	ToAddress   string    `json:"to_address,omitempty"`
+	Price       float64   `json:"price"`
+	RideTime    time.Time `json:"ride_time"`
+	ETA         *string   `json:"eta,omitempty"` // changed to pointer
+	// ArrivalTime is the full timestamp behind ETA, which only shows HH:MM.
+	ArrivalTime    *time.Time `json:"arrival_time,omitempty"`
+	AvailableSeats int        `json:"available_seats,omitempty"`
+	// VehicleID is required when posting a ride. Rides whose vehicle has
+	// since been deleted have none.
+	VehicleID *int             `json:"vehicle_id,omitempty"`
+	Vehicle   *vehicle.Vehicle `json:"vehicle,omitempty"`
+	// CarType describes the vehicle as it was when the ride was posted.
 	CarType         string    `json:"car_type,omitempty"`
 	RideStatus      *string   `json:"ride_status,omitempty"`      // changed to pointer
 	AdditionalNotes *string   `json:"additional_notes,omitempty"` // changed to pointer
 	CreatedAt       time.Time `json:"created_at,omitempty"`
 	DriverName      *string   `json:"driver_name,omitempty"` // changed to pointer
-	DriverRating    *float64  `json:"driver_rating,omitempty"` 
+	DriverRating    *float64  `json:"driver_rating,omitempty"`
 	InstantBooking  bool      `json:"instant_booking"`
-
+	// DriverVerified is whether an admin checked the driver's license
+	// and insurance.
+	DriverVerified bool `json:"driver_verified"`
+	// Rules are what the driver allows. Rides posted without them get the
+	// driver's default rules.
+	Rules *preference.Rules `json:"rules,omitempty"`
 
 	// Calculated distances returned from geospatial queries.
 	OriginDistance      float64 `json:"origin_distance,omitempty"`
 	DestinationDistance float64 `json:"destination_distance,omitempty"`
 }
+
+// Ride statuses stored in rides.ride_status. Removed (by an admin) and
+// cancelled rides are hidden from listings and can no longer be booked.
+const (
+	StatusRemoved   = "removed"
+	StatusCancelled = "cancelled"
+)
+
+// Validate checks a ride submitted by a driver.
+func (r *Ride) Validate() validation.Errors {
+	v := &validation.Validator{}
+	v.Longitude("from_lon", r.FromLon)
+	v.Latitude("from_lat", r.FromLat)
+	v.Longitude("to_lon", r.ToLon)
+	v.Latitude("to_lat", r.ToLat)
+	v.MaxLength("from_address", r.FromAddress, 255)
+	v.MaxLength("to_address", r.ToAddress, 255)
+	v.Check(r.Price >= 0, "price", "must not be negative")
+	v.Check(r.AvailableSeats >= 1, "available_seats", "must be at least 1")
+	v.Check(!r.RideTime.IsZero(), "ride_time", "is required")
+	v.Check(r.RideTime.After(time.Now()), "ride_time", "must be in the future")
+	v.Check(r.VehicleID != nil, "vehicle_id", "is required")
+	if r.Rules != nil {
+		v.Check(preference.ValidLuggage(r.Rules.Luggage), "rules.luggage", "must be none, small, medium or large")
+	}
+	return v.Errors()
+}
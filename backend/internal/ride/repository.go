@@ -1,17 +1,68 @@
 package ride
 
 import (
+	"context"
 	"database/sql"
-	//"log"
 	"time"
+
+	"carpool/backend/internal/deadline"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/vehicle"
+
+	"github.com/lib/pq"
 )
 
 type Repository struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
+}
+
+// vehicleColumns are the columns of the vehicle joined as v, in the order
+// joinedVehicle is scanned.
+const vehicleColumns = `v.vehicle_id, v.user_id, v.make, v.model, v.color, v.plate, v.seats, v.photos, v.created_at`
+
+// ruleColumns are the ride's rules, scanned into a preference.Rules in
+// field order.
+const ruleColumns = `r.smoking, r.pets, r.music, r.quiet, r.luggage, r.women_only`
+
+// joinedVehicle receives vehicleColumns from a LEFT JOIN, which are all
+// NULL for rides without a vehicle.
+type joinedVehicle struct {
+	id, userID, seats         sql.NullInt64
+	make, model, color, plate sql.NullString
+	photos                    pq.StringArray
+	createdAt                 sql.NullTime
 }
 
-func (r *Repository) CreateRide(ride *Ride) error {
-    query := `
+// attach sets the ride's vehicle, if it has one.
+func (v *joinedVehicle) attach(ride *Ride) {
+	if !v.id.Valid {
+		return
+	}
+	id := int(v.id.Int64)
+	ride.VehicleID = &id
+	ride.Vehicle = &vehicle.Vehicle{
+		VehicleID: id,
+		UserID:    int(v.userID.Int64),
+		Make:      v.make.String,
+		Model:     v.model.String,
+		Color:     v.color.String,
+		Plate:     v.plate.String,
+		Seats:     int(v.seats.Int64),
+		Photos:    v.photos,
+		CreatedAt: v.createdAt.Time,
+	}
+}
+
+func (r *Repository) CreateRide(ctx context.Context, ride *Ride) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.CreateRide")
+	defer cancel()
+
+	rules := preference.DefaultRules()
+	if ride.Rules != nil {
+		rules = *ride.Rules
+	}
+	query := `
         INSERT INTO rides (
             user_id,
             from_lon, 
@@ -26,34 +77,49 @@ func (r *Repository) CreateRide(ride *Ride) error {
             car_type,
             instant_booking,
             eta,
+            vehicle_id,
+            smoking,
+            pets,
+            music,
+            quiet,
+            luggage,
+            women_only,
             created_at
         ) VALUES (
-            $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW()
+            $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, NOW()
         )
         RETURNING ride_id, created_at
     `
-    return r.DB.QueryRow(
-        query,
-        ride.UserID,
-        ride.FromLon,
-        ride.FromLat,
-        ride.ToLon,
-        ride.ToLat,
-        ride.FromAddress,
-        ride.ToAddress,
-        ride.Price,
-        ride.RideTime,
-        ride.AvailableSeats,
-        ride.CarType,
-        ride.InstantBooking,  // New field
-        ride.ETA,
-    ).Scan(&ride.RideID, &ride.CreatedAt)
+	return r.DB.QueryRowContext(ctx,
+		query,
+		ride.UserID,
+		ride.FromLon,
+		ride.FromLat,
+		ride.ToLon,
+		ride.ToLat,
+		ride.FromAddress,
+		ride.ToAddress,
+		ride.Price,
+		ride.RideTime,
+		ride.AvailableSeats,
+		ride.CarType,
+		ride.InstantBooking, // New field
+		ride.ArrivalTime,
+		ride.VehicleID,
+		rules.Smoking,
+		rules.Pets,
+		rules.Music,
+		rules.Quiet,
+		rules.Luggage,
+		rules.WomenOnly,
+	).Scan(&ride.RideID, &ride.CreatedAt)
 }
 
-
-
 // GetAllRides retrieves all rides ordered by ride_time.
-func (r *Repository) GetAllRides() ([]*Ride, error) {
+func (r *Repository) GetAllRides(ctx context.Context) ([]*Ride, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.GetAllRides")
+	defer cancel()
+
 	query := `
         SELECT 
             r.ride_id, 
@@ -70,23 +136,32 @@ func (r *Repository) GetAllRides() ([]*Ride, error) {
             r.car_type,
             r.ride_status, 
             r.additional_notes, 
+            to_char(r.eta, 'HH24:MI'),
             r.eta,
             r.created_at,
             u.name as driver_name,
-			u.rating as driver_rating
+			u.rating as driver_rating,
+            ` + ruleColumns + `,
+            COALESCE(dv.status = 'verified', FALSE),
+            ` + vehicleColumns + `
         FROM rides r
         LEFT JOIN users u ON r.user_id = u.user_id
+        LEFT JOIN vehicles v ON r.vehicle_id = v.vehicle_id
+        LEFT JOIN driver_verifications dv ON r.user_id = dv.user_id
+        WHERE r.ride_status IS NULL OR r.ride_status NOT IN ('removed', 'cancelled')
         ORDER BY r.ride_time ASC;
     `
-	rows, err := r.DB.Query(query)
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var rides []*Ride
+	rides := []*Ride{}
 	for rows.Next() {
 		var ride Ride
+		var rules preference.Rules
+		var v joinedVehicle
 		err := rows.Scan(
 			&ride.RideID,
 			&ride.UserID,
@@ -103,57 +178,234 @@ func (r *Repository) GetAllRides() ([]*Ride, error) {
 			&ride.RideStatus,
 			&ride.AdditionalNotes,
 			&ride.ETA,
+			&ride.ArrivalTime,
 			&ride.CreatedAt,
 			&ride.DriverName,
 			&ride.DriverRating,
+			&rules.Smoking, &rules.Pets, &rules.Music, &rules.Quiet, &rules.Luggage, &rules.WomenOnly,
+			&ride.DriverVerified,
+			&v.id, &v.userID, &v.make, &v.model, &v.color, &v.plate, &v.seats, &v.photos, &v.createdAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		ride.Rules = &rules
+		v.attach(&ride)
 		rides = append(rides, &ride)
 	}
 	return rides, nil
 }
 
-// SearchRidesFiltered applies geospatial filtering (via PostGIS), time window filtering, and seat availability filtering. It returns rides matching the criteria.
+// GetRideByID returns a single ride with its driver's name and rating, or
+// sql.ErrNoRows.
+func (r *Repository) GetRideByID(ctx context.Context, rideID int) (*Ride, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.GetRideByID")
+	defer cancel()
+
+	query := `
+        SELECT
+            r.ride_id,
+            r.user_id,
+            r.from_lon,
+            r.from_lat,
+            r.to_lon,
+            r.to_lat,
+            r.from_address,
+            r.to_address,
+            r.price,
+            r.ride_time,
+            r.available_seats,
+            r.car_type,
+            r.ride_status,
+            r.additional_notes,
+            to_char(r.eta, 'HH24:MI'),
+            r.eta,
+            r.instant_booking,
+            r.created_at,
+            u.name as driver_name,
+            u.rating as driver_rating,
+            ` + ruleColumns + `,
+            COALESCE(dv.status = 'verified', FALSE),
+            ` + vehicleColumns + `
+        FROM rides r
+        LEFT JOIN users u ON r.user_id = u.user_id
+        LEFT JOIN vehicles v ON r.vehicle_id = v.vehicle_id
+        LEFT JOIN driver_verifications dv ON r.user_id = dv.user_id
+        WHERE r.ride_id = $1
+    `
+	var ride Ride
+	var rules preference.Rules
+	var v joinedVehicle
+	err := r.DB.QueryRowContext(ctx, query, rideID).Scan(
+		&ride.RideID,
+		&ride.UserID,
+		&ride.FromLon,
+		&ride.FromLat,
+		&ride.ToLon,
+		&ride.ToLat,
+		&ride.FromAddress,
+		&ride.ToAddress,
+		&ride.Price,
+		&ride.RideTime,
+		&ride.AvailableSeats,
+		&ride.CarType,
+		&ride.RideStatus,
+		&ride.AdditionalNotes,
+		&ride.ETA,
+		&ride.ArrivalTime,
+		&ride.InstantBooking,
+		&ride.CreatedAt,
+		&ride.DriverName,
+		&ride.DriverRating,
+		&rules.Smoking, &rules.Pets, &rules.Music, &rules.Quiet, &rules.Luggage, &rules.WomenOnly,
+		&ride.DriverVerified,
+		&v.id, &v.userID, &v.make, &v.model, &v.color, &v.plate, &v.seats, &v.photos, &v.createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ride.Rules = &rules
+	v.attach(&ride)
+	return &ride, nil
+}
+
+// GetRidesByUser returns every ride the user has posted, including
+// cancelled and removed ones, newest first.
+func (r *Repository) GetRidesByUser(ctx context.Context, userID int) ([]*Ride, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.GetRidesByUser")
+	defer cancel()
+
+	query := `
+        SELECT
+            r.ride_id,
+            r.user_id,
+            r.from_lon,
+            r.from_lat,
+            r.to_lon,
+            r.to_lat,
+            r.from_address,
+            r.to_address,
+            r.price,
+            r.ride_time,
+            r.available_seats,
+            r.car_type,
+            r.ride_status,
+            r.additional_notes,
+            to_char(r.eta, 'HH24:MI'),
+            r.eta,
+            r.instant_booking,
+            r.created_at,
+            ` + ruleColumns + `,
+            COALESCE(dv.status = 'verified', FALSE),
+            ` + vehicleColumns + `
+        FROM rides r
+        LEFT JOIN vehicles v ON r.vehicle_id = v.vehicle_id
+        LEFT JOIN driver_verifications dv ON r.user_id = dv.user_id
+        WHERE r.user_id = $1
+        ORDER BY r.ride_time DESC
+    `
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rides := []*Ride{}
+	for rows.Next() {
+		var ride Ride
+		var rules preference.Rules
+		var v joinedVehicle
+		err := rows.Scan(
+			&ride.RideID,
+			&ride.UserID,
+			&ride.FromLon,
+			&ride.FromLat,
+			&ride.ToLon,
+			&ride.ToLat,
+			&ride.FromAddress,
+			&ride.ToAddress,
+			&ride.Price,
+			&ride.RideTime,
+			&ride.AvailableSeats,
+			&ride.CarType,
+			&ride.RideStatus,
+			&ride.AdditionalNotes,
+			&ride.ETA,
+			&ride.ArrivalTime,
+			&ride.InstantBooking,
+			&ride.CreatedAt,
+			&rules.Smoking, &rules.Pets, &rules.Music, &rules.Quiet, &rules.Luggage, &rules.WomenOnly,
+			&ride.DriverVerified,
+			&v.id, &v.userID, &v.make, &v.model, &v.color, &v.plate, &v.seats, &v.photos, &v.createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ride.Rules = &rules
+		v.attach(&ride)
+		rides = append(rides, &ride)
+	}
+	return rides, rows.Err()
+}
+
+// SearchRidesFiltered applies geospatial filtering (via PostGIS), time
+// window filtering, seat availability and preference filtering. It returns
+// rides matching the criteria.
 func (r *Repository) SearchRidesFiltered(
+	ctx context.Context,
 	fromLon, fromLat, toLon, toLat float64,
 	timeLowerBound, timeUpperBound time.Time,
 	numPeople int,
 	maximumDistance int,
+	filter preference.Filter,
 ) ([]*Ride, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.SearchRidesFiltered")
+	defer cancel()
+
 	query := `
         SELECT 
-            ride_id, 
-            user_id,
-            from_lon, 
-            from_lat,
-            to_lon, 
-            to_lat,
-            from_address, 
-            to_address,
-            price, 
-            ride_time,
-            available_seats, 
-            car_type,
-            created_at
-        FROM rides
+            r.ride_id, 
+            r.user_id,
+            r.from_lon, 
+            r.from_lat,
+            r.to_lon, 
+            r.to_lat,
+            r.from_address, 
+            r.to_address,
+            r.price, 
+            r.ride_time,
+            r.available_seats, 
+            r.car_type,
+            r.created_at,
+            ` + ruleColumns + `,
+            COALESCE(dv.status = 'verified', FALSE),
+            ` + vehicleColumns + `
+        FROM rides r
+        LEFT JOIN vehicles v ON r.vehicle_id = v.vehicle_id
+        LEFT JOIN driver_verifications dv ON r.user_id = dv.user_id
         WHERE
             ST_DWithin(
-                ST_SetSRID(ST_MakePoint(from_lon, from_lat), 4326)::geography,
+                ST_SetSRID(ST_MakePoint(r.from_lon, r.from_lat), 4326)::geography,
                 ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
                 $3
             )
             AND ST_DWithin(
-                ST_SetSRID(ST_MakePoint(to_lon, to_lat), 4326)::geography,
+                ST_SetSRID(ST_MakePoint(r.to_lon, r.to_lat), 4326)::geography,
                 ST_SetSRID(ST_MakePoint($4, $5), 4326)::geography,
                 $3
             )
-            AND ride_time BETWEEN $6 AND $7
-            AND available_seats >= $8
-        ORDER BY ride_time ASC
+            AND r.ride_time BETWEEN $6 AND $7
+            AND r.available_seats >= $8
+            AND ($9::boolean IS NULL OR r.smoking = $9)
+            AND ($10::boolean IS NULL OR r.pets = $10)
+            AND ($11::boolean IS NULL OR r.music = $11)
+            AND ($12::boolean IS NULL OR r.quiet = $12)
+            AND ($13::boolean IS NULL OR r.women_only = $13)
+            AND ($14::text[] IS NULL OR r.luggage = ANY($14))
+            AND (r.ride_status IS NULL OR r.ride_status NOT IN ('removed', 'cancelled'))
+        ORDER BY r.ride_time ASC
     `
-	rows, err := r.DB.Query(query,
+	rows, err := r.DB.QueryContext(ctx, query,
 		fromLon,
 		fromLat,
 		maximumDistance,
@@ -162,15 +414,23 @@ func (r *Repository) SearchRidesFiltered(
 		timeLowerBound,
 		timeUpperBound,
 		numPeople,
+		filter.Smoking,
+		filter.Pets,
+		filter.Music,
+		filter.Quiet,
+		filter.WomenOnly,
+		pq.Array(preference.LuggageAtLeast(filter.Luggage)),
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var rides []*Ride
+	rides := []*Ride{}
 	for rows.Next() {
 		var ride Ride
+		var rules preference.Rules
+		var v joinedVehicle
 		err := rows.Scan(
 			&ride.RideID,
 			&ride.UserID,
@@ -185,11 +445,41 @@ func (r *Repository) SearchRidesFiltered(
 			&ride.AvailableSeats,
 			&ride.CarType,
 			&ride.CreatedAt,
+			&rules.Smoking, &rules.Pets, &rules.Music, &rules.Quiet, &rules.Luggage, &rules.WomenOnly,
+			&ride.DriverVerified,
+			&v.id, &v.userID, &v.make, &v.model, &v.color, &v.plate, &v.seats, &v.photos, &v.createdAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		ride.Rules = &rules
+		v.attach(&ride)
 		rides = append(rides, &ride)
 	}
 	return rides, nil
 }
+
+// RemoveRide marks the ride removed and cancels its bookings in one
+// transaction. It returns sql.ErrNoRows if the ride does not exist.
+func (r *Repository) RemoveRide(ctx context.Context, rideID int) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "ride.RemoveRide")
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE rides SET ride_status = $1 WHERE ride_id = $2`, StatusRemoved, rideID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE bookings SET status = 'cancelled' WHERE ride_id = $1`, rideID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,46 +1,178 @@
 package ride
 
 import (
-    "time"
-    "log"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/metrics"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/validation"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+)
+
+// Defaults for a Service whose search settings are left zero.
+const (
+	defaultSearchWindow   = time.Hour
+	defaultSearchRadiusKm = 5
 )
 
 // Service struct holds a reference to the Repository
 type Service struct {
-    Repo *Repository
+	Repo Store
+	// Vehicles looks up the vehicle a ride is posted with.
+	Vehicles vehicle.Store
+	// Users provides the driver's default rules and gender.
+	Users user.Store
+	// Verifications tells whether the driver is verified.
+	Verifications verification.Store
+	// RequireVerifiedDriver only lets verified drivers post rides.
+	RequireVerifiedDriver bool
+	// ETA estimates trip durations. Rides are saved without an estimate
+	// when it is nil or fails.
+	ETA DurationEstimator
+	// SearchWindow is how far either side of the requested time a ride may
+	// depart and still match a search.
+	SearchWindow    time.Duration
+	DefaultRadiusKm int
+	// MaxRadiusKm caps the radius a search may ask for; zero means no cap.
+	MaxRadiusKm int
 }
 
-func (s *Service) CreateRide(ride *Ride) error {
-    // Calculate travel duration (in minutes) from the API.
-    durationMinutes, err := GetDuration(ride.FromLon, ride.FromLat, ride.ToLon, ride.ToLat)
-    if err != nil {
-        // Optionally log or handle the error; here we set ETA to "N/A"
-        log.Println("Error calculating duration:", err)
-        durationMinutes = 0 // or choose a default value
-    }
+// CreateRide saves a ride posted with one of the driver's vehicles, which
+// must have at least as many seats as the ride offers. Rides without rules
+// get the driver's defaults; only women may post women-only rides. With
+// RequireVerifiedDriver, only verified drivers may post at all.
+func (s *Service) CreateRide(ctx context.Context, ride *Ride) error {
+	verified, err := s.driverVerified(ctx, ride.UserID)
+	if err != nil {
+		return err
+	}
+	if s.RequireVerifiedDriver && !verified {
+		return apperr.Forbidden("Only verified drivers may post rides")
+	}
+	ride.DriverVerified = verified
 
-    // Compute the estimated time of arrival: starting time plus duration.
-    etaTime := ride.RideTime.Add(time.Duration(durationMinutes) * time.Minute)
-    // Format ETA as desired, for example "15:04" (24-hour format).
-    etaStr := etaTime.Format("15:04")
-    ride.ETA = &etaStr
+	v, err := s.Vehicles.GetVehicle(ctx, *ride.VehicleID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && v.UserID != ride.UserID) {
+		return apperr.Validation(validation.Errors{"vehicle_id": "is not one of your vehicles"})
+	}
+	if err != nil {
+		return err
+	}
+	if ride.AvailableSeats > v.Seats {
+		return apperr.Validation(validation.Errors{"available_seats": fmt.Sprintf("must be at most %d, the seats of the vehicle", v.Seats)})
+	}
+	ride.Vehicle = v
+	ride.CarType = v.Description()
 
-    // Now insert the ride into the database.
-    return s.Repo.CreateRide(ride)
-}
+	settings, err := s.Users.GetPreferences(ctx, ride.UserID)
+	if err != nil {
+		return err
+	}
+	if ride.Rules == nil {
+		ride.Rules = &settings.Driver
+	}
+	if ride.Rules.WomenOnly && (settings.Gender == nil || *settings.Gender != preference.GenderFemale) {
+		return apperr.Validation(validation.Errors{"rules.women_only": "requires your gender to be female in your preferences"})
+	}
 
+	// Calculate travel duration (in minutes) from the API.
+	durationMinutes, err := 0, errors.New("no ETA estimator configured")
+	if s.ETA != nil {
+		durationMinutes, err = s.ETA.GetDuration(ctx, ride.FromLon, ride.FromLat, ride.ToLon, ride.ToLat)
+	}
+	if err != nil {
+		// Optionally log or handle the error; here we set ETA to "N/A"
+		log.Println("Error calculating duration:", err)
+		durationMinutes = 0 // or choose a default value
+	}
 
+	// Compute the estimated time of arrival: starting time plus duration.
+	etaTime := ride.RideTime.Add(time.Duration(durationMinutes) * time.Minute)
+	// Format ETA as desired, for example "15:04" (24-hour format).
+	etaStr := etaTime.Format("15:04")
+	ride.ETA = &etaStr
+	ride.ArrivalTime = &etaTime
 
+	// Now insert the ride into the database.
+	if err := s.Repo.CreateRide(ctx, ride); err != nil {
+		return err
+	}
+	metrics.RidePosted()
+	return nil
+}
+
+// driverVerified reports whether an admin approved the documents of the
+// driver userID.
+func (s *Service) driverVerified(ctx context.Context, userID int) (bool, error) {
+	if s.Verifications == nil {
+		return false, nil
+	}
+	v, err := s.Verifications.GetVerification(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v.Status == verification.StatusVerified, nil
+}
 
 // GetAllRides fetches all rides without filters
-func (s *Service) GetAllRides() ([]*Ride, error) {
-    return s.Repo.GetAllRides()
+func (s *Service) GetAllRides(ctx context.Context) ([]*Ride, error) {
+	return s.Repo.GetAllRides(ctx)
+}
+
+// GetRide returns the ride with rideID. Removed rides are reported as not
+// found.
+func (s *Service) GetRide(ctx context.Context, rideID int) (*Ride, error) {
+	ride, err := s.Repo.GetRideByID(ctx, rideID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && ride.RideStatus != nil && *ride.RideStatus == StatusRemoved) {
+		return nil, apperr.NotFound("Ride not found")
+	}
+	return ride, err
+}
+
+// GetRidesByUser returns all rides posted by userID.
+func (s *Service) GetRidesByUser(ctx context.Context, userID int) ([]*Ride, error) {
+	return s.Repo.GetRidesByUser(ctx, userID)
+}
+
+// SearchRidesFiltered applies geospatial proximity, time compatibility,
+// seat availability and the rider's filter.
+// maxDistance is in kilometres; zero means the configured default.
+func (s *Service) SearchRidesFiltered(ctx context.Context, fromLon, fromLat, toLon, toLat float64, rideTime time.Time, numPeople int, maxDistance int, filter preference.Filter) ([]*Ride, error) {
+	window := s.SearchWindow
+	if window <= 0 {
+		window = defaultSearchWindow
+	}
+	if maxDistance <= 0 {
+		maxDistance = s.DefaultRadiusKm
+		if maxDistance <= 0 {
+			maxDistance = defaultSearchRadiusKm
+		}
+	}
+	if s.MaxRadiusKm > 0 && maxDistance > s.MaxRadiusKm {
+		return nil, apperr.Validation(validation.Errors{"maxDistance": fmt.Sprintf("must be at most %d", s.MaxRadiusKm)})
+	}
+	timeLowerBound := rideTime.Add(-window)
+	timeUpperBound := rideTime.Add(window)
+	maximumDistance := 1000 * maxDistance
+	return s.Repo.SearchRidesFiltered(ctx, fromLon, fromLat, toLon, toLat, timeLowerBound, timeUpperBound, numPeople, maximumDistance, filter)
 }
 
-// SearchRidesFiltered applies geospatial proximity, time compatibility, and seat availability
-func (s *Service) SearchRidesFiltered(fromLon, fromLat, toLon, toLat float64, rideTime time.Time, numPeople int, maxDistance int) ([]*Ride, error) {
-    timeLowerBound := rideTime.Add(-1 * time.Hour)
-    timeUpperBound := rideTime.Add(1 * time.Hour)
-    maximumDistance := 1000*maxDistance
-    return s.Repo.SearchRidesFiltered(fromLon, fromLat, toLon, toLat, timeLowerBound, timeUpperBound, numPeople, maximumDistance)
+// RemoveRide takes a ride down on behalf of an admin.
+func (s *Service) RemoveRide(ctx context.Context, rideID int) error {
+	err := s.Repo.RemoveRide(ctx, rideID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("Ride not found")
+	}
+	return err
 }
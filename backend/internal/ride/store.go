@@ -0,0 +1,28 @@
+package ride
+
+import (
+	"context"
+	"time"
+
+	"carpool/backend/internal/preference"
+)
+
+// Store persists rides. Repository implements it on Postgres with PostGIS;
+// memstore implements it in memory for tests. Lookups of a missing ride
+// return sql.ErrNoRows.
+type Store interface {
+	// CreateRide fills in RideID and CreatedAt. Rides without Rules get
+	// preference.DefaultRules.
+	CreateRide(ctx context.Context, ride *Ride) error
+	GetAllRides(ctx context.Context) ([]*Ride, error)
+	GetRideByID(ctx context.Context, rideID int) (*Ride, error)
+	GetRidesByUser(ctx context.Context, userID int) ([]*Ride, error)
+	// SearchRidesFiltered returns open rides starting and ending within
+	// maximumDistance metres of the given points, leaving within the time
+	// bounds (inclusive), with at least numPeople seats and rules matching
+	// filter, earliest first.
+	SearchRidesFiltered(ctx context.Context, fromLon, fromLat, toLon, toLat float64, timeLowerBound, timeUpperBound time.Time, numPeople int, maximumDistance int, filter preference.Filter) ([]*Ride, error)
+	RemoveRide(ctx context.Context, rideID int) error
+}
+
+var _ Store = (*Repository)(nil)
@@ -0,0 +1,308 @@
+// Package routes declares every HTTP endpoint in one table and builds the
+// server's handler from it.
+package routes
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"carpool/backend/config"
+	"carpool/backend/internal/account"
+	"carpool/backend/internal/admin"
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/health"
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/openapi"
+	"carpool/backend/internal/profile"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Access says who may call a route.
+type Access int
+
+const (
+	Public Access = iota
+	Authenticated
+	AdminOnly
+)
+
+// Route is one method and path pattern (in http.ServeMux syntax, e.g.
+// "/rides/{id}") served by Handler.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Access  Access
+}
+
+// Deps are the handlers and keys the routes are built from.
+type Deps struct {
+	Keys     *token.KeySet
+	Health   *health.Handler
+	Users    *user.Handler
+	Rides    *ride.Handler
+	Bookings *booking.Handler
+	Accounts *account.Handler
+	Admin    *admin.Handler
+	Profiles *profile.Handler
+	Reviews  *review.Handler
+	Vehicles *vehicle.Handler
+	// Verifications are the driver's side of verification; admins review
+	// through Admin.
+	Verifications *verification.Handler
+	// Idempotency, if set, makes the routes that create rides and bookings
+	// honour an Idempotency-Key header.
+	Idempotency *idempotency.Guard
+	// CORSOrigins are allowed to call the API from a browser.
+	CORSOrigins []string
+	// Metrics, if set, is served at GET /metrics as is; it must do its own
+	// access control.
+	Metrics http.Handler
+	// Media, if set, serves uploaded files at GET /media/{key...}, for
+	// stores that don't serve them themselves.
+	Media http.Handler
+	// Features leave out the routes of switched-off features. Start from
+	// config.Defaults().Features to get everything.
+	Features config.Features
+	// Legacy serves the /v1 routes at their old unversioned paths too.
+	Legacy Legacy
+}
+
+// Version is one version of the API, served under Prefix.
+type Version struct {
+	Prefix string
+	Routes []Route
+}
+
+// Versions lists the API versions, oldest first. A version that changes
+// response shapes gets its own handlers, built on the same services, and
+// its own entry here; unchanged routes can reuse the previous version's.
+func Versions(d Deps) []Version {
+	return []Version{
+		{"/v1", V1(d)},
+	}
+}
+
+// Table lists every route with its full path: the unversioned service
+// routes and each version's routes under its prefix. It has no side
+// effects, so tests can inspect it without starting a server.
+func Table(d Deps) []Route {
+	routes := serviceRoutes(d)
+	for _, v := range Versions(d) {
+		for _, rt := range v.Routes {
+			rt.Path = v.Prefix + rt.Path
+			routes = append(routes, rt)
+		}
+	}
+	return routes
+}
+
+// serviceRoutes are for operators and other services rather than API
+// clients, so they stay outside the versions.
+func serviceRoutes(d Deps) []Route {
+	routes := []Route{
+		{http.MethodGet, "/{$}", hello, Public},
+		{http.MethodGet, "/healthz", d.Health.LivenessHandler, Public},
+		{http.MethodGet, "/readyz", d.Health.ReadinessHandler, Public},
+		{http.MethodGet, "/.well-known/jwks.json", d.Keys.JWKSHandler, Public},
+		{http.MethodGet, "/openapi.json", openapi.Handler, Public},
+	}
+	if d.Metrics != nil {
+		routes = append(routes, Route{http.MethodGet, "/metrics", d.Metrics.ServeHTTP, Public})
+	}
+	if d.Media != nil {
+		routes = append(routes, Route{http.MethodGet, "/media/{key...}", d.Media.ServeHTTP, Public})
+	}
+	return routes
+}
+
+// V1 lists the routes of /v1, relative to the prefix.
+func V1(d Deps) []Route {
+	f := d.Features
+	routes := []Route{
+		// User domain.
+		{http.MethodPost, "/login", d.Users.LoginHandler, Public},
+		{http.MethodPost, "/login/2fa", d.Users.TwoFactorLoginHandler, Public},
+		{http.MethodGet, "/profile", d.Users.GetProfileHandler, Authenticated},
+		{http.MethodPatch, "/profile", d.Users.UpdateProfileHandler, Authenticated},
+		{http.MethodPost, "/profile/email/confirm", d.Users.ConfirmEmailHandler, Public},
+		{http.MethodPatch, "/profile/password", d.Users.ChangePasswordHandler, Authenticated},
+		{http.MethodPost, "/profile/picture", d.Users.UploadProfilePictureHandler, Authenticated},
+		{http.MethodDelete, "/profile/picture", d.Users.DeleteProfilePictureHandler, Authenticated},
+		{http.MethodGet, "/profile/preferences", d.Users.GetPreferencesHandler, Authenticated},
+		{http.MethodPut, "/profile/preferences", d.Users.UpdatePreferencesHandler, Authenticated},
+		// Disabling 2FA stays available so users aren't stuck with it when
+		// enrollment is switched off.
+		{http.MethodPost, "/profile/2fa/disable", d.Users.DisableTOTPHandler, Authenticated},
+
+		{http.MethodGet, "/users/{id}", d.Profiles.GetPublicProfileHandler, Public},
+
+		// Vehicle domain.
+		{http.MethodGet, "/vehicles", d.Vehicles.ListVehiclesHandler, Authenticated},
+		{http.MethodPost, "/vehicles", d.Vehicles.CreateVehicleHandler, Authenticated},
+		{http.MethodPut, "/vehicles/{id}", d.Vehicles.UpdateVehicleHandler, Authenticated},
+		{http.MethodDelete, "/vehicles/{id}", d.Vehicles.DeleteVehicleHandler, Authenticated},
+		{http.MethodPost, "/vehicles/{id}/photos", d.Vehicles.UploadPhotoHandler, Authenticated},
+		{http.MethodDelete, "/vehicles/{id}/photos/{index}", d.Vehicles.DeletePhotoHandler, Authenticated},
+
+		// Driver verification domain.
+		{http.MethodGet, "/profile/verification", d.Verifications.GetVerificationHandler, Authenticated},
+		{http.MethodPost, "/profile/verification/documents/{kind}", d.Verifications.UploadDocumentHandler, Authenticated},
+		{http.MethodPost, "/profile/verification/submit", d.Verifications.SubmitHandler, Authenticated},
+
+		// Ride domain.
+		{http.MethodPost, "/rides", d.Idempotency.Wrap(d.Rides.PostRideHandler), Authenticated},
+		{http.MethodGet, "/rides/search", d.Rides.SearchRidesHandler, Public},
+		{http.MethodGet, "/rides/{id}", d.Rides.GetRideHandler, Public},
+		{http.MethodPost, "/rides/{id}/reviews", d.Reviews.CreateReviewHandler, Authenticated},
+
+		// Booking domain.
+		{http.MethodPost, "/bookings", d.Idempotency.Wrap(d.Bookings.CreateBookingHandler), Authenticated},
+		{http.MethodGet, "/bookings", d.Bookings.GetUserBookingsHandler, Authenticated},
+
+		// Admin domain.
+		{http.MethodGet, "/admin/users", d.Admin.ListUsersHandler, AdminOnly},
+		{http.MethodPatch, "/admin/users/{id}/status", d.Admin.UpdateUserStatusHandler, AdminOnly},
+		{http.MethodDelete, "/admin/rides/{id}", d.Admin.RemoveRideHandler, AdminOnly},
+		{http.MethodDelete, "/admin/bookings/{id}", d.Admin.RemoveBookingHandler, AdminOnly},
+		{http.MethodGet, "/admin/verifications", d.Admin.ListVerificationsHandler, AdminOnly},
+		{http.MethodGet, "/admin/verifications/{id}", d.Admin.GetVerificationHandler, AdminOnly},
+		{http.MethodPatch, "/admin/verifications/{id}", d.Admin.DecideVerificationHandler, AdminOnly},
+		{http.MethodGet, "/admin/verifications/{id}/documents/{kind}", d.Admin.GetVerificationDocumentHandler, AdminOnly},
+	}
+	if f.Registration {
+		routes = append(routes, Route{http.MethodPost, "/register", d.Users.RegisterHandler, Public})
+	}
+	if f.TwoFactor {
+		routes = append(routes,
+			Route{http.MethodPost, "/profile/2fa/enroll", d.Users.EnrollTOTPHandler, Authenticated},
+			Route{http.MethodPost, "/profile/2fa/confirm", d.Users.ConfirmTOTPHandler, Authenticated},
+		)
+	}
+	if f.SSO && d.Users.SSO != nil {
+		routes = append(routes,
+			Route{http.MethodGet, "/auth/oidc/login", d.Users.OIDCLoginHandler, Public},
+			Route{http.MethodGet, "/auth/oidc/callback", d.Users.OIDCCallbackHandler, Public},
+		)
+	}
+	if f.DataExport {
+		routes = append(routes, Route{http.MethodGet, "/profile/export", d.Accounts.ExportHandler, Authenticated})
+	}
+	if f.AccountDeletion {
+		routes = append(routes, Route{http.MethodDelete, "/profile", d.Accounts.DeleteHandler, Authenticated})
+	}
+	return routes
+}
+
+// New returns the server's handler: the routes from Table, and the legacy
+// aliases if enabled, behind tracing, logging, CORS and panic recovery.
+func New(d Deps) http.Handler {
+	mux := http.NewServeMux()
+	Register(mux, Table(d), d.Keys)
+	if d.Legacy.Enabled {
+		registerLegacy(mux, V1(d), d.Keys, d.Legacy)
+	}
+	mux.Handle("/", middleware.Chain(http.HandlerFunc(notFound), middleware.Tracing("unmatched"), middleware.Metrics("unmatched")))
+	h := middleware.Chain(mux,
+		middleware.Logging,
+		middleware.CORS(d.CORSOrigins),
+		middleware.Recovery,
+	)
+	// The server span is renamed to the route once one matches.
+	return otelhttp.NewHandler(h, "http.request")
+}
+
+// Legacy configures the unversioned aliases of the /v1 routes, kept for
+// clients that predate versioning.
+type Legacy struct {
+	Enabled bool
+	// DeprecatedSince and Sunset are announced on every response; either
+	// may be zero to leave its header out.
+	DeprecatedSince time.Time
+	Sunset          time.Time
+}
+
+// registerLegacy serves routes at their unversioned paths, marking every
+// response (405s and auth failures included) as deprecated in favour of
+// /v1.
+func registerLegacy(mux *http.ServeMux, routes []Route, keys *token.KeySet, legacy Legacy) {
+	aliases := http.NewServeMux()
+	Register(aliases, routes, keys)
+	h := middleware.Chain(aliases, middleware.Deprecated(legacy.DeprecatedSince, legacy.Sunset, "/v1"))
+	seen := map[string]bool{}
+	for _, rt := range routes {
+		if seen[rt.Path] {
+			continue
+		}
+		seen[rt.Path] = true
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			mux.Handle(m+" "+rt.Path, h)
+		}
+	}
+}
+
+// Register adds routes to mux with the middleware their Access requires,
+// naming trace spans and recording metrics after the route's pattern.
+// Every path also answers the methods it doesn't support with a 405
+// problem document listing the allowed ones.
+func Register(mux *http.ServeMux, routes []Route, keys *token.KeySet) {
+	allowed := map[string][]string{}
+	for _, rt := range routes {
+		var h http.Handler = rt.Handler
+		switch rt.Access {
+		case Authenticated:
+			h = middleware.Chain(h, middleware.Auth(keys))
+		case AdminOnly:
+			h = middleware.Chain(h, middleware.Auth(keys), middleware.Roles(user.RoleAdmin))
+		}
+		pattern := rt.Method + " " + rt.Path
+		mux.Handle(pattern, middleware.Chain(h, middleware.Tracing(pattern), middleware.Metrics(pattern)))
+		allowed[rt.Path] = append(allowed[rt.Path], rt.Method)
+	}
+	for path, methods := range allowed {
+		sort.Strings(methods)
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if contains(methods, m) {
+				continue
+			}
+			pattern := m + " " + path
+			mux.Handle(pattern, middleware.Chain(methodNotAllowed(methods), middleware.Tracing(pattern), middleware.Metrics(pattern)))
+		}
+	}
+}
+
+func methodNotAllowed(methods []string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		apperr.WriteStatus(w, r, http.StatusMethodNotAllowed, "Allowed methods: "+allow)
+	}
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	apperr.Write(w, r, apperr.NotFound("No such endpoint"))
+}
+
+func hello(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Hello from the backend!"))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
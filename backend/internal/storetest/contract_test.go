@@ -0,0 +1,66 @@
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/memstore"
+	"carpool/backend/internal/migrate"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+
+	_ "github.com/lib/pq"
+)
+
+func TestMemstore(t *testing.T) {
+	Run(t, func(t *testing.T) Stores {
+		s := memstore.New()
+		return Stores{Users: s.Users(), Rides: s.Rides(), Bookings: s.Bookings(), Idempotency: s.Idempotency(), Reviews: s.Reviews(), Vehicles: s.Vehicles(), Verifications: s.Verifications()}
+	})
+}
+
+// TestPostgres runs the contract against TEST_DATABASE_URL, which must
+// point at a disposable PostGIS database: it is migrated and then emptied
+// before every case.
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	m, err := migrate.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	Run(t, func(t *testing.T) Stores {
+		_, err := db.ExecContext(ctx, `TRUNCATE users, rides, bookings, recovery_codes, login_challenges, user_identities, idempotency_keys, reviews, vehicles, verification_documents, driver_verifications RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Stores{
+			Users:         &user.Repository{DB: db},
+			Rides:         &ride.Repository{DB: db},
+			Bookings:      &booking.Repository{DB: db},
+			Idempotency:   &idempotency.Repository{DB: db},
+			Reviews:       &review.Repository{DB: db},
+			Vehicles:      &vehicle.Repository{DB: db},
+			Verifications: &verification.Repository{DB: db},
+		}
+	})
+}
@@ -0,0 +1,1299 @@
+// Package storetest is a contract test suite for the user, vehicle, ride,
+// booking, review, verification and idempotency stores. Every
+// implementation runs the same cases so the in-memory store stays faithful
+// to Postgres.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/booking"
+	"carpool/backend/internal/idempotency"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/review"
+	"carpool/backend/internal/ride"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/vehicle"
+	"carpool/backend/internal/verification"
+)
+
+// Stores is one implementation of every store, sharing a single empty
+// database.
+type Stores struct {
+	Users         user.Store
+	Rides         ride.Store
+	Bookings      booking.Store
+	Idempotency   idempotency.Store
+	Reviews       review.Store
+	Vehicles      vehicle.Store
+	Verifications verification.Store
+}
+
+type testCase struct {
+	name string
+	run  func(t *testing.T, s Stores)
+}
+
+// Run runs every contract case against fresh stores from open.
+func Run(t *testing.T, open func(t *testing.T) Stores) {
+	for _, group := range []struct {
+		name  string
+		cases []testCase
+	}{
+		{"users", userCases},
+		{"rides", rideCases},
+		{"bookings", bookingCases},
+		{"idempotency", idempotencyCases},
+		{"reviews", reviewCases},
+		{"vehicles", vehicleCases},
+		{"verifications", verificationCases},
+	} {
+		t.Run(group.name, func(t *testing.T) {
+			for _, tc := range group.cases {
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, open(t))
+				})
+			}
+		})
+	}
+}
+
+// Points used by the ride cases, in lon/lat degrees. Each "near" point is
+// about 1 km from its base and each "far" one about 20 km, well clear of
+// the 5 km search radius.
+var (
+	zurich     = point{8.5417, 47.3769}
+	zurichNear = point{8.5417, 47.3859}
+	zurichFar  = point{8.5417, 47.5569}
+	bern       = point{7.4474, 46.9480}
+	bernNear   = point{7.4474, 46.9570}
+	basel      = point{7.5886, 47.5596}
+)
+
+const searchRadius = 5000
+
+type point struct{ lon, lat float64 }
+
+// at returns a departure time days from now, in whole seconds so it
+// survives a round trip through a TIMESTAMP column unchanged.
+func at(days int, hour int) time.Time {
+	d := time.Now().UTC().AddDate(0, 0, days)
+	return time.Date(d.Year(), d.Month(), d.Day(), hour, 0, 0, 0, time.UTC)
+}
+
+func createUser(t *testing.T, s Stores, name, email string) *user.User {
+	t.Helper()
+	u := &user.User{Name: name, Email: email}
+	if err := s.Users.CreateUser(context.Background(), u, "hash-"+email); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return u
+}
+
+func createRide(t *testing.T, s Stores, driverID int, from, to point, when time.Time, seats int) *ride.Ride {
+	t.Helper()
+	r := &ride.Ride{
+		UserID:         driverID,
+		FromLon:        from.lon,
+		FromLat:        from.lat,
+		ToLon:          to.lon,
+		ToLat:          to.lat,
+		FromAddress:    "From",
+		ToAddress:      "To",
+		Price:          12.50,
+		RideTime:       when,
+		AvailableSeats: seats,
+		CarType:        "Estate",
+	}
+	if err := s.Rides.CreateRide(context.Background(), r); err != nil {
+		t.Fatalf("CreateRide: %v", err)
+	}
+	return r
+}
+
+func createVehicle(t *testing.T, s Stores, ownerID, seats int) *vehicle.Vehicle {
+	t.Helper()
+	v := &vehicle.Vehicle{UserID: ownerID, Make: "Toyota", Model: "Corolla", Color: "Blue", Plate: "ZH 123456", Seats: seats}
+	if err := s.Vehicles.CreateVehicle(context.Background(), v); err != nil {
+		t.Fatalf("CreateVehicle: %v", err)
+	}
+	return v
+}
+
+// createRideWith posts a Zurich to Bern ride with all of v's seats.
+func createRideWith(t *testing.T, s Stores, v *vehicle.Vehicle, when time.Time) *ride.Ride {
+	t.Helper()
+	r := &ride.Ride{
+		UserID: v.UserID, FromLon: zurich.lon, FromLat: zurich.lat, ToLon: bern.lon, ToLat: bern.lat,
+		Price: 12.50, RideTime: when, AvailableSeats: v.Seats, VehicleID: &v.VehicleID, CarType: v.Description(),
+	}
+	if err := s.Rides.CreateRide(context.Background(), r); err != nil {
+		t.Fatalf("CreateRide: %v", err)
+	}
+	return r
+}
+
+func createBooking(t *testing.T, s Stores, userID, rideID, seats int) *booking.Booking {
+	t.Helper()
+	b := &booking.Booking{UserID: userID, RideID: rideID, SeatCount: seats}
+	if err := s.Bookings.CreateBooking(context.Background(), b); err != nil {
+		t.Fatalf("CreateBooking: %v", err)
+	}
+	return b
+}
+
+func wantNoRows(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("%s: got error %v, want sql.ErrNoRows", op, err)
+	}
+}
+
+func wantNoError(t *testing.T, op string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", op, err)
+	}
+}
+
+func rideIDs(rides []*ride.Ride) []int {
+	ids := []int{}
+	for _, r := range rides {
+		ids = append(ids, r.RideID)
+	}
+	return ids
+}
+
+func sameIDs(got, want []int) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var userCases = []testCase{
+	{"create and get", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		if u.ID == 0 || u.Role != user.RoleUser || u.Status != user.StatusActive || u.CreatedAt.IsZero() || u.TwoFactorEnabled {
+			t.Fatalf("CreateUser filled in %+v", u)
+		}
+
+		byID, err := s.Users.GetUserByID(ctx, u.ID)
+		wantNoError(t, "GetUserByID", err)
+		byEmail, err := s.Users.GetUserByEmail(ctx, "ada@example.com")
+		wantNoError(t, "GetUserByEmail", err)
+		for _, got := range []*user.User{byID, byEmail} {
+			if got.ID != u.ID || got.Name != "Ada" || got.Email != "ada@example.com" ||
+				got.Password != "hash-ada@example.com" || got.Role != user.RoleUser ||
+				got.Status != user.StatusActive || got.Phone != nil || got.Rating != nil {
+				t.Errorf("got %+v", got)
+			}
+		}
+	}},
+	{"missing user", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		_, err := s.Users.GetUserByID(ctx, 4242)
+		wantNoRows(t, "GetUserByID", err)
+		_, err = s.Users.GetUserByEmail(ctx, "nobody@example.com")
+		wantNoRows(t, "GetUserByEmail", err)
+		_, err = s.Users.GetTOTPState(ctx, 4242)
+		wantNoRows(t, "GetTOTPState", err)
+		wantNoRows(t, "UpdateProfile", s.Users.UpdateProfile(ctx, 4242, user.ProfileUpdate{}))
+		wantNoRows(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, 4242, "x@example.com", "hash", time.Hour))
+		wantNoRows(t, "UpdateUserStatus", s.Users.UpdateUserStatus(ctx, 4242, user.StatusBanned, nil))
+		wantNoRows(t, "AnonymizeUser", s.Users.AnonymizeUser(ctx, 4242))
+	}},
+	{"profile picture", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		pic, thumb := "https://cdn.example/p.jpg", "https://cdn.example/t.jpg"
+		wantNoError(t, "SetProfilePicture", s.Users.SetProfilePicture(ctx, u.ID, &pic, &thumb))
+		got, err := s.Users.GetUserByID(ctx, u.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.ProfilePic == nil || *got.ProfilePic != pic || got.ProfilePicThumbnail == nil || *got.ProfilePicThumbnail != thumb {
+			t.Errorf("got picture %v, thumbnail %v", got.ProfilePic, got.ProfilePicThumbnail)
+		}
+
+		wantNoError(t, "SetProfilePicture(nil)", s.Users.SetProfilePicture(ctx, u.ID, nil, nil))
+		got, err = s.Users.GetUserByEmail(ctx, "ada@example.com")
+		wantNoError(t, "GetUserByEmail", err)
+		if got.ProfilePic != nil || got.ProfilePicThumbnail != nil {
+			t.Errorf("cleared picture is %v, %v", got.ProfilePic, got.ProfilePicThumbnail)
+		}
+		wantNoRows(t, "SetProfilePicture", s.Users.SetProfilePicture(ctx, 4242, &pic, &thumb))
+	}},
+	{"duplicate email", func(t *testing.T, s Stores) {
+		createUser(t, s, "Ada", "ada@example.com")
+		err := s.Users.CreateUser(context.Background(), &user.User{Name: "Other", Email: "ada@example.com"}, "x")
+		if apperr.KindOf(err) != apperr.KindConflict {
+			t.Errorf("got %v, want a conflict", err)
+		}
+	}},
+	{"search", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada Lovelace", "ada@example.com")
+		grace := createUser(t, s, "Grace Hopper", "grace@navy.example")
+		alan := createUser(t, s, "Alan Turing", "alan@example.com")
+		wantNoError(t, "UpdateUserStatus", s.Users.UpdateUserStatus(ctx, alan.ID, user.StatusSuspended, nil))
+
+		for _, tc := range []struct {
+			q, status     string
+			limit, offset int
+			want          []int
+		}{
+			{"", "", 10, 0, []int{ada.ID, grace.ID, alan.ID}},
+			{"EXAMPLE.COM", "", 10, 0, []int{ada.ID, alan.ID}},
+			{"hopper", "", 10, 0, []int{grace.ID}},
+			{"", user.StatusSuspended, 10, 0, []int{alan.ID}},
+			{"example.com", user.StatusActive, 10, 0, []int{ada.ID}},
+			{"", "", 2, 1, []int{grace.ID, alan.ID}},
+			{"", "", 10, 5, []int{}},
+		} {
+			users, err := s.Users.SearchUsers(ctx, tc.q, tc.status, tc.limit, tc.offset)
+			wantNoError(t, "SearchUsers", err)
+			got := []int{}
+			for _, u := range users {
+				got = append(got, u.ID)
+			}
+			if !sameIDs(got, tc.want) {
+				t.Errorf("SearchUsers(%q, %q, %d, %d) = %v, want %v", tc.q, tc.status, tc.limit, tc.offset, got, tc.want)
+			}
+		}
+	}},
+	{"update profile and password", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		name, bio, phone := "Ada Lovelace", "Analytical engines", "+41790000000"
+		wantNoError(t, "UpdateProfile", s.Users.UpdateProfile(ctx, u.ID, user.ProfileUpdate{
+			Name: &name, SetBio: true, Bio: &bio, SetPhone: true, Phone: &phone,
+		}))
+		got, err := s.Users.GetUserByID(ctx, u.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.Name != name || got.Bio == nil || *got.Bio != bio || got.Phone == nil || *got.Phone != phone {
+			t.Errorf("updated profile %+v", got)
+		}
+
+		// Fields without their Set flag are left alone; nil clears.
+		wantNoError(t, "UpdateProfile", s.Users.UpdateProfile(ctx, u.ID, user.ProfileUpdate{SetPhone: true}))
+		wantNoError(t, "UpdateUserPassword", s.Users.UpdateUserPassword(ctx, u.ID, "new-hash"))
+		got, err = s.Users.GetUserByID(ctx, u.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.Name != name || got.Bio == nil || got.Phone != nil || got.Password != "new-hash" {
+			t.Errorf("got %+v", got)
+		}
+	}},
+	{"email change", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		createUser(t, s, "Grace", "grace@example.com")
+
+		wantNoError(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, ada.ID, "ada@new.example", "hash-1", time.Hour))
+		got, err := s.Users.GetUserByID(ctx, ada.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.Email != "ada@example.com" || got.PendingEmail == nil || *got.PendingEmail != "ada@new.example" {
+			t.Fatalf("pending change: email %q, pending %v", got.Email, got.PendingEmail)
+		}
+		_, err = s.Users.ConfirmEmailChange(ctx, "hash-x")
+		wantNoRows(t, "ConfirmEmailChange(unknown)", err)
+
+		id, err := s.Users.ConfirmEmailChange(ctx, "hash-1")
+		wantNoError(t, "ConfirmEmailChange", err)
+		got, _ = s.Users.GetUserByEmail(ctx, "ada@new.example")
+		if id != ada.ID || got == nil || got.ID != ada.ID || got.PendingEmail != nil || !got.EmailVerified {
+			t.Fatalf("confirmed user %d, got %+v", id, got)
+		}
+		_, err = s.Users.ConfirmEmailChange(ctx, "hash-1")
+		wantNoRows(t, "ConfirmEmailChange(again)", err)
+
+		// A cancelled or expired request can't be confirmed.
+		wantNoError(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, ada.ID, "ada@third.example", "hash-2", time.Hour))
+		wantNoError(t, "CancelEmailChange", s.Users.CancelEmailChange(ctx, ada.ID))
+		_, err = s.Users.ConfirmEmailChange(ctx, "hash-2")
+		wantNoRows(t, "ConfirmEmailChange(cancelled)", err)
+		wantNoError(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, ada.ID, "ada@third.example", "hash-3", -time.Second))
+		if got, _ := s.Users.GetUserByID(ctx, ada.ID); got.PendingEmail != nil {
+			t.Errorf("expired change still pending: %v", *got.PendingEmail)
+		}
+		_, err = s.Users.ConfirmEmailChange(ctx, "hash-3")
+		wantNoRows(t, "ConfirmEmailChange(expired)", err)
+
+		// The address may have been registered since the request.
+		wantNoError(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, ada.ID, "grace@example.com", "hash-4", time.Hour))
+		if _, err := s.Users.ConfirmEmailChange(ctx, "hash-4"); apperr.KindOf(err) != apperr.KindConflict {
+			t.Errorf("confirming a taken address: got %v, want a conflict", err)
+		}
+	}},
+	{"two-factor", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		wantNoError(t, "SetPendingTOTPSecret", s.Users.SetPendingTOTPSecret(ctx, u.ID, "SECRET"))
+		state, err := s.Users.GetTOTPState(ctx, u.ID)
+		wantNoError(t, "GetTOTPState", err)
+		if state.Secret == nil || *state.Secret != "SECRET" || state.Enabled || state.LastStep != nil {
+			t.Fatalf("pending state %+v", state)
+		}
+
+		wantNoError(t, "EnableTOTP", s.Users.EnableTOTP(ctx, u.ID, 100, []string{"code-a", "code-b"}))
+		state, err = s.Users.GetTOTPState(ctx, u.ID)
+		wantNoError(t, "GetTOTPState", err)
+		if !state.Enabled || state.LastStep == nil || *state.LastStep != 100 {
+			t.Fatalf("enabled state %+v", state)
+		}
+		if got, _ := s.Users.GetUserByID(ctx, u.ID); !got.TwoFactorEnabled {
+			t.Error("TwoFactorEnabled is false after EnableTOTP")
+		}
+
+		for _, tc := range []struct {
+			step int64
+			want bool
+		}{{100, false}, {99, false}, {101, true}, {101, false}} {
+			ok, err := s.Users.AdvanceTOTPStep(ctx, u.ID, tc.step)
+			wantNoError(t, "AdvanceTOTPStep", err)
+			if ok != tc.want {
+				t.Errorf("AdvanceTOTPStep(%d) = %v, want %v", tc.step, ok, tc.want)
+			}
+		}
+
+		for _, tc := range []struct {
+			code string
+			want bool
+		}{{"code-a", true}, {"code-a", false}, {"code-x", false}, {"code-b", true}} {
+			ok, err := s.Users.UseRecoveryCode(ctx, u.ID, tc.code)
+			wantNoError(t, "UseRecoveryCode", err)
+			if ok != tc.want {
+				t.Errorf("UseRecoveryCode(%s) = %v, want %v", tc.code, ok, tc.want)
+			}
+		}
+
+		// Enabling again replaces the codes.
+		wantNoError(t, "EnableTOTP", s.Users.EnableTOTP(ctx, u.ID, 200, []string{"code-c"}))
+		if ok, _ := s.Users.UseRecoveryCode(ctx, u.ID, "code-b"); ok {
+			t.Error("old recovery code still works after re-enabling")
+		}
+
+		wantNoError(t, "DisableTOTP", s.Users.DisableTOTP(ctx, u.ID))
+		state, err = s.Users.GetTOTPState(ctx, u.ID)
+		wantNoError(t, "GetTOTPState", err)
+		if state.Secret != nil || state.Enabled || state.LastStep != nil {
+			t.Errorf("disabled state %+v", state)
+		}
+		if ok, _ := s.Users.UseRecoveryCode(ctx, u.ID, "code-c"); ok {
+			t.Error("recovery code still works after DisableTOTP")
+		}
+	}},
+	{"login challenges", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		wantNoError(t, "CreateLoginChallenge", s.Users.CreateLoginChallenge(ctx, "challenge-1", ada.ID, time.Hour))
+		for want := 1; want <= 2; want++ {
+			attempts, err := s.Users.AttemptLoginChallenge(ctx, "challenge-1", ada.ID)
+			wantNoError(t, "AttemptLoginChallenge", err)
+			if attempts != want {
+				t.Errorf("AttemptLoginChallenge = %d, want %d", attempts, want)
+			}
+		}
+		_, err := s.Users.AttemptLoginChallenge(ctx, "challenge-1", grace.ID)
+		wantNoRows(t, "AttemptLoginChallenge(other user)", err)
+
+		for _, want := range []bool{true, false} {
+			ok, err := s.Users.ConsumeLoginChallenge(ctx, "challenge-1")
+			wantNoError(t, "ConsumeLoginChallenge", err)
+			if ok != want {
+				t.Errorf("ConsumeLoginChallenge = %v, want %v", ok, want)
+			}
+		}
+		_, err = s.Users.AttemptLoginChallenge(ctx, "challenge-1", ada.ID)
+		wantNoRows(t, "AttemptLoginChallenge(consumed)", err)
+
+		wantNoError(t, "CreateLoginChallenge", s.Users.CreateLoginChallenge(ctx, "challenge-2", ada.ID, -time.Second))
+		_, err = s.Users.AttemptLoginChallenge(ctx, "challenge-2", ada.ID)
+		wantNoRows(t, "AttemptLoginChallenge(expired)", err)
+
+		wantNoError(t, "CreateLoginChallenge", s.Users.CreateLoginChallenge(ctx, "challenge-3", ada.ID, time.Hour))
+		wantNoError(t, "AnonymizeUser", s.Users.AnonymizeUser(ctx, ada.ID))
+		_, err = s.Users.AttemptLoginChallenge(ctx, "challenge-3", ada.ID)
+		wantNoRows(t, "AttemptLoginChallenge(anonymized)", err)
+	}},
+	{"two-factor failures", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		until := time.Now().Add(time.Hour)
+		lockedUntil := func() *time.Time {
+			t.Helper()
+			state, err := s.Users.GetTOTPState(ctx, u.ID)
+			wantNoError(t, "GetTOTPState", err)
+			return state.LockedUntil
+		}
+
+		// A reset in between starts the count again.
+		for i := 0; i < 2; i++ {
+			wantNoError(t, "RecordTwoFactorFailure", s.Users.RecordTwoFactorFailure(ctx, u.ID, 3, until))
+		}
+		wantNoError(t, "ResetTwoFactorFailures", s.Users.ResetTwoFactorFailures(ctx, u.ID))
+		for i := 0; i < 2; i++ {
+			wantNoError(t, "RecordTwoFactorFailure", s.Users.RecordTwoFactorFailure(ctx, u.ID, 3, until))
+		}
+		if got := lockedUntil(); got != nil {
+			t.Fatalf("LockedUntil = %v after 2 failures since the reset, want nil", got)
+		}
+
+		wantNoError(t, "RecordTwoFactorFailure", s.Users.RecordTwoFactorFailure(ctx, u.ID, 3, until))
+		if got := lockedUntil(); got == nil || !got.After(time.Now()) {
+			t.Fatalf("LockedUntil = %v after 3 failures, want about %v", got, until)
+		}
+
+		wantNoError(t, "DisableTOTP", s.Users.DisableTOTP(ctx, u.ID))
+		if got := lockedUntil(); got != nil {
+			t.Errorf("LockedUntil = %v after DisableTOTP, want nil", got)
+		}
+	}},
+	{"identities", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		_, err := s.Users.GetUserByIdentity(ctx, "https://idp.example", "sub-1")
+		wantNoRows(t, "GetUserByIdentity", err)
+
+		wantNoError(t, "LinkIdentity", s.Users.LinkIdentity(ctx, u.ID, "https://idp.example", "sub-1"))
+		// Linking the same identity again is a no-op.
+		wantNoError(t, "LinkIdentity", s.Users.LinkIdentity(ctx, u.ID, "https://idp.example", "sub-1"))
+
+		got, err := s.Users.GetUserByIdentity(ctx, "https://idp.example", "sub-1")
+		wantNoError(t, "GetUserByIdentity", err)
+		if got.ID != u.ID || !got.EmailVerified {
+			t.Errorf("GetUserByIdentity returned user %d (email verified %v), want %d", got.ID, got.EmailVerified, u.ID)
+		}
+		ids, err := s.Users.ListIdentities(ctx, u.ID)
+		wantNoError(t, "ListIdentities", err)
+		if len(ids) != 1 || ids[0].Issuer != "https://idp.example" || ids[0].Subject != "sub-1" {
+			t.Errorf("ListIdentities = %+v", ids)
+		}
+
+		if err := s.Users.LinkIdentity(ctx, 4242, "https://idp.example", "sub-2"); err == nil {
+			t.Error("LinkIdentity to a missing user succeeded")
+		}
+	}},
+	{"preferences", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		u := createUser(t, s, "Ada", "ada@example.com")
+		got, err := s.Users.GetPreferences(ctx, u.ID)
+		wantNoError(t, "GetPreferences", err)
+		if got.Gender != nil || got.Driver != preference.DefaultRules() || got.Rider != (preference.Filter{}) {
+			t.Errorf("new user has preferences %+v", got)
+		}
+
+		gender, no, yes := preference.GenderFemale, false, true
+		want := &preference.Settings{
+			Gender: &gender,
+			Driver: preference.Rules{Pets: true, Music: true, Luggage: preference.LuggageLarge, WomenOnly: true},
+			Rider:  preference.Filter{Smoking: &no, Quiet: &yes, Luggage: preference.LuggageSmall},
+		}
+		wantNoError(t, "SetPreferences", s.Users.SetPreferences(ctx, u.ID, want))
+		got, err = s.Users.GetPreferences(ctx, u.ID)
+		wantNoError(t, "GetPreferences", err)
+		if got.Gender == nil || *got.Gender != gender || got.Driver != want.Driver || got.Rider.Luggage != preference.LuggageSmall ||
+			got.Rider.Smoking == nil || *got.Rider.Smoking || got.Rider.Quiet == nil || !*got.Rider.Quiet ||
+			got.Rider.Pets != nil || got.Rider.Music != nil || got.Rider.WomenOnly != nil {
+			t.Errorf("got preferences %+v, rider %+v", got, got.Rider)
+		}
+
+		_, err = s.Users.GetPreferences(ctx, 4242)
+		wantNoRows(t, "GetPreferences", err)
+		wantNoRows(t, "SetPreferences", s.Users.SetPreferences(ctx, 4242, want))
+	}},
+	{"anonymize", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		rider := createUser(t, s, "Grace", "grace@example.com")
+		other := createUser(t, s, "Alan", "alan@example.com")
+		wantNoError(t, "EnableTOTP", s.Users.EnableTOTP(ctx, driver.ID, 1, []string{"code"}))
+		wantNoError(t, "LinkIdentity", s.Users.LinkIdentity(ctx, driver.ID, "https://idp.example", "sub-1"))
+		pic, thumb := "https://cdn.example/p.jpg", "https://cdn.example/t.jpg"
+		wantNoError(t, "SetProfilePicture", s.Users.SetProfilePicture(ctx, driver.ID, &pic, &thumb))
+		bio := "Drives to Bern on Mondays"
+		wantNoError(t, "UpdateProfile", s.Users.UpdateProfile(ctx, driver.ID, user.ProfileUpdate{SetBio: true, Bio: &bio}))
+		wantNoError(t, "RequestEmailChange", s.Users.RequestEmailChange(ctx, driver.ID, "ada@new.example", "hash", time.Hour))
+		gender := preference.GenderFemale
+		wantNoError(t, "SetPreferences", s.Users.SetPreferences(ctx, driver.ID, &preference.Settings{Gender: &gender, Driver: preference.DefaultRules()}))
+
+		upcoming := createRide(t, s, driver.ID, zurich, bern, at(30, 8), 3)
+		car := createVehicle(t, s, driver.ID, 4)
+		past := createRideWith(t, s, car, at(-30, 8))
+		othersRide := createRide(t, s, other.ID, zurich, bern, at(30, 9), 3)
+		onUpcoming := createBooking(t, s, rider.ID, upcoming.RideID, 1)
+		onPast := createBooking(t, s, rider.ID, past.RideID, 1)
+		driverBooking := createBooking(t, s, driver.ID, othersRide.RideID, 1)
+
+		wantNoError(t, "AnonymizeUser", s.Users.AnonymizeUser(ctx, driver.ID))
+		wantNoRows(t, "AnonymizeUser again", s.Users.AnonymizeUser(ctx, driver.ID))
+		wantNoRows(t, "UpdateUserStatus", s.Users.UpdateUserStatus(ctx, driver.ID, user.StatusActive, nil))
+
+		got, err := s.Users.GetUserByID(ctx, driver.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.Status != user.StatusDeleted || got.Name != "Deleted user" || got.Email == "ada@example.com" || got.TwoFactorEnabled ||
+			got.ProfilePic != nil || got.ProfilePicThumbnail != nil || got.Bio != nil || got.PendingEmail != nil {
+			t.Errorf("anonymized user %+v", got)
+		}
+		if _, err := s.Users.ConfirmEmailChange(ctx, "hash"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("email change still pending: %v", err)
+		}
+		if _, err := s.Users.GetUserByIdentity(ctx, "https://idp.example", "sub-1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("identity still linked: %v", err)
+		}
+		if ok, _ := s.Users.UseRecoveryCode(ctx, driver.ID, "code"); ok {
+			t.Error("recovery code survived anonymization")
+		}
+		if settings, _ := s.Users.GetPreferences(ctx, driver.ID); settings == nil || settings.Gender != nil {
+			t.Errorf("preferences survived anonymization: %+v", settings)
+		}
+		// The email is free for a new account.
+		createUser(t, s, "Ada", "ada@example.com")
+
+		if vehicles, _ := s.Vehicles.ListVehicles(ctx, driver.ID); len(vehicles) != 0 {
+			t.Errorf("vehicles survived anonymization: %+v", vehicles)
+		}
+		if r, _ := s.Rides.GetRideByID(ctx, past.RideID); r == nil || r.VehicleID != nil || r.Vehicle != nil || r.CarType != past.CarType {
+			t.Errorf("past ride after anonymization: %+v", r)
+		}
+
+		wantRideStatus(t, s, upcoming.RideID, ride.StatusCancelled)
+		wantRideStatus(t, s, past.RideID, "")
+		wantRideStatus(t, s, othersRide.RideID, "")
+		wantBookingStatus(t, s, rider.ID, onUpcoming.BookingID, booking.StatusCancelled)
+		wantBookingStatus(t, s, rider.ID, onPast.BookingID, booking.StatusPending)
+		wantBookingStatus(t, s, driver.ID, driverBooking.BookingID, booking.StatusCancelled)
+	}},
+}
+
+func wantRideStatus(t *testing.T, s Stores, rideID int, want string) {
+	t.Helper()
+	r, err := s.Rides.GetRideByID(context.Background(), rideID)
+	wantNoError(t, "GetRideByID", err)
+	got := ""
+	if r.RideStatus != nil {
+		got = *r.RideStatus
+	}
+	if got != want {
+		t.Errorf("ride %d has status %q, want %q", rideID, got, want)
+	}
+}
+
+func wantBookingStatus(t *testing.T, s Stores, userID, bookingID int, want string) {
+	t.Helper()
+	bookings, err := s.Bookings.GetBookingsByUser(context.Background(), userID)
+	wantNoError(t, "GetBookingsByUser", err)
+	for _, b := range bookings {
+		if b.BookingID == bookingID {
+			if b.Status != want {
+				t.Errorf("booking %d has status %q, want %q", bookingID, b.Status, want)
+			}
+			return
+		}
+	}
+	t.Errorf("booking %d not listed for user %d", bookingID, userID)
+}
+
+var rideCases = []testCase{
+	{"create and get", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		arrival := at(1, 9).Add(30 * time.Minute)
+		r := &ride.Ride{
+			UserID: driver.ID, FromLon: zurich.lon, FromLat: zurich.lat, ToLon: bern.lon, ToLat: bern.lat,
+			FromAddress: "Zurich HB", ToAddress: "Bern Bahnhof", Price: 25.50, RideTime: at(1, 8),
+			ArrivalTime: &arrival, AvailableSeats: 3, CarType: "Estate", InstantBooking: true,
+		}
+		wantNoError(t, "CreateRide", s.Rides.CreateRide(ctx, r))
+		if r.RideID == 0 || r.CreatedAt.IsZero() {
+			t.Fatalf("CreateRide filled in %+v", r)
+		}
+
+		got, err := s.Rides.GetRideByID(ctx, r.RideID)
+		wantNoError(t, "GetRideByID", err)
+		if got.UserID != driver.ID || got.FromLon != zurich.lon || got.FromLat != zurich.lat ||
+			got.ToLon != bern.lon || got.ToLat != bern.lat || got.FromAddress != "Zurich HB" ||
+			got.ToAddress != "Bern Bahnhof" || got.Price != 25.50 || !got.RideTime.Equal(r.RideTime) ||
+			got.AvailableSeats != 3 || got.CarType != "Estate" || !got.InstantBooking || got.RideStatus != nil {
+			t.Errorf("got %+v", got)
+		}
+		if got.ArrivalTime == nil || !got.ArrivalTime.Equal(arrival) || got.ETA == nil || *got.ETA != "09:30" {
+			t.Errorf("got arrival %v, ETA %v", got.ArrivalTime, got.ETA)
+		}
+		if got.DriverName == nil || *got.DriverName != "Ada" {
+			t.Errorf("got driver name %v", got.DriverName)
+		}
+
+		_, err = s.Rides.GetRideByID(ctx, 4242)
+		wantNoRows(t, "GetRideByID", err)
+		if err := s.Rides.CreateRide(ctx, &ride.Ride{UserID: 4242, RideTime: at(1, 8), AvailableSeats: 1}); err == nil {
+			t.Error("CreateRide for a missing driver succeeded")
+		}
+	}},
+	{"listings", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		later := createRide(t, s, ada.ID, zurich, bern, at(3, 8), 2)
+		sooner := createRide(t, s, ada.ID, zurich, bern, at(1, 8), 2)
+		removed := createRide(t, s, ada.ID, zurich, bern, at(2, 8), 2)
+		graces := createRide(t, s, grace.ID, bern, zurich, at(2, 9), 2)
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, removed.RideID))
+		wantNoRows(t, "RemoveRide", s.Rides.RemoveRide(ctx, 4242))
+
+		all, err := s.Rides.GetAllRides(ctx)
+		wantNoError(t, "GetAllRides", err)
+		if got, want := rideIDs(all), []int{sooner.RideID, graces.RideID, later.RideID}; !sameIDs(got, want) {
+			t.Errorf("GetAllRides = %v, want %v", got, want)
+		}
+
+		mine, err := s.Rides.GetRidesByUser(ctx, ada.ID)
+		wantNoError(t, "GetRidesByUser", err)
+		if got, want := rideIDs(mine), []int{later.RideID, removed.RideID, sooner.RideID}; !sameIDs(got, want) {
+			t.Errorf("GetRidesByUser = %v, want %v", got, want)
+		}
+		wantRideStatus(t, s, removed.RideID, ride.StatusRemoved)
+
+		none, err := s.Rides.GetRidesByUser(ctx, 4242)
+		wantNoError(t, "GetRidesByUser", err)
+		if len(none) != 0 {
+			t.Errorf("GetRidesByUser for a missing user = %v", rideIDs(none))
+		}
+	}},
+	{"search", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		exact := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+		nearby := createRide(t, s, driver.ID, zurichNear, bernNear, at(1, 7), 3)
+		createRide(t, s, driver.ID, zurichFar, bern, at(1, 8), 3)
+		wrongEnd := createRide(t, s, driver.ID, zurich, basel, at(1, 8), 3)
+		createRide(t, s, driver.ID, zurich, bern, at(1, 5), 3)
+		fewSeats := createRide(t, s, driver.ID, zurich, bern, at(1, 8).Add(30*time.Minute), 1)
+		removed := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, removed.RideID))
+
+		for _, tc := range []struct {
+			name         string
+			from, to     point
+			lower, upper time.Time
+			seats        int
+			want         []int
+		}{
+			{"window", zurich, bern, at(1, 6), at(1, 10), 2, []int{nearby.RideID, exact.RideID}},
+			{"bounds are inclusive", zurich, bern, at(1, 7), at(1, 8), 2, []int{nearby.RideID, exact.RideID}},
+			{"searching from the nearby points", zurichNear, bernNear, at(1, 6), at(1, 10), 2, []int{nearby.RideID, exact.RideID}},
+			{"one seat", zurich, bern, at(1, 8), at(1, 8).Add(30 * time.Minute), 1, []int{exact.RideID, fewSeats.RideID}},
+			{"too many seats", zurich, bern, at(1, 0), at(1, 23), 4, []int{}},
+			{"other destination", zurich, basel, at(1, 0), at(1, 23), 1, []int{wrongEnd.RideID}},
+			{"nothing there", bern, zurich, at(1, 0), at(1, 23), 1, []int{}},
+		} {
+			rides, err := s.Rides.SearchRidesFiltered(ctx, tc.from.lon, tc.from.lat, tc.to.lon, tc.to.lat, tc.lower, tc.upper, tc.seats, searchRadius, preference.Filter{})
+			wantNoError(t, "SearchRidesFiltered", err)
+			if got := rideIDs(rides); !sameIDs(got, tc.want) {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}},
+	{"rules", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		plain := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+		strict := &ride.Ride{
+			UserID: driver.ID, FromLon: zurich.lon, FromLat: zurich.lat, ToLon: bern.lon, ToLat: bern.lat,
+			Price: 12.50, RideTime: at(1, 9), AvailableSeats: 3,
+			Rules: &preference.Rules{Pets: true, Quiet: true, Luggage: preference.LuggageLarge, WomenOnly: true},
+		}
+		wantNoError(t, "CreateRide", s.Rides.CreateRide(ctx, strict))
+
+		wantRules := func(op string, r *ride.Ride) {
+			t.Helper()
+			want := preference.DefaultRules()
+			if r.RideID == strict.RideID {
+				want = *strict.Rules
+			}
+			if r.Rules == nil || *r.Rules != want {
+				t.Errorf("%s: ride %d has rules %+v, want %+v", op, r.RideID, r.Rules, want)
+			}
+		}
+		got, err := s.Rides.GetRideByID(ctx, plain.RideID)
+		wantNoError(t, "GetRideByID", err)
+		wantRules("GetRideByID", got)
+		got, err = s.Rides.GetRideByID(ctx, strict.RideID)
+		wantNoError(t, "GetRideByID", err)
+		wantRules("GetRideByID", got)
+		all, err := s.Rides.GetAllRides(ctx)
+		wantNoError(t, "GetAllRides", err)
+		mine, err := s.Rides.GetRidesByUser(ctx, driver.ID)
+		wantNoError(t, "GetRidesByUser", err)
+		for _, r := range append(all, mine...) {
+			wantRules("listing", r)
+		}
+
+		yes, no := true, false
+		for _, tc := range []struct {
+			name   string
+			filter preference.Filter
+			want   []int
+		}{
+			{"no filter", preference.Filter{}, []int{plain.RideID, strict.RideID}},
+			{"pets", preference.Filter{Pets: &yes}, []int{strict.RideID}},
+			{"no pets", preference.Filter{Pets: &no}, []int{plain.RideID}},
+			{"not women-only", preference.Filter{WomenOnly: &no}, []int{plain.RideID}},
+			{"no smoking and quiet", preference.Filter{Smoking: &no, Quiet: &yes}, []int{strict.RideID}},
+			{"medium luggage", preference.Filter{Luggage: preference.LuggageMedium}, []int{plain.RideID, strict.RideID}},
+			{"large luggage", preference.Filter{Luggage: preference.LuggageLarge}, []int{strict.RideID}},
+			{"music", preference.Filter{Music: &yes}, []int{}},
+		} {
+			found, err := s.Rides.SearchRidesFiltered(ctx, zurich.lon, zurich.lat, bern.lon, bern.lat, at(1, 7), at(1, 10), 1, searchRadius, tc.filter)
+			wantNoError(t, "SearchRidesFiltered", err)
+			if got := rideIDs(found); !sameIDs(got, tc.want) {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+			for _, r := range found {
+				wantRules("SearchRidesFiltered", r)
+			}
+		}
+	}},
+}
+
+var bookingCases = []testCase{
+	{"create and list", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		rider := createUser(t, s, "Grace", "grace@example.com")
+		r := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+
+		first := createBooking(t, s, rider.ID, r.RideID, 2)
+		if first.BookingID == 0 || first.Status != booking.StatusPending || first.CreatedAt.IsZero() {
+			t.Fatalf("CreateBooking filled in %+v", first)
+		}
+		second := createBooking(t, s, rider.ID, r.RideID, 1)
+
+		got, err := s.Bookings.GetBookingsByUser(ctx, rider.ID)
+		wantNoError(t, "GetBookingsByUser", err)
+		if len(got) != 2 || got[0].BookingID != second.BookingID || got[1].BookingID != first.BookingID {
+			t.Fatalf("GetBookingsByUser = %+v", got)
+		}
+		if b := got[1]; b.UserID != rider.ID || b.RideID != r.RideID || b.SeatCount != 2 || b.Status != booking.StatusPending {
+			t.Errorf("got %+v", b)
+		}
+
+		none, err := s.Bookings.GetBookingsByUser(ctx, driver.ID)
+		wantNoError(t, "GetBookingsByUser", err)
+		if len(none) != 0 {
+			t.Errorf("driver has bookings %+v", none)
+		}
+
+		if err := s.Bookings.CreateBooking(ctx, &booking.Booking{UserID: rider.ID, RideID: 4242, SeatCount: 1}); err == nil {
+			t.Error("CreateBooking on a missing ride succeeded")
+		}
+		if err := s.Bookings.CreateBooking(ctx, &booking.Booking{UserID: 4242, RideID: r.RideID, SeatCount: 1}); err == nil {
+			t.Error("CreateBooking by a missing user succeeded")
+		}
+	}},
+	{"available seats", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		r := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+
+		seats, err := s.Bookings.GetRideAvailableSeats(ctx, r.RideID)
+		wantNoError(t, "GetRideAvailableSeats", err)
+		if seats != 3 {
+			t.Errorf("GetRideAvailableSeats = %d, want 3", seats)
+		}
+		_, err = s.Bookings.GetRideAvailableSeats(ctx, 4242)
+		wantNoRows(t, "GetRideAvailableSeats", err)
+
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, r.RideID))
+		_, err = s.Bookings.GetRideAvailableSeats(ctx, r.RideID)
+		wantNoRows(t, "GetRideAvailableSeats on a removed ride", err)
+	}},
+	{"cancel", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		rider := createUser(t, s, "Grace", "grace@example.com")
+		r := createRide(t, s, driver.ID, zurich, bern, at(1, 8), 3)
+		kept := createBooking(t, s, rider.ID, r.RideID, 1)
+		cancelled := createBooking(t, s, rider.ID, r.RideID, 1)
+
+		wantNoError(t, "CancelBooking", s.Bookings.CancelBooking(ctx, cancelled.BookingID))
+		wantNoRows(t, "CancelBooking", s.Bookings.CancelBooking(ctx, 4242))
+		wantBookingStatus(t, s, rider.ID, cancelled.BookingID, booking.StatusCancelled)
+		wantBookingStatus(t, s, rider.ID, kept.BookingID, booking.StatusPending)
+
+		// Removing the ride cancels the rest.
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, r.RideID))
+		wantBookingStatus(t, s, rider.ID, kept.BookingID, booking.StatusCancelled)
+	}},
+}
+
+func claim(t *testing.T, s Stores, userID int, key, hash string, ttl time.Duration) *idempotency.Record {
+	t.Helper()
+	rec := &idempotency.Record{UserID: userID, Key: key, RequestHash: hash}
+	existing, err := s.Idempotency.Claim(context.Background(), rec, ttl)
+	wantNoError(t, "Claim", err)
+	if existing != nil {
+		t.Fatalf("Claim(%d, %q): key already held by %+v", userID, key, existing)
+	}
+	if rec.CreatedAt.IsZero() || !rec.ExpiresAt.After(rec.CreatedAt.Add(ttl-time.Second)) {
+		t.Fatalf("Claim filled in %+v", rec)
+	}
+	return rec
+}
+
+var idempotencyCases = []testCase{
+	{"claim, complete and replay", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		rec := claim(t, s, ada.ID, "k1", "hash", time.Hour)
+
+		existing, err := s.Idempotency.Claim(ctx, &idempotency.Record{UserID: ada.ID, Key: "k1", RequestHash: "other"}, time.Hour)
+		wantNoError(t, "Claim again", err)
+		if existing == nil || !existing.InProgress() || existing.RequestHash != "hash" {
+			t.Fatalf("second Claim got %+v, want the in-progress claim", existing)
+		}
+		// Keys belong to users.
+		claim(t, s, grace.ID, "k1", "hash", time.Hour)
+
+		rec.Status = 201
+		rec.ContentType = "application/json"
+		rec.Body = []byte(`{"ride_id":1}`)
+		wantNoError(t, "Complete", s.Idempotency.Complete(ctx, rec))
+		existing, err = s.Idempotency.Claim(ctx, &idempotency.Record{UserID: ada.ID, Key: "k1", RequestHash: "hash"}, time.Hour)
+		wantNoError(t, "Claim after Complete", err)
+		if existing == nil || existing.Status != 201 || existing.ContentType != "application/json" ||
+			string(existing.Body) != `{"ride_id":1}` || !existing.ExpiresAt.Equal(rec.ExpiresAt) {
+			t.Errorf("got %+v, want the completed %+v", existing, rec)
+		}
+
+		// Completed records aren't released.
+		wantNoError(t, "Release", s.Idempotency.Release(ctx, ada.ID, "k1"))
+		existing, err = s.Idempotency.Claim(ctx, &idempotency.Record{UserID: ada.ID, Key: "k1", RequestHash: "hash"}, time.Hour)
+		wantNoError(t, "Claim after Release", err)
+		if existing == nil || existing.Status != 201 {
+			t.Errorf("got %+v after releasing a completed record", existing)
+		}
+	}},
+	{"release", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		claim(t, s, ada.ID, "k1", "hash", time.Hour)
+		wantNoError(t, "Release", s.Idempotency.Release(ctx, ada.ID, "k1"))
+		wantNoError(t, "Release missing", s.Idempotency.Release(ctx, ada.ID, "k2"))
+		claim(t, s, ada.ID, "k1", "other", time.Hour)
+	}},
+	{"expiry", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		expired := claim(t, s, ada.ID, "old", "hash", -time.Minute)
+		expired.Status = 201
+		wantNoError(t, "Complete", s.Idempotency.Complete(ctx, expired))
+		claim(t, s, ada.ID, "stale", "hash", -time.Minute)
+		claim(t, s, ada.ID, "live", "hash", time.Hour)
+
+		// An expired key can be claimed afresh.
+		claim(t, s, ada.ID, "old", "other", time.Hour)
+		n, err := s.Idempotency.DeleteExpired(ctx)
+		wantNoError(t, "DeleteExpired", err)
+		if n != 1 {
+			t.Errorf("DeleteExpired removed %d records, want 1", n)
+		}
+		existing, err := s.Idempotency.Claim(ctx, &idempotency.Record{UserID: ada.ID, Key: "live", RequestHash: "hash"}, time.Hour)
+		wantNoError(t, "Claim", err)
+		if existing == nil {
+			t.Error("DeleteExpired removed a live key")
+		}
+	}},
+	{"missing user", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		if _, err := s.Idempotency.Claim(ctx, &idempotency.Record{UserID: 4242, Key: "k", RequestHash: "h"}, time.Hour); err == nil {
+			t.Error("Claim for a missing user succeeded")
+		}
+		wantNoRows(t, "Complete", s.Idempotency.Complete(ctx, &idempotency.Record{UserID: 4242, Key: "k", Status: 201}))
+	}},
+	{"anonymize removes keys", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		claim(t, s, ada.ID, "k1", "hash", time.Hour)
+		wantNoError(t, "AnonymizeUser", s.Users.AnonymizeUser(ctx, ada.ID))
+		claim(t, s, ada.ID, "k1", "hash", time.Hour)
+	}},
+}
+
+func createReview(t *testing.T, s Stores, rideID, reviewerID, revieweeID, rating int) *review.Review {
+	t.Helper()
+	rev := &review.Review{RideID: rideID, ReviewerID: reviewerID, RevieweeID: revieweeID, Rating: rating}
+	if err := s.Reviews.CreateReview(context.Background(), rev); err != nil {
+		t.Fatalf("CreateReview: %v", err)
+	}
+	return rev
+}
+
+var reviewCases = []testCase{
+	{"create and list", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		alan := createUser(t, s, "Alan", "alan@example.com")
+		first := createRide(t, s, driver.ID, zurich, bern, at(-2, 8), 3)
+		second := createRide(t, s, driver.ID, zurich, bern, at(-1, 8), 3)
+
+		comment := "Smooth ride"
+		rev := &review.Review{RideID: first.RideID, ReviewerID: grace.ID, RevieweeID: driver.ID, Rating: 5, Comment: &comment}
+		wantNoError(t, "CreateReview", s.Reviews.CreateReview(ctx, rev))
+		if rev.ReviewID == 0 || rev.CreatedAt.IsZero() {
+			t.Fatalf("CreateReview filled in %+v", rev)
+		}
+		createReview(t, s, first.RideID, alan.ID, driver.ID, 4)
+		latest := createReview(t, s, second.RideID, grace.ID, driver.ID, 4)
+		createReview(t, s, first.RideID, driver.ID, grace.ID, 3)
+
+		err := s.Reviews.CreateReview(ctx, &review.Review{RideID: first.RideID, ReviewerID: grace.ID, RevieweeID: driver.ID, Rating: 1})
+		if apperr.KindOf(err) != apperr.KindConflict {
+			t.Errorf("second review of the same person for a ride: got %v, want a conflict", err)
+		}
+
+		got, err := s.Users.GetUserByID(ctx, driver.ID)
+		wantNoError(t, "GetUserByID", err)
+		if got.Rating == nil || *got.Rating != 4.33 {
+			t.Errorf("driver rating %v, want 4.33", got.Rating)
+		}
+
+		reviews, err := s.Reviews.ListReviewsFor(ctx, driver.ID, 2)
+		wantNoError(t, "ListReviewsFor", err)
+		if len(reviews) != 2 || reviews[0].ReviewID != latest.ReviewID {
+			t.Fatalf("ListReviewsFor returned %+v, want 2 starting with review %d", reviews, latest.ReviewID)
+		}
+		all, err := s.Reviews.ListReviewsFor(ctx, driver.ID, 10)
+		wantNoError(t, "ListReviewsFor", err)
+		oldest := all[len(all)-1]
+		if len(all) != 3 || oldest.ReviewerName == nil || *oldest.ReviewerName != "Grace" ||
+			oldest.Comment == nil || *oldest.Comment != comment || oldest.Rating != 5 {
+			t.Errorf("ListReviewsFor returned %+v", all)
+		}
+
+		mine, err := s.Reviews.ListUserReviews(ctx, grace.ID)
+		wantNoError(t, "ListUserReviews", err)
+		var ids []int
+		for _, rev := range mine {
+			ids = append(ids, rev.ReviewID)
+		}
+		if len(mine) != 3 || ids[1] != latest.ReviewID || ids[2] != rev.ReviewID ||
+			mine[0].ReviewerName == nil || *mine[0].ReviewerName != "Ada" {
+			t.Errorf("ListUserReviews returned %+v", mine)
+		}
+	}},
+	{"participants", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		alan := createUser(t, s, "Alan", "alan@example.com")
+		r := createRide(t, s, driver.ID, zurich, bern, at(-1, 8), 3)
+		createBooking(t, s, grace.ID, r.RideID, 1)
+		createBooking(t, s, grace.ID, r.RideID, 1)
+		cancelled := createBooking(t, s, alan.ID, r.RideID, 1)
+		wantNoError(t, "CancelBooking", s.Bookings.CancelBooking(ctx, cancelled.BookingID))
+
+		p, err := s.Reviews.GetParticipants(ctx, r.RideID)
+		wantNoError(t, "GetParticipants", err)
+		if p.DriverID != driver.ID || !sameIDs(p.RiderIDs, []int{grace.ID}) || !p.RideTime.Equal(r.RideTime) || !p.Open {
+			t.Errorf("GetParticipants = %+v", p)
+		}
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, r.RideID))
+		if p, _ := s.Reviews.GetParticipants(ctx, r.RideID); p == nil || p.Open {
+			t.Errorf("removed ride: participants %+v", p)
+		}
+		_, err = s.Reviews.GetParticipants(ctx, 4242)
+		wantNoRows(t, "GetParticipants", err)
+	}},
+	{"user stats", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		driver := createUser(t, s, "Ada", "ada@example.com")
+		rider := createUser(t, s, "Grace", "grace@example.com")
+		past := createRide(t, s, driver.ID, zurich, bern, at(-3, 8), 3)
+		pastToo := createRide(t, s, driver.ID, bern, zurich, at(-2, 18), 3)
+		removed := createRide(t, s, driver.ID, zurich, bern, at(-1, 8), 3)
+		upcoming := createRide(t, s, driver.ID, zurich, bern, at(3, 8), 3)
+		createBooking(t, s, rider.ID, past.RideID, 1)
+		createBooking(t, s, rider.ID, past.RideID, 1)
+		cancelled := createBooking(t, s, rider.ID, pastToo.RideID, 1)
+		createBooking(t, s, rider.ID, removed.RideID, 1)
+		createBooking(t, s, rider.ID, upcoming.RideID, 1)
+		wantNoError(t, "CancelBooking", s.Bookings.CancelBooking(ctx, cancelled.BookingID))
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, removed.RideID))
+		createReview(t, s, past.RideID, rider.ID, driver.ID, 5)
+
+		for _, tc := range []struct {
+			userID int
+			want   user.Stats
+		}{
+			{driver.ID, user.Stats{RidesDriven: 2, RidesTaken: 0, RatingCount: 1}},
+			{rider.ID, user.Stats{RidesDriven: 0, RidesTaken: 1, RatingCount: 0}},
+			{4242, user.Stats{}},
+		} {
+			got, err := s.Users.GetUserStats(ctx, tc.userID)
+			wantNoError(t, "GetUserStats", err)
+			if *got != tc.want {
+				t.Errorf("GetUserStats(%d) = %+v, want %+v", tc.userID, *got, tc.want)
+			}
+		}
+	}},
+}
+
+var vehicleCases = []testCase{
+	{"create, list and update", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		first := createVehicle(t, s, ada.ID, 4)
+		if first.VehicleID == 0 || first.CreatedAt.IsZero() || first.Photos == nil || len(first.Photos) != 0 {
+			t.Fatalf("CreateVehicle filled in %+v", first)
+		}
+		second := createVehicle(t, s, ada.ID, 2)
+		createVehicle(t, s, grace.ID, 3)
+		if err := s.Vehicles.CreateVehicle(ctx, &vehicle.Vehicle{UserID: 4242, Make: "VW", Model: "Golf", Color: "Red", Plate: "BE 1", Seats: 4}); err == nil {
+			t.Error("CreateVehicle accepted an unknown owner")
+		}
+
+		list, err := s.Vehicles.ListVehicles(ctx, ada.ID)
+		wantNoError(t, "ListVehicles", err)
+		if len(list) != 2 || list[0].VehicleID != first.VehicleID || list[1].VehicleID != second.VehicleID {
+			t.Fatalf("ListVehicles returned %+v", list)
+		}
+
+		update := &vehicle.Vehicle{VehicleID: first.VehicleID, Make: "Skoda", Model: "Octavia", Color: "Grey", Plate: "ZH 654321", Seats: 3}
+		wantNoError(t, "UpdateVehicle", s.Vehicles.UpdateVehicle(ctx, update))
+		got, err := s.Vehicles.GetVehicle(ctx, first.VehicleID)
+		wantNoError(t, "GetVehicle", err)
+		if got.UserID != ada.ID || got.Make != "Skoda" || got.Model != "Octavia" || got.Color != "Grey" ||
+			got.Plate != "ZH 654321" || got.Seats != 3 || !got.CreatedAt.Equal(first.CreatedAt) {
+			t.Errorf("updated vehicle %+v", got)
+		}
+		update.VehicleID = 4242
+		wantNoRows(t, "UpdateVehicle", s.Vehicles.UpdateVehicle(ctx, update))
+		_, err = s.Vehicles.GetVehicle(ctx, 4242)
+		wantNoRows(t, "GetVehicle", err)
+	}},
+	{"photos", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		v := createVehicle(t, s, createUser(t, s, "Ada", "ada@example.com").ID, 4)
+		for _, url := range []string{"https://cdn.example/1.jpg", "https://cdn.example/2.jpg"} {
+			wantNoError(t, "AddPhoto", s.Vehicles.AddPhoto(ctx, v.VehicleID, url, 2))
+		}
+		err := s.Vehicles.AddPhoto(ctx, v.VehicleID, "https://cdn.example/3.jpg", 2)
+		if apperr.KindOf(err) != apperr.KindConflict {
+			t.Errorf("third photo: got %v, want a conflict", err)
+		}
+		wantNoRows(t, "AddPhoto", s.Vehicles.AddPhoto(ctx, 4242, "https://cdn.example/4.jpg", 2))
+
+		wantNoError(t, "RemovePhoto", s.Vehicles.RemovePhoto(ctx, v.VehicleID, "https://cdn.example/1.jpg"))
+		wantNoError(t, "AddPhoto", s.Vehicles.AddPhoto(ctx, v.VehicleID, "https://cdn.example/5.jpg", 2))
+		got, _ := s.Vehicles.GetVehicle(ctx, v.VehicleID)
+		if got == nil || len(got.Photos) != 2 || got.Photos[0] != "https://cdn.example/2.jpg" || got.Photos[1] != "https://cdn.example/5.jpg" {
+			t.Errorf("photos %+v", got)
+		}
+		wantNoRows(t, "RemovePhoto", s.Vehicles.RemovePhoto(ctx, 4242, "https://cdn.example/2.jpg"))
+	}},
+	{"rides", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		v := createVehicle(t, s, ada.ID, 4)
+		wantNoError(t, "AddPhoto", s.Vehicles.AddPhoto(ctx, v.VehicleID, "https://cdn.example/1.jpg", 5))
+		upcoming := createRideWith(t, s, v, at(2, 8))
+		past := createRideWith(t, s, v, at(-2, 8))
+		plain := createRide(t, s, ada.ID, zurich, bern, at(2, 9), 3)
+
+		wantVehicle := func(op string, r *ride.Ride) {
+			t.Helper()
+			if r.VehicleID == nil || *r.VehicleID != v.VehicleID || r.Vehicle == nil || r.Vehicle.Plate != "ZH 123456" ||
+				r.Vehicle.Seats != 4 || len(r.Vehicle.Photos) != 1 || r.CarType != "Blue Toyota Corolla" {
+				t.Errorf("%s: ride %d has vehicle %v %+v, car type %q", op, r.RideID, r.VehicleID, r.Vehicle, r.CarType)
+			}
+		}
+		got, err := s.Rides.GetRideByID(ctx, upcoming.RideID)
+		wantNoError(t, "GetRideByID", err)
+		wantVehicle("GetRideByID", got)
+		found, err := s.Rides.SearchRidesFiltered(ctx, zurich.lon, zurich.lat, bern.lon, bern.lat, at(2, 7), at(2, 10), 1, searchRadius, preference.Filter{})
+		wantNoError(t, "SearchRidesFiltered", err)
+		if !sameIDs(rideIDs(found), []int{upcoming.RideID, plain.RideID}) {
+			t.Fatalf("SearchRidesFiltered found %v", rideIDs(found))
+		}
+		wantVehicle("SearchRidesFiltered", found[0])
+		if found[1].VehicleID != nil || found[1].Vehicle != nil {
+			t.Errorf("ride without a vehicle: %+v", found[1])
+		}
+		all, err := s.Rides.GetAllRides(ctx)
+		wantNoError(t, "GetAllRides", err)
+		wantVehicle("GetAllRides", all[0])
+		mine, err := s.Rides.GetRidesByUser(ctx, ada.ID)
+		wantNoError(t, "GetRidesByUser", err)
+		wantVehicle("GetRidesByUser", mine[len(mine)-1])
+
+		busy, err := s.Vehicles.HasUpcomingRides(ctx, v.VehicleID)
+		wantNoError(t, "HasUpcomingRides", err)
+		if !busy {
+			t.Error("HasUpcomingRides = false with an upcoming ride")
+		}
+		wantNoError(t, "RemoveRide", s.Rides.RemoveRide(ctx, upcoming.RideID))
+		if busy, _ := s.Vehicles.HasUpcomingRides(ctx, v.VehicleID); busy {
+			t.Error("HasUpcomingRides = true with only a removed and a past ride")
+		}
+
+		wantNoError(t, "DeleteVehicle", s.Vehicles.DeleteVehicle(ctx, v.VehicleID))
+		wantNoRows(t, "DeleteVehicle", s.Vehicles.DeleteVehicle(ctx, v.VehicleID))
+		got, err = s.Rides.GetRideByID(ctx, past.RideID)
+		wantNoError(t, "GetRideByID", err)
+		if got.VehicleID != nil || got.Vehicle != nil || got.CarType != "Blue Toyota Corolla" {
+			t.Errorf("ride of a deleted vehicle: %+v", got)
+		}
+	}},
+}
+
+var verificationCases = []testCase{
+	{"documents", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		putDocument(t, s, ada.ID, verification.KindLicense, "image/png", "first")
+		doc := putDocument(t, s, ada.ID, verification.KindLicense, "application/pdf", "second scan")
+		if doc.Size != len("second scan") || doc.UploadedAt.IsZero() {
+			t.Errorf("PutDocument filled in %+v", doc)
+		}
+		putDocument(t, s, ada.ID, verification.KindInsurance, "image/jpeg", "policy")
+		if err := s.Verifications.PutDocument(ctx, &verification.Document{UserID: 4242, Kind: verification.KindLicense, ContentType: "image/png", Data: []byte("x")}); err == nil {
+			t.Error("PutDocument accepted an unknown user")
+		}
+
+		got, err := s.Verifications.GetDocument(ctx, ada.ID, verification.KindLicense)
+		wantNoError(t, "GetDocument", err)
+		if got.ContentType != "application/pdf" || string(got.Data) != "second scan" || got.Size != len("second scan") {
+			t.Errorf("GetDocument returned %+v", got)
+		}
+		_, err = s.Verifications.GetDocument(ctx, 4242, verification.KindLicense)
+		wantNoRows(t, "GetDocument", err)
+
+		docs, err := s.Verifications.ListDocuments(ctx, ada.ID)
+		wantNoError(t, "ListDocuments", err)
+		if len(docs) != 2 || docs[0].Kind != verification.KindInsurance || docs[1].Kind != verification.KindLicense ||
+			docs[1].Size != len("second scan") || docs[1].Data != nil {
+			t.Errorf("ListDocuments returned %+v", docs)
+		}
+	}},
+	{"review", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		admin := createUser(t, s, "Admin", "admin@example.com")
+		putDocument(t, s, ada.ID, verification.KindLicense, "image/png", "license")
+		_, err := s.Verifications.GetVerification(ctx, ada.ID)
+		wantNoRows(t, "GetVerification", err)
+		wantNoRows(t, "Decide", s.Verifications.Decide(ctx, ada.ID, admin.ID, verification.StatusVerified, nil))
+
+		wantNoError(t, "Submit", s.Verifications.Submit(ctx, ada.ID))
+		wantNoError(t, "Submit", s.Verifications.Submit(ctx, grace.ID))
+		v, err := s.Verifications.GetVerification(ctx, ada.ID)
+		wantNoError(t, "GetVerification", err)
+		if v.Status != verification.StatusPending || v.SubmittedAt == nil || v.ReviewedAt != nil || v.ReviewerID != nil {
+			t.Errorf("submitted verification %+v", v)
+		}
+		queue, err := s.Verifications.ListVerifications(ctx, verification.StatusPending)
+		wantNoError(t, "ListVerifications", err)
+		if len(queue) != 2 || queue[0].UserID != ada.ID || queue[0].Name != "Ada" || queue[1].UserID != grace.ID {
+			t.Errorf("ListVerifications returned %+v", queue)
+		}
+
+		reason := "The license is expired"
+		wantNoError(t, "Decide", s.Verifications.Decide(ctx, ada.ID, admin.ID, verification.StatusRejected, &reason))
+		wantNoRows(t, "Decide again", s.Verifications.Decide(ctx, ada.ID, admin.ID, verification.StatusVerified, nil))
+		v, _ = s.Verifications.GetVerification(ctx, ada.ID)
+		if v == nil || v.Status != verification.StatusRejected || v.Reason == nil || *v.Reason != reason ||
+			v.ReviewedAt == nil || v.ReviewerID == nil || *v.ReviewerID != admin.ID {
+			t.Errorf("rejected verification %+v", v)
+		}
+		if docs, _ := s.Verifications.ListDocuments(ctx, ada.ID); len(docs) != 1 {
+			t.Errorf("rejection left documents %+v", docs)
+		}
+
+		wantNoError(t, "Submit again", s.Verifications.Submit(ctx, ada.ID))
+		v, _ = s.Verifications.GetVerification(ctx, ada.ID)
+		if v == nil || v.Status != verification.StatusPending || v.Reason != nil || v.ReviewedAt != nil || v.ReviewerID != nil {
+			t.Errorf("resubmitted verification %+v", v)
+		}
+		wantNoError(t, "Decide", s.Verifications.Decide(ctx, ada.ID, admin.ID, verification.StatusVerified, nil))
+		if docs, _ := s.Verifications.ListDocuments(ctx, ada.ID); len(docs) != 0 {
+			t.Errorf("approval kept documents %+v", docs)
+		}
+		verified, err := s.Verifications.ListVerifications(ctx, verification.StatusVerified)
+		wantNoError(t, "ListVerifications", err)
+		if len(verified) != 1 || verified[0].UserID != ada.ID {
+			t.Errorf("verified %+v", verified)
+		}
+	}},
+	{"rides", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		grace := createUser(t, s, "Grace", "grace@example.com")
+		admin := createUser(t, s, "Admin", "admin@example.com")
+		verified := createRide(t, s, ada.ID, zurich, bern, at(2, 8), 3)
+		unverified := createRide(t, s, grace.ID, zurich, bern, at(2, 9), 3)
+		wantNoError(t, "Submit", s.Verifications.Submit(ctx, ada.ID))
+		wantNoError(t, "Decide", s.Verifications.Decide(ctx, ada.ID, admin.ID, verification.StatusVerified, nil))
+		wantNoError(t, "Submit", s.Verifications.Submit(ctx, grace.ID))
+
+		wantVerified := func(op string, rides []*ride.Ride) {
+			t.Helper()
+			for _, r := range rides {
+				if r.DriverVerified != (r.RideID == verified.RideID) {
+					t.Errorf("%s: ride %d has DriverVerified %v", op, r.RideID, r.DriverVerified)
+				}
+			}
+		}
+		got, err := s.Rides.GetRideByID(ctx, verified.RideID)
+		wantNoError(t, "GetRideByID", err)
+		other, err := s.Rides.GetRideByID(ctx, unverified.RideID)
+		wantNoError(t, "GetRideByID", err)
+		wantVerified("GetRideByID", []*ride.Ride{got, other})
+		found, err := s.Rides.SearchRidesFiltered(ctx, zurich.lon, zurich.lat, bern.lon, bern.lat, at(2, 7), at(2, 10), 1, searchRadius, preference.Filter{})
+		wantNoError(t, "SearchRidesFiltered", err)
+		if len(found) != 2 {
+			t.Fatalf("SearchRidesFiltered found %v", rideIDs(found))
+		}
+		wantVerified("SearchRidesFiltered", found)
+		all, err := s.Rides.GetAllRides(ctx)
+		wantNoError(t, "GetAllRides", err)
+		wantVerified("GetAllRides", all)
+		mine, err := s.Rides.GetRidesByUser(ctx, ada.ID)
+		wantNoError(t, "GetRidesByUser", err)
+		wantVerified("GetRidesByUser", mine)
+	}},
+	{"anonymize", func(t *testing.T, s Stores) {
+		ctx := context.Background()
+		ada := createUser(t, s, "Ada", "ada@example.com")
+		putDocument(t, s, ada.ID, verification.KindLicense, "image/png", "license")
+		wantNoError(t, "Submit", s.Verifications.Submit(ctx, ada.ID))
+		wantNoError(t, "AnonymizeUser", s.Users.AnonymizeUser(ctx, ada.ID))
+		_, err := s.Verifications.GetVerification(ctx, ada.ID)
+		wantNoRows(t, "GetVerification", err)
+		_, err = s.Verifications.GetDocument(ctx, ada.ID, verification.KindLicense)
+		wantNoRows(t, "GetDocument", err)
+	}},
+}
+
+func putDocument(t *testing.T, s Stores, userID int, kind, contentType, data string) *verification.Document {
+	t.Helper()
+	d := &verification.Document{UserID: userID, Kind: kind, ContentType: contentType, Data: []byte(data)}
+	if err := s.Verifications.PutDocument(context.Background(), d); err != nil {
+		t.Fatalf("PutDocument: %v", err)
+	}
+	return d
+}
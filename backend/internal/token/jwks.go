@@ -0,0 +1,77 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+// JWK is the public part of a key as published in a JSON Web Key Set.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func publicJWK(public interface{}) (*JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return &JWK{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}, nil
+	case ed25519.PublicKey:
+		return &JWK{Kty: "OKP", Crv: "Ed25519", X: b64(k)}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+// thumbprint computes the RFC 7638 thumbprint: a hash over the required
+// members in lexicographic order.
+func (j *JWK) thumbprint() string {
+	var canonical string
+	switch j.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, j.E, j.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, j.Crv, j.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWKS returns the public keys of the set. HMAC secrets are never
+// published, so a set of only HS256 keys yields an empty list.
+func (ks *KeySet) JWKS() []JWK {
+	keys := []JWK{}
+	for _, k := range ks.keys {
+		jwk, err := publicJWK(k.verifyKey)
+		if err != nil {
+			continue
+		}
+		jwk.Kid = k.ID
+		jwk.Alg = k.Method.Alg()
+		jwk.Use = "sig"
+		keys = append(keys, *jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+// JWKSHandler serves the set's public keys at /.well-known/jwks.json.
+func (ks *KeySet) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": ks.JWKS()})
+}
@@ -0,0 +1,150 @@
+// Package token signs and verifies the app's JWTs. A KeySet holds one
+// signing key plus any number of verification-only keys, identified by the
+// "kid" header, so keys can be rotated without logging everyone out.
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single signing or verification key.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is nil for verification-only keys.
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns an HS256 key. Its ID is derived from the secret so
+// replacing the secret also changes the kid.
+func NewHMACKey(secret []byte) *Key {
+	sum := sha256.Sum256(secret)
+	return &Key{
+		ID:        "hs256-" + hex.EncodeToString(sum[:4]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewPrivateKey returns an RS256 key for an RSA key or an EdDSA key for an
+// Ed25519 key. Its ID is the RFC 7638 thumbprint of the public key.
+func NewPrivateKey(private interface{}) (*Key, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return newAsymmetricKey(jwt.SigningMethodRS256, k, &k.PublicKey)
+	case ed25519.PrivateKey:
+		return newAsymmetricKey(jwt.SigningMethodEdDSA, k, k.Public())
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", private)
+}
+
+// NewPublicKey returns a verification-only key for an RSA or Ed25519
+// public key.
+func NewPublicKey(public interface{}) (*Key, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return newAsymmetricKey(jwt.SigningMethodRS256, nil, public)
+	case ed25519.PublicKey:
+		return newAsymmetricKey(jwt.SigningMethodEdDSA, nil, public)
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+func newAsymmetricKey(method jwt.SigningMethod, private, public interface{}) (*Key, error) {
+	jwk, err := publicJWK(public)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Method: method, verifyKey: public, ID: jwk.thumbprint()}
+	if private != nil {
+		key.signKey = private
+	}
+	return key, nil
+}
+
+// KeySet signs with one key and verifies with any key it holds.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	// legacy verifies tokens issued before kid headers were added.
+	legacy *Key
+}
+
+// NewKeySet returns a KeySet signing with signing and additionally
+// accepting tokens signed by any of verifyOnly.
+func NewKeySet(signing *Key, verifyOnly ...*Key) (*KeySet, error) {
+	if signing == nil || signing.signKey == nil {
+		return nil, errors.New("token: signing key has no private part")
+	}
+	ks := &KeySet{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, k := range verifyOnly {
+		if _, dup := ks.keys[k.ID]; dup {
+			continue
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// AcceptUnidentified makes the KeySet verify tokens without a kid header
+// against k, so tokens issued before rotation support stay valid until
+// they expire.
+func (ks *KeySet) AcceptUnidentified(k *Key) {
+	ks.legacy = k
+}
+
+// Sign returns a token for claims, signed with the signing key and
+// carrying its kid.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(ks.signing.Method, claims)
+	t.Header["kid"] = ks.signing.ID
+	return t.SignedString(ks.signing.signKey)
+}
+
+// Parse verifies tokenStr and returns its claims. The token's alg must be
+// the one registered for its key, which rules out "none" and HS256 tokens
+// forged with a public key as the secret.
+func (ks *KeySet) Parse(tokenStr string) (jwt.MapClaims, error) {
+	t, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		key, err := ks.lookup(t)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("token: unexpected signing method %q", t.Method.Alg())
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok || !t.Valid {
+		return nil, errors.New("token: invalid token")
+	}
+	return claims, nil
+}
+
+func (ks *KeySet) lookup(t *jwt.Token) (*Key, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		if ks.legacy != nil {
+			return ks.legacy, nil
+		}
+		return nil, errors.New("token: missing kid")
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("token: unknown kid %q", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,244 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type testKeys struct {
+	rsa, ed, hmac, oldHMAC *Key
+	rsaPublic              *rsa.PublicKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := testKeys{
+		hmac:      NewHMACKey([]byte("current secret")),
+		oldHMAC:   NewHMACKey([]byte("old secret")),
+		rsaPublic: &rsaPrivate.PublicKey,
+	}
+	if keys.rsa, err = NewPrivateKey(rsaPrivate); err != nil {
+		t.Fatal(err)
+	}
+	if keys.ed, err = NewPrivateKey(edPrivate); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// forge returns a token for subject 42 signed by method with secret and
+// carrying header, bypassing KeySet.Sign.
+func forge(t *testing.T, method jwt.SigningMethod, secret interface{}, header map[string]interface{}) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "42"})
+	for k, v := range header {
+		tok.Header[k] = v
+	}
+	s, err := tok.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := newTestKeys(t)
+	for _, tc := range []struct {
+		name string
+		key  *Key
+		alg  string
+	}{
+		{"RS256", keys.rsa, "RS256"},
+		{"EdDSA", keys.ed, "EdDSA"},
+		{"HS256", keys.hmac, "HS256"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ks, err := NewKeySet(tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := ks.Sign(jwt.MapClaims{"sub": "42"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Method.Alg() != tc.alg || parsed.Header["kid"] != tc.key.ID {
+				t.Errorf("header %v, want alg %s and kid %s", parsed.Header, tc.alg, tc.key.ID)
+			}
+			claims, err := ks.Parse(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["sub"] != "42" {
+				t.Errorf("claims %v", claims)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	ks, err := NewKeySet(keys.rsa, keys.ed, keys.oldHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(keys.rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"HS256 signed with the RS256 public key", forge(t, jwt.SigningMethodHS256, publicPEM, map[string]interface{}{"kid": keys.rsa.ID})},
+		{"HS256 signed with the raw RS256 modulus", forge(t, jwt.SigningMethodHS256, keys.rsaPublic.N.Bytes(), map[string]interface{}{"kid": keys.rsa.ID})},
+		{"none", forge(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, map[string]interface{}{"kid": keys.rsa.ID})},
+		{"none without kid", forge(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil)},
+		{"EdDSA key with RS256 kid", forge(t, jwt.SigningMethodEdDSA, keys.ed.signKey, map[string]interface{}{"kid": keys.rsa.ID})},
+		{"unknown kid", forge(t, jwt.SigningMethodHS256, []byte("current secret"), map[string]interface{}{"kid": "hs256-00000000"})},
+		{"kid of a key not in the set", forge(t, jwt.SigningMethodHS256, []byte("current secret"), map[string]interface{}{"kid": keys.hmac.ID})},
+		{"no kid and no legacy key", forge(t, jwt.SigningMethodHS256, []byte("old secret"), nil)},
+	} {
+		if claims, err := ks.Parse(tc.token); err == nil {
+			t.Errorf("%s: accepted with claims %v", tc.name, claims)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	keys := newTestKeys(t)
+	old, err := NewKeySet(keys.oldHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := old.Sign(jwt.MapClaims{"sub": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewKeySet(keys.ed, keys.oldHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Parse(oldToken); err != nil {
+		t.Errorf("token of a verify-only key: %v", err)
+	}
+	dropped, err := NewKeySet(keys.rsa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dropped.Parse(oldToken); err == nil {
+		t.Error("token of a retired key accepted after it was dropped")
+	}
+}
+
+func TestLegacyTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	ks, err := NewKeySet(keys.rsa, keys.hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := forge(t, jwt.SigningMethodHS256, []byte("current secret"), nil)
+	if _, err := ks.Parse(legacy); err == nil {
+		t.Error("token without kid accepted before AcceptUnidentified")
+	}
+
+	ks.AcceptUnidentified(keys.hmac)
+	if _, err := ks.Parse(legacy); err != nil {
+		t.Errorf("token without kid: %v", err)
+	}
+	for name, token := range map[string]string{
+		"wrong secret": forge(t, jwt.SigningMethodHS256, []byte("old secret"), nil),
+		"RS256":        forge(t, jwt.SigningMethodRS256, keys.rsa.signKey, nil),
+		"none":         forge(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, nil),
+	} {
+		if _, err := ks.Parse(token); err == nil {
+			t.Errorf("token without kid, %s: accepted", name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	ks, err := NewKeySet(keys.rsa, keys.ed, keys.hmac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	ks.JWKSHandler(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+
+	// The HMAC secret is never published.
+	byKid := map[string]JWK{}
+	for _, k := range set.Keys {
+		byKid[k.Kid] = k
+	}
+	if len(set.Keys) != 2 || len(byKid) != 2 {
+		t.Fatalf("got keys %+v, want the RS256 and EdDSA keys", set.Keys)
+	}
+	if set.Keys[0].Kid > set.Keys[1].Kid {
+		t.Errorf("keys not sorted by kid: %s, %s", set.Keys[0].Kid, set.Keys[1].Kid)
+	}
+
+	rsaJWK, ok := byKid[keys.rsa.ID]
+	if !ok || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" {
+		t.Fatalf("RSA key %+v", rsaJWK)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	if err != nil || new(big.Int).SetBytes(n).Cmp(keys.rsaPublic.N) != 0 {
+		t.Errorf("RSA modulus %q does not match the key", rsaJWK.N)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	if err != nil || new(big.Int).SetBytes(e).Int64() != int64(keys.rsaPublic.E) {
+		t.Errorf("RSA exponent %q does not match the key", rsaJWK.E)
+	}
+
+	edJWK, ok := byKid[keys.ed.ID]
+	if !ok || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" || edJWK.Use != "sig" {
+		t.Fatalf("Ed25519 key %+v", edJWK)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(edJWK.X)
+	if err != nil || !ed25519.PublicKey(x).Equal(keys.ed.verifyKey) {
+		t.Errorf("Ed25519 x %q does not match the key", edJWK.X)
+	}
+
+	// The kid is the RFC 7638 thumbprint, so recomputing it from the
+	// published members gives the same value.
+	for _, k := range set.Keys {
+		if got := k.thumbprint(); got != k.Kid {
+			t.Errorf("thumbprint of %s = %s", k.Kid, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Options describes where keys come from. Secret and SigningKeyFile may
+// both be set while migrating from HS256 to an asymmetric algorithm: the
+// file's key signs and the secret only verifies.
+type Options struct {
+	// Secret is the current HS256 secret (JWT_SECRET).
+	Secret string
+	// PreviousSecrets are retired HS256 secrets still accepted.
+	PreviousSecrets []string
+	// SigningKeyFile is a PEM RSA or Ed25519 private key.
+	SigningKeyFile string
+	// VerifyKeyFiles are PEM public keys (or certificates) of retired
+	// asymmetric keys still accepted.
+	VerifyKeyFiles []string
+}
+
+// LoadKeySet builds a KeySet from opts.
+func LoadKeySet(opts Options) (*KeySet, error) {
+	var signing *Key
+	var verifyOnly []*Key
+	var secretKey *Key
+	if opts.Secret != "" {
+		secretKey = NewHMACKey([]byte(opts.Secret))
+	}
+
+	if opts.SigningKeyFile != "" {
+		key, err := loadPrivateKey(opts.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signing = key
+		if secretKey != nil {
+			verifyOnly = append(verifyOnly, secretKey)
+		}
+	} else if secretKey != nil {
+		signing = secretKey
+	} else {
+		return nil, errors.New("token: either a secret or a signing key file is required")
+	}
+
+	for _, secret := range opts.PreviousSecrets {
+		verifyOnly = append(verifyOnly, NewHMACKey([]byte(secret)))
+	}
+	for _, path := range opts.VerifyKeyFiles {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verifyOnly = append(verifyOnly, key)
+	}
+
+	ks, err := NewKeySet(signing, verifyOnly...)
+	if err != nil {
+		return nil, err
+	}
+	if secretKey != nil {
+		ks.AcceptUnidentified(secretKey)
+	}
+	return ks, nil
+}
+
+func loadPrivateKey(path string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token: parsing %s: %w", path, err)
+	}
+	return NewPrivateKey(private)
+}
+
+func loadPublicKey(path string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var public interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			public = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token: parsing %s: %w", path, err)
+	}
+	return NewPublicKey(public)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("token: %s contains no PEM data", path)
+	}
+	return block, nil
+}
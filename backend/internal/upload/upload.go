@@ -0,0 +1,47 @@
+// Package upload reads files posted as multipart/form-data.
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/validation"
+)
+
+// File returns the contents of the form field named field in the
+// multipart/form-data body of r, which must be at most limit bytes. what
+// names the file in error messages, such as "Profile picture". Errors are
+// ready for apperr.Write.
+func File(w http.ResponseWriter, r *http.Request, field string, limit int64, what string) ([]byte, error) {
+	tooLarge := apperr.TooLarge(fmt.Sprintf("%s must be at most %d bytes", what, limit))
+	// Leave room for the multipart framing and other small fields.
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, apperr.UnsupportedMediaType("Expected a multipart/form-data upload")
+	}
+	var data []byte
+	for data == nil {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && part.FormName() == field {
+			data, err = io.ReadAll(io.LimitReader(part, limit+1))
+		}
+		var maxBytes *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytes) || int64(len(data)) > limit:
+			return nil, tooLarge
+		case err != nil:
+			return nil, apperr.BadRequest("Invalid multipart body")
+		}
+	}
+	if len(data) == 0 {
+		return nil, validation.Errors{field: "is required"}
+	}
+	return data, nil
+}
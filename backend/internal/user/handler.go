@@ -1,165 +1,388 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"mime"
 	"net/http"
-	"regexp"
 	"time"
 
+	"carpool/backend/internal/apperr"
 	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/preference"
+	"carpool/backend/internal/sso"
+	"carpool/backend/internal/token"
+	"carpool/backend/internal/upload"
+	"carpool/backend/internal/validation"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
+const (
+	// challengePurpose marks a token that only proves the password step of
+	// a two-factor login. JWTMiddleware refuses tokens carrying a purpose.
+	challengePurpose  = "2fa_challenge"
+	challengeLifetime = 5 * time.Minute
+	// defaultTokenLifetime applies when Handler.TokenLifetime is zero.
+	defaultTokenLifetime = time.Hour
+	// defaultMaxPictureBytes applies when Handler.MaxPictureBytes is zero.
+	defaultMaxPictureBytes = 5 << 20
+	// pictureField is the multipart form field carrying the picture.
+	pictureField = "profile_pic"
+)
+
 type Handler struct {
 	Service *Service
-	JWTKey  []byte
+	Keys    *token.KeySet
+	// TokenLifetime is how long access tokens are valid.
+	TokenLifetime time.Duration
+	// SSO is nil when no OpenID Connect provider is configured.
+	SSO *sso.Provider
+	// SSOPostLoginURL is the frontend page that receives the token after
+	// single sign-on.
+	SSOPostLoginURL string
+	// MaxPictureBytes limits profile picture uploads.
+	MaxPictureBytes int64
 }
 
 func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost {
-        http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-        return
-    }
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		log.Println("Error decoding user JSON:", err)
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return
+	}
 
-    var user User
-    if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-        log.Println("Error decoding user JSON:", err)
-        http.Error(w, "Invalid input", http.StatusBadRequest)
-        return
-    }
-    log.Printf("Decoded user: %+v\n", user)
+	if errs := user.Validate(); errs != nil {
+		apperr.Write(w, r, errs)
+		return
+	}
 
-    // Call the service
-    log.Println("Calling h.Service.Register")
-    if err := h.Service.Register(&user); err != nil {
-        log.Println("Error in Service.Register:", err)
-        http.Error(w, "Error registering user", http.StatusInternalServerError)
-        return
-    }
-    log.Println("Service.Register succeeded")
+	if err := h.Service.Register(r.Context(), &user); err != nil {
+		log.Println("Error in Service.Register:", err)
+		apperr.Write(w, r, err)
+		return
+	}
 
-    // Hide password in response
-    user.Password = ""
+	// Hide password in response
+	user.Password = ""
 
-    w.WriteHeader(http.StatusCreated)
-    json.NewEncoder(w).Encode(user)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
 }
 
-
 func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	var creds struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		log.Printf("Error decoding login request: %v", err)
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
 		return
 	}
 
-	// Debug: log the login attempt
-	log.Printf("Login attempt for email: %s", creds.Email)
+	user, err := h.Service.Login(r.Context(), creds.Email, creds.Password)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
 
-	user, err := h.Service.Login(creds.Email, creds.Password)
+	if user.TwoFactorEnabled {
+		// Password alone isn't enough; hand back a short-lived challenge
+		// that must be exchanged at /login/2fa along with a code.
+		challenge, err := h.issueChallengeToken(r.Context(), user)
+		if err != nil {
+			apperr.Write(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		return
+	}
+
+	tokenString, err := h.issueAccessToken(user)
 	if err != nil {
-		// Debug: log detailed error information
-		log.Printf("Login error for email %s: %v", creds.Email, err)
-		http.Error(w, "User not found or invalid password", http.StatusUnauthorized)
+		apperr.Write(w, r, err)
 		return
 	}
 
-	log.Printf("User found: %+v", user)
-	log.Printf("Stored hash: %s", user.Password) // Log the stored hash for debugging
+	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+}
+
+// TwoFactorLoginHandler exchanges a challenge token from LoginHandler and a
+// TOTP or recovery code for an access token.
+func (h *Handler) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return
+	}
+	userID, challengeID, err := h.parseChallenge(req.ChallengeToken)
+	if err != nil {
+		apperr.Write(w, r, errInvalidChallenge)
+		return
+	}
+	user, err := h.Service.CompleteTwoFactorLogin(r.Context(), userID, challengeID, req.Code)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	tokenString, err := h.issueAccessToken(user)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+}
 
-	expirationTime := time.Now().Add(1 * time.Hour)
-	claims := jwt.MapClaims{
+func (h *Handler) issueAccessToken(user *User) (string, error) {
+	lifetime := h.TokenLifetime
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	expirationTime := time.Now().Add(lifetime)
+	return h.signToken(jwt.MapClaims{
 		"user_id": user.ID,
 		"email":   user.Email,
+		"role":    user.Role,
 		"exp":     expirationTime.Unix(),
 		"iat":     time.Now().Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.JWTKey)
+	})
+}
+
+// issueChallengeToken proves only the first factor of a login. Its jti
+// names the challenge the service records, so the token works only once.
+func (h *Handler) issueChallengeToken(ctx context.Context, user *User) (string, error) {
+	challengeID, err := h.Service.StartTwoFactorLogin(ctx, user.ID, challengeLifetime)
 	if err != nil {
-		log.Printf("Error signing token for email %s: %v", creds.Email, err)
-		http.Error(w, "Could not generate token", http.StatusInternalServerError)
-		return
+		return "", err
 	}
+	return h.signToken(jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": challengePurpose,
+		"jti":     challengeID,
+		"exp":     time.Now().Add(challengeLifetime).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+}
 
-	log.Printf("Login successful for email %s, token: %s", creds.Email, tokenString)
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+func (h *Handler) signToken(claims jwt.MapClaims) (string, error) {
+	return h.Keys.Sign(claims)
 }
 
-func (h *Handler) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
-		return
+func (h *Handler) parseChallenge(tokenStr string) (int, string, error) {
+	claims, err := h.Keys.Parse(tokenStr)
+	if err != nil {
+		return 0, "", err
 	}
+	userID, ok := claims["user_id"].(float64)
+	challengeID, _ := claims["jti"].(string)
+	if !ok || challengeID == "" || claims["purpose"] != challengePurpose {
+		return 0, "", errors.New("not a challenge token")
+	}
+	return int(userID), challengeID, nil
+}
+
+func (h *Handler) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserIDFromContext(r.Context())
-	user, err := h.Service.GetUserByID(userID)
+	user, err := h.Service.GetUserByID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "Error fetching profile", http.StatusInternalServerError)
+		apperr.Write(w, r, err)
 		return
 	}
 	user.Password = ""
 	json.NewEncoder(w).Encode(user)
 }
 
+// UpdateProfileHandler applies a JSON merge patch of name, bio, email and
+// phone to the profile and returns it. A new email shows as pending_email
+// until confirmed.
 func (h *Handler) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
-	// Only updates the phone number.
-	if r.Method != http.MethodPatch {
-		http.Error(w, "Only PATCH allowed", http.StatusMethodNotAllowed)
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, _ := mime.ParseMediaType(ct); mt != "application/merge-patch+json" && mt != "application/json" {
+			apperr.Write(w, r, apperr.UnsupportedMediaType("Expected application/merge-patch+json"))
+			return
+		}
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		apperr.Write(w, r, apperr.TooLarge("Profile update is too large"))
+		return
+	}
+	update, email, err := parseProfilePatch(data)
+	if err != nil {
+		apperr.Write(w, r, err)
 		return
 	}
 	userID := middleware.GetUserIDFromContext(r.Context())
-	var updates struct {
-		Phone string `json:"phone"`
+	updatedUser, err := h.Service.UpdateProfile(r.Context(), userID, update, email)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	updatedUser.Password = ""
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// ConfirmEmailHandler takes the token from a confirmation link. It needs
+// no sign-in, since the link may be opened on another device.
+func (h *Handler) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
 		return
 	}
-	if updates.Phone != "" {
-		// Validate phone is numeric
-		matched, err := regexp.MatchString(`^[0-9]*$`, updates.Phone)
-		if err != nil || !matched {
-			http.Error(w, "Phone must contain only numbers", http.StatusBadRequest)
-			return
-		}
+	if req.Token == "" {
+		apperr.Write(w, r, validation.Errors{"token": "is required"})
+		return
 	}
-	updatedUser, err := h.Service.UpdateUserProfile(userID, updates.Phone)
+	if err := h.Service.ConfirmEmailChange(r.Context(), req.Token); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address confirmed"})
+}
+
+// UploadProfilePictureHandler takes the picture from the profile_pic field
+// of a multipart/form-data body and returns the updated profile.
+func (h *Handler) UploadProfilePictureHandler(w http.ResponseWriter, r *http.Request) {
+	limit := h.MaxPictureBytes
+	if limit <= 0 {
+		limit = defaultMaxPictureBytes
+	}
+	data, err := upload.File(w, r, pictureField, limit, "Profile picture")
 	if err != nil {
-		http.Error(w, "Error updating profile", http.StatusInternalServerError)
+		apperr.Write(w, r, err)
 		return
 	}
-	updatedUser.Password = ""
-	json.NewEncoder(w).Encode(updatedUser)
+	userID := middleware.GetUserIDFromContext(r.Context())
+	user, err := h.Service.SetProfilePicture(r.Context(), userID, data)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	user.Password = ""
+	json.NewEncoder(w).Encode(user)
 }
 
-func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPatch {
-		http.Error(w, "Only PATCH allowed", http.StatusMethodNotAllowed)
+func (h *Handler) DeleteProfilePictureHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	user, err := h.Service.RemoveProfilePicture(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	user.Password = ""
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *Handler) GetPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	settings, err := h.Service.GetPreferences(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdatePreferencesHandler replaces the user's gender, default ride rules
+// and search filter. Members left out take their default values.
+func (h *Handler) UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	settings := preference.DefaultSettings()
+	if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
 		return
 	}
+	userID := middleware.GetUserIDFromContext(r.Context())
+	settings, err := h.Service.UpdatePreferences(r.Context(), userID, settings)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserIDFromContext(r.Context())
 	var pwd struct {
 		CurrentPassword string `json:"currentPassword"`
 		NewPassword     string `json:"newPassword"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&pwd); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+		return
+	}
+	v := &validation.Validator{}
+	validatePassword(v, "newPassword", pwd.NewPassword)
+	if errs := v.Errors(); errs != nil {
+		apperr.Write(w, r, errs)
 		return
 	}
-	if err := h.Service.ChangeUserPassword(userID, pwd.CurrentPassword, pwd.NewPassword); err != nil {
-		http.Error(w, "Error changing password", http.StatusUnauthorized)
+	if err := h.Service.ChangeUserPassword(r.Context(), userID, pwd.CurrentPassword, pwd.NewPassword); err != nil {
+		apperr.Write(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
 }
+
+// EnrollTOTPHandler starts two-factor enrollment and returns the secret and
+// otpauth:// URI to show as a QR code.
+func (h *Handler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	enrollment, err := h.Service.BeginTOTPEnrollment(r.Context(), userID)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmTOTPHandler enables two-factor authentication with a first code
+// and returns the one-time display of the recovery codes.
+func (h *Handler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+		return
+	}
+	codes, err := h.Service.ConfirmTOTPEnrollment(r.Context(), userID, req.Code)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTPHandler turns two-factor authentication off.
+func (h *Handler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid JSON"))
+		return
+	}
+	if err := h.Service.DisableTOTP(r.Context(), userID, req.Password, req.Code); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,14 +2,122 @@ package user
 
 import (
 	"time"
+
+	"carpool/backend/internal/validation"
 )
 
 type User struct {
-	ID        int       `json:"id,omitempty"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password,omitempty"`
-	Phone     *string   `json:"phone,omitempty"`
-	Rating    *float64  `json:"rating,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	ID    int    `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// PendingEmail is a new address awaiting confirmation.
+	PendingEmail *string  `json:"pending_email,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	Phone        *string  `json:"phone,omitempty"`
+	Bio          *string  `json:"bio,omitempty"`
+	Rating       *float64 `json:"rating,omitempty"`
+	// ProfilePic and ProfilePicThumbnail are URLs of the uploaded picture
+	// at 512 and 128 pixels square.
+	ProfilePic          *string   `json:"profile_pic,omitempty"`
+	ProfilePicThumbnail *string   `json:"profile_pic_thumbnail,omitempty"`
+	Role                string    `json:"role,omitempty"`
+	Status              string    `json:"status,omitempty"`
+	CreatedAt           time.Time `json:"created_at,omitempty"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	// EmailVerified is set once the user has shown they receive mail at
+	// Email, by following a confirmation link or signing in through an
+	// identity provider that vouches for it.
+	EmailVerified bool `json:"email_verified"`
+}
+
+// Stats summarises what a user has done on the platform. Rides count once
+// they have departed, unless cancelled or removed.
+type Stats struct {
+	RidesDriven int
+	RidesTaken  int
+	RatingCount int
+}
+
+// ProfileUpdate lists the profile fields to change: Name when not nil,
+// and Bio and Phone when their Set flag is true, with nil clearing them.
+type ProfileUpdate struct {
+	Name     *string
+	SetBio   bool
+	Bio      *string
+	SetPhone bool
+	Phone    *string
+}
+
+// TOTPEnrollment is returned when a user starts enrolling an authenticator.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TOTPState is the stored second-factor configuration of a user. Secret is
+// set but Enabled false while enrollment awaits confirmation. LockedUntil
+// is set after too many wrong codes.
+type TOTPState struct {
+	Secret      *string
+	Enabled     bool
+	LastStep    *int64
+	LockedUntil *time.Time
+}
+
+// Roles stored in users.role and embedded in JWT claims.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Account statuses stored in users.status. Only active accounts can
+// authenticate.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusBanned    = "banned"
+	// StatusDeleted marks an account anonymized at the user's request.
+	StatusDeleted = "deleted"
+)
+
+// ValidStatus reports whether status is one an admin may set. Deletion is
+// irreversible and only done by the user.
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusSuspended, StatusBanned:
+		return true
+	}
+	return false
+}
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes.
+	maxPasswordLength = 72
+)
+
+// Validate checks the fields required to register a user.
+func (u *User) Validate() validation.Errors {
+	v := &validation.Validator{}
+	v.Required("name", u.Name)
+	v.MaxLength("name", u.Name, 255)
+	v.Required("email", u.Email)
+	v.Email("email", u.Email)
+	v.MaxLength("email", u.Email, 255)
+	validatePassword(v, "password", u.Password)
+	return v.Errors()
+}
+
+func validatePassword(v *validation.Validator, field, password string) {
+	v.Required(field, password)
+	v.MinLength(field, password, minPasswordLength)
+	v.Check(len(password) <= maxPasswordLength, field, "must be at most 72 bytes")
+}
+
+// Identity is an external sign-in linked to the user.
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,113 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/imaging"
+)
+
+const (
+	// Profile pictures are stored square, at these sizes in pixels.
+	pictureSize   = 512
+	thumbnailSize = 128
+	// maxPicturePixels bounds the decoded size of an upload, about what a
+	// current phone camera produces.
+	maxPicturePixels = 50_000_000
+)
+
+// SetProfilePicture stores data, a JPEG, PNG or GIF image, as the user's
+// picture and thumbnail, replacing any previous ones. The image is turned
+// upright, cropped square and re-encoded without its metadata.
+func (s *Service) SetProfilePicture(ctx context.Context, userID int, data []byte) (*User, error) {
+	old, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	img, err := imaging.Decode(data, maxPicturePixels)
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		return nil, apperr.UnsupportedMediaType("Profile picture must be a JPEG, PNG or GIF image")
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		return nil, apperr.TooLarge("Profile picture must be at most 50 megapixels")
+	case err != nil:
+		return nil, err
+	}
+
+	// Every upload gets new keys, so cached copies of the old picture are
+	// never served in its place.
+	name := make([]byte, 12)
+	if _, err := rand.Read(name); err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("profile-pictures/%d/%s", userID, hex.EncodeToString(name))
+	var urls []string
+	for _, size := range []int{pictureSize, thumbnailSize} {
+		encoded, err := imaging.EncodeJPEG(imaging.Square(img, size))
+		if err != nil {
+			return nil, err
+		}
+		url, err := s.Pictures.Put(ctx, fmt.Sprintf("%s-%d.jpg", prefix, size), "image/jpeg", encoded)
+		if err != nil {
+			s.deletePictures(ctx, urls...)
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	err = s.Repo.SetProfilePicture(ctx, userID, &urls[0], &urls[1])
+	if err != nil {
+		s.deletePictures(ctx, urls...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("User not found")
+		}
+		return nil, err
+	}
+	s.deletePictures(ctx, deref(old.ProfilePic), deref(old.ProfilePicThumbnail))
+	return s.GetUserByID(ctx, userID)
+}
+
+// RemoveProfilePicture clears the user's picture and deletes its files.
+func (s *Service) RemoveProfilePicture(ctx context.Context, userID int) (*User, error) {
+	old, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repo.SetProfilePicture(ctx, userID, nil, nil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("User not found")
+		}
+		return nil, err
+	}
+	s.deletePictures(ctx, deref(old.ProfilePic), deref(old.ProfilePicThumbnail))
+	return s.GetUserByID(ctx, userID)
+}
+
+// deletePictures removes stored pictures, logging failures: a leftover
+// file is harmless, so they don't fail the request.
+func (s *Service) deletePictures(ctx context.Context, urls ...string) {
+	if s.Pictures == nil {
+		return
+	}
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		if err := s.Pictures.Delete(context.WithoutCancel(ctx), url); err != nil {
+			log.Printf("Deleting profile picture %s: %v", url, err)
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
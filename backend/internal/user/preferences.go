@@ -0,0 +1,35 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/preference"
+)
+
+// GetPreferences returns the user's ride settings.
+func (s *Service) GetPreferences(ctx context.Context, userID int) (*preference.Settings, error) {
+	settings, err := s.Repo.GetPreferences(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("User not found")
+	}
+	return settings, err
+}
+
+// UpdatePreferences replaces the user's ride settings. Rides already
+// posted keep their rules.
+func (s *Service) UpdatePreferences(ctx context.Context, userID int, settings *preference.Settings) (*preference.Settings, error) {
+	if errs := settings.Validate(); errs != nil {
+		return nil, apperr.Validation(errs)
+	}
+	err := s.Repo.SetPreferences(ctx, userID, settings)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetPreferences(ctx, userID)
+}
@@ -0,0 +1,214 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/mail"
+	"carpool/backend/internal/validation"
+)
+
+const (
+	maxBioLength = 500
+	// defaultEmailChangeTTL applies when Service.EmailChangeTTL is zero.
+	defaultEmailChangeTTL = 24 * time.Hour
+)
+
+// parseProfilePatch reads a JSON merge patch (RFC 7396) of the profile:
+// members that are absent are left alone and null clears a field. A new
+// email is returned separately, since it only takes effect once
+// confirmed.
+func parseProfilePatch(data []byte) (ProfileUpdate, *string, error) {
+	var update ProfileUpdate
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil || members == nil {
+		return update, nil, apperr.BadRequest("Body must be a JSON object")
+	}
+	v := &validation.Validator{}
+	// str decodes a string member; ok is false for null.
+	str := func(field string) (s string, ok bool) {
+		raw := members[field]
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			return "", false
+		}
+		v.Check(json.Unmarshal(raw, &s) == nil, field, "must be a string or null")
+		return strings.TrimSpace(s), true
+	}
+	var email *string
+	for field := range members {
+		switch field {
+		case "name":
+			name, ok := str(field)
+			v.Check(ok, field, "cannot be cleared")
+			v.Required(field, name)
+			v.MaxLength(field, name, 255)
+			update.Name = &name
+		case "email":
+			addr, ok := str(field)
+			v.Check(ok, field, "cannot be cleared")
+			v.Required(field, addr)
+			v.Email(field, addr)
+			v.MaxLength(field, addr, 255)
+			email = &addr
+		case "bio":
+			update.SetBio = true
+			if bio, ok := str(field); ok && bio != "" {
+				v.MaxLength(field, bio, maxBioLength)
+				update.Bio = &bio
+			}
+		case "phone":
+			update.SetPhone = true
+			if phone, ok := str(field); ok && phone != "" {
+				normalized, valid := normalizePhone(phone)
+				v.Check(valid, field, "must be an international number such as +41 79 123 45 67")
+				update.Phone = &normalized
+			}
+		default:
+			v.Check(false, field, "cannot be changed")
+		}
+	}
+	if errs := v.Errors(); errs != nil {
+		return update, nil, errs
+	}
+	return update, email, nil
+}
+
+// normalizePhone returns phone in E.164 form, such as "+41791234567". It
+// accepts a leading "+" or "00" and ignores spaces, dots, dashes and
+// parentheses; national numbers without a country code are rejected.
+func normalizePhone(phone string) (string, bool) {
+	digits, ok := strings.CutPrefix(phone, "+")
+	if !ok {
+		if digits, ok = strings.CutPrefix(phone, "00"); !ok {
+			return "", false
+		}
+	}
+	var b strings.Builder
+	b.WriteByte('+')
+	for _, c := range digits {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case strings.ContainsRune(" .-()", c):
+		default:
+			return "", false
+		}
+	}
+	e164 := b.String()
+	// Country codes never start with 0, and E.164 numbers have at most 15
+	// digits; the shortest in use have 7.
+	if len(e164) < 8 || len(e164) > 16 || e164[1] == '0' {
+		return "", false
+	}
+	return e164, true
+}
+
+// UpdateProfile applies update and, if newEmail differs from the user's
+// address, mails a confirmation link to it; the address changes once the
+// link is followed. Sending the current address again cancels a pending
+// change.
+func (s *Service) UpdateProfile(ctx context.Context, userID int, update ProfileUpdate, newEmail *string) (*User, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	changeEmail := newEmail != nil && *newEmail != user.Email
+	if changeEmail {
+		_, err := s.Repo.GetUserByEmail(ctx, *newEmail)
+		if err == nil {
+			return nil, apperr.Conflict("Email is already registered")
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	if err := s.Repo.UpdateProfile(ctx, userID, update); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("User not found")
+		}
+		return nil, err
+	}
+	switch {
+	case changeEmail:
+		err = s.requestEmailChange(ctx, user, *newEmail)
+	case newEmail != nil:
+		err = s.Repo.CancelEmailChange(ctx, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+func (s *Service) requestEmailChange(ctx context.Context, user *User, email string) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	ttl := s.EmailChangeTTL
+	if ttl <= 0 {
+		ttl = defaultEmailChangeTTL
+	}
+	if err := s.Repo.RequestEmailChange(ctx, user.ID, email, hashEmailToken(token), ttl); err != nil {
+		return err
+	}
+	link, err := url.Parse(s.EmailConfirmURL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+	return s.Mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nTo use this address for your Carpool account, open this link within %s:\n\n%s\n\n"+
+			"If you didn't ask for this, ignore this email and your address stays unchanged.\n",
+			user.Name, humanDuration(ttl), link),
+	})
+}
+
+// ConfirmEmailChange makes the pending address the token was mailed to the
+// user's email.
+func (s *Service) ConfirmEmailChange(ctx context.Context, token string) error {
+	_, err := s.Repo.ConfirmEmailChange(ctx, hashEmailToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.BadRequest("The confirmation link is invalid or has expired")
+	}
+	return err
+}
+
+// hashEmailToken hashes a confirmation token for storage. Tokens are
+// random 256-bit values, so an unsalted SHA-256 is sufficient.
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// humanDuration renders whole hours and minutes as "24 hours" or
+// "30 minutes".
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d >= time.Minute:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return d.String()
+}
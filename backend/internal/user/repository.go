@@ -1,60 +1,600 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
 	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/deadline"
+	"carpool/backend/internal/preference"
+
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is the Postgres error code for a UNIQUE constraint failure.
+const uniqueViolation = "23505"
+
+// userColumns matches the order scanned by scanUser. Each entry starts
+// with a column name, so prefixColumns can qualify them.
+const userColumns = `user_id, name, email, pending_email, pending_email_expires_at > NOW(), password, phone, bio, rating, profile_pic, profile_pic_thumbnail, role, status, created_at, totp_enabled, email_verified`
+
 type Repository struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
 }
 
-func (repo *Repository) CreateUser(user *User, hashedPassword string) error {
-	query := `INSERT INTO users (name, email, password, created_at) VALUES ($1, $2, $3, $4) RETURNING user_id, created_at`
-	return repo.DB.QueryRow(query, user.Name, user.Email, hashedPassword, time.Now()).Scan(&user.ID, &user.CreatedAt)
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (repo *Repository) GetUserByEmail(email string) (*User, error) {
+func scanUser(row rowScanner) (*User, error) {
 	user := &User{}
-	query := `SELECT user_id, name, email, password, phone, rating, created_at FROM users WHERE email = $1`
-	err := repo.DB.QueryRow(query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.Rating, &user.CreatedAt)
+	var pending *bool
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.PendingEmail, &pending, &user.Password, &user.Phone, &user.Bio, &user.Rating, &user.ProfilePic, &user.ProfilePicThumbnail, &user.Role, &user.Status, &user.CreatedAt, &user.TwoFactorEnabled, &user.EmailVerified)
 	if err != nil {
 		return nil, err
 	}
+	// Expired requests are left in place until replaced.
+	if pending == nil || !*pending {
+		user.PendingEmail = nil
+	}
 	return user, nil
 }
 
-func (repo *Repository) GetUserByID(userID int) (*User, error) {
-	user := &User{}
-	query := `SELECT user_id, name, email, password, phone, rating, created_at FROM users WHERE user_id = $1`
-	err := repo.DB.QueryRow(query, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.Rating, &user.CreatedAt)
+func (repo *Repository) CreateUser(ctx context.Context, user *User, hashedPassword string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.CreateUser")
+	defer cancel()
+
+	query := `INSERT INTO users (name, email, password, created_at) VALUES ($1, $2, $3, $4) RETURNING user_id, role, status, created_at, totp_enabled, email_verified`
+	err := repo.DB.QueryRowContext(ctx, query, user.Name, user.Email, hashedPassword, time.Now()).Scan(&user.ID, &user.Role, &user.Status, &user.CreatedAt, &user.TwoFactorEnabled, &user.EmailVerified)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return apperr.Conflict("Email is already registered")
+	}
+	return err
+}
+
+func (repo *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetUserByEmail")
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	return scanUser(repo.DB.QueryRowContext(ctx, query, email))
+}
+
+func (repo *Repository) GetUserByID(ctx context.Context, userID int) (*User, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetUserByID")
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE user_id = $1`
+	return scanUser(repo.DB.QueryRowContext(ctx, query, userID))
+}
+
+// SearchUsers matches q against name and email (case-insensitively) and
+// optionally filters by status. An empty q or status matches everything.
+func (repo *Repository) SearchUsers(ctx context.Context, q, status string, limit, offset int) ([]*User, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.SearchUsers")
+	defer cancel()
+
+	query := `
+        SELECT ` + userColumns + `
+        FROM users
+        WHERE ($1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%')
+          AND ($2 = '' OR status = $2)
+        ORDER BY user_id
+        LIMIT $3 OFFSET $4
+    `
+	rows, err := repo.DB.QueryContext(ctx, query, q, status, limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateProfile returns sql.ErrNoRows if the user does not exist.
+func (repo *Repository) UpdateProfile(ctx context.Context, userID int, update ProfileUpdate) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.UpdateProfile")
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET name = COALESCE($2, name),
+            bio = CASE WHEN $3 THEN $4 ELSE bio END,
+            phone = CASE WHEN $5 THEN $6 ELSE phone END
+        WHERE user_id = $1
+    `
+	res, err := repo.DB.ExecContext(ctx, query, userID, update.Name, update.SetBio, update.Bio, update.SetPhone, update.Phone)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// UpdateUser updates only the phone field.
-func (repo *Repository) UpdateUser(user *User) error {
+// RequestEmailChange returns sql.ErrNoRows if the user does not exist.
+func (repo *Repository) RequestEmailChange(ctx context.Context, userID int, email, tokenHash string, ttl time.Duration) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.RequestEmailChange")
+	defer cancel()
+
 	query := `
         UPDATE users
-        SET phone = COALESCE($1, phone)
-        WHERE user_id = $2
-        RETURNING user_id, name, email, phone, rating, created_at
+        SET pending_email = $2,
+            pending_email_token_hash = $3,
+            pending_email_expires_at = NOW() + $4 * INTERVAL '1 millisecond'
+        WHERE user_id = $1
     `
-	return repo.DB.QueryRow(query, nullIfEmpty(user.Phone), user.ID).
-		Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Rating, &user.CreatedAt)
+	res, err := repo.DB.ExecContext(ctx, query, userID, email, tokenHash, ttl.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (repo *Repository) CancelEmailChange(ctx context.Context, userID int) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.CancelEmailChange")
+	defer cancel()
+
+	query := `UPDATE users SET pending_email = NULL, pending_email_token_hash = NULL, pending_email_expires_at = NULL WHERE user_id = $1`
+	_, err := repo.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (repo *Repository) ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.ConfirmEmailChange")
+	defer cancel()
+
+	query := `
+        UPDATE users
+        SET email = pending_email,
+            email_verified = TRUE,
+            pending_email = NULL,
+            pending_email_token_hash = NULL,
+            pending_email_expires_at = NULL
+        WHERE pending_email_token_hash = $1 AND pending_email_expires_at > NOW()
+        RETURNING user_id
+    `
+	var userID int
+	err := repo.DB.QueryRowContext(ctx, query, tokenHash).Scan(&userID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return 0, apperr.Conflict("Email is already registered")
+	}
+	return userID, err
 }
 
-func (repo *Repository) UpdateUserPassword(userID int, hashedPwd string) error {
+// SetProfilePicture replaces the picture URLs; nil clears them. It returns
+// sql.ErrNoRows if the user does not exist.
+func (repo *Repository) SetProfilePicture(ctx context.Context, userID int, url, thumbnailURL *string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.SetProfilePicture")
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `UPDATE users SET profile_pic = $2, profile_pic_thumbnail = $3 WHERE user_id = $1`, userID, url, thumbnailURL)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPreferences returns the user's settings, with defaults for those not
+// yet saved, or sql.ErrNoRows if the user does not exist.
+func (repo *Repository) GetPreferences(ctx context.Context, userID int) (*preference.Settings, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetPreferences")
+	defer cancel()
+
+	settings := preference.DefaultSettings()
+	var driver, rider []byte
+	err := repo.DB.QueryRowContext(ctx, `SELECT gender, driver_rules, rider_filter FROM users WHERE user_id = $1`, userID).
+		Scan(&settings.Gender, &driver, &rider)
+	if err != nil {
+		return nil, err
+	}
+	if driver != nil {
+		if err := json.Unmarshal(driver, &settings.Driver); err != nil {
+			return nil, err
+		}
+	}
+	if rider != nil {
+		if err := json.Unmarshal(rider, &settings.Rider); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+// SetPreferences replaces the user's settings. It returns sql.ErrNoRows
+// if the user does not exist.
+func (repo *Repository) SetPreferences(ctx context.Context, userID int, settings *preference.Settings) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.SetPreferences")
+	defer cancel()
+
+	driver, err := json.Marshal(settings.Driver)
+	if err != nil {
+		return err
+	}
+	rider, err := json.Marshal(settings.Rider)
+	if err != nil {
+		return err
+	}
+	res, err := repo.DB.ExecContext(ctx, `UPDATE users SET gender = $2, driver_rules = $3, rider_filter = $4 WHERE user_id = $1`,
+		userID, settings.Gender, driver, rider)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (repo *Repository) UpdateUserPassword(ctx context.Context, userID int, hashedPwd string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.UpdateUserPassword")
+	defer cancel()
+
 	query := `UPDATE users SET password = $1 WHERE user_id = $2`
-	_, err := repo.DB.Exec(query, hashedPwd, userID)
+	_, err := repo.DB.ExecContext(ctx, query, hashedPwd, userID)
+	return err
+}
+
+// UpdateUserStatus returns sql.ErrNoRows if the user does not exist or
+// is deleted.
+func (repo *Repository) UpdateUserStatus(ctx context.Context, userID int, status string, reason *string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.UpdateUserStatus")
+	defer cancel()
+
+	query := `UPDATE users SET status = $1, status_reason = $2 WHERE user_id = $3 AND status <> $4`
+	res, err := repo.DB.ExecContext(ctx, query, status, reason, userID, StatusDeleted)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (repo *Repository) GetTOTPState(ctx context.Context, userID int) (*TOTPState, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetTOTPState")
+	defer cancel()
+
+	state := &TOTPState{}
+	query := `SELECT totp_secret, totp_enabled, totp_last_step, totp_locked_until FROM users WHERE user_id = $1`
+	err := repo.DB.QueryRowContext(ctx, query, userID).Scan(&state.Secret, &state.Enabled, &state.LastStep, &state.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SetPendingTOTPSecret stores a secret that is not yet enabled.
+func (repo *Repository) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.SetPendingTOTPSecret")
+	defer cancel()
+
+	query := `UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = NULL WHERE user_id = $2`
+	_, err := repo.DB.ExecContext(ctx, query, secret, userID)
 	return err
 }
 
-func nullIfEmpty(s *string) *string {
-	if s == nil || *s == "" {
-		return nil
+// EnableTOTP turns on the pending secret and replaces the user's recovery
+// codes with codeHashes.
+func (repo *Repository) EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.EnableTOTP")
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE user_id = $2`, step, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AdvanceTOTPStep records step as used. It reports false if step (or a
+// later one) was already used, which rejects replayed codes.
+func (repo *Repository) AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.AdvanceTOTPStep")
+	defer cancel()
+
+	query := `
+        UPDATE users SET totp_last_step = $1
+        WHERE user_id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)
+    `
+	res, err := repo.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode consumes an unused recovery code, reporting whether one
+// matched.
+func (repo *Repository) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.UseRecoveryCode")
+	defer cancel()
+
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	res, err := repo.DB.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateLoginChallenge records a two-factor login challenge that expires
+// after ttl, clearing out expired ones first.
+func (repo *Repository) CreateLoginChallenge(ctx context.Context, challengeID string, userID int, ttl time.Duration) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.CreateLoginChallenge")
+	defer cancel()
+
+	if _, err := repo.DB.ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	query := `INSERT INTO login_challenges (challenge_id, user_id, expires_at) VALUES ($1, $2, $3)`
+	_, err := repo.DB.ExecContext(ctx, query, challengeID, userID, time.Now().Add(ttl))
+	return err
+}
+
+// AttemptLoginChallenge counts an attempt at the user's unexpired
+// challenge and returns how many there have been, or sql.ErrNoRows.
+func (repo *Repository) AttemptLoginChallenge(ctx context.Context, challengeID string, userID int) (int, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.AttemptLoginChallenge")
+	defer cancel()
+
+	query := `
+        UPDATE login_challenges SET attempts = attempts + 1
+        WHERE challenge_id = $1 AND user_id = $2 AND expires_at > NOW()
+        RETURNING attempts
+    `
+	var attempts int
+	err := repo.DB.QueryRowContext(ctx, query, challengeID, userID).Scan(&attempts)
+	return attempts, err
+}
+
+// ConsumeLoginChallenge deletes a challenge, reporting whether it existed.
+func (repo *Repository) ConsumeLoginChallenge(ctx context.Context, challengeID string) (bool, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.ConsumeLoginChallenge")
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `DELETE FROM login_challenges WHERE challenge_id = $1`, challengeID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RecordTwoFactorFailure counts a wrong code, locking two-factor sign-in
+// until lockedUntil on the limit-th in a row.
+func (repo *Repository) RecordTwoFactorFailure(ctx context.Context, userID, limit int, lockedUntil time.Time) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.RecordTwoFactorFailure")
+	defer cancel()
+
+	query := `
+        UPDATE users SET
+            totp_failed_attempts = CASE WHEN totp_failed_attempts + 1 >= $2 THEN 0 ELSE totp_failed_attempts + 1 END,
+            totp_locked_until = CASE WHEN totp_failed_attempts + 1 >= $2 THEN $3 ELSE totp_locked_until END
+        WHERE user_id = $1
+    `
+	_, err := repo.DB.ExecContext(ctx, query, userID, limit, lockedUntil)
+	return err
+}
+
+func (repo *Repository) ResetTwoFactorFailures(ctx context.Context, userID int) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.ResetTwoFactorFailures")
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `UPDATE users SET totp_failed_attempts = 0 WHERE user_id = $1`, userID)
+	return err
+}
+
+func (repo *Repository) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.DisableTOTP")
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE users
+        SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL,
+            totp_failed_attempts = 0, totp_locked_until = NULL
+        WHERE user_id = $1
+    `
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUserByIdentity finds the user linked to an external identity.
+func (repo *Repository) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetUserByIdentity")
+	defer cancel()
+
+	query := `
+        SELECT ` + prefixColumns("u.", userColumns) + `
+        FROM users u
+        JOIN user_identities i ON i.user_id = u.user_id
+        WHERE i.issuer = $1 AND i.subject = $2
+    `
+	return scanUser(repo.DB.QueryRowContext(ctx, query, issuer, subject))
+}
+
+// LinkIdentity records that the external identity belongs to userID.
+func (repo *Repository) LinkIdentity(ctx context.Context, userID int, issuer, subject string) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.LinkIdentity")
+	defer cancel()
+
+	query := `
+        WITH linked AS (
+            INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)
+            ON CONFLICT (issuer, subject) DO NOTHING
+            RETURNING user_id
+        )
+        UPDATE users SET email_verified = TRUE WHERE user_id IN (SELECT user_id FROM linked)
+    `
+	_, err := repo.DB.ExecContext(ctx, query, userID, issuer, subject)
+	return err
+}
+
+func (repo *Repository) ListIdentities(ctx context.Context, userID int) ([]*Identity, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.ListIdentities")
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, `SELECT issuer, subject, created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*Identity{}
+	for rows.Next() {
+		id := &Identity{}
+		if err := rows.Scan(&id.Issuer, &id.Subject, &id.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+	return identities, rows.Err()
+}
+
+// GetUserStats counts rides and reviews for the user's public profile.
+func (repo *Repository) GetUserStats(ctx context.Context, userID int) (*Stats, error) {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.GetUserStats")
+	defer cancel()
+
+	query := `
+        SELECT
+            (SELECT COUNT(*) FROM rides r
+             WHERE r.user_id = $1 AND r.ride_time <= NOW()
+               AND (r.ride_status IS NULL OR r.ride_status NOT IN ('removed', 'cancelled'))),
+            (SELECT COUNT(DISTINCT b.ride_id) FROM bookings b JOIN rides r ON r.ride_id = b.ride_id
+             WHERE b.user_id = $1 AND b.status <> 'cancelled' AND r.ride_time <= NOW()
+               AND (r.ride_status IS NULL OR r.ride_status NOT IN ('removed', 'cancelled'))),
+            (SELECT COUNT(*) FROM reviews WHERE reviewee_id = $1)
+    `
+	stats := &Stats{}
+	err := repo.DB.QueryRowContext(ctx, query, userID).Scan(&stats.RidesDriven, &stats.RidesTaken, &stats.RatingCount)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// AnonymizeUser clears the user's personal data, removes their second
+// factors, linked identities, idempotency keys, vehicles and driver
+// verification, and cancels their upcoming rides and bookings, all in one
+// transaction. It returns
+// sql.ErrNoRows if the user does not exist or is already deleted.
+func (repo *Repository) AnonymizeUser(ctx context.Context, userID int) error {
+	ctx, cancel := repo.Timeouts.Apply(ctx, "user.AnonymizeUser")
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The placeholder password is not a bcrypt hash, so no password matches.
+	res, err := tx.ExecContext(ctx, `
+        UPDATE users
+        SET name = 'Deleted user',
+            email = 'deleted-' || user_id || '@deleted.invalid',
+            password = '!',
+            pending_email = NULL,
+            pending_email_token_hash = NULL,
+            pending_email_expires_at = NULL,
+            phone = NULL,
+            bio = NULL,
+            profile_pic = NULL,
+            profile_pic_thumbnail = NULL,
+            totp_secret = NULL,
+            totp_enabled = FALSE,
+            totp_last_step = NULL,
+            email_verified = FALSE,
+            gender = NULL,
+            driver_rules = NULL,
+            rider_filter = NULL,
+            status = $2,
+            status_reason = NULL
+        WHERE user_id = $1 AND status <> $2
+    `, userID, StatusDeleted)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, stmt := range []string{
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM login_challenges WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		// Stored responses can hold the user's personal data.
+		`DELETE FROM idempotency_keys WHERE user_id = $1`,
+		// Riders shouldn't wait for a driver who no longer exists.
+		`UPDATE bookings SET status = 'cancelled'
+         WHERE ride_id IN (SELECT ride_id FROM rides WHERE user_id = $1 AND ride_time > NOW())`,
+		`UPDATE rides SET ride_status = 'cancelled' WHERE user_id = $1 AND ride_time > NOW()`,
+		`UPDATE bookings SET status = 'cancelled'
+         WHERE user_id = $1 AND ride_id IN (SELECT ride_id FROM rides WHERE ride_time > NOW())`,
+		// Plates identify the user; past rides keep their car_type.
+		`DELETE FROM vehicles WHERE user_id = $1`,
+		`DELETE FROM verification_documents WHERE user_id = $1`,
+		`DELETE FROM driver_verifications WHERE user_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prefixColumns qualifies each column in a comma-separated list.
+func prefixColumns(prefix, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i, c := range parts {
+		parts[i] = prefix + c
 	}
-	return s
+	return strings.Join(parts, ", ")
 }
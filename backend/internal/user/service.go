@@ -1,65 +1,479 @@
 package user
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"log"
+	"strings"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/blob"
+	"carpool/backend/internal/mail"
+	"carpool/backend/internal/sso"
+	"carpool/backend/internal/totp"
+	"carpool/backend/internal/validation"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// errInvalidCredentials is deliberately vague so login doesn't reveal
+// which emails are registered.
+var errInvalidCredentials = apperr.Unauthorized("Invalid email or password")
+
+var errInvalidCode = apperr.Unauthorized("Invalid authentication code")
+
+var errInvalidChallenge = apperr.Unauthorized("Invalid or expired challenge token")
+
+var errAccountExists = apperr.Conflict("An account with this email already exists; sign in with your password")
+
+const (
+	// totpIssuer labels the account in authenticator apps.
+	totpIssuer        = "Carpool"
+	recoveryCodeCount = 10
+	// maxChallengeAttempts is how many codes may be tried against one
+	// login challenge before the user has to enter their password again.
+	maxChallengeAttempts = 5
+	// maxFailedCodes wrong codes in a row, across all of a user's
+	// challenges, lock two-factor sign-in for twoFactorLockout.
+	maxFailedCodes   = 10
+	twoFactorLockout = 15 * time.Minute
+	// defaultSSOName names accounts created by single sign-on when the
+	// provider supplies neither a name nor a usable email.
+	defaultSSOName = "Carpool user"
+)
+
 type Service struct {
-	Repo *Repository
+	Repo Store
+	// Pictures stores profile pictures.
+	Pictures blob.Store
+	// Mail sends the links that confirm a new email address, which point
+	// to EmailConfirmURL and expire after EmailChangeTTL.
+	Mail            mail.Sender
+	EmailConfirmURL string
+	EmailChangeTTL  time.Duration
 }
 
-func (s *Service) Register(user *User) error {
+func (s *Service) Register(ctx context.Context, user *User) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	return s.Repo.CreateUser(user, string(hashedPassword))
+	return s.Repo.CreateUser(ctx, user, string(hashedPassword))
 }
 
-func (s *Service) Login(email, password string) (*User, error) {
-	user, err := s.Repo.GetUserByEmail(email)
+func (s *Service) Login(ctx context.Context, email, password string) (*User, error) {
+	user, err := s.Repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
-	// Log the retrieved hash for debugging
-	log.Printf("Comparing stored hash %s with password %s", user.Password, password)
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, errInvalidCredentials
+	}
+	if err := checkActive(user); err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *Service) GetUserByID(userID int) (*User, error) {
-	return s.Repo.GetUserByID(userID)
+// ActiveRole returns the current role of userID, rejecting users that no
+// longer exist or have been suspended or banned. It runs on every
+// authenticated request, so a suspension or a change of role takes effect
+// without waiting for the user's token to expire.
+func (s *Service) ActiveRole(ctx context.Context, userID int) (string, error) {
+	user, err := s.Repo.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", apperr.Unauthorized("Account no longer exists")
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkActive(user); err != nil {
+		return "", err
+	}
+	return user.Role, nil
 }
 
-// UpdateUserProfile updates only the phone number.
-func (s *Service) UpdateUserProfile(userID int, phone string) (*User, error) {
-	// Create a User object with only the phone to update.
-	user := &User{
-		ID:    userID,
-		Phone: &phone,
+func checkActive(user *User) error {
+	switch user.Status {
+	case StatusSuspended:
+		return apperr.Forbidden("Account is suspended")
+	case StatusBanned:
+		return apperr.Forbidden("Account is banned")
+	case StatusDeleted:
+		return apperr.Unauthorized("Account no longer exists")
 	}
-	if err := s.Repo.UpdateUser(user); err != nil {
-		return nil, err
+	return nil
+}
+
+func (s *Service) GetUserByID(ctx context.Context, userID int) (*User, error) {
+	user, err := s.Repo.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("User not found")
 	}
-	return s.Repo.GetUserByID(userID)
+	return user, err
+}
+
+// GetStats returns the counts shown on the user's public profile.
+func (s *Service) GetStats(ctx context.Context, userID int) (*Stats, error) {
+	return s.Repo.GetUserStats(ctx, userID)
 }
 
-func (s *Service) ChangeUserPassword(userID int, currentPwd, newPwd string) error {
-	user, err := s.Repo.GetUserByID(userID)
+func (s *Service) ChangeUserPassword(ctx context.Context, userID int, currentPwd, newPwd string) error {
+	user, err := s.GetUserByID(ctx, userID)
 	if err != nil {
 		return err
 	}
 	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPwd)) != nil {
-		return errors.New("invalid current password")
+		return apperr.Validation(validation.Errors{"currentPassword": "is incorrect"})
 	}
 	hashedNew, err := bcrypt.GenerateFromPassword([]byte(newPwd), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	return s.Repo.UpdateUserPassword(userID, string(hashedNew))
+	return s.Repo.UpdateUserPassword(ctx, userID, string(hashedNew))
+}
+
+// SearchUsers lists users for the admin console.
+func (s *Service) SearchUsers(ctx context.Context, q, status string, limit, offset int) ([]*User, error) {
+	users, err := s.Repo.SearchUsers(ctx, q, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		u.Password = ""
+	}
+	return users, nil
+}
+
+// SetStatus suspends, bans or reinstates a user.
+func (s *Service) SetStatus(ctx context.Context, userID int, status string, reason *string) error {
+	if !ValidStatus(status) {
+		return apperr.Validation(validation.Errors{"status": "must be one of active, suspended, banned"})
+	}
+	if status == StatusActive {
+		reason = nil
+	}
+	err := s.Repo.UpdateUserStatus(ctx, userID, status, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("User not found")
+	}
+	return err
+}
+
+// BeginTOTPEnrollment generates a new secret for userID. It only takes
+// effect once confirmed with a code from the authenticator.
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, userID int) (*TOTPEnrollment, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, apperr.Conflict("Two-factor authentication is already enabled")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repo.SetPendingTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(totpIssuer, user.Email, secret)}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication if code matches
+// the pending secret, and returns freshly generated recovery codes. The
+// codes are only stored hashed, so this is the only time they are shown.
+func (s *Service) ConfirmTOTPEnrollment(ctx context.Context, userID int, code string) ([]string, error) {
+	state, err := s.Repo.GetTOTPState(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, apperr.Conflict("Two-factor authentication is already enabled")
+	}
+	if state.Secret == nil {
+		return nil, apperr.Conflict("Two-factor enrollment has not been started")
+	}
+	step, ok := totp.Validate(*state.Secret, code, time.Now())
+	if !ok {
+		return nil, apperr.Validation(validation.Errors{"code": "is incorrect"})
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = generateRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err := s.Repo.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off two-factor authentication after re-checking both
+// the password and a current code (or recovery code).
+func (s *Service) DisableTOTP(ctx context.Context, userID int, password, code string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return apperr.Conflict("Two-factor authentication is not enabled")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return apperr.Validation(validation.Errors{"password": "is incorrect"})
+	}
+	if err := s.verifySecondFactor(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.Repo.DisableTOTP(ctx, userID)
+}
+
+// StartTwoFactorLogin records a challenge for the second step of login
+// that expires after ttl and returns its ID.
+func (s *Service) StartTwoFactorLogin(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	challengeID, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.Repo.CreateLoginChallenge(ctx, challengeID, userID, ttl); err != nil {
+		return "", err
+	}
+	return challengeID, nil
+}
+
+// CompleteTwoFactorLogin is the second step of login for users with
+// two-factor authentication. code may be a TOTP code or a recovery code.
+// Each challenge completes one login and allows maxChallengeAttempts
+// codes, after which it is discarded; maxFailedCodes wrong codes in a row
+// lock the user out for twoFactorLockout however many challenges they
+// are spread over.
+func (s *Service) CompleteTwoFactorLogin(ctx context.Context, userID int, challengeID, code string) (*User, error) {
+	attempts, err := s.Repo.AttemptLoginChallenge(ctx, challengeID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attempts > maxChallengeAttempts {
+		if _, err := s.Repo.ConsumeLoginChallenge(ctx, challengeID); err != nil {
+			return nil, err
+		}
+		return nil, apperr.TooManyRequests("Too many invalid codes; sign in again")
+	}
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkActive(user); err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled {
+		return nil, errInvalidCode
+	}
+	state, err := s.Repo.GetTOTPState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.LockedUntil != nil && state.LockedUntil.After(time.Now()) {
+		return nil, apperr.TooManyRequests("Too many invalid codes; try again later")
+	}
+	if err := s.verifySecondFactor(ctx, userID, code); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			if err := s.Repo.RecordTwoFactorFailure(ctx, userID, maxFailedCodes, time.Now().Add(twoFactorLockout)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	if err := s.Repo.ResetTwoFactorFailures(ctx, userID); err != nil {
+		return nil, err
+	}
+	// A concurrent request may have completed the login first.
+	consumed, err := s.Repo.ConsumeLoginChallenge(ctx, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, errInvalidChallenge
+	}
+	return user, nil
+}
+
+func (s *Service) verifySecondFactor(ctx context.Context, userID int, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == 6 {
+		state, err := s.Repo.GetTOTPState(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !state.Enabled || state.Secret == nil {
+			return errInvalidCode
+		}
+		step, ok := totp.Validate(*state.Secret, code, time.Now())
+		if !ok {
+			return errInvalidCode
+		}
+		fresh, err := s.Repo.AdvanceTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidCode
+		}
+		return nil
+	}
+	used, err := s.Repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errInvalidCode
+	}
+	return nil
+}
+
+// randomToken returns 192 random bits, URL-safe encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generateRecoveryCode returns a code like "k3m9x-pq2zt" (50 random bits).
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode normalises case and separators before hashing. Recovery
+// codes are high-entropy, so an unsalted SHA-256 is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoginWithIdentity signs in a user authenticated by an external OpenID
+// Connect provider. The identity is matched by issuer and subject first;
+// otherwise it is linked to the account with the same email if that
+// account has verified it, or a new account is created.
+func (s *Service) LoginWithIdentity(ctx context.Context, id *sso.Identity) (*User, error) {
+	user, err := s.Repo.GetUserByIdentity(ctx, id.Issuer, id.Subject)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if user == nil {
+		if !id.EmailVerified || id.Email == "" {
+			return nil, apperr.Forbidden("The identity provider did not supply a verified email address")
+		}
+		if user, err = s.findOrCreateForIdentity(ctx, id); err != nil {
+			return nil, err
+		}
+		if err := s.Repo.LinkIdentity(ctx, user.ID, id.Issuer, id.Subject); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkActive(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) findOrCreateForIdentity(ctx context.Context, id *sso.Identity) (*User, error) {
+	user, err := s.Repo.GetUserByEmail(ctx, id.Email)
+	if err == nil {
+		// Anyone can register an address they don't own. Linking to such
+		// an account would let whoever set its password share it with the
+		// provider's user.
+		if !user.EmailVerified {
+			return nil, errAccountExists
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// The claims are the provider's word, so the email may not even have
+	// a local part to fall back on.
+	name := id.Name
+	if name == "" {
+		if local, _, found := strings.Cut(id.Email, "@"); found && local != "" {
+			name = local
+		} else {
+			name = defaultSSOName
+		}
+	}
+	// The account has no usable password until the user sets one.
+	unusable := make([]byte, 32)
+	if _, err := rand.Read(unusable); err != nil {
+		return nil, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(unusable)), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user = &User{Name: name, Email: id.Email}
+	if err := s.Repo.CreateUser(ctx, user, string(hashed)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListIdentities returns the external sign-ins linked to userID.
+func (s *Service) ListIdentities(ctx context.Context, userID int) ([]*Identity, error) {
+	return s.Repo.ListIdentities(ctx, userID)
+}
+
+// VerifyPassword checks password against userID's stored hash.
+func (s *Service) VerifyPassword(ctx context.Context, userID int, password string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return apperr.Validation(validation.Errors{"password": "is incorrect"})
+	}
+	return nil
+}
+
+// DeleteAccount anonymizes the user in place. The row is kept so rides and
+// bookings other people took part in still reference it, but everything
+// identifying is cleared and the account can no longer sign in. The user's
+// upcoming rides and bookings are cancelled, their vehicles deleted and
+// their profile picture deleted. Vehicle photos are left to the caller.
+func (s *Service) DeleteAccount(ctx context.Context, userID int) error {
+	user, err := s.Repo.GetUserByID(ctx, userID)
+	if err == nil {
+		err = s.Repo.AnonymizeUser(ctx, userID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return err
+	}
+	s.deletePictures(ctx, deref(user.ProfilePic), deref(user.ProfilePicThumbnail))
+	return nil
 }
@@ -0,0 +1,147 @@
+package user
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/sso"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// ssoCookie carries the state, nonce and PKCE verifier between the
+	// redirect to the provider and the callback, signed with the app's keys.
+	// Its path is the root so it reaches the callback under /v1 as well as
+	// through the legacy alias.
+	ssoCookie     = "oidc_flow"
+	ssoCookiePath = "/"
+	ssoPurpose    = "oidc_flow"
+	ssoLifetime   = 10 * time.Minute
+)
+
+// OIDCLoginHandler redirects the browser to the identity provider.
+func (h *Handler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	verifier := sso.GenerateVerifier()
+	flow, err := h.signToken(jwt.MapClaims{
+		"purpose":  ssoPurpose,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(ssoLifetime).Unix(),
+	})
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    flow,
+		Path:     ssoCookiePath,
+		MaxAge:   int(ssoLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		// Lax so the cookie survives the top-level redirect back from the
+		// provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.SSO.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// OIDCCallbackHandler completes the flow and hands the app's token to the
+// frontend in the URL fragment of SSOPostLoginURL (or as JSON when no
+// frontend URL is configured). Users with two-factor authentication get a
+// challenge_token to finish at /login/2fa instead.
+func (h *Handler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Path: ssoCookiePath, MaxAge: -1, HttpOnly: true, Secure: true})
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.finishSSO(w, r, url.Values{"error": {providerErr}})
+		return
+	}
+	cookie, err := r.Cookie(ssoCookie)
+	if err != nil {
+		h.finishSSO(w, r, url.Values{"error": {"missing_flow"}})
+		return
+	}
+	flow, err := h.Keys.Parse(cookie.Value)
+	if err != nil || flow["purpose"] != ssoPurpose {
+		h.finishSSO(w, r, url.Values{"error": {"invalid_flow"}})
+		return
+	}
+	state, _ := flow["state"].(string)
+	nonce, _ := flow["nonce"].(string)
+	verifier, _ := flow["verifier"].(string)
+	if subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		h.finishSSO(w, r, url.Values{"error": {"state_mismatch"}})
+		return
+	}
+
+	identity, err := h.SSO.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Println("OIDC exchange failed:", err)
+		h.finishSSO(w, r, url.Values{"error": {"exchange_failed"}})
+		return
+	}
+	user, err := h.Service.LoginWithIdentity(r.Context(), identity)
+	if errors.Is(err, errAccountExists) {
+		h.finishSSO(w, r, url.Values{"error": {"account_exists"}})
+		return
+	}
+	if err != nil {
+		log.Println("OIDC login failed:", err)
+		h.finishSSO(w, r, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := h.issueChallengeToken(r.Context(), user)
+		if err != nil {
+			apperr.Write(w, r, err)
+			return
+		}
+		h.finishSSO(w, r, url.Values{"two_factor_required": {"true"}, "challenge_token": {challenge}})
+		return
+	}
+	tokenString, err := h.issueAccessToken(user)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	h.finishSSO(w, r, url.Values{"token": {tokenString}})
+}
+
+// finishSSO redirects to the frontend with result in the fragment, which
+// browsers never send to servers, or writes it as JSON.
+func (h *Handler) finishSSO(w http.ResponseWriter, r *http.Request, result url.Values) {
+	if h.SSOPostLoginURL == "" {
+		if result.Has("error") {
+			apperr.Write(w, r, apperr.Unauthorized("Single sign-on failed: "+result.Get("error")))
+			return
+		}
+		body := map[string]string{}
+		for k := range result {
+			body[k] = result.Get(k)
+		}
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	http.Redirect(w, r, h.SSOPostLoginURL+"#"+result.Encode(), http.StatusFound)
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"carpool/backend/internal/preference"
+)
+
+// Store persists users. Repository implements it on Postgres; memstore
+// implements it in memory for tests. Lookups of a missing user return
+// sql.ErrNoRows.
+type Store interface {
+	// CreateUser fills in ID, Role, Status and CreatedAt. A taken email is
+	// an apperr.Conflict.
+	CreateUser(ctx context.Context, user *User, hashedPassword string) error
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, userID int) (*User, error)
+	SearchUsers(ctx context.Context, q, status string, limit, offset int) ([]*User, error)
+	UpdateProfile(ctx context.Context, userID int, update ProfileUpdate) error
+	UpdateUserPassword(ctx context.Context, userID int, hashedPwd string) error
+	UpdateUserStatus(ctx context.Context, userID int, status string, reason *string) error
+	// SetProfilePicture replaces both picture URLs; nil clears them.
+	SetProfilePicture(ctx context.Context, userID int, url, thumbnailURL *string) error
+	// GetPreferences fills in defaults for settings the user hasn't saved.
+	GetPreferences(ctx context.Context, userID int) (*preference.Settings, error)
+	SetPreferences(ctx context.Context, userID int, settings *preference.Settings) error
+
+	// RequestEmailChange makes email the user's pending email until ttl
+	// passes, replacing any earlier request. tokenHash identifies it.
+	RequestEmailChange(ctx context.Context, userID int, email, tokenHash string, ttl time.Duration) error
+	CancelEmailChange(ctx context.Context, userID int) error
+	// ConfirmEmailChange replaces the email of the user whose unexpired
+	// request has tokenHash and returns their ID. An address registered in
+	// the meantime is an apperr.Conflict.
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (int, error)
+
+	GetTOTPState(ctx context.Context, userID int) (*TOTPState, error)
+	SetPendingTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error
+	AdvanceTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+	DisableTOTP(ctx context.Context, userID int) error
+
+	// CreateLoginChallenge records a two-factor login challenge that
+	// expires after ttl.
+	CreateLoginChallenge(ctx context.Context, challengeID string, userID int, ttl time.Duration) error
+	// AttemptLoginChallenge counts an attempt at the user's unexpired
+	// challenge and returns the count so far. A missing or expired
+	// challenge is sql.ErrNoRows.
+	AttemptLoginChallenge(ctx context.Context, challengeID string, userID int) (int, error)
+	// ConsumeLoginChallenge deletes a challenge, reporting whether it
+	// existed, so each one completes at most one login.
+	ConsumeLoginChallenge(ctx context.Context, challengeID string) (bool, error)
+	// RecordTwoFactorFailure counts a wrong second-factor code. The
+	// limit-th in a row resets the count and locks two-factor sign-in
+	// until lockedUntil.
+	RecordTwoFactorFailure(ctx context.Context, userID, limit int, lockedUntil time.Time) error
+	// ResetTwoFactorFailures clears the count after a successful sign-in.
+	ResetTwoFactorFailures(ctx context.Context, userID int) error
+
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// LinkIdentity also marks the user's email verified, since identities
+	// are only linked through a verified address.
+	LinkIdentity(ctx context.Context, userID int, issuer, subject string) error
+	ListIdentities(ctx context.Context, userID int) ([]*Identity, error)
+
+	GetUserStats(ctx context.Context, userID int) (*Stats, error)
+	// AnonymizeUser also cancels the user's upcoming rides and bookings
+	// and deletes their vehicles and driver verification.
+	AnonymizeUser(ctx context.Context, userID int) error
+}
+
+var _ Store = (*Repository)(nil)
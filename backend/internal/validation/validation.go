@@ -0,0 +1,101 @@
+// Package validation collects field-level input errors. apperr.Write
+// renders them in the "errors" member of a problem document.
+package validation
+
+import (
+	"errors"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors maps a JSON field name to a human readable message.
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, msg := range e {
+		fields = append(fields, field+": "+msg)
+	}
+	sort.Strings(fields)
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+// Validator accumulates errors. Only the first error per field is kept.
+type Validator struct {
+	errs Errors
+}
+
+// Check records msg against field when ok is false.
+func (v *Validator) Check(ok bool, field, msg string) {
+	if ok {
+		return
+	}
+	if v.errs == nil {
+		v.errs = Errors{}
+	}
+	if _, exists := v.errs[field]; !exists {
+		v.errs[field] = msg
+	}
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// MinLength checks that value has at least n characters.
+func (v *Validator) MinLength(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) >= n, field, "must be at least "+strconv.Itoa(n)+" characters")
+}
+
+// MaxLength checks that value has at most n characters.
+func (v *Validator) MaxLength(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, "must be at most "+strconv.Itoa(n)+" characters")
+}
+
+// Email checks that value is a bare email address such as "a@b.com".
+func (v *Validator) Email(field, value string) {
+	addr, err := mail.ParseAddress(value)
+	ok := err == nil && addr.Address == value
+	if ok {
+		// mail.ParseAddress accepts "user@localhost"; require a dotted domain.
+		domain := value[strings.LastIndex(value, "@")+1:]
+		ok = strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+	}
+	v.Check(ok, field, "must be a valid email address")
+}
+
+// Latitude checks that value is within [-90, 90].
+func (v *Validator) Latitude(field string, value float64) {
+	v.Check(value >= -90 && value <= 90, field, "must be between -90 and 90")
+}
+
+// Longitude checks that value is within [-180, 180].
+func (v *Validator) Longitude(field string, value float64) {
+	v.Check(value >= -180 && value <= 180, field, "must be between -180 and 180")
+}
+
+// Valid reports whether no errors have been recorded.
+func (v *Validator) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Errors returns the accumulated Errors, or nil if there are none.
+func (v *Validator) Errors() Errors {
+	if v.Valid() {
+		return nil
+	}
+	return v.errs
+}
+
+// AsErrors reports whether err is, or wraps, Errors and returns them.
+func AsErrors(err error) (Errors, bool) {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs, true
+	}
+	return nil, false
+}
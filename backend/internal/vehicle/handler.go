@@ -0,0 +1,153 @@
+package vehicle
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/upload"
+)
+
+const (
+	// defaultMaxPhotoBytes applies when Handler.MaxPhotoBytes is zero.
+	defaultMaxPhotoBytes = 5 << 20
+	// photoField is the multipart form field carrying a photo.
+	photoField = "photo"
+)
+
+type Handler struct {
+	Service *Service
+	// MaxPhotoBytes limits photo uploads.
+	MaxPhotoBytes int64
+}
+
+// ListVehiclesHandler returns the caller's vehicles.
+func (h *Handler) ListVehiclesHandler(w http.ResponseWriter, r *http.Request) {
+	vehicles, err := h.Service.ListVehicles(r.Context(), middleware.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(vehicles)
+}
+
+// CreateVehicleHandler registers a vehicle for the caller.
+func (h *Handler) CreateVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	v.UserID = middleware.GetUserIDFromContext(r.Context())
+	if err := h.Service.RegisterVehicle(r.Context(), v); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+// UpdateVehicleHandler replaces the details of the caller's vehicle {id}.
+// Its photos are kept.
+func (h *Handler) UpdateVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	vehicleID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	v, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	v.VehicleID = vehicleID
+	updated, err := h.Service.UpdateVehicle(r.Context(), middleware.GetUserIDFromContext(r.Context()), v)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *Handler) DeleteVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	vehicleID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteVehicle(r.Context(), middleware.GetUserIDFromContext(r.Context()), vehicleID); err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UploadPhotoHandler adds the photo in the photo field of a
+// multipart/form-data body to the caller's vehicle {id} and returns the
+// vehicle.
+func (h *Handler) UploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	vehicleID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	limit := h.MaxPhotoBytes
+	if limit <= 0 {
+		limit = defaultMaxPhotoBytes
+	}
+	data, err := upload.File(w, r, photoField, limit, "Vehicle photo")
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	v, err := h.Service.AddPhoto(r.Context(), middleware.GetUserIDFromContext(r.Context()), vehicleID, data)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// DeletePhotoHandler deletes photo {index}, counting from 0, of the
+// caller's vehicle {id} and returns the vehicle.
+func (h *Handler) DeletePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	vehicleID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid photo index"))
+		return
+	}
+	v, err := h.Service.RemovePhoto(r.Context(), middleware.GetUserIDFromContext(r.Context()), vehicleID, index)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// decode reads and validates the vehicle details in the request body,
+// writing the error response if they are unusable.
+func decode(w http.ResponseWriter, r *http.Request) (*Vehicle, bool) {
+	var in Vehicle
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid input"))
+		return nil, false
+	}
+	// Only the details are the client's to set.
+	v := &Vehicle{Make: in.Make, Model: in.Model, Color: in.Color, Plate: in.Plate, Seats: in.Seats}
+	v.Normalize()
+	if errs := v.Validate(); errs != nil {
+		apperr.Write(w, r, errs)
+		return nil, false
+	}
+	return v, true
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	vehicleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apperr.Write(w, r, apperr.BadRequest("Invalid vehicle id"))
+		return 0, false
+	}
+	return vehicleID, true
+}
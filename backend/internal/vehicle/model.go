@@ -0,0 +1,56 @@
+// Package vehicle keeps the cars drivers offer rides in.
+package vehicle
+
+import (
+	"strings"
+	"time"
+
+	"carpool/backend/internal/validation"
+)
+
+// MaxSeats is the most seats a vehicle may offer riders.
+const MaxSeats = 8
+
+type Vehicle struct {
+	VehicleID int    `json:"vehicle_id,omitempty"`
+	UserID    int    `json:"user_id"`
+	Make      string `json:"make"`
+	Model     string `json:"model"`
+	Color     string `json:"color"`
+	Plate     string `json:"plate"`
+	// Seats is how many riders the vehicle takes, not counting the driver.
+	Seats int `json:"seats"`
+	// Photos are URLs, oldest first.
+	Photos    []string  `json:"photos"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// Normalize trims the text fields and writes the plate in capitals
+// without surrounding spaces, so the same plate always looks the same.
+func (v *Vehicle) Normalize() {
+	v.Make = strings.TrimSpace(v.Make)
+	v.Model = strings.TrimSpace(v.Model)
+	v.Color = strings.TrimSpace(v.Color)
+	v.Plate = strings.ToUpper(strings.Join(strings.Fields(v.Plate), " "))
+}
+
+// Validate checks the fields a driver supplies.
+func (v *Vehicle) Validate() validation.Errors {
+	val := &validation.Validator{}
+	val.Required("make", v.Make)
+	val.MaxLength("make", v.Make, 50)
+	val.Required("model", v.Model)
+	val.MaxLength("model", v.Model, 50)
+	val.Required("color", v.Color)
+	val.MaxLength("color", v.Color, 30)
+	val.Required("plate", v.Plate)
+	val.MaxLength("plate", v.Plate, 20)
+	val.Check(v.Seats >= 1 && v.Seats <= MaxSeats, "seats", "must be between 1 and 8")
+	return val.Errors()
+}
+
+// Description is how rides describe the vehicle, such as "Blue Toyota
+// Corolla".
+func (v *Vehicle) Description() string {
+	return v.Color + " " + v.Make + " " + v.Model
+}
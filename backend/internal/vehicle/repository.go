@@ -0,0 +1,158 @@
+package vehicle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/deadline"
+
+	"github.com/lib/pq"
+)
+
+type Repository struct {
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
+}
+
+// columns are selected in the order the Scan calls expect them.
+const columns = "vehicle_id, user_id, make, model, color, plate, seats, photos, created_at"
+
+func (r *Repository) CreateVehicle(ctx context.Context, v *Vehicle) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.CreateVehicle")
+	defer cancel()
+
+	query := `
+        INSERT INTO vehicles (user_id, make, model, color, plate, seats, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NOW())
+        RETURNING vehicle_id, photos, created_at
+    `
+	return r.DB.QueryRowContext(ctx, query, v.UserID, v.Make, v.Model, v.Color, v.Plate, v.Seats).
+		Scan(&v.VehicleID, pq.Array(&v.Photos), &v.CreatedAt)
+}
+
+func (r *Repository) GetVehicle(ctx context.Context, vehicleID int) (*Vehicle, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.GetVehicle")
+	defer cancel()
+
+	var v Vehicle
+	err := r.DB.QueryRowContext(ctx, `SELECT `+columns+` FROM vehicles WHERE vehicle_id = $1`, vehicleID).
+		Scan(&v.VehicleID, &v.UserID, &v.Make, &v.Model, &v.Color, &v.Plate, &v.Seats, pq.Array(&v.Photos), &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *Repository) ListVehicles(ctx context.Context, userID int) ([]*Vehicle, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.ListVehicles")
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+columns+` FROM vehicles WHERE user_id = $1 ORDER BY vehicle_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vehicles := []*Vehicle{}
+	for rows.Next() {
+		var v Vehicle
+		err := rows.Scan(&v.VehicleID, &v.UserID, &v.Make, &v.Model, &v.Color, &v.Plate, &v.Seats, pq.Array(&v.Photos), &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, &v)
+	}
+	return vehicles, rows.Err()
+}
+
+// UpdateVehicle returns sql.ErrNoRows if the vehicle doesn't exist.
+func (r *Repository) UpdateVehicle(ctx context.Context, v *Vehicle) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.UpdateVehicle")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `
+        UPDATE vehicles SET make = $2, model = $3, color = $4, plate = $5, seats = $6
+        WHERE vehicle_id = $1
+    `, v.VehicleID, v.Make, v.Model, v.Color, v.Plate, v.Seats)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddPhoto returns sql.ErrNoRows if the vehicle doesn't exist.
+func (r *Repository) AddPhoto(ctx context.Context, vehicleID int, url string, limit int) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.AddPhoto")
+	defer cancel()
+
+	// Checking the count in the same statement keeps concurrent uploads
+	// from going over the limit.
+	res, err := r.DB.ExecContext(ctx, `
+        UPDATE vehicles SET photos = array_append(photos, $2)
+        WHERE vehicle_id = $1 AND cardinality(photos) < $3
+    `, vehicleID, url, limit)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var exists bool
+	if err := r.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM vehicles WHERE vehicle_id = $1)`, vehicleID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return apperr.Conflict(fmt.Sprintf("A vehicle can have at most %d photos", limit))
+}
+
+// RemovePhoto returns sql.ErrNoRows if the vehicle doesn't exist.
+func (r *Repository) RemovePhoto(ctx context.Context, vehicleID int, url string) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.RemovePhoto")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE vehicles SET photos = array_remove(photos, $2) WHERE vehicle_id = $1`, vehicleID, url)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteVehicle returns sql.ErrNoRows if the vehicle doesn't exist.
+func (r *Repository) DeleteVehicle(ctx context.Context, vehicleID int) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.DeleteVehicle")
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM vehicles WHERE vehicle_id = $1`, vehicleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Repository) HasUpcomingRides(ctx context.Context, vehicleID int) (bool, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "vehicle.HasUpcomingRides")
+	defer cancel()
+
+	var exists bool
+	err := r.DB.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM rides
+            WHERE vehicle_id = $1 AND ride_time > NOW()
+              AND (ride_status IS NULL OR ride_status NOT IN ('removed', 'cancelled'))
+        )
+    `, vehicleID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,174 @@
+package vehicle
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/blob"
+	"carpool/backend/internal/imaging"
+)
+
+const (
+	// MaxPhotos is how many photos a vehicle may have.
+	MaxPhotos = 5
+	// photoSize bounds the longer side of stored photos, in pixels.
+	photoSize = 1280
+	// maxPhotoPixels bounds the decoded size of an upload, about what a
+	// current phone camera produces.
+	maxPhotoPixels = 50_000_000
+)
+
+type Service struct {
+	Repo Store
+	// Photos stores vehicle photos.
+	Photos blob.Store
+}
+
+// RegisterVehicle adds a vehicle for v.UserID.
+func (s *Service) RegisterVehicle(ctx context.Context, v *Vehicle) error {
+	return s.Repo.CreateVehicle(ctx, v)
+}
+
+// ListVehicles returns the user's vehicles, oldest first.
+func (s *Service) ListVehicles(ctx context.Context, userID int) ([]*Vehicle, error) {
+	return s.Repo.ListVehicles(ctx, userID)
+}
+
+// owned returns the vehicle if userID registered it. Other people's
+// vehicles are reported as not found.
+func (s *Service) owned(ctx context.Context, userID, vehicleID int) (*Vehicle, error) {
+	v, err := s.Repo.GetVehicle(ctx, vehicleID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && v.UserID != userID) {
+		return nil, apperr.NotFound("Vehicle not found")
+	}
+	return v, err
+}
+
+// UpdateVehicle replaces the details of one of the user's vehicles and
+// returns it.
+func (s *Service) UpdateVehicle(ctx context.Context, userID int, v *Vehicle) (*Vehicle, error) {
+	if _, err := s.owned(ctx, userID, v.VehicleID); err != nil {
+		return nil, err
+	}
+	if err := s.Repo.UpdateVehicle(ctx, v); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("Vehicle not found")
+		}
+		return nil, err
+	}
+	return s.Repo.GetVehicle(ctx, v.VehicleID)
+}
+
+// DeleteVehicle deletes one of the user's vehicles and its photos.
+// Vehicles that upcoming rides are posted with can't be deleted.
+func (s *Service) DeleteVehicle(ctx context.Context, userID, vehicleID int) error {
+	v, err := s.owned(ctx, userID, vehicleID)
+	if err != nil {
+		return err
+	}
+	busy, err := s.Repo.HasUpcomingRides(ctx, vehicleID)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return apperr.Conflict("This vehicle is used by upcoming rides")
+	}
+	if err := s.Repo.DeleteVehicle(ctx, vehicleID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.NotFound("Vehicle not found")
+		}
+		return err
+	}
+	s.deletePhotos(ctx, v.Photos...)
+	return nil
+}
+
+// AddPhoto stores data, a JPEG, PNG or GIF image, as a photo of one of the
+// user's vehicles and returns the vehicle. The image is turned upright,
+// scaled down and re-encoded without its metadata.
+func (s *Service) AddPhoto(ctx context.Context, userID, vehicleID int, data []byte) (*Vehicle, error) {
+	v, err := s.owned(ctx, userID, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Photos) >= MaxPhotos {
+		return nil, apperr.Conflict(fmt.Sprintf("A vehicle can have at most %d photos", MaxPhotos))
+	}
+	img, err := imaging.Decode(data, maxPhotoPixels)
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		return nil, apperr.UnsupportedMediaType("Vehicle photo must be a JPEG, PNG or GIF image")
+	case errors.Is(err, imaging.ErrTooManyPixels):
+		return nil, apperr.TooLarge("Vehicle photo must be at most 50 megapixels")
+	case err != nil:
+		return nil, err
+	}
+	encoded, err := imaging.EncodeJPEG(imaging.Fit(img, photoSize))
+	if err != nil {
+		return nil, err
+	}
+	name := make([]byte, 12)
+	if _, err := rand.Read(name); err != nil {
+		return nil, err
+	}
+	url, err := s.Photos.Put(ctx, fmt.Sprintf("vehicle-photos/%d/%s.jpg", vehicleID, hex.EncodeToString(name)), "image/jpeg", encoded)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repo.AddPhoto(ctx, vehicleID, url, MaxPhotos); err != nil {
+		s.deletePhotos(ctx, url)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("Vehicle not found")
+		}
+		return nil, err
+	}
+	return s.Repo.GetVehicle(ctx, vehicleID)
+}
+
+// RemovePhoto deletes the photo at index in the vehicle's photos and
+// returns the vehicle.
+func (s *Service) RemovePhoto(ctx context.Context, userID, vehicleID, index int) (*Vehicle, error) {
+	v, err := s.owned(ctx, userID, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(v.Photos) {
+		return nil, apperr.NotFound("Photo not found")
+	}
+	url := v.Photos[index]
+	if err := s.Repo.RemovePhoto(ctx, vehicleID, url); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NotFound("Vehicle not found")
+		}
+		return nil, err
+	}
+	s.deletePhotos(ctx, url)
+	return s.Repo.GetVehicle(ctx, vehicleID)
+}
+
+// DeletePhotoFiles deletes the stored photos of vehicles whose rows are
+// already gone, such as those of a deleted account.
+func (s *Service) DeletePhotoFiles(ctx context.Context, vehicles []*Vehicle) {
+	for _, v := range vehicles {
+		s.deletePhotos(ctx, v.Photos...)
+	}
+}
+
+// deletePhotos removes stored photos, logging failures: a leftover file
+// is harmless, so they don't fail the request.
+func (s *Service) deletePhotos(ctx context.Context, urls ...string) {
+	if s.Photos == nil {
+		return
+	}
+	for _, url := range urls {
+		if err := s.Photos.Delete(context.WithoutCancel(ctx), url); err != nil {
+			log.Printf("Deleting vehicle photo %s: %v", url, err)
+		}
+	}
+}
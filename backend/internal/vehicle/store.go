@@ -0,0 +1,28 @@
+package vehicle
+
+import "context"
+
+// Store persists vehicles. Repository implements it on Postgres; memstore
+// implements it in memory for tests. Lookups of a missing vehicle return
+// sql.ErrNoRows.
+type Store interface {
+	// CreateVehicle fills in VehicleID, CreatedAt and an empty Photos.
+	CreateVehicle(ctx context.Context, v *Vehicle) error
+	GetVehicle(ctx context.Context, vehicleID int) (*Vehicle, error)
+	// ListVehicles returns the user's vehicles, oldest first.
+	ListVehicles(ctx context.Context, userID int) ([]*Vehicle, error)
+	// UpdateVehicle replaces the make, model, color, plate and seats.
+	UpdateVehicle(ctx context.Context, v *Vehicle) error
+	// AddPhoto appends url to the vehicle's photos unless it already has
+	// limit of them, which is an apperr.Conflict.
+	AddPhoto(ctx context.Context, vehicleID int, url string, limit int) error
+	RemovePhoto(ctx context.Context, vehicleID int, url string) error
+	// DeleteVehicle deletes the vehicle. Rides posted with it keep their
+	// car_type but no longer refer to it.
+	DeleteVehicle(ctx context.Context, vehicleID int) error
+	// HasUpcomingRides reports whether open rides that haven't departed
+	// yet are posted with the vehicle.
+	HasUpcomingRides(ctx context.Context, vehicleID int) (bool, error)
+}
+
+var _ Store = (*Repository)(nil)
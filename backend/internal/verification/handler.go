@@ -0,0 +1,65 @@
+package verification
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/middleware"
+	"carpool/backend/internal/upload"
+)
+
+const (
+	// defaultMaxDocumentBytes applies when Handler.MaxDocumentBytes is
+	// zero.
+	defaultMaxDocumentBytes = 10 << 20
+	// documentField is the multipart form field carrying a document.
+	documentField = "document"
+)
+
+type Handler struct {
+	Service *Service
+	// MaxDocumentBytes limits document uploads.
+	MaxDocumentBytes int64
+}
+
+// GetVerificationHandler returns where the caller's verification stands.
+func (h *Handler) GetVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	v, err := h.Service.GetVerification(r.Context(), middleware.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// UploadDocumentHandler stores the file in the document field of a
+// multipart/form-data body as the caller's {kind} document and returns
+// the verification.
+func (h *Handler) UploadDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	limit := h.MaxDocumentBytes
+	if limit <= 0 {
+		limit = defaultMaxDocumentBytes
+	}
+	data, err := upload.File(w, r, documentField, limit, "Document")
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	v, err := h.Service.UploadDocument(r.Context(), middleware.GetUserIDFromContext(r.Context()), r.PathValue("kind"), data)
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// SubmitHandler puts the caller's documents up for review.
+func (h *Handler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	v, err := h.Service.Submit(r.Context(), middleware.GetUserIDFromContext(r.Context()))
+	if err != nil {
+		apperr.Write(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,71 @@
+// Package verification checks the license and insurance of drivers who
+// ask to be shown as verified.
+package verification
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Documents a driver uploads.
+const (
+	KindLicense   = "license"
+	KindInsurance = "insurance"
+)
+
+// Kinds are the documents a driver must upload before submitting them.
+var Kinds = []string{KindLicense, KindInsurance}
+
+// ValidKind reports whether kind is one of Kinds.
+func ValidKind(kind string) bool {
+	return slices.Contains(Kinds, kind)
+}
+
+// Verification statuses. Drivers who never submitted their documents are
+// unverified; the others are pending until an admin decides.
+const (
+	StatusUnverified = "unverified"
+	StatusPending    = "pending"
+	StatusVerified   = "verified"
+	StatusRejected   = "rejected"
+)
+
+type Verification struct {
+	UserID int `json:"user_id"`
+	// Name is the driver's name, filled in for the admin queue.
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	// Reason explains a rejection.
+	Reason      *string    `json:"reason,omitempty"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewerID  *int       `json:"reviewer_id,omitempty"`
+	// Documents are the ones still stored, ordered by kind. Approved
+	// drivers have none, and the admin queue leaves them out.
+	Documents []*Document `json:"documents,omitempty"`
+}
+
+// Document is an uploaded license or insurance certificate.
+type Document struct {
+	UserID      int       `json:"-"`
+	Kind        string    `json:"kind"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	// Data is only loaded by Store.GetDocument.
+	Data []byte `json:"-"`
+}
+
+// extensions are the file extensions of the accepted document formats.
+var extensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// Filename is what the document is saved as when downloaded, such as
+// "license-12.pdf".
+func (d *Document) Filename() string {
+	return fmt.Sprintf("%s-%d%s", d.Kind, d.UserID, extensions[d.ContentType])
+}
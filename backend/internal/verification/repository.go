@@ -0,0 +1,154 @@
+package verification
+
+import (
+	"context"
+	"database/sql"
+
+	"carpool/backend/internal/deadline"
+)
+
+type Repository struct {
+	DB       *sql.DB
+	Timeouts deadline.Timeouts
+}
+
+func (r *Repository) PutDocument(ctx context.Context, d *Document) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.PutDocument")
+	defer cancel()
+
+	d.Size = len(d.Data)
+	query := `
+        INSERT INTO verification_documents (user_id, kind, content_type, data, uploaded_at)
+        VALUES ($1, $2, $3, $4, NOW())
+        ON CONFLICT (user_id, kind) DO UPDATE
+        SET content_type = EXCLUDED.content_type, data = EXCLUDED.data, uploaded_at = EXCLUDED.uploaded_at
+        RETURNING uploaded_at
+    `
+	return r.DB.QueryRowContext(ctx, query, d.UserID, d.Kind, d.ContentType, d.Data).Scan(&d.UploadedAt)
+}
+
+func (r *Repository) GetDocument(ctx context.Context, userID int, kind string) (*Document, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.GetDocument")
+	defer cancel()
+
+	d := Document{UserID: userID, Kind: kind}
+	err := r.DB.QueryRowContext(ctx, `
+        SELECT content_type, data, uploaded_at FROM verification_documents
+        WHERE user_id = $1 AND kind = $2
+    `, userID, kind).Scan(&d.ContentType, &d.Data, &d.UploadedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Size = len(d.Data)
+	return &d, nil
+}
+
+func (r *Repository) ListDocuments(ctx context.Context, userID int) ([]*Document, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.ListDocuments")
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `
+        SELECT kind, content_type, octet_length(data), uploaded_at FROM verification_documents
+        WHERE user_id = $1 ORDER BY kind
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []*Document{}
+	for rows.Next() {
+		d := Document{UserID: userID}
+		if err := rows.Scan(&d.Kind, &d.ContentType, &d.Size, &d.UploadedAt); err != nil {
+			return nil, err
+		}
+		docs = append(docs, &d)
+	}
+	return docs, rows.Err()
+}
+
+func (r *Repository) GetVerification(ctx context.Context, userID int) (*Verification, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.GetVerification")
+	defer cancel()
+
+	v := Verification{UserID: userID}
+	err := r.DB.QueryRowContext(ctx, `
+        SELECT status, reason, submitted_at, reviewed_at, reviewer_id FROM driver_verifications
+        WHERE user_id = $1
+    `, userID).Scan(&v.Status, &v.Reason, &v.SubmittedAt, &v.ReviewedAt, &v.ReviewerID)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *Repository) Submit(ctx context.Context, userID int) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.Submit")
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `
+        INSERT INTO driver_verifications (user_id, status, submitted_at)
+        VALUES ($1, 'pending', NOW())
+        ON CONFLICT (user_id) DO UPDATE
+        SET status = 'pending', reason = NULL, submitted_at = NOW(), reviewed_at = NULL, reviewer_id = NULL
+    `, userID)
+	return err
+}
+
+func (r *Repository) ListVerifications(ctx context.Context, status string) ([]*Verification, error) {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.ListVerifications")
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `
+        SELECT v.user_id, u.name, v.status, v.reason, v.submitted_at, v.reviewed_at, v.reviewer_id
+        FROM driver_verifications v
+        JOIN users u ON u.user_id = v.user_id
+        WHERE v.status = $1
+        ORDER BY v.submitted_at, v.user_id
+    `, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []*Verification{}
+	for rows.Next() {
+		var v Verification
+		err := rows.Scan(&v.UserID, &v.Name, &v.Status, &v.Reason, &v.SubmittedAt, &v.ReviewedAt, &v.ReviewerID)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, &v)
+	}
+	return verifications, rows.Err()
+}
+
+func (r *Repository) Decide(ctx context.Context, userID, reviewerID int, status string, reason *string) error {
+	ctx, cancel := r.Timeouts.Apply(ctx, "verification.Decide")
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+        UPDATE driver_verifications
+        SET status = $3, reason = $4, reviewed_at = NOW(), reviewer_id = $2
+        WHERE user_id = $1 AND status = 'pending'
+    `, userID, reviewerID, status, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	// Once checked, the documents are only a liability.
+	if status == StatusVerified {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM verification_documents WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,187 @@
+package verification
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"carpool/backend/internal/apperr"
+	"carpool/backend/internal/mail"
+	"carpool/backend/internal/user"
+	"carpool/backend/internal/validation"
+)
+
+// maxReasonLength bounds the reason an admin gives for a rejection.
+const maxReasonLength = 500
+
+type Service struct {
+	Repo  Store
+	Users user.Store
+	// Mail tells drivers about decisions. Nil sends nothing.
+	Mail mail.Sender
+}
+
+// GetVerification returns where the user's verification stands, with the
+// documents they have uploaded.
+func (s *Service) GetVerification(ctx context.Context, userID int) (*Verification, error) {
+	v, err := s.Repo.GetVerification(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		v, err = &Verification{UserID: userID, Status: StatusUnverified}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.Documents, err = s.Repo.ListDocuments(ctx, userID); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// IsVerified reports whether an admin approved the user's documents.
+func (s *Service) IsVerified(ctx context.Context, userID int) (bool, error) {
+	v, err := s.Repo.GetVerification(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return v.Status == StatusVerified, nil
+}
+
+// editable returns the user's verification if they may still change their
+// documents, which they can't while they are under review or once they
+// are verified.
+func (s *Service) editable(ctx context.Context, userID int) (*Verification, error) {
+	v, err := s.GetVerification(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch v.Status {
+	case StatusPending:
+		return nil, apperr.Conflict("Your documents are already under review")
+	case StatusVerified:
+		return nil, apperr.Conflict("You are already verified")
+	}
+	return v, nil
+}
+
+// UploadDocument stores data, a JPEG, PNG or PDF, as the user's document
+// of kind, replacing any earlier one, and returns the verification.
+func (s *Service) UploadDocument(ctx context.Context, userID int, kind string, data []byte) (*Verification, error) {
+	if !ValidKind(kind) {
+		return nil, apperr.NotFound("Unknown document kind")
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := extensions[contentType]; !ok {
+		return nil, apperr.UnsupportedMediaType("Document must be a JPEG, PNG or PDF file")
+	}
+	if _, err := s.editable(ctx, userID); err != nil {
+		return nil, err
+	}
+	if err := s.Repo.PutDocument(ctx, &Document{UserID: userID, Kind: kind, ContentType: contentType, Data: data}); err != nil {
+		return nil, err
+	}
+	return s.GetVerification(ctx, userID)
+}
+
+// Submit puts the user's documents up for review once all of Kinds are
+// uploaded, and returns the verification.
+func (s *Service) Submit(ctx context.Context, userID int) (*Verification, error) {
+	v, err := s.editable(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	val := &validation.Validator{}
+	for _, kind := range Kinds {
+		uploaded := slices.ContainsFunc(v.Documents, func(d *Document) bool { return d.Kind == kind })
+		val.Check(uploaded, "documents."+kind, "must be uploaded first")
+	}
+	if errs := val.Errors(); errs != nil {
+		return nil, apperr.Validation(errs)
+	}
+	if err := s.Repo.Submit(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.GetVerification(ctx, userID)
+}
+
+// ListVerifications returns the verifications with status, oldest
+// submission first. The review queue is the pending ones.
+func (s *Service) ListVerifications(ctx context.Context, status string) ([]*Verification, error) {
+	switch status {
+	case StatusPending, StatusVerified, StatusRejected:
+	default:
+		return nil, apperr.Validation(validation.Errors{"status": "must be one of pending, verified, rejected"})
+	}
+	return s.Repo.ListVerifications(ctx, status)
+}
+
+// GetDocument returns the user's document of kind with its contents.
+func (s *Service) GetDocument(ctx context.Context, userID int, kind string) (*Document, error) {
+	d, err := s.Repo.GetDocument(ctx, userID, kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperr.NotFound("Document not found")
+	}
+	return d, err
+}
+
+// Decide approves or rejects the user's pending verification, with
+// status verified or rejected, and emails them the outcome. Rejections
+// need a reason, which the driver is shown.
+func (s *Service) Decide(ctx context.Context, userID, reviewerID int, status string, reason *string) error {
+	v := &validation.Validator{}
+	v.Check(status == StatusVerified || status == StatusRejected, "status", "must be verified or rejected")
+	if status == StatusRejected {
+		text := ""
+		if reason != nil {
+			text = *reason
+		}
+		v.Required("reason", text)
+		v.MaxLength("reason", text, maxReasonLength)
+	}
+	if errs := v.Errors(); errs != nil {
+		return apperr.Validation(errs)
+	}
+	if status == StatusVerified {
+		reason = nil
+	}
+	err := s.Repo.Decide(ctx, userID, reviewerID, status, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.NotFound("No pending verification for this user")
+	}
+	if err != nil {
+		return err
+	}
+	s.notify(ctx, userID, status, reason)
+	return nil
+}
+
+// notify emails the driver about a decision, logging failures: the
+// decision stands either way and shows on their profile.
+func (s *Service) notify(ctx context.Context, userID int, status string, reason *string) {
+	if s.Mail == nil {
+		return
+	}
+	u, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Notifying user %d of verification: %v", userID, err)
+		return
+	}
+	msg := mail.Message{
+		To:      u.Email,
+		Subject: "You are now a verified driver",
+		Body:    fmt.Sprintf("Hi %s,\n\nWe checked your license and insurance, and your rides and profile now show you as a verified driver.\n", u.Name),
+	}
+	if status == StatusRejected {
+		msg.Subject = "We couldn't verify your documents"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nWe couldn't verify you as a driver:\n\n%s\n\nYou can upload new documents from your profile and submit them again.\n", u.Name, *reason)
+	}
+	if err := s.Mail.Send(context.WithoutCancel(ctx), msg); err != nil {
+		log.Printf("Notifying user %d of verification: %v", userID, err)
+	}
+}
@@ -0,0 +1,31 @@
+package verification
+
+import "context"
+
+// Store persists verifications and their documents. Repository implements
+// it on Postgres; memstore implements it in memory for tests.
+type Store interface {
+	// PutDocument stores d, replacing the user's document of the same
+	// kind, and fills in Size and UploadedAt.
+	PutDocument(ctx context.Context, d *Document) error
+	// GetDocument returns the document with its Data, or sql.ErrNoRows.
+	GetDocument(ctx context.Context, userID int, kind string) (*Document, error)
+	// ListDocuments returns the user's documents without their Data,
+	// ordered by kind.
+	ListDocuments(ctx context.Context, userID int) ([]*Document, error)
+	// GetVerification returns the user's verification without Name or
+	// Documents, or sql.ErrNoRows if they never submitted.
+	GetVerification(ctx context.Context, userID int) (*Verification, error)
+	// Submit puts the user's verification up for review, clearing any
+	// earlier decision.
+	Submit(ctx context.Context, userID int) error
+	// ListVerifications returns the verifications with status, oldest
+	// submission first, with Name but without Documents.
+	ListVerifications(ctx context.Context, status string) ([]*Verification, error)
+	// Decide records an admin's decision on a pending verification and
+	// returns sql.ErrNoRows if the user has none. Approving it deletes the
+	// user's documents.
+	Decide(ctx context.Context, userID, reviewerID int, status string, reason *string) error
+}
+
+var _ Store = (*Repository)(nil)
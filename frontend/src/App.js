@@ -9,6 +9,8 @@ import FindRidePage from './pages/FindRidePage';
 import ChooseRidePage from './pages/ChooseRidePage';
 import SelectedRidePage from './pages/SelectedRidePage';
 import PostRidePage from './pages/PostRidePage';
+import ConfirmEmailPage from './pages/ConfirmEmailPage';
+import UserProfilePage from './pages/UserProfilePage';
 
 function App() {
   return (
@@ -22,6 +24,8 @@ function App() {
         <Route path="/choose-ride" element={<ChooseRidePage />} />
         <Route path="/selected-ride" element={<SelectedRidePage />} />
         <Route path="/post-ride" element={<PostRidePage />} />
+        <Route path="/confirm-email" element={<ConfirmEmailPage />} />
+        <Route path="/users/:id" element={<UserProfilePage />} />
       </Routes>
     </Router>
   );
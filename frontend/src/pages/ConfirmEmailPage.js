@@ -0,0 +1,56 @@
+// src/pages/ConfirmEmailPage.js
+import React, { useEffect, useState } from 'react';
+import { Link, useSearchParams } from 'react-router-dom';
+import Navbar from '../components/Navbar';
+import api from '../services/api';
+
+// Opened from the link mailed to a new email address.
+function ConfirmEmailPage() {
+  const [searchParams] = useSearchParams();
+  const [status, setStatus] = useState('confirming');
+
+  useEffect(() => {
+    const token = searchParams.get('token');
+    if (!token) {
+      setStatus('failed');
+      return;
+    }
+    api
+      .post('/profile/email/confirm', { token })
+      .then(() => setStatus('confirmed'))
+      .catch((error) => {
+        console.error('Error confirming email:', error);
+        setStatus('failed');
+      });
+  }, [searchParams]);
+
+  return (
+    <>
+      <Navbar />
+      <div style={styles.container}>
+        <h2>Confirm Email</h2>
+        {status === 'confirming' && <p>Confirming your new email address...</p>}
+        {status === 'confirmed' && (
+          <p>
+            Your email address has been changed. <Link to="/profile">Back to your profile</Link>
+          </p>
+        )}
+        {status === 'failed' && (
+          <p>This link is invalid or has expired. Change your email again from your profile to get a new one.</p>
+        )}
+      </div>
+    </>
+  );
+}
+
+const styles = {
+  container: {
+    maxWidth: '400px',
+    margin: '50px auto',
+    padding: '20px',
+    textAlign: 'center',
+    boxSizing: 'border-box',
+  },
+};
+
+export default ConfirmEmailPage;
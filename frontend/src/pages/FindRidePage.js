@@ -1,9 +1,10 @@
-import React, { useState, useCallback } from 'react';
+import React, { useState, useCallback, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
 import debounce from 'lodash.debounce';
 import Navbar from '../components/Navbar';
 import RoundedInput from '../components/RoundedInput';
 import RoundedButton from '../components/RoundedButton';
+import ToggleSwitch from '../components/ToggleSwitch';
 import api from '../services/api';
 
 function FindRidePage() {
@@ -26,9 +27,33 @@ function FindRidePage() {
   const [time, setTime] = useState(defaultTime); 
   const [numPeople, setNumPeople] = useState('1');
   const [maxDistance, setMaxDistance] = useState('5'); // default 5 km
+  // Ride rules to filter by; absent members match any ride.
+  const [filter, setFilter] = useState({});
 
   const navigate = useNavigate();
 
+  // Start from the filter saved in the rider's preferences.
+  useEffect(() => {
+    if (!localStorage.getItem('token')) {
+      return;
+    }
+    api
+      .get('/profile/preferences')
+      .then((response) => setFilter(response.data.rider))
+      .catch((error) => console.error('Error fetching preferences:', error));
+  }, []);
+
+  // toggle sets a filter member to value when on and removes it when off.
+  const toggle = (key, value) => (on) => {
+    const next = { ...filter };
+    if (on) {
+      next[key] = value;
+    } else {
+      delete next[key];
+    }
+    setFilter(next);
+  };
+
   // Fetch address suggestions from Nominatim
   const fetchSuggestions = async (query, setSuggestions) => {
     if (query.length < 3) {
@@ -129,7 +154,12 @@ function FindRidePage() {
       rideTime: time, // New time field value
       numPeople: numPeople,
       maxDistance: maxDistance,  // pass the selected distance
-
+      smoking: filter.smoking,
+      pets: filter.pets,
+      music: filter.music,
+      quiet: filter.quiet,
+      womenOnly: filter.women_only,
+      luggage: filter.luggage,
     };
     await performSearch(params);
   };
@@ -233,6 +263,21 @@ function FindRidePage() {
             />
           </div>
 
+          <ToggleSwitch label="No smoking" checked={filter.smoking === false} onChange={toggle('smoking', false)} />
+          <ToggleSwitch label="Pets allowed" checked={filter.pets === true} onChange={toggle('pets', true)} />
+          <ToggleSwitch label="Quiet ride" checked={filter.quiet === true} onChange={toggle('quiet', true)} />
+          <ToggleSwitch label="Women only" checked={filter.women_only === true} onChange={toggle('women_only', true)} />
+          <select
+            value={filter.luggage || ''}
+            onChange={(e) => toggle('luggage', e.target.value)(e.target.value !== '')}
+            style={styles.select}
+          >
+            <option value="">Any luggage</option>
+            <option value="small">Small luggage</option>
+            <option value="medium">Medium luggage</option>
+            <option value="large">Large luggage</option>
+          </select>
+
           <RoundedButton type="submit" style={styles.button}>
             Search
           </RoundedButton>
@@ -246,6 +291,14 @@ function FindRidePage() {
 }
 
 const styles = {
+  select: {
+    boxSizing: 'border-box',
+    width: '100%',
+    padding: '12px',
+    fontSize: '16px',
+    borderRadius: '8px',
+    border: '1px solid #ccc',
+  },
   container: {
     maxWidth: '400px',
     margin: '50px auto',
@@ -1,5 +1,5 @@
-import React, { useState, useCallback } from 'react';
-import { useNavigate } from 'react-router-dom';
+import React, { useState, useCallback, useEffect, useRef } from 'react';
+import { Link, useNavigate } from 'react-router-dom';
 import debounce from 'lodash.debounce';
 import Navbar from '../components/Navbar';
 import RoundedInput from '../components/RoundedInput';
@@ -25,12 +25,45 @@ function PostRidePage() {
   const [price, setPrice] = useState('');
   const [seats, setSeats] = useState('');
   const [instantBooking, setInstantBooking] = useState(false);
-  const [carType, setCarType] = useState('');
+  const [vehicles, setVehicles] = useState([]);
+  const [vehicleId, setVehicleId] = useState('');
+  const [rules, setRules] = useState({
+    smoking: false,
+    pets: false,
+    music: false,
+    quiet: false,
+    luggage: 'medium',
+    women_only: false,
+  });
+
+  // Resubmitting the same ride reuses its Idempotency-Key, so a retry after
+  // a dropped connection doesn't post it twice.
+  const lastSubmission = useRef({ body: null, key: null });
 
   // Modal state
   const [showPostModal, setShowPostModal] = useState(false);
   const navigate = useNavigate();
 
+  // Rides are posted with one of the driver's registered vehicles.
+  useEffect(() => {
+    api
+      .get('/vehicles')
+      .then((response) => {
+        setVehicles(response.data);
+        if (response.data.length > 0) {
+          setVehicleId(String(response.data[0].vehicle_id));
+        }
+      })
+      .catch((err) => console.error('Error fetching vehicles:', err));
+    // Start from the driver's default rules.
+    api
+      .get('/profile/preferences')
+      .then((response) => setRules(response.data.driver))
+      .catch((err) => console.error('Error fetching preferences:', err));
+  }, []);
+
+  const setRule = (key) => (value) => setRules({ ...rules, [key]: value });
+
   // Function to fetch suggestions from Nominatim API
   const fetchSuggestions = async (query, setSuggestions) => {
     if (query.length < 3) {
@@ -122,17 +155,27 @@ function PostRidePage() {
       price: Number(price),
       ride_time: new Date(date + 'T' + startTime + ':00Z'),
       available_seats: Number(seats),
-      car_type: carType,
+      vehicle_id: Number(vehicleId),
       instant_booking: instantBooking,
+      rules,
     };
 
+    const body = JSON.stringify(rideData);
+    if (lastSubmission.current.body !== body) {
+      lastSubmission.current = { body, key: crypto.randomUUID() };
+    }
+
     try {
-      const response = await api.post('/rides', rideData);
+      const response = await api.post('/rides', rideData, {
+        headers: { 'Idempotency-Key': lastSubmission.current.key },
+      });
       console.log('Ride posted successfully:', response.data);
       setShowPostModal(true);
     } catch (error) {
       console.error('Error posting ride:', error);
-      alert('Failed to post ride. Please try again.');
+      alert(error.response?.status === 403
+        ? 'Only verified drivers may post rides. Upload your license and insurance on your profile.'
+        : 'Failed to post ride. Please try again.');
     }
   };
 
@@ -237,11 +280,40 @@ function PostRidePage() {
             required
           />
 
-          <RoundedInput
-            placeholder="Car Name & Model"
-            value={carType}
-            onChange={(e) => setCarType(e.target.value)}
-          />
+          <ToggleSwitch label="Smoking" checked={rules.smoking} onChange={setRule('smoking')} />
+          <ToggleSwitch label="Pets" checked={rules.pets} onChange={setRule('pets')} />
+          <ToggleSwitch label="Music" checked={rules.music} onChange={setRule('music')} />
+          <ToggleSwitch label="Quiet ride" checked={rules.quiet} onChange={setRule('quiet')} />
+          <ToggleSwitch label="Women only" checked={rules.women_only} onChange={setRule('women_only')} />
+          <select
+            value={rules.luggage}
+            onChange={(e) => setRule('luggage')(e.target.value)}
+            style={styles.select}
+          >
+            <option value="none">No luggage</option>
+            <option value="small">Small luggage</option>
+            <option value="medium">Medium luggage</option>
+            <option value="large">Large luggage</option>
+          </select>
+
+          {vehicles.length > 0 ? (
+            <select
+              value={vehicleId}
+              onChange={(e) => setVehicleId(e.target.value)}
+              style={styles.select}
+              required
+            >
+              {vehicles.map((vehicle) => (
+                <option key={vehicle.vehicle_id} value={vehicle.vehicle_id}>
+                  {[vehicle.color, vehicle.make, vehicle.model].filter(Boolean).join(' ')} ({vehicle.plate})
+                </option>
+              ))}
+            </select>
+          ) : (
+            <span style={styles.noVehicle}>
+              Add a vehicle on your <Link to="/profile">profile</Link> to post a ride.
+            </span>
+          )}
 
           <RoundedButton type="submit" style={styles.button}>
             Post
@@ -284,6 +356,17 @@ const styles = {
     width: '100%',
     boxSizing: 'border-box',
   },
+  select: {
+    boxSizing: 'border-box',
+    width: '100%',
+    padding: '12px',
+    fontSize: '16px',
+    borderRadius: '8px',
+    border: '1px solid #ccc',
+  },
+  noVehicle: {
+    alignSelf: 'center',
+  },
   inputWrapper: {
     position: 'relative',
   },
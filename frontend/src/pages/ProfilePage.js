@@ -12,9 +12,13 @@ function ProfilePage() {
     name: '',
     email: '',
     phone: '',
+    bio: '',
     profile_pic: '',
     rating: '',
   });
+  const [editName, setEditName] = useState('');
+  const [editEmail, setEditEmail] = useState('');
+  const [editBio, setEditBio] = useState('');
   const [editPhone, setEditPhone] = useState('');
   const [showUpdateModal, setShowUpdateModal] = useState(false);
 
@@ -24,6 +28,14 @@ function ProfilePage() {
   const [newPassword, setNewPassword] = useState('');
   const [confirmPassword, setConfirmPassword] = useState('');
 
+  // Vehicles
+  const [vehicles, setVehicles] = useState([]);
+  const [showVehicleModal, setShowVehicleModal] = useState(false);
+  const [newVehicle, setNewVehicle] = useState({ make: '', model: '', color: '', plate: '', seats: '' });
+
+  // Driver verification
+  const [verification, setVerification] = useState(null);
+
   const fileInputRef = useRef(null);
   const navigate = useNavigate();
 
@@ -42,6 +54,14 @@ function ProfilePage() {
     };
 
     fetchProfile();
+    api
+      .get('/vehicles')
+      .then((response) => setVehicles(response.data))
+      .catch((error) => console.error('Error fetching vehicles:', error));
+    api
+      .get('/profile/verification')
+      .then((response) => setVerification(response.data))
+      .catch((error) => console.error('Error fetching verification:', error));
   }, []);
 
   // Handle profile picture upload
@@ -62,7 +82,7 @@ function ProfilePage() {
             'Content-Type': 'multipart/form-data',
           },
         });
-        setProfile({ ...profile, profile_pic: response.data.url });
+        setProfile({ ...profile, profile_pic: response.data.profile_pic });
       } catch (error) {
         console.error('Error uploading profile picture:', error);
         alert('Error uploading profile picture. Please try again.');
@@ -70,23 +90,36 @@ function ProfilePage() {
     }
   };
 
-  // Handle phone update modal
+  // Handle profile update modal
   const handleUpdateProfileOpen = () => {
-    setEditPhone(profile.phone);
+    setEditName(profile.name);
+    setEditEmail(profile.pending_email || profile.email);
+    setEditBio(profile.bio || '');
+    setEditPhone(profile.phone || '');
     setShowUpdateModal(true);
   };
 
   const handleUpdateProfileSave = async (e) => {
     e.preventDefault();
+    // Send a merge patch of the changed fields; empty bio and phone clear them.
+    const patch = {};
+    if (editName !== profile.name) patch.name = editName;
+    if (editEmail !== (profile.pending_email || profile.email)) patch.email = editEmail;
+    if (editBio !== (profile.bio || '')) patch.bio = editBio || null;
+    if (editPhone !== (profile.phone || '')) patch.phone = editPhone || null;
     try {
       const token = localStorage.getItem('token');
-      const response = await api.patch(
-        '/profile',
-        { phone: editPhone },
-        { headers: { Authorization: `Bearer ${token}` } }
-      );
-      setProfile({ ...profile, phone: response.data.phone });
+      const response = await api.patch('/profile', patch, {
+        headers: {
+          Authorization: `Bearer ${token}`,
+          'Content-Type': 'application/merge-patch+json',
+        },
+      });
+      setProfile(response.data);
       setShowUpdateModal(false);
+      if (patch.email && response.data.pending_email) {
+        alert(`We sent a confirmation link to ${response.data.pending_email}.`);
+      }
     } catch (error) {
       console.error('Error updating profile:', error);
       alert('Error updating profile. Please try again.');
@@ -122,6 +155,57 @@ function ProfilePage() {
     }
   };
 
+  const handleAddVehicleSave = async (e) => {
+    e.preventDefault();
+    try {
+      const response = await api.post('/vehicles', { ...newVehicle, seats: Number(newVehicle.seats) });
+      setVehicles([...vehicles, response.data]);
+      setNewVehicle({ make: '', model: '', color: '', plate: '', seats: '' });
+      setShowVehicleModal(false);
+    } catch (error) {
+      console.error('Error adding vehicle:', error);
+      alert('Failed to add vehicle. Please check the details.');
+    }
+  };
+
+  const handleDeleteVehicle = async (id) => {
+    try {
+      await api.delete(`/vehicles/${id}`);
+      setVehicles(vehicles.filter((vehicle) => vehicle.vehicle_id !== id));
+    } catch (error) {
+      console.error('Error deleting vehicle:', error);
+      alert(error.response?.status === 409
+        ? 'This vehicle is used by upcoming rides.'
+        : 'Failed to delete vehicle.');
+    }
+  };
+
+  const handleDocumentChange = async (kind, e) => {
+    const file = e.target.files[0];
+    if (!file) return;
+    const formData = new FormData();
+    formData.append('document', file);
+    try {
+      const response = await api.post(`/profile/verification/documents/${kind}`, formData, {
+        headers: { 'Content-Type': 'multipart/form-data' },
+      });
+      setVerification(response.data);
+    } catch (error) {
+      console.error('Error uploading document:', error);
+      alert(error.response?.data?.detail || 'Failed to upload document. Use a JPEG, PNG or PDF file.');
+    }
+  };
+
+  const handleSubmitVerification = async () => {
+    try {
+      const response = await api.post('/profile/verification/submit');
+      setVerification(response.data);
+    } catch (error) {
+      console.error('Error submitting verification:', error);
+      alert('Upload your license and insurance before submitting.');
+    }
+  };
+
   // Logout handler
   const handleLogout = () => {
     localStorage.removeItem('token');
@@ -153,17 +237,26 @@ function ProfilePage() {
         {/* Profile Details */}
         <RoundedInput value={profile.name} readOnly style={styles.readOnlyInput} />
         <RoundedInput value={profile.email} readOnly style={styles.readOnlyInput} />
+        {profile.pending_email && (
+          <p style={styles.pending}>Waiting for confirmation of {profile.pending_email}</p>
+        )}
         <RoundedInput value={profile.rating} readOnly placeholder="Rating" style={styles.readOnlyInput} />
         <RoundedInput
-          type="number"
+          type="tel"
           placeholder="Phone Number"
           value={profile.phone || ''}
           readOnly
           style={styles.readOnlyInput}
         />
+        <RoundedInput
+          placeholder="Bio"
+          value={profile.bio || ''}
+          readOnly
+          style={styles.readOnlyInput}
+        />
 
         <RoundedButton onClick={handleUpdateProfileOpen} style={styles.button}>
-          Update Phone Number
+          Edit Profile
         </RoundedButton>
 
         {/* Change Password Button */}
@@ -174,18 +267,130 @@ function ProfilePage() {
         <RoundedButton onClick={handleLogout} style={styles.button}>
           Log Out
         </RoundedButton>
+
+        {/* Vehicles */}
+        <h3>My Vehicles</h3>
+        {vehicles.map((vehicle) => (
+          <div key={vehicle.vehicle_id} style={styles.vehicle}>
+            <span>
+              {[vehicle.color, vehicle.make, vehicle.model].filter(Boolean).join(' ')} · {vehicle.plate} · {vehicle.seats} seats
+            </span>
+            <button onClick={() => handleDeleteVehicle(vehicle.vehicle_id)} style={styles.vehicleDelete}>
+              Remove
+            </button>
+          </div>
+        ))}
+        <RoundedButton onClick={() => setShowVehicleModal(true)} style={styles.button}>
+          Add Vehicle
+        </RoundedButton>
+
+        {/* Driver Verification */}
+        {verification && (
+          <>
+            <h3>Driver Verification</h3>
+            <p style={styles.pending}>{verificationLabels[verification.status]}</p>
+            {verification.status === 'rejected' && verification.reason && (
+              <p style={styles.pending}>Reason: {verification.reason}</p>
+            )}
+            {(verification.status === 'unverified' || verification.status === 'rejected') && (
+              <>
+                {['license', 'insurance'].map((kind) => (
+                  <div key={kind} style={styles.vehicle}>
+                    <span>
+                      {kind === 'license' ? "Driver's license" : 'Insurance'}
+                      {(verification.documents || []).some((d) => d.kind === kind) ? ' · uploaded' : ''}
+                    </span>
+                    <input
+                      type="file"
+                      accept="image/jpeg,image/png,application/pdf"
+                      onChange={(e) => handleDocumentChange(kind, e)}
+                    />
+                  </div>
+                ))}
+                <RoundedButton onClick={handleSubmitVerification} style={styles.button}>
+                  Submit for Review
+                </RoundedButton>
+              </>
+            )}
+          </>
+        )}
       </div>
 
-      {/* Modal for Updating Phone Number */}
+      {/* Modal for Adding a Vehicle */}
+      <Modal visible={showVehicleModal} onClose={() => setShowVehicleModal(false)}>
+        <h3>Add Vehicle</h3>
+        <form onSubmit={handleAddVehicleSave} style={styles.modalForm}>
+          <RoundedInput
+            placeholder="Make"
+            value={newVehicle.make}
+            onChange={(e) => setNewVehicle({ ...newVehicle, make: e.target.value })}
+            required
+          />
+          <RoundedInput
+            placeholder="Model"
+            value={newVehicle.model}
+            onChange={(e) => setNewVehicle({ ...newVehicle, model: e.target.value })}
+            required
+          />
+          <RoundedInput
+            placeholder="Color"
+            value={newVehicle.color}
+            onChange={(e) => setNewVehicle({ ...newVehicle, color: e.target.value })}
+          />
+          <RoundedInput
+            placeholder="Plate"
+            value={newVehicle.plate}
+            onChange={(e) => setNewVehicle({ ...newVehicle, plate: e.target.value })}
+            required
+          />
+          <RoundedInput
+            type="number"
+            min="1"
+            max="8"
+            placeholder="Seats"
+            value={newVehicle.seats}
+            onChange={(e) => setNewVehicle({ ...newVehicle, seats: e.target.value })}
+            required
+          />
+          <div style={styles.modalButtons}>
+            <RoundedButton type="submit" style={styles.modalButton}>
+              Save
+            </RoundedButton>
+            <RoundedButton onClick={() => setShowVehicleModal(false)} style={styles.modalButton}>
+              Cancel
+            </RoundedButton>
+          </div>
+        </form>
+      </Modal>
+
+      {/* Modal for Editing the Profile */}
       <Modal visible={showUpdateModal} onClose={() => setShowUpdateModal(false)}>
         <h3>Edit Profile</h3>
         <form onSubmit={handleUpdateProfileSave} style={styles.modalForm}>
           <RoundedInput
-            placeholder="Phone Number"
+            placeholder="Name"
+            value={editName}
+            onChange={(e) => setEditName(e.target.value)}
+            required
+          />
+          <RoundedInput
+            placeholder="Email"
+            type="email"
+            value={editEmail}
+            onChange={(e) => setEditEmail(e.target.value)}
+            required
+          />
+          <RoundedInput
+            placeholder="Phone Number, e.g. +41 79 123 45 67"
             type="tel"
             value={editPhone}
             onChange={(e) => setEditPhone(e.target.value)}
-            required
+          />
+          <RoundedInput
+            placeholder="Bio"
+            value={editBio}
+            onChange={(e) => setEditBio(e.target.value)}
+            maxLength={500}
           />
           <div style={styles.modalButtons}>
             <RoundedButton
@@ -245,7 +450,19 @@ function ProfilePage() {
   );
 }
 
+const verificationLabels = {
+  unverified: 'Upload your license and insurance to become a verified driver.',
+  pending: 'Your documents are under review.',
+  verified: 'You are a verified driver.',
+  rejected: 'Your documents were rejected. Upload new ones and submit again.',
+};
+
 const styles = {
+  pending: {
+    fontSize: '0.9em',
+    color: '#666',
+    margin: '0 0 10px',
+  },
   container: {
     maxWidth: '400px',
     margin: '50px auto',
@@ -275,6 +492,18 @@ const styles = {
     width: '100%',
     marginBottom: '10px',
   },
+  vehicle: {
+    display: 'flex',
+    justifyContent: 'space-between',
+    alignItems: 'center',
+    marginBottom: '10px',
+  },
+  vehicleDelete: {
+    background: 'none',
+    border: 'none',
+    color: '#c00',
+    cursor: 'pointer',
+  },
   modalForm: {
     display: 'flex',
     flexDirection: 'column',
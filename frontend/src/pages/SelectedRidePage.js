@@ -1,10 +1,22 @@
 // src/pages/SelectedRidePage.js
 import React, { useState } from 'react';
-import { useLocation } from 'react-router-dom';
+import { Link, useLocation } from 'react-router-dom';
 import Navbar from '../components/Navbar';
 import RoundedButton from '../components/RoundedButton';
 import Modal from '../components/Modal';
 
+// ruleLabels describes what the driver allows on the ride.
+function ruleLabels(rules) {
+  return [
+    rules.smoking ? 'Smoking allowed' : 'No smoking',
+    rules.pets ? 'Pets allowed' : 'No pets',
+    rules.music && 'Music',
+    rules.quiet && 'Quiet ride',
+    rules.luggage === 'none' ? 'No luggage' : `Up to ${rules.luggage} luggage`,
+    rules.women_only && 'Women only',
+  ].filter(Boolean);
+}
+
 function SelectedRidePage() {
   const location = useLocation();
   const ride = location.state?.ride;
@@ -53,7 +65,10 @@ const formattedRideTime = ride.ride_time
                 alt="Driver"
                 style={styles.profilePic}
               />
-              <span style={styles.driverName}>{ride.driver_name}</span>
+              <Link to={`/users/${ride.user_id}`} style={styles.driverName}>
+                {ride.driver_name}
+              </Link>
+              {ride.driver_verified && <span style={styles.verified}>✓ Verified</span>}
             </div>
             <div style={styles.rating}>{ride.driver_rating} ★</div>
           </div>
@@ -85,13 +100,25 @@ const formattedRideTime = ride.ride_time
           <div style={styles.bottomRow}>
             <div style={styles.carInfo}>
               <img src="/caricon.svg" alt="Car" style={styles.carIcon} />
-              <span style={styles.carType}>{ride.car_type}</span>
+              <span style={styles.carType}>
+                {ride.car_type}
+                {ride.vehicle && ` · ${ride.vehicle.plate}`}
+              </span>
             </div>
             <div style={styles.priceContainer}>
               <span style={styles.price}>{ride.price} €</span>
             </div>
           </div>
 
+          {/* Ride rules */}
+          {ride.rules && (
+            <div style={styles.rules}>
+              {ruleLabels(ride.rules).map((label) => (
+                <span key={label} style={styles.rule}>{label}</span>
+              ))}
+            </div>
+          )}
+
           {/* Button Row */}
           <div style={styles.buttonRow}>
             <RoundedButton style={styles.bookButton} onClick={handleBookClick}>
@@ -179,6 +206,15 @@ const styles = {
   driverName: {
     fontSize: '1.3rem',
     fontWeight: '600',
+    color: '#fff',
+  },
+  verified: {
+    marginLeft: '8px',
+    padding: '2px 8px',
+    borderRadius: '12px',
+    backgroundColor: 'rgba(255, 255, 255, 0.25)',
+    fontSize: '0.8rem',
+    color: '#fff',
   },
   rating: {
     fontSize: '1.3rem',
@@ -223,6 +259,18 @@ const styles = {
     width: '40px',
     height: '40px',
   },
+  rules: {
+    display: 'flex',
+    flexWrap: 'wrap',
+    gap: '8px',
+    marginTop: '10px',
+  },
+  rule: {
+    padding: '4px 10px',
+    borderRadius: '12px',
+    backgroundColor: 'rgba(255, 255, 255, 0.25)',
+    fontSize: '0.9rem',
+  },
   carType: {
     fontSize: '1.1rem',
     fontWeight: 'bold',
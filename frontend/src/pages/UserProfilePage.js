@@ -0,0 +1,192 @@
+// src/pages/UserProfilePage.js
+import React, { useEffect, useState } from 'react';
+import { useParams } from 'react-router-dom';
+import Navbar from '../components/Navbar';
+import api from '../services/api';
+
+const badgeLabels = {
+  email_verified: 'Email verified',
+  verified_driver: 'Verified driver',
+};
+
+// What other people see about a driver or rider.
+function UserProfilePage() {
+  const { id } = useParams();
+  const [profile, setProfile] = useState(null);
+  const [error, setError] = useState('');
+
+  useEffect(() => {
+    api
+      .get(`/users/${id}`)
+      .then((response) => setProfile(response.data))
+      .catch((err) => {
+        console.error('Error fetching profile:', err);
+        setError('This profile is not available.');
+      });
+  }, [id]);
+
+  if (error) {
+    return (
+      <>
+        <Navbar />
+        <div style={styles.container}>
+          <p>{error}</p>
+        </div>
+      </>
+    );
+  }
+
+  if (!profile) {
+    return (
+      <>
+        <Navbar />
+        <div style={styles.container}>
+          <p>Loading profile...</p>
+        </div>
+      </>
+    );
+  }
+
+  return (
+    <>
+      <Navbar />
+      <div style={styles.container}>
+        <div style={styles.card}>
+          <div style={styles.header}>
+            <img
+              src={profile.profile_pic || '/profilepic.svg'}
+              alt={profile.name}
+              style={styles.profilePic}
+            />
+            <div>
+              <h2 style={styles.name}>{profile.name}</h2>
+              <span style={styles.meta}>
+                Member since {new Date(profile.member_since).toLocaleDateString('en-US', { month: 'long', year: 'numeric' })}
+              </span>
+            </div>
+          </div>
+
+          {profile.badges.length > 0 && (
+            <div style={styles.badges}>
+              {profile.badges.map((badge) => (
+                <span key={badge} style={styles.badge}>
+                  {badgeLabels[badge] || badge}
+                </span>
+              ))}
+            </div>
+          )}
+
+          {profile.bio && <p style={styles.bio}>{profile.bio}</p>}
+
+          <div style={styles.stats}>
+            <div style={styles.stat}>
+              <strong>{profile.rating ? `${profile.rating.toFixed(1)} ★` : '–'}</strong>
+              <span>{profile.rating_count} ratings</span>
+            </div>
+            <div style={styles.stat}>
+              <strong>{profile.rides_driven}</strong>
+              <span>rides driven</span>
+            </div>
+            <div style={styles.stat}>
+              <strong>{profile.rides_taken}</strong>
+              <span>rides taken</span>
+            </div>
+          </div>
+
+          <h3>Recent reviews</h3>
+          {profile.recent_reviews.length === 0 && <p style={styles.meta}>No reviews yet.</p>}
+          {profile.recent_reviews.map((review) => (
+            <div key={review.review_id} style={styles.review}>
+              <div style={styles.reviewHeader}>
+                <span>{review.reviewer_name}</span>
+                <span>{'★'.repeat(review.rating)}</span>
+              </div>
+              {review.comment && <p style={styles.comment}>{review.comment}</p>}
+            </div>
+          ))}
+        </div>
+      </div>
+    </>
+  );
+}
+
+const styles = {
+  container: {
+    width: '100%',
+    minHeight: '100vh',
+    padding: '40px 20px',
+    boxSizing: 'border-box',
+    background: "url('/background.jpg') center center / cover no-repeat",
+    backgroundColor: 'rgba(0, 0, 0, 0.4)',
+    backgroundBlendMode: 'darken',
+    display: 'flex',
+    justifyContent: 'center',
+    alignItems: 'center',
+    color: '#fff',
+  },
+  card: {
+    width: '550px',
+    backgroundColor: 'rgba(255, 255, 255, 0.15)',
+    backdropFilter: 'blur(8px)',
+    borderRadius: '12px',
+    border: '1px solid rgba(255, 255, 255, 0.3)',
+    padding: '30px',
+    boxSizing: 'border-box',
+  },
+  header: {
+    display: 'flex',
+    alignItems: 'center',
+    gap: '15px',
+  },
+  profilePic: {
+    width: '80px',
+    height: '80px',
+    borderRadius: '50%',
+    objectFit: 'cover',
+    border: '2px solid #ccc',
+  },
+  name: {
+    margin: 0,
+  },
+  meta: {
+    opacity: 0.8,
+  },
+  badges: {
+    display: 'flex',
+    gap: '8px',
+    marginTop: '15px',
+  },
+  badge: {
+    padding: '4px 10px',
+    borderRadius: '12px',
+    backgroundColor: 'rgba(255, 255, 255, 0.25)',
+    fontSize: '0.9rem',
+  },
+  bio: {
+    marginTop: '15px',
+  },
+  stats: {
+    display: 'flex',
+    justifyContent: 'space-between',
+    marginTop: '20px',
+  },
+  stat: {
+    display: 'flex',
+    flexDirection: 'column',
+    alignItems: 'center',
+  },
+  review: {
+    borderTop: '1px solid rgba(255, 255, 255, 0.3)',
+    padding: '10px 0',
+  },
+  reviewHeader: {
+    display: 'flex',
+    justifyContent: 'space-between',
+    fontWeight: '600',
+  },
+  comment: {
+    margin: '5px 0 0',
+  },
+};
+
+export default UserProfilePage;
@@ -2,7 +2,7 @@
 import axios from 'axios';
 
 const api = axios.create({
-  baseURL: 'https://carpoolbackend-hj1i.onrender.com', // Adjust if your backend runs on a different URL or port
+  baseURL: 'https://carpoolbackend-hj1i.onrender.com/v1', // Adjust if your backend runs on a different URL or port
 });
 
 